@@ -1,12 +1,18 @@
 package alerter
 
 import (
+	"fmt"
 	"github.com/op/go-logging"
 	"time"
 )
 
 var log = logging.MustGetLogger("alerter")
 
+// SchemaVersion is the version of the PingerUpdate format. It is incremented
+// whenever the format changes in a way that alert consumers need to be aware
+// of.
+const SchemaVersion = 1
+
 // PingerStatus describes the current status of a pinger.
 // If the ping was successful, OK will be true and Error will be nil.
 // Should the ping not have been successful, OK  will be false and
@@ -14,20 +20,94 @@ var log = logging.MustGetLogger("alerter")
 type PingerStatus struct {
 	OK    bool
 	Error string
+	// The kind of failure (such as "timeout" or "tls") that the pinger ran
+	// into, if it failed and its pinger categorizes its failures.
+	ErrorCategory string `json:",omitempty"`
+	// The Error with volatile parts (such as ports, IP addresses and
+	// timestamps) masked, to deduplicate alerts on. Only set when error
+	// normalization is configured.
+	NormalizedError string `json:",omitempty"`
 	// A URL to the watcher where the latest output for the given pinger
 	// can be found (if any).
 	OutputURL string
+	// OutputChanged is true if the output of the pinger differs from
+	// that of its previous ping.
+	OutputChanged bool
 }
 
 // A PingerUpdate is sent to an Alerter from a Pinger to notify
 // the Alerter of the result of a recent health check of the Pinger's
 // endpoint.
 type PingerUpdate struct {
-	Name        string
-	Status      PingerStatus
-	Consecutive int
-	LatestOK    *time.Time
-	LatestNOK   *time.Time
+	// The SchemaVersion that the PingerUpdate adheres to.
+	SchemaVersion int
+	Name          string
+	Status        PingerStatus
+	Consecutive   int
+	LatestOK      *time.Time
+	LatestNOK     *time.Time
+	// A free-text description of the pinger (if any).
+	Description string
+	// The time since which the pinger has been in its current state.
+	InStateSince *time.Time
+	// The state that the pinger was in before its current state (nil if
+	// none).
+	PreviousState *PreviousState
+	// The stable identifier of the pinger (which, unlike the Name, stays
+	// the same if the pinger is renamed).
+	ID string
+	// Labels of the pinger (including global labels), such as env=prod.
+	Labels map[string]string
+	// Recovered is true if the pinger went from NOT OK to OK. The first
+	// successful ping after startup is not a recovery.
+	Recovered bool
+	// Flapping is true if the pinger has started to change state too often
+	// to alert on every state transition. No further state transitions are
+	// alerted on until the pinger stabilizes, which is alerted on with its
+	// current status.
+	Flapping bool `json:",omitempty"`
+}
+
+// PreviousState describes a state that a pinger was in.
+type PreviousState struct {
+	OK bool
+	// The period during which the pinger was in the state.
+	Since time.Time
+	Until time.Time
+	// For a NOT OK state, the number of consecutive failed pings that
+	// the state ended with.
+	Failures int
+}
+
+// RecoveryContext returns a short description of a recovery, such as
+// "after 3 failures". It is empty unless the pinger has recovered.
+func (update *PingerUpdate) RecoveryContext() string {
+	if !update.Recovered || update.PreviousState == nil {
+		return ""
+	}
+	if update.PreviousState.Failures == 1 {
+		return "after 1 failure"
+	}
+	return fmt.Sprintf("after %d failures", update.PreviousState.Failures)
+}
+
+// StateContext returns a short description of how the pinger got into its
+// current state, such as "was OK for 3h0m0s, now NOT OK". It is empty if no
+// previous state is known.
+func (update *PingerUpdate) StateContext() string {
+	if update.PreviousState == nil {
+		return ""
+	}
+	previous := "OK"
+	if !update.PreviousState.OK {
+		previous = "NOT OK"
+	}
+	current := "OK"
+	if !update.Status.OK {
+		current = "NOT OK"
+	}
+	duration := update.PreviousState.Until.Sub(update.PreviousState.Since).Round(time.Second)
+	return fmt.Sprintf("was %s for %s, now %s", previous, duration, current)
 }
 
 // Alerter implmentations send notification messages over a given
@@ -1,7 +1,6 @@
 package alerter
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/petergardfjall/watcher/config"
 	"net/smtp"
@@ -9,7 +8,8 @@ import (
 
 // An EmailAlerter sends alerts over the SMTP protocol to a group of receivers.
 type EmailAlerter struct {
-	Config *config.Email
+	Config   *config.Email
+	Template *MessageTemplate
 }
 
 // NewEmailAlerter creates a new EmailAlerter from a configuration.
@@ -17,7 +17,11 @@ func NewEmailAlerter(emailConfig *config.Email) (*EmailAlerter, error) {
 	if emailConfig == nil {
 		return nil, fmt.Errorf("cannot create email alerter: config is nil")
 	}
-	return &EmailAlerter{Config: emailConfig}, nil
+	template, err := NewMessageTemplate(emailConfig.Template)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create email alerter: %s", err)
+	}
+	return &EmailAlerter{Config: emailConfig, Template: template}, nil
 }
 
 // Alert sends an alert over the SMTP protocol to the server and recipients
@@ -50,18 +54,27 @@ func (emailAlerter *EmailAlerter) Alert(update PingerUpdate) error {
 func (emailAlerter *EmailAlerter) message(update *PingerUpdate) ([]byte, error) {
 	conf := emailAlerter.Config
 
-	status := "OK"
-	if !update.Status.OK {
-		status = "NOT OK"
+	status := "is " + NewTemplateData(*update).State
+	if update.Recovered {
+		status = "RECOVERED " + update.RecoveryContext()
+	}
+	if update.Flapping {
+		status = "is FLAPPING"
+	}
+	if update.Status.OutputChanged {
+		status += " (output changed)"
 	}
 
-	subject := fmt.Sprintf("[watcher] pinger [%s] is %s", update.Name, status)
+	subject := fmt.Sprintf("[watcher] pinger [%s] %s", update.Name, status)
+	if context := update.StateContext(); context != "" {
+		subject += fmt.Sprintf(" (%s)", context)
+	}
 	headers := fmt.Sprintf("From: %s\r\nSubject: %s\r\n", conf.From, subject)
 
-	body, err := json.MarshalIndent(update, "", "    ")
+	body, err := emailAlerter.Template.Render(*update)
 	if err != nil {
 		return nil, fmt.Errorf("failed to produce alert message: %s", err)
 	}
 
-	return []byte(headers + "\r\n" + string(body) + "\r\n"), nil
+	return []byte(headers + "\r\n" + body + "\r\n"), nil
 }
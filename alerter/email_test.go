@@ -0,0 +1,86 @@
+package alerter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+// newTestEmailAlerter creates an EmailAlerter with a given message template.
+func newTestEmailAlerter(t *testing.T, template string) *EmailAlerter {
+	t.Helper()
+	emailAlerter, err := NewEmailAlerter(&config.Email{From: "watcher@example.com", To: []string{"ops@example.com"}, Template: template})
+	if err != nil {
+		t.Fatalf("failed to create email alerter: %s", err)
+	}
+	return emailAlerter
+}
+
+func TestEmailPayloadVersion(t *testing.T) {
+	emailAlerter := newTestEmailAlerter(t, "")
+	message, err := emailAlerter.message(&PingerUpdate{SchemaVersion: SchemaVersion, Name: "test"})
+	if err != nil {
+		t.Fatalf("failed to produce message: %s", err)
+	}
+	parts := strings.SplitN(string(message), "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("message lacks a body: %q", message)
+	}
+	var payload struct {
+		SchemaVersion *int
+		Name          string
+	}
+	if err := json.Unmarshal([]byte(parts[1]), &payload); err != nil {
+		t.Fatalf("failed to parse message body: %s", err)
+	}
+	if payload.SchemaVersion == nil || *payload.SchemaVersion != SchemaVersion || payload.Name != "test" {
+		t.Errorf("expected schema version %d in payload: %s", SchemaVersion, parts[1])
+	}
+}
+
+func TestEmailDescription(t *testing.T) {
+	update := &PingerUpdate{Name: "test", Description: "Public web site, see runbook/web.md"}
+	for template, want := range map[string]string{
+		"":                            `"Description": "Public web site, see runbook/web.md"`,
+		"{{.Name}}: {{.Description}}": "test: Public web site, see runbook/web.md",
+	} {
+		message, err := newTestEmailAlerter(t, template).message(update)
+		if err != nil {
+			t.Fatalf("failed to produce message: %s", err)
+		}
+		if !strings.Contains(string(message), want) {
+			t.Errorf("template %q: expected %q in message: %q", template, want, message)
+		}
+	}
+}
+
+func TestEmailStateContext(t *testing.T) {
+	since := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	emailAlerter := newTestEmailAlerter(t, "")
+	tests := []struct {
+		update      PingerUpdate
+		wantSubject string
+	}{
+		{
+			PingerUpdate{Name: "web", PreviousState: &PreviousState{OK: true, Since: since, Until: since.Add(3 * time.Hour)}},
+			"[watcher] pinger [web] is NOT OK (was OK for 3h0m0s, now NOT OK)",
+		},
+		{
+			PingerUpdate{Name: "web", Status: PingerStatus{OK: true}, PreviousState: &PreviousState{Since: since, Until: since.Add(90 * time.Second)}},
+			"[watcher] pinger [web] is OK (was NOT OK for 1m30s, now OK)",
+		},
+		{PingerUpdate{Name: "web"}, "[watcher] pinger [web] is NOT OK"},
+	}
+	for _, test := range tests {
+		message, err := emailAlerter.message(&test.update)
+		if err != nil {
+			t.Fatalf("failed to produce message: %s", err)
+		}
+		if !strings.Contains(string(message), "\r\nSubject: "+test.wantSubject+"\r\n") {
+			t.Errorf("expected subject %q in message: %q", test.wantSubject, message)
+		}
+	}
+}
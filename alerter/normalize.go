@@ -0,0 +1,62 @@
+package alerter
+
+import (
+	"fmt"
+	"github.com/petergardfjall/watcher/config"
+	"regexp"
+)
+
+// maskedPlaceholder replaces matches of the configured volatile patterns.
+const maskedPlaceholder = "<masked>"
+
+// volatileParts match parts of error messages that tend to differ between
+// occurrences of the same failure, along with their replacements. They are
+// applied in order (timestamps before ports, since times contain colons).
+var volatileParts = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// timestamps, such as 2016-05-26T09:28:57.684Z, and times of day
+	{regexp.MustCompile(`(\d{4}-\d{2}-\d{2}[T ])?\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+	// IPv6 addresses (in brackets, as in host:port)
+	{regexp.MustCompile(`\[[0-9a-fA-F:.]*:[0-9a-fA-F:.]*(%\w+)?\]`), "[<ip>]"},
+	// IPv4 addresses
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`), "<ip>"},
+	// ports (following a host)
+	{regexp.MustCompile(`([\w\]>]):\d{1,5}\b`), "$1:<port>"},
+}
+
+// An ErrorNormalizer masks the volatile parts of error messages (such as
+// ports, IP addresses and timestamps), so that the messages of repeated
+// occurrences of a failure are the same and can be deduplicated on.
+type ErrorNormalizer struct {
+	// additional patterns to mask (applied before the volatile parts)
+	patterns []*regexp.Regexp
+}
+
+// NewErrorNormalizer creates a new ErrorNormalizer from a configuration.
+func NewErrorNormalizer(normalizationConfig *config.ErrorNormalization) (*ErrorNormalizer, error) {
+	if normalizationConfig == nil {
+		return nil, fmt.Errorf("cannot create error normalizer: config is nil")
+	}
+	normalizer := &ErrorNormalizer{}
+	for _, pattern := range normalizationConfig.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create error normalizer: %s", err)
+		}
+		normalizer.patterns = append(normalizer.patterns, compiled)
+	}
+	return normalizer, nil
+}
+
+// Normalize returns an error message with its volatile parts masked.
+func (normalizer *ErrorNormalizer) Normalize(message string) string {
+	for _, pattern := range normalizer.patterns {
+		message = pattern.ReplaceAllString(message, maskedPlaceholder)
+	}
+	for _, part := range volatileParts {
+		message = part.pattern.ReplaceAllString(message, part.replacement)
+	}
+	return message
+}
@@ -0,0 +1,55 @@
+package alerter
+
+import (
+	"testing"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+func TestNormalizeVolatileParts(t *testing.T) {
+	normalizer, err := NewErrorNormalizer(&config.ErrorNormalization{Patterns: []string{`req [0-9a-f]+`}})
+	if err != nil {
+		t.Fatalf("failed to create normalizer: %s", err)
+	}
+	for _, messages := range [][2]string{
+		{
+			`ping failed: Get "http://10.0.0.1:8080/x": dial tcp 10.0.0.1:8080: connect: connection refused`,
+			`ping failed: Get "http://10.0.0.2:9090/x": dial tcp 10.0.0.2:9090: connect: connection refused`,
+		},
+		{
+			`read tcp 127.0.0.1:53422->127.0.0.1:443: read: connection reset by peer`,
+			`read tcp 127.0.0.1:60001->127.0.0.1:443: read: connection reset by peer`,
+		},
+		{
+			`at 2016-05-26T09:28:57.684217751Z: timeout (req abc123)`,
+			`at 2017-01-01T00:00:00Z: timeout (req ff00)`,
+		},
+		{`dial tcp [::1]:5432: refused`, `dial tcp [fe80::1%eth0]:5433: refused`},
+		{`localhost:8080 down`, `localhost:8081 down`},
+	} {
+		first, second := normalizer.Normalize(messages[0]), normalizer.Normalize(messages[1])
+		if first != second {
+			t.Errorf("expected same normalized message, got %q and %q", first, second)
+		}
+	}
+}
+
+func TestNormalizeKeepsStableParts(t *testing.T) {
+	normalizer, err := NewErrorNormalizer(&config.ErrorNormalization{})
+	if err != nil {
+		t.Fatalf("failed to create normalizer: %s", err)
+	}
+	message := "expected status code (200) differs from actual (503)"
+	if normalized := normalizer.Normalize(message); normalized != message {
+		t.Errorf("expected message to be kept, got %q", normalized)
+	}
+	if normalizer.Normalize("http://a:1") == normalizer.Normalize("http://b:1") {
+		t.Errorf("expected hosts to be kept")
+	}
+}
+
+func TestNewErrorNormalizerRejectsIllegalPattern(t *testing.T) {
+	if _, err := NewErrorNormalizer(&config.ErrorNormalization{Patterns: []string{`(`}}); err == nil {
+		t.Errorf("expected illegal pattern to be rejected")
+	}
+}
@@ -0,0 +1,147 @@
+package alerter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/petergardfjall/watcher/config"
+	"net/http"
+	"time"
+)
+
+const (
+	// pagerDutyTimeout is the longest time to wait for the PagerDuty
+	// Events API to respond.
+	pagerDutyTimeout = 10 * time.Second
+	// defaultPagerDutySeverity is the severity of triggered incidents,
+	// unless configured otherwise.
+	defaultPagerDutySeverity = "error"
+	// pagerDutyMaxSummary is the longest summary accepted by PagerDuty.
+	pagerDutyMaxSummary = 1024
+)
+
+// pagerDutyEventsURL is the endpoint of the PagerDuty Events API v2.
+var pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// A PagerDutyAlerter opens and resolves PagerDuty incidents via the Events API
+// v2. A failing pinger triggers an incident that is resolved when the pinger
+// recovers. Each pinger has its own deduplication key, so repeated alerts
+// (such as reminders) for a failing pinger end up in the same incident.
+type PagerDutyAlerter struct {
+	Config *config.PagerDuty
+	Client *http.Client
+}
+
+// pagerDutyEvent is the JSON payload of a PagerDuty Events API v2 event.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+// pagerDutyPayload describes the incident of a trigger event.
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// pagerDutyLink is a link attached to an incident.
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// NewPagerDutyAlerter creates a new PagerDutyAlerter from a configuration.
+func NewPagerDutyAlerter(pagerDutyConfig *config.PagerDuty) (*PagerDutyAlerter, error) {
+	if pagerDutyConfig == nil {
+		return nil, fmt.Errorf("cannot create pagerduty alerter: config is nil")
+	}
+	return &PagerDutyAlerter{Config: pagerDutyConfig, Client: &http.Client{Timeout: pagerDutyTimeout}}, nil
+}
+
+// Alert triggers a PagerDuty incident for a failing pinger and resolves it
+// for a recovered pinger.
+func (pagerDutyAlerter *PagerDutyAlerter) Alert(update PingerUpdate) error {
+	payload, err := json.Marshal(pagerDutyAlerter.event(&update))
+	if err != nil {
+		return fmt.Errorf("failed to send pagerduty event: %s", err)
+	}
+
+	log.Debugf("sending pagerduty event ...")
+	resp, err := pagerDutyAlerter.Client.Post(pagerDutyEventsURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send pagerduty event: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to send pagerduty event: events API responded with status code %d", resp.StatusCode)
+	}
+	log.Debugf("pagerduty event sent.")
+
+	return nil
+}
+
+func (pagerDutyAlerter *PagerDutyAlerter) event(update *PingerUpdate) pagerDutyEvent {
+	conf := pagerDutyAlerter.Config
+
+	// the ID stays the same if the pinger is renamed (and defaults to the
+	// pinger name)
+	dedupKey := update.ID
+	if dedupKey == "" {
+		dedupKey = update.Name
+	}
+	event := pagerDutyEvent{RoutingKey: conf.RoutingKey, DedupKey: "watcher/" + dedupKey}
+	// a flapping pinger stays triggered until it stabilizes
+	if update.Status.OK && !update.Flapping {
+		event.EventAction = "resolve"
+		return event
+	}
+
+	severity := conf.Severity
+	if severity == "" {
+		severity = defaultPagerDutySeverity
+	}
+	summary := fmt.Sprintf("[watcher] pinger [%s] is NOT OK", update.Name)
+	if update.Flapping {
+		summary = fmt.Sprintf("[watcher] pinger [%s] is FLAPPING", update.Name)
+	}
+	if update.Status.Error != "" {
+		summary += ": " + update.Status.Error
+	}
+	details := map[string]interface{}{
+		"pinger":      update.Name,
+		"error":       update.Status.Error,
+		"consecutive": update.Consecutive,
+	}
+	if update.Description != "" {
+		details["description"] = update.Description
+	}
+	if len(update.Labels) > 0 {
+		details["labels"] = update.Labels
+	}
+	if update.Status.OutputURL != "" {
+		details["outputURL"] = update.Status.OutputURL
+		event.Links = []pagerDutyLink{{Href: update.Status.OutputURL, Text: "latest output"}}
+	}
+
+	event.EventAction = "trigger"
+	event.Payload = &pagerDutyPayload{
+		Summary:       truncate(summary, pagerDutyMaxSummary),
+		Source:        "watcher",
+		Severity:      severity,
+		CustomDetails: details,
+	}
+	return event
+}
+
+// truncate cuts a string down to at most a given number of bytes.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
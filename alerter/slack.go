@@ -0,0 +1,125 @@
+package alerter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/petergardfjall/watcher/config"
+	"net/http"
+	"time"
+)
+
+const (
+	// slackTimeout is the longest time to wait for the Slack webhook to
+	// respond.
+	slackTimeout = 10 * time.Second
+	// attachment colors for OK and NOT OK pingers
+	slackColorOK  = "good"
+	slackColorNOK = "danger"
+)
+
+// A SlackAlerter posts alerts to a Slack channel via an incoming webhook.
+type SlackAlerter struct {
+	Config *config.Slack
+	Client *http.Client
+}
+
+// slackMessage is the JSON payload posted to a Slack incoming webhook.
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// slackAttachment is a color-coded part of a slackMessage.
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link,omitempty"`
+	Text      string       `json:"text,omitempty"`
+	Fields    []slackField `json:"fields,omitempty"`
+}
+
+// slackField is a short key-value entry of a slackAttachment.
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// NewSlackAlerter creates a new SlackAlerter from a configuration.
+func NewSlackAlerter(slackConfig *config.Slack) (*SlackAlerter, error) {
+	if slackConfig == nil {
+		return nil, fmt.Errorf("cannot create slack alerter: config is nil")
+	}
+	return &SlackAlerter{Config: slackConfig, Client: &http.Client{Timeout: slackTimeout}}, nil
+}
+
+// Alert posts an alert to the Slack webhook configured for the SlackAlerter.
+func (slackAlerter *SlackAlerter) Alert(update PingerUpdate) error {
+	payload, err := json.Marshal(slackAlerter.message(&update))
+	if err != nil {
+		return fmt.Errorf("failed to post to slack: %s", err)
+	}
+
+	log.Debugf("posting alert to slack ...")
+	resp, err := slackAlerter.Client.Post(slackAlerter.Config.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		// the error includes the (secret) webhook URL
+		return fmt.Errorf("failed to post to slack: request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to post to slack: webhook responded with status code %d", resp.StatusCode)
+	}
+	log.Debugf("alert posted to slack.")
+
+	return nil
+}
+
+func (slackAlerter *SlackAlerter) message(update *PingerUpdate) slackMessage {
+	conf := slackAlerter.Config
+
+	color := slackColorOK
+	if !update.Status.OK {
+		color = slackColorNOK
+	}
+	status := "is " + NewTemplateData(*update).State
+	if update.Recovered {
+		status = "RECOVERED " + update.RecoveryContext()
+	}
+	if update.Flapping {
+		status = "is FLAPPING"
+	}
+	if update.Status.OutputChanged {
+		status += " (output changed)"
+	}
+	title := fmt.Sprintf("pinger [%s] %s", update.Name, status)
+
+	attachment := slackAttachment{
+		Fallback:  "[watcher] " + title,
+		Color:     color,
+		Title:     title,
+		TitleLink: update.Status.OutputURL,
+		Text:      update.Status.Error,
+	}
+	if context := update.StateContext(); context != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "State", Value: context})
+	}
+	if update.Description != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Description", Value: update.Description})
+	}
+	if update.Status.OutputURL != "" {
+		attachment.Fields = append(attachment.Fields,
+			slackField{Title: "Output", Value: fmt.Sprintf("<%s|latest output>", update.Status.OutputURL)})
+	}
+
+	return slackMessage{
+		Channel:     conf.Channel,
+		Username:    conf.Username,
+		IconEmoji:   conf.IconEmoji,
+		Attachments: []slackAttachment{attachment},
+	}
+}
@@ -0,0 +1,70 @@
+package alerter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+// defaultTemplate is the message template used by alerters that are not
+// configured with a template of their own: the PingerUpdate as JSON.
+const defaultTemplate = "{{json .PingerUpdate}}"
+
+// templateFuncs are the functions available to message templates.
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		bytes, err := json.MarshalIndent(value, "", "    ")
+		return string(bytes), err
+	},
+}
+
+// TemplateData is the data model that message templates are rendered with.
+// It is shared by all alerters, so that a template can be reused between
+// alerters. Besides the fields of the PingerUpdate, it carries some derived
+// fields for convenience.
+type TemplateData struct {
+	PingerUpdate
+	// State is "OK" or "NOT OK".
+	State string
+	// StateContext describes the state change, such as "was OK for 3h0m0s,
+	// now NOT OK" (empty if there is no previous state).
+	StateContext string
+}
+
+// NewTemplateData creates the TemplateData for a PingerUpdate.
+func NewTemplateData(update PingerUpdate) TemplateData {
+	state := "OK"
+	if !update.Status.OK {
+		state = "NOT OK"
+	}
+	return TemplateData{PingerUpdate: update, State: state, StateContext: update.StateContext()}
+}
+
+// A MessageTemplate renders alert messages from PingerUpdates.
+type MessageTemplate struct {
+	template *template.Template
+}
+
+// NewMessageTemplate parses a message template (see TemplateData for the
+// available fields). An empty text selects the default template, which
+// renders the PingerUpdate as JSON.
+func NewMessageTemplate(text string) (*MessageTemplate, error) {
+	if text == "" {
+		text = defaultTemplate
+	}
+	parsed, err := template.New("message").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("illegal template: %s", err)
+	}
+	return &MessageTemplate{template: parsed}, nil
+}
+
+// Render renders a message for a PingerUpdate.
+func (messageTemplate *MessageTemplate) Render(update PingerUpdate) (string, error) {
+	var message bytes.Buffer
+	if err := messageTemplate.template.Execute(&message, NewTemplateData(update)); err != nil {
+		return "", fmt.Errorf("failed to render template: %s", err)
+	}
+	return message.String(), nil
+}
@@ -0,0 +1,87 @@
+package alerter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+func TestMessageTemplate(t *testing.T) {
+	since := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	update := PingerUpdate{
+		Name:          "web",
+		Status:        PingerStatus{Error: "connection refused"},
+		Labels:        map[string]string{"env": "prod"},
+		PreviousState: &PreviousState{OK: true, Since: since, Until: since.Add(3 * time.Hour)},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{{.Name}} is {{.State}}: {{.Status.Error}}", "web is NOT OK: connection refused"},
+		{"[{{.Labels.env}}] {{.Name}} {{.StateContext}}", "[prod] web was OK for 3h0m0s, now NOT OK"},
+		{"{{with .PreviousState}}since {{.Since.Format \"15:04\"}}{{end}}", "since 12:00"},
+	}
+	for _, test := range tests {
+		messageTemplate, err := NewMessageTemplate(test.template)
+		if err != nil {
+			t.Fatalf("%q: failed to parse template: %s", test.template, err)
+		}
+		message, err := messageTemplate.Render(update)
+		if err != nil {
+			t.Fatalf("%q: failed to render template: %s", test.template, err)
+		}
+		if message != test.want {
+			t.Errorf("%q: got message %q, want %q", test.template, message, test.want)
+		}
+	}
+}
+
+func TestDefaultMessageTemplate(t *testing.T) {
+	update := PingerUpdate{SchemaVersion: SchemaVersion, Name: "web", Status: PingerStatus{Error: "connection refused"}}
+	messageTemplate, err := NewMessageTemplate("")
+	if err != nil {
+		t.Fatalf("failed to parse default template: %s", err)
+	}
+	message, err := messageTemplate.Render(update)
+	if err != nil {
+		t.Fatalf("failed to render default template: %s", err)
+	}
+	want, _ := json.MarshalIndent(update, "", "    ")
+	if message != string(want) {
+		t.Errorf("expected the default template to render the update as JSON, got %q", message)
+	}
+}
+
+func TestMessageTemplateErrors(t *testing.T) {
+	if _, err := NewMessageTemplate("{{.Name"); err == nil || !strings.HasPrefix(err.Error(), "illegal template") {
+		t.Errorf("expected unparseable template to be rejected, got %v", err)
+	}
+
+	messageTemplate, err := NewMessageTemplate("{{.Labels.team}}")
+	if err != nil {
+		t.Fatalf("failed to parse template: %s", err)
+	}
+	if _, err := messageTemplate.Render(PingerUpdate{Labels: map[string]string{"env": "prod"}}); err == nil {
+		t.Errorf("expected a missing label to fail rendering")
+	}
+}
+
+func TestEmailTemplate(t *testing.T) {
+	update := &PingerUpdate{Name: "web", Status: PingerStatus{OK: true}}
+	message, err := newTestEmailAlerter(t, "{{.Name}} is {{.State}}").message(update)
+	if err != nil {
+		t.Fatalf("failed to produce message: %s", err)
+	}
+	if parts := strings.SplitN(string(message), "\r\n\r\n", 2); len(parts) != 2 || parts[1] != "web is OK\r\n" {
+		t.Errorf("expected the template to render the message body, got %q", message)
+	}
+
+	if _, err := NewEmailAlerter(&config.Email{From: "watcher@example.com", To: []string{"ops@example.com"}, Template: "{{.Name"}); err == nil {
+		t.Errorf("expected an email alerter with an illegal template to be rejected")
+	}
+}
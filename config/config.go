@@ -1,17 +1,35 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/petergardfjall/watcher/expr"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"net/mail"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// clockFormat is the time layout of a time of day (HH:MM).
+const clockFormat = "15:04"
+
+// weekdays maps the abbreviated weekday names accepted in configurations to
+// their time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"Mon": time.Monday, "Tue": time.Tuesday, "Wed": time.Wednesday,
+	"Thu": time.Thursday, "Fri": time.Friday, "Sat": time.Saturday,
+	"Sun": time.Sunday,
+}
+
 var (
 	// Regular expression describing a valid IPv4 address
 	ipv4AddrRegexp = regexp.MustCompile("^[0-9]{1,3}\\.[0-9]{1,3}\\.[0-9]{1,3}\\.[0-9]{1,3}$")
@@ -21,6 +39,18 @@ var (
 	// Regular expression that describes a valid pinger name (must
 	// be possible to use as a path segment in a URL)
 	validPingerName = regexp.MustCompile("^[a-zA-Z0-9_\\-\\.]+$")
+
+	// Regular expression that describes a valid service (unit) name (must
+	// be safe to pass as an argument to a shell command)
+	validServiceUnit = regexp.MustCompile("^[a-zA-Z0-9_@:\\-\\.]+$")
+
+	// Regular expression that describes a valid label key (must be
+	// usable as a metric label)
+	validLabelKey = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+	// Regular expression describing a class of HTTP status codes (such as
+	// 2xx)
+	httpStatusClass = regexp.MustCompile("^[1-5][xX][xX]$")
 )
 
 // Engine is the root type of the watcher engine configuration.
@@ -28,6 +58,82 @@ type Engine struct {
 	DefaultSchedule *Schedule `json:"defaultSchedule"`
 	Pingers         []Pinger  `json:"pingers"`
 	Alerter         *Alerter  `json:"alerter"`
+	// The maximum number of concurrent SSH connections to make against a
+	// single host (0 means no limit).
+	MaxSSHConnectionsPerHost int `json:"maxSSHConnectionsPerHost"`
+	// If given, coordinates with other (redundant) watcher instances so
+	// that only one of them dispatches alerts.
+	HA *HA `json:"ha"`
+	// Vantages that pingers can run their checks from.
+	Vantages []Vantage `json:"vantages"`
+	// Labels (such as env=prod) that apply to all pingers. A pinger label
+	// with the same key takes precedence.
+	GlobalLabels map[string]string `json:"globalLabels"`
+	// The largest value that the consecutive counter of a pinger is
+	// allowed to reach (0 means no limit).
+	MaxConsecutive int `json:"maxConsecutive"`
+	// The shortest interval that pingers may run at. Shorter intervals
+	// are raised to it (0 means no floor, which is the default).
+	MinInterval *Duration `json:"minInterval"`
+	// On reload, the longest time to wait for ongoing pings of removed or
+	// changed pingers to finish before replacing them (0 means not to
+	// wait). Default: 30s.
+	DrainTimeout *Duration `json:"drainTimeout"`
+	// The number of past pings to keep for each pinger (0 means none).
+	// Default: 100.
+	HistorySize *int `json:"historySize"`
+	// If given, a webhook that every status update of every pinger is
+	// posted to (regardless of whether it is alerted on).
+	StatusWebhook *StatusWebhook `json:"statusWebhook"`
+	// If given, the liveness endpoint reports the watcher as unhealthy
+	// when too few pingers are producing results.
+	Liveness *Liveness `json:"liveness"`
+	// If given, the seed of the random numbers (such as schedule jitter)
+	// that the watcher uses, which makes them the same on every run.
+	// Only read on startup. Default: seeded from the current time.
+	RandSeed *int64 `json:"randSeed"`
+}
+
+// Liveness describes when the watcher is considered stuck: when too few of
+// its pingers have produced a result (of any status) within a window.
+type Liveness struct {
+	// The smallest fraction of pingers, in the range (0,1], that must have
+	// produced a result within the Window.
+	MinReporting float64 `json:"minReporting"`
+	// The window within which pingers must have produced a result. A
+	// pinger whose interval (including its jitter and retry delays) is
+	// longer is given that long instead. Default: 5m.
+	Window *Duration `json:"window"`
+}
+
+// A StatusWebhook is an HTTP endpoint that status updates are posted to.
+type StatusWebhook struct {
+	// The http(s) URL to POST status updates to.
+	URL string `json:"url"`
+	// The longest time to wait for the webhook to respond. Default: 10s.
+	Timeout *Duration `json:"timeout"`
+}
+
+// A Vantage is a source that pingers can run their checks from, such as a
+// local address (network interface) or a proxy in another network.
+type Vantage struct {
+	Name string `json:"name"`
+	// A local IP address to make connections from.
+	SourceAddress string `json:"sourceAddress"`
+	// A SOCKS5 proxy (host:port) to make connections through.
+	Socks5Proxy string `json:"socks5Proxy"`
+}
+
+// HA describes how a watcher instance coordinates with other instances
+// running the same checks, so that only the active instance sends alerts.
+type HA struct {
+	// Path to a lock file on storage shared by the instances.
+	LockFile string `json:"lockFile"`
+	// How long the active instance's lease on the lock file lasts without
+	// being renewed.
+	LeaseDuration *Duration `json:"leaseDuration"`
+	// A unique identifier of this instance. Default: <hostname>:<pid>.
+	InstanceID string `json:"instanceID"`
 }
 
 // A Pinger definition in an Engine config. Note that the "check" field of the
@@ -38,12 +144,113 @@ type Pinger struct {
 	Type     string          `json:"type"`
 	Check    json.RawMessage `json:"check"`
 	Schedule *Schedule       `json:"schedule"`
+	// A free-text description of the pinger (for example, what it checks
+	// and a link to a runbook).
+	Description string `json:"description"`
+	// If given, alerts for the pinger are deferred until business hours.
+	BusinessHours *BusinessHours `json:"businessHours"`
+	// Names of vantages to run the check from (one task per vantage). If
+	// none are given, the check runs from the local host.
+	Vantages []string `json:"vantages"`
+	// If true, output identical to the previously stored output is not
+	// stored again.
+	SuppressDuplicateOutput bool `json:"suppressDuplicateOutput"`
+	// A stable identifier of the pinger, which (unlike the Name) is not
+	// meant to change. Alert state is tracked by ID so that a renamed
+	// pinger keeps its history. Default: the Name.
+	ID string `json:"id"`
+	// The state that a new pinger is assumed to be in: ok, nok, or
+	// unknown (default). Only a change from this state is alerted on.
+	AssumeInitialState string `json:"assumeInitialState"`
+	// Labels (such as team=db) that are attached to the alerts of the
+	// pinger.
+	Labels map[string]string `json:"labels"`
+	// Commands to run when the pinger changes state (such as to restart
+	// a failed service).
+	Hooks *Hooks `json:"hooks"`
+	// How much the pinger counts in the health score (nil means 1).
+	Weight *int `json:"weight"`
+	// The name of another pinger that must be OK for this pinger to run.
+	// While it is not, pings are skipped.
+	RunIf string `json:"runIf"`
+	// The number of failure alerts after which alerting for the pinger is
+	// paused until it is acknowledged or recovers (0 means no limit).
+	MaxAlerts int `json:"maxAlerts"`
+	// If true, output of the pinger (excerpts of it in errors, and the
+	// link to it) is left out of its alerts. It is still served by the
+	// REST API.
+	RedactOutputInAlerts bool `json:"redactOutputInAlerts"`
+	// Names of groups that the pinger belongs to. All pingers of a group
+	// can be run at once (such as to gate a deployment).
+	Groups []string `json:"groups"`
+	// Names of the alerters to send the alerts of the pinger to. If none
+	// are given, alerts are sent to the email, slack and pagerDuty
+	// alerters of the Alerter configuration.
+	Alerters []string `json:"alerters"`
+}
+
+// Hooks are commands that are run (on the watcher host, by "sh -c") when a
+// pinger changes state. They are not run on reminders.
+type Hooks struct {
+	// Command to run when the pinger goes down.
+	OnDown string `json:"onDown"`
+	// Command to run when the pinger recovers.
+	OnUp string `json:"onUp"`
+	// The longest time that a command may run. Default: 5m.
+	Timeout *Duration `json:"timeout"`
+}
+
+// PingerID returns the identifier of a Pinger (its ID or, if not set, its
+// Name).
+func (pinger *Pinger) PingerID() string {
+	if pinger.ID != "" {
+		return pinger.ID
+	}
+	return pinger.Name
+}
+
+// BusinessHours describes a recurring weekly time window, such as 09:00-17:00
+// on weekdays. If End is before Start, the window spans midnight.
+type BusinessHours struct {
+	// Start of the window (inclusive) as HH:MM.
+	Start string `json:"start"`
+	// End of the window (exclusive) as HH:MM.
+	End string `json:"end"`
+	// Days (Mon, Tue, ...) on which the window starts. Default: Mon-Fri.
+	Weekdays []string `json:"weekdays"`
+	// The IANA time zone (for example, Europe/Stockholm) that Start and End
+	// are given in. Default: the local time zone.
+	Timezone string `json:"timezone"`
 }
 
 // A Schedule describes how often to carry out a ping check.
 type Schedule struct {
 	Interval *Duration `json:"interval"`
 	Retries  *Retries  `json:"retries"`
+	// ReportInterval, if given, is the interval at which to report an
+	// aggregated status of the pings performed during the interval
+	// (rather than reporting the result of every single ping).
+	ReportInterval *Duration `json:"reportInterval"`
+	// FailureThreshold is the number of consecutive failures required
+	// before a pinger is alerted on as failing (0 is treated as 1). A
+	// failure is either a failed ping or, if the retries are set to
+	// countAttempts, a failed attempt.
+	FailureThreshold int `json:"failureThreshold"`
+	// FailureWindow, if given, evaluates failures over a rolling window of
+	// recent pings rather than over consecutive pings.
+	FailureWindow *FailureWindow `json:"failureWindow"`
+	// Jitter, if given, adds a random offset in [0, Jitter) to every wait
+	// for the next ping (including the first one), to keep pingers with
+	// the same interval from pinging in lockstep.
+	Jitter *Duration `json:"jitter"`
+}
+
+// A FailureWindow deems a pinger failing when at least Failures of its latest
+// Pings pings failed (for example, 3 of 5), and OK once fewer than Failures
+// of them failed and the latest ping succeeded.
+type FailureWindow struct {
+	Failures int `json:"failures"`
+	Pings    int `json:"pings"`
 }
 
 // Retries describes the retry behavior for a pinger.
@@ -55,6 +262,13 @@ type Retries struct {
 	// Whether to use exponential backoff to increase delay by a factor 2
 	// with every retry.
 	ExponentialBackoff bool `json:"exponentialBackoff"`
+	// Whether every failed attempt (rather than every failed ping) is to
+	// count towards the failure threshold.
+	CountAttempts bool `json:"countAttempts"`
+	// If greater than 1, the attempts of a ping are made concurrently
+	// (at most Concurrency at a time) and the ping succeeds as soon as
+	// any attempt succeeds.
+	Concurrency int `json:"concurrency"`
 }
 
 // HTTPCheck describes a check for a HTTP(S) pinger.
@@ -64,6 +278,60 @@ type HTTPCheck struct {
 	BasicAuth  *HTTPBasicAuth  `json:"basicAuth"`
 	Expect     HTTPExpectation `json:"expect"`
 	Timeout    *Duration       `json:"timeout"`
+	// If given, only retry failed pings when the endpoint responded
+	// with one of these status codes.
+	RetryOnStatus []int `json:"retryOnStatus"`
+	// URLs can be given instead of URL to check a set of URLs in a
+	// round-robin fashion (one URL per ping).
+	URLs []string `json:"urls"`
+	// The network to connect over: tcp (default), tcp4, or tcp6.
+	Network string `json:"network"`
+	// Expr is an expression over the response (status, latency, body,
+	// and header) that decides if the ping is successful. If given, it
+	// supersedes Expect.
+	Expr string `json:"expr"`
+	// The maximum number of bytes to read from the response body. Any
+	// remainder is discarded.
+	MaxOutputBytes int `json:"maxOutputBytes"`
+	// If true, the server must staple a valid OCSP response that does not
+	// report its certificate as revoked (https only).
+	CheckOCSP bool `json:"checkOCSP"`
+	// Expectations for responses with certain status codes (keyed on
+	// status code), such as a known maintenance page served with 503.
+	// A response with one of these status codes is judged by the
+	// corresponding expectation instead of by Expect.
+	ExpectOnStatus map[int]HTTPExpectation `json:"expectOnStatus"`
+	// If given, a keep-alive connection to the endpoint is kept warm in
+	// between pings (re-established at this interval if dropped), so
+	// that pings reuse it rather than include a (TLS) handshake.
+	Prewarm *Duration `json:"prewarm"`
+	// The HTTP method of the request. Default: GET.
+	Method string `json:"method"`
+	// A body to send with the request (not allowed for GET and HEAD).
+	Body string `json:"body"`
+	// Headers to set on the request, such as Content-Type.
+	Headers map[string]string `json:"headers"`
+	// Paths to a (PEM-encoded) client certificate and its key to present
+	// to the server, for mutual TLS. Either both or none must be given.
+	ClientCertFile string `json:"clientCertFile"`
+	ClientKeyFile  string `json:"clientKeyFile"`
+	// Path to a bundle of (PEM-encoded) CA certificates to verify the
+	// server certificate against, instead of the system roots.
+	CACertFile string `json:"caCertFile"`
+	// If given, each request starts a new trace, propagated in this
+	// format: w3c (a traceparent header) or b3 (X-B3-* headers).
+	Tracing string `json:"tracing"`
+}
+
+// httpMethods are the HTTP methods that a HTTPCheck can make requests with.
+var httpMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
 }
 
 // HTTPBasicAuth describes how to authenticate in case a HTTPCheck
@@ -77,17 +345,189 @@ type HTTPBasicAuth struct {
 // a HTTPCheck to be deemed successful.
 type HTTPExpectation struct {
 	StatusCode int `json:"statusCode"`
+	// Status codes that are accepted (in addition to StatusCode): specific
+	// codes (such as 204) and classes of codes (such as "2xx").
+	StatusCodes []HTTPStatusCode `json:"statusCodes"`
+	// Names of cookies that the response must set (via Set-Cookie).
+	Cookies []string `json:"cookies"`
+	// Bounds on the length of the response body (0 means no bound).
+	MinBodyBytes int64 `json:"minBodyBytes"`
+	MaxBodyBytes int64 `json:"maxBodyBytes"`
+	// Strings that the response body must contain.
+	BodyContains []string `json:"bodyContains"`
+	// A regular expression that the response body must match.
+	BodyRegexp string `json:"bodyRegexp"`
+	// If given, only the match of BodyRegexp (or, on a mismatch, the
+	// location where it came closest to matching) with this many bytes
+	// of context on either side is kept as output, rather than the full
+	// body (0 means the full body).
+	BodyRegexpContext int `json:"bodyRegexpContext"`
+	// Optional assertions on a JSON response body: maps a dotted path
+	// (for example, "db.status") to the value expected at that path.
+	JSONPath map[string]string `json:"jsonPath"`
+	// Optional assertion on a health endpoint that reports the status of
+	// each of its dependencies in a JSON object.
+	Dependencies *HealthDependencies `json:"dependencies"`
+}
+
+// An HTTPStatusCode is an accepted HTTP status code: either a specific code
+// (such as "204", given as a number in JSON) or a class of codes (such as
+// "2xx").
+type HTTPStatusCode string
+
+// UnmarshalJSON implements the json.Unmarshaler interface for HTTPStatusCode.
+func (code *HTTPStatusCode) UnmarshalJSON(b []byte) error {
+	var number int
+	if err := json.Unmarshal(b, &number); err == nil {
+		*code = HTTPStatusCode(strconv.Itoa(number))
+		return nil
+	}
+	var class string
+	if err := json.Unmarshal(b, &class); err != nil {
+		return fmt.Errorf("status code must be a number or a class (such as \"2xx\"): %s", b)
+	}
+	*code = HTTPStatusCode(class)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface for HTTPStatusCode.
+func (code HTTPStatusCode) MarshalJSON() ([]byte, error) {
+	if number, err := strconv.Atoi(string(code)); err == nil {
+		return json.Marshal(number)
+	}
+	return json.Marshal(string(code))
+}
+
+// Validate validates an HTTPStatusCode.
+func (code HTTPStatusCode) Validate() error {
+	if number, err := strconv.Atoi(string(code)); err == nil {
+		if !ValidHTTPStatusCode(number) {
+			return fmt.Errorf("illegal status code: %d", number)
+		}
+		return nil
+	}
+	if !httpStatusClass.MatchString(string(code)) {
+		return fmt.Errorf("illegal status code class: '%s' (must be one of 1xx to 5xx)", code)
+	}
+	return nil
+}
+
+// Matches returns true if a status code is, or is of the class of, the
+// HTTPStatusCode.
+func (code HTTPStatusCode) Matches(statusCode int) bool {
+	if number, err := strconv.Atoi(string(code)); err == nil {
+		return number == statusCode
+	}
+	return httpStatusClass.MatchString(string(code)) && int(code[0]-'0') == statusCode/100
+}
+
+// Accepts returns true if a status code is accepted by the HTTPExpectation.
+func (expect *HTTPExpectation) Accepts(statusCode int) bool {
+	if expect.StatusCode != 0 && expect.StatusCode == statusCode {
+		return true
+	}
+	for _, code := range expect.StatusCodes {
+		if code.Matches(statusCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepted describes the status codes accepted by the HTTPExpectation (for
+// example "200, 204, 3xx").
+func (expect *HTTPExpectation) Accepted() string {
+	var accepted []string
+	if expect.StatusCode != 0 {
+		accepted = append(accepted, strconv.Itoa(expect.StatusCode))
+	}
+	for _, code := range expect.StatusCodes {
+		accepted = append(accepted, string(code))
+	}
+	return strings.Join(accepted, ", ")
+}
+
+// HealthDependencies describes the JSON object, in the response of a health
+// endpoint, that maps each dependency of a service (such as "db") to its
+// status, and which dependencies that must be OK.
+type HealthDependencies struct {
+	// The dotted path to the object of dependency statuses (empty means
+	// the whole body).
+	Path string `json:"path"`
+	// The dependencies that must be OK (empty means all of them).
+	Names []string `json:"names"`
+	// The status of an OK dependency. Default: "ok".
+	OKStatus string `json:"okStatus"`
+}
+
+// SSHTarget describes an SSH server to connect to and how to connect to it.
+// It is shared by the checks of all SSH-based pingers.
+type SSHTarget struct {
+	Host    string    `json:"host"`
+	Port    int       `json:"port"`
+	Auth    SSHAuth   `json:"auth"`
+	Timeout *Duration `json:"timeout"`
+	// The longest time that a command may run. Default: 1m.
+	CommandTimeout *Duration `json:"commandTimeout"`
+	// Paths to public keys of certificate authorities trusted to sign
+	// host certificates. If given, the server must present a host
+	// certificate signed by one of these.
+	TrustedCAKeys []string `json:"trustedCAKeys"`
+	// The network to connect over: tcp (default), tcp4, or tcp6.
+	Network string `json:"network"`
+	// A SOCKS5 proxy (host:port) to connect to the SSH server through.
+	Socks5Proxy string `json:"socks5Proxy"`
+	// SHA-256 fingerprints (of form SHA256:<base64> or SHA256:<hex>) of the
+	// host keys that the server is accepted with. Whitespace, base64
+	// padding, colons between hex digits, and the case of the prefix and
+	// of hex digits are ignored.
+	TrustedFingerprints []string `json:"trustedFingerprints"`
+	// A known_hosts file to verify the host key of the server against.
+	KnownHostsFile string `json:"knownHostsFile"`
+	// If true, the host key of the server is not verified at all (which
+	// leaves the connection open to man-in-the-middle attacks).
+	InsecureIgnoreHostKey bool `json:"insecureIgnoreHostKey"`
+	// If true, a single connection to the server is kept open and reused
+	// across pings (each ping runs in a new session on it).
+	KeepAlive bool `json:"keepAlive"`
 }
 
 // SSHCheck descibres a check for an SSH pinger.
 type SSHCheck struct {
-	Host        string         `json:"host"`
-	Port        int            `json:"port"`
-	Auth        SSHAuth        `json:"auth"`
+	SSHTarget
 	Command     string         `json:"command"`
 	CommandFile string         `json:"commandFile"`
 	Expect      SSHExpectation `json:"expect"`
-	Timeout     *Duration      `json:"timeout"`
+	// If true, an alert is sent whenever the command output differs from
+	// that of the previous ping.
+	AlertOnOutputChange bool `json:"alertOnOutputChange"`
+	// A regular expression that the identification string of the server
+	// (such as "SSH-2.0-OpenSSH_8.9p1") must match.
+	ExpectServerVersion string `json:"expectServerVersion"`
+}
+
+// ServiceCheck describes a check for a service pinger, which verifies over
+// SSH that a service is running on a remote server.
+type ServiceCheck struct {
+	SSHTarget
+	// The name of the service (for systemd, the unit, such as
+	// "docker.service").
+	Unit string `json:"unit"`
+	// The service manager of the server: systemd (default) or sysv.
+	Manager string `json:"manager"`
+}
+
+// DiskCheck describes a check for a disk pinger, which verifies over SSH that
+// a file system on a remote server has enough free space.
+type DiskCheck struct {
+	SSHTarget
+	// A path on the file system to check (such as its mount point).
+	Path string `json:"path"`
+	// The least free space allowed, as a percentage of the file system
+	// size.
+	MinFreePercent float64 `json:"minFreePercent"`
+	// The least free space allowed, in bytes.
+	MinFreeBytes int64 `json:"minFreeBytes"`
 }
 
 // SSHAuth describes how to authenticate for an SSHCheck. Either
@@ -97,24 +537,155 @@ type SSHAuth struct {
 	Password *string `json:"password"`
 	Key      *string `json:"key"`
 	Agent    bool    `json:"agent"`
+	// The passphrase that the private Key is encrypted with (if any).
+	KeyPassphrase *string `json:"keyPassphrase"`
 }
 
 // SSHExpectation is the expected exit code of the script in order for
 // a SSHCheck to be deemed successful.
 type SSHExpectation struct {
 	ExitCode int `json:"exitCode"`
+	// Optional assertions on JSON output: maps a dotted path (for example,
+	// "db.status") to the value expected at that path.
+	JSONPath map[string]string `json:"jsonPath"`
+	// Regular expressions that the output must not match. A match fails
+	// the check even if the exit code is as expected.
+	OutputForbidden []string `json:"outputForbidden"`
+}
+
+// DNSSerialCheck describes a check for a DNS serial pinger, which compares
+// the SOA serial of a zone as reported by a set of name servers (for example,
+// a primary and its secondaries).
+type DNSSerialCheck struct {
+	// The zone whose SOA serial to check (for example, "example.com").
+	Zone string `json:"zone"`
+	// The name servers to query, each given as host or host:port.
+	Servers []string `json:"servers"`
+	// The largest allowed difference between the serials of any two
+	// name servers.
+	Tolerance uint32 `json:"tolerance"`
+	// Timeout for each query.
+	Timeout *Duration `json:"timeout"`
+}
+
+// GRPCCheck describes a check for a gRPC pinger, which uses the standard gRPC
+// health checking protocol (grpc.health.v1.Health).
+type GRPCCheck struct {
+	// The server to check, as host:port.
+	Target string `json:"target"`
+	// The service whose health to check. If empty, the overall health of
+	// the server is checked.
+	ServiceName string `json:"serviceName"`
+	// If true, connect over TLS.
+	TLS bool `json:"tls"`
+	// Timeout for the health check call (including connecting).
+	Timeout *Duration `json:"timeout"`
+}
+
+// ICMPCheck describes a check for an ICMP pinger, which sends ICMP echo
+// requests to a host.
+type ICMPCheck struct {
+	// The host (name or IP address) to ping.
+	Host string `json:"host"`
+	// The number of echo requests to send per ping.
+	Count int `json:"count"`
+	// How long to wait for each echo reply.
+	Timeout *Duration `json:"timeout"`
+	// The largest allowed packet loss, as a percentage of the echo
+	// requests sent.
+	MaxPacketLoss float64 `json:"maxPacketLoss"`
 }
 
 // Alerter describes how to configure alerting.
 type Alerter struct {
+	// If given, the externally reachable base URL of the watcher (such as
+	// the URL of a virtual IP in front of highly available watchers) to
+	// advertise in alerts. It is used as is, rather than being made up of
+	// AdvertisedScheme, AdvertisedIP, AdvertisedPort and
+	// AdvertisedPathPrefix (which must then be left out).
+	AdvertisedURL string `json:"advertisedURL"`
 	// The externally reachable IP address to advertise in alerts.
 	AdvertisedIP string `json:"advertisedIP"`
 	// The watcher port to advertise in alerts.
 	AdvertisedPort int `json:"advertisedPort"`
+	// The URL scheme (http or https) to advertise in alerts.
+	AdvertisedScheme string `json:"advertisedScheme"`
+	// A path prefix to advertise in alerts, for when watcher is reached via
+	// a reverse proxy at a certain path (for example, "/watcher").
+	AdvertisedPathPrefix string `json:"advertisedPathPrefix"`
 	// Delay between reminders on pings that fail repeatedly.
 	ReminderDelay Duration `json:"reminderDelay"`
+	// How long to remember the latest alert of a pinger that is no longer
+	// failing. Default: 24h.
+	AlertHistoryTTL *Duration `json:"alertHistoryTTL"`
 	// An email alerter to use (or nil).
 	Email *Email `json:"email"`
+	// A Slack alerter to use (or nil).
+	Slack *Slack `json:"slack"`
+	// A PagerDuty alerter to use (or nil).
+	PagerDuty *PagerDuty `json:"pagerDuty"`
+	// If given, alerts also carry the error in a normalized form, with
+	// volatile parts (such as ports) masked, to deduplicate on.
+	ErrorNormalization *ErrorNormalization `json:"errorNormalization"`
+	// If given, failed alert deliveries are retried with exponential
+	// backoff. Default: no retries.
+	Retry *AlertRetry `json:"retry"`
+	// If given, a file that alerts that could not be delivered are
+	// appended to (as JSON lines).
+	DeadLetterFile string `json:"deadLetterFile"`
+	// Additional alerters that only receive the alerts of pingers that
+	// select them (by name).
+	Named []NamedAlerter `json:"named"`
+	// If given, a pinger that changes state too often is alerted on once
+	// as flapping, rather than on every state transition.
+	FlapDetection *FlapDetection `json:"flapDetection"`
+}
+
+// FlapDetection configures when a pinger is considered flapping.
+type FlapDetection struct {
+	// The number of state transitions within the window that makes a
+	// pinger flapping. A flapping pinger stops flapping once it has not
+	// changed state for a window.
+	Transitions int `json:"transitions"`
+	// The window. Default: 10m.
+	Window *Duration `json:"window"`
+}
+
+// The names by which pingers select the email, slack and pagerDuty alerters
+// of an Alerter configuration.
+const (
+	EmailAlerterName     = "email"
+	SlackAlerterName     = "slack"
+	PagerDutyAlerterName = "pagerDuty"
+)
+
+// A NamedAlerter is an alerter (exactly one of Email, Slack and PagerDuty)
+// that pingers can route their alerts to by name, such as a separate channel
+// for database alerts.
+type NamedAlerter struct {
+	Name      string     `json:"name"`
+	Email     *Email     `json:"email"`
+	Slack     *Slack     `json:"slack"`
+	PagerDuty *PagerDuty `json:"pagerDuty"`
+}
+
+// AlertRetry configures how failed alert deliveries are retried.
+type AlertRetry struct {
+	// The number of times to retry a failed delivery.
+	Retries int `json:"retries"`
+	// The delay before the first retry, which is doubled for every
+	// subsequent retry. Default: 1s.
+	Backoff *Duration `json:"backoff"`
+	// The longest delay between two retries. Default: 5m.
+	MaxBackoff *Duration `json:"maxBackoff"`
+}
+
+// ErrorNormalization configures how errors are normalized for alerts.
+// Ports, IP addresses and timestamps are always masked.
+type ErrorNormalization struct {
+	// Regular expressions for additional parts of errors to mask (such
+	// as request IDs).
+	Patterns []string `json:"patterns"`
 }
 
 // Email alerter configuration.
@@ -124,6 +695,32 @@ type Email struct {
 	Auth     *EmailAuth `json:"auth"`
 	From     string     `json:"from"`
 	To       []string   `json:"to"`
+	// A text/template for the message body (see alerter.TemplateData).
+	// Default: the pinger update as JSON.
+	Template string `json:"template"`
+}
+
+// Slack alerter configuration.
+type Slack struct {
+	// The URL of the Slack incoming webhook to post alerts to.
+	WebhookURL string `json:"webhookURL"`
+	// Overrides the default channel of the webhook (if given).
+	Channel string `json:"channel"`
+	// Overrides the default username of the webhook (if given).
+	Username string `json:"username"`
+	// Overrides the default icon of the webhook (if given), for example
+	// ":rotating_light:".
+	IconEmoji string `json:"iconEmoji"`
+}
+
+// PagerDuty alerter configuration.
+type PagerDuty struct {
+	// The integration (routing) key of a PagerDuty Events API v2
+	// integration.
+	RoutingKey string `json:"routingKey"`
+	// The severity of triggered incidents: critical, error, warning or
+	// info. Default: error.
+	Severity string `json:"severity"`
 }
 
 // EmailAuth describes how to authenticate to a SMTP host.
@@ -151,13 +748,63 @@ func (d Duration) MarshalJSON() (b []byte, err error) {
 
 // Validate validates an Engine.
 func (engine *Engine) Validate() error {
+	if engine.MaxSSHConnectionsPerHost < 0 {
+		return fmt.Errorf("engine: maxSSHConnectionsPerHost must not be negative")
+	}
+
 	if engine.DefaultSchedule != nil {
 		if err := engine.DefaultSchedule.Validate(); err != nil {
 			return fmt.Errorf("engine: %s", err)
 		}
 	}
 
+	if engine.MaxConsecutive < 0 {
+		return fmt.Errorf("engine: maxConsecutive must not be negative")
+	}
+	if engine.MinInterval != nil && engine.MinInterval.Duration < 0 {
+		return fmt.Errorf("engine: minInterval must not be negative")
+	}
+	if engine.DrainTimeout != nil && engine.DrainTimeout.Duration < 0 {
+		return fmt.Errorf("engine: drainTimeout must not be negative")
+	}
+	if engine.HistorySize != nil && *engine.HistorySize < 0 {
+		return fmt.Errorf("engine: historySize must not be negative")
+	}
+	if engine.StatusWebhook != nil {
+		if err := engine.StatusWebhook.Validate(); err != nil {
+			return fmt.Errorf("engine: %s", err)
+		}
+	}
+	if engine.Liveness != nil {
+		if err := engine.Liveness.Validate(); err != nil {
+			return fmt.Errorf("engine: %s", err)
+		}
+	}
+	if engine.MaxConsecutive > 0 {
+		if err := engine.validateMaxConsecutive(); err != nil {
+			return fmt.Errorf("engine: %s", err)
+		}
+	}
+
+	if err := validateLabels(engine.GlobalLabels); err != nil {
+		return fmt.Errorf("engine: globalLabels: %s", err)
+	}
+
+	vantageNames := make(map[string]bool)
+	for _, vantage := range engine.Vantages {
+		if vantageNames[vantage.Name] {
+			return fmt.Errorf("engine: vantage name '%s' is used multiple times -- vantage names must be unique", vantage.Name)
+		}
+		vantageNames[vantage.Name] = true
+
+		if err := vantage.Validate(); err != nil {
+			return fmt.Errorf("engine: %s", err)
+		}
+	}
+
+	alerterNames := engine.Alerter.Names()
 	takenNames := make(map[string]bool)
+	takenIDs := make(map[string]bool)
 	for _, pinger := range engine.Pingers {
 		// enforce name uniqueness.
 		// note: map retrieval on missing key yields zero-value (false)
@@ -166,17 +813,157 @@ func (engine *Engine) Validate() error {
 		}
 		takenNames[pinger.Name] = true
 
-		if err := pinger.Validate(); err != nil {
+		if takenIDs[pinger.PingerID()] {
+			return fmt.Errorf("engine: pinger id '%s' is used multiple times -- pinger ids must be unique", pinger.PingerID())
+		}
+		takenIDs[pinger.PingerID()] = true
+
+		if err := pinger.validateIn(vantageNames, alerterNames); err != nil {
 			return fmt.Errorf("engine: %s", err)
 		}
 	}
 
+	if err := engine.validateRunIf(); err != nil {
+		return fmt.Errorf("engine: %s", err)
+	}
+
 	if engine.Alerter != nil {
 		if err := engine.Alerter.Validate(); err != nil {
 			return fmt.Errorf("engine: %s", err)
 		}
 	}
 
+	if engine.HA != nil {
+		if err := engine.HA.Validate(); err != nil {
+			return fmt.Errorf("engine: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// validateRunIf verifies that the runIf of each pinger refers to another
+// pinger and that no pingers depend on each other in a cycle.
+func (engine *Engine) validateRunIf() error {
+	runIf := make(map[string]string)
+	for _, pinger := range engine.Pingers {
+		runIf[pinger.Name] = pinger.RunIf
+	}
+	for _, pinger := range engine.Pingers {
+		if pinger.RunIf == "" {
+			continue
+		}
+		if _, ok := runIf[pinger.RunIf]; !ok {
+			return fmt.Errorf("pinger '%s': runIf: no such pinger: '%s'", pinger.Name, pinger.RunIf)
+		}
+		// follow the chain of prerequisites (which can be no longer than
+		// the number of pingers unless it is a cycle)
+		name := pinger.Name
+		for i := 0; i < len(engine.Pingers) && runIf[name] != ""; i++ {
+			name = runIf[name]
+			if name == pinger.Name {
+				return fmt.Errorf("pinger '%s': runIf: cyclic dependency", pinger.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateMaxConsecutive verifies that MaxConsecutive does not prevent any
+// pinger from reaching its failure threshold.
+func (engine *Engine) validateMaxConsecutive() error {
+	schedules := []*Schedule{engine.DefaultSchedule}
+	for _, pinger := range engine.Pingers {
+		schedules = append(schedules, pinger.Schedule)
+	}
+	for _, schedule := range schedules {
+		if schedule != nil && schedule.FailureThreshold > engine.MaxConsecutive {
+			return fmt.Errorf("maxConsecutive (%d) must not be less than any failureThreshold (%d)", engine.MaxConsecutive, schedule.FailureThreshold)
+		}
+	}
+	return nil
+}
+
+// SkipInvalidPingers removes the pingers with invalid configurations from the
+// Engine configuration and returns the validation errors of the removed
+// pingers (keyed on pinger name). This lets the remaining pingers run even
+// though some pingers are misconfigured.
+func (engine *Engine) SkipInvalidPingers() map[string]error {
+	vantageNames := make(map[string]bool)
+	for _, vantage := range engine.Vantages {
+		vantageNames[vantage.Name] = true
+	}
+	alerterNames := engine.Alerter.Names()
+
+	invalid := make(map[string]error)
+	var valid []Pinger
+	for _, pinger := range engine.Pingers {
+		if err := pinger.validateIn(vantageNames, alerterNames); err != nil {
+			invalid[pinger.Name] = err
+			continue
+		}
+		valid = append(valid, pinger)
+	}
+
+	// pingers that depend on a skipped pinger cannot run either
+	for removed := true; removed; {
+		removed = false
+		var remaining []Pinger
+		for _, pinger := range valid {
+			if _, skipped := invalid[pinger.RunIf]; pinger.RunIf != "" && skipped {
+				invalid[pinger.Name] = fmt.Errorf("pinger '%s': runIf: pinger '%s' was skipped", pinger.Name, pinger.RunIf)
+				removed = true
+				continue
+			}
+			remaining = append(remaining, pinger)
+		}
+		valid = remaining
+	}
+	engine.Pingers = valid
+	return invalid
+}
+
+// validateIn validates a Pinger and verifies that its vantages and alerters
+// are among sets of defined vantage and alerter names.
+func (pinger *Pinger) validateIn(vantageNames, alerterNames map[string]bool) error {
+	for _, vantage := range pinger.Vantages {
+		if !vantageNames[vantage] {
+			return fmt.Errorf("pinger '%s': undefined vantage: '%s'", pinger.Name, vantage)
+		}
+	}
+	for _, name := range pinger.Alerters {
+		if !alerterNames[name] {
+			return fmt.Errorf("pinger '%s': undefined alerter: '%s'", pinger.Name, name)
+		}
+	}
+	return pinger.Validate()
+}
+
+// Validate validates a Hooks configuration.
+func (hooks *Hooks) Validate() error {
+	if hooks.OnDown == "" && hooks.OnUp == "" {
+		return fmt.Errorf("hooks: neither onDown nor onUp given")
+	}
+	if hooks.Timeout != nil && hooks.Timeout.Duration <= 0 {
+		return fmt.Errorf("hooks: timeout must be positive")
+	}
+	return nil
+}
+
+// reservedLabelKeys are the label keys that are set on metrics by the watcher
+// itself and cannot be used as pinger labels.
+var reservedLabelKeys = map[string]bool{"name": true, "result": true, "le": true, "error": true}
+
+// validateLabels verifies that a set of labels has valid keys.
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if !validLabelKey.MatchString(key) {
+			return fmt.Errorf("illegal key: '%s' (must be of form '%s')", key, validLabelKey)
+		}
+		if reservedLabelKeys[key] {
+			return fmt.Errorf("illegal key: '%s' (name, result, le and error are reserved)", key)
+		}
+	}
 	return nil
 }
 
@@ -191,6 +978,40 @@ func (pinger *Pinger) Validate() error {
 		return fmt.Errorf("pinger: illegal name: '%s' (must be of form '%s')", pinger.Name, validPingerName)
 	}
 
+	if pinger.ID != "" && !ValidPingerName(pinger.ID) {
+		return fmt.Errorf("pinger '%s': illegal id: '%s' (must be of form '%s')", pinger.Name, pinger.ID, validPingerName)
+	}
+
+	switch pinger.AssumeInitialState {
+	case "", "ok", "nok", "unknown":
+	default:
+		return fmt.Errorf("pinger '%s': illegal assumeInitialState: '%s' (must be one of ok, nok, and unknown)", pinger.Name, pinger.AssumeInitialState)
+	}
+
+	if err := validateLabels(pinger.Labels); err != nil {
+		return fmt.Errorf("pinger '%s': labels: %s", pinger.Name, err)
+	}
+
+	for _, group := range pinger.Groups {
+		if !ValidPingerName(group) {
+			return fmt.Errorf("pinger '%s': illegal group: '%s' (must be of form '%s')", pinger.Name, group, validPingerName)
+		}
+	}
+
+	if pinger.Weight != nil && *pinger.Weight < 0 {
+		return fmt.Errorf("pinger '%s': weight must not be negative", pinger.Name)
+	}
+
+	if pinger.MaxAlerts < 0 {
+		return fmt.Errorf("pinger '%s': maxAlerts must not be negative", pinger.Name)
+	}
+
+	if pinger.Hooks != nil {
+		if err := pinger.Hooks.Validate(); err != nil {
+			return fmt.Errorf("pinger '%s': %s", pinger.Name, err)
+		}
+	}
+
 	if pinger.Type == "" {
 		return fmt.Errorf("pinger '%s': missing type", pinger.Name)
 	}
@@ -206,9 +1027,120 @@ func (pinger *Pinger) Validate() error {
 		}
 	}
 
+	if pinger.BusinessHours != nil {
+		if err := pinger.BusinessHours.Validate(); err != nil {
+			return fmt.Errorf("pinger '%s': %s", pinger.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates a Vantage.
+func (vantage *Vantage) Validate() error {
+	if !ValidPingerName(vantage.Name) {
+		return fmt.Errorf("vantage: illegal name: '%s' (must be of form '%s')", vantage.Name, validPingerName)
+	}
+	if vantage.SourceAddress == "" && vantage.Socks5Proxy == "" {
+		return fmt.Errorf("vantage '%s': neither sourceAddress nor socks5Proxy given", vantage.Name)
+	}
+	if vantage.SourceAddress != "" && net.ParseIP(vantage.SourceAddress) == nil {
+		return fmt.Errorf("vantage '%s': sourceAddress: illegal IP address: '%s'", vantage.Name, vantage.SourceAddress)
+	}
+	if vantage.Socks5Proxy != "" {
+		if _, _, err := net.SplitHostPort(vantage.Socks5Proxy); err != nil {
+			return fmt.Errorf("vantage '%s': socks5Proxy: must be of form host:port: '%s'", vantage.Name, vantage.Socks5Proxy)
+		}
+	}
+	return nil
+}
+
+// Validate validates an HA configuration.
+func (ha *HA) Validate() error {
+	if ha.LockFile == "" {
+		return fmt.Errorf("ha: missing lockFile")
+	}
+	if ha.LeaseDuration != nil && ha.LeaseDuration.Duration <= 0 {
+		return fmt.Errorf("ha: leaseDuration must be positive")
+	}
+	return nil
+}
+
+// Validate validates a BusinessHours window.
+func (hours *BusinessHours) Validate() error {
+	if _, err := time.Parse(clockFormat, hours.Start); err != nil {
+		return fmt.Errorf("businessHours: start: must be of form HH:MM: '%s'", hours.Start)
+	}
+	if _, err := time.Parse(clockFormat, hours.End); err != nil {
+		return fmt.Errorf("businessHours: end: must be of form HH:MM: '%s'", hours.End)
+	}
+	if hours.Start == hours.End {
+		return fmt.Errorf("businessHours: start and end must differ")
+	}
+	for _, day := range hours.Weekdays {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("businessHours: illegal weekday: '%s' (must be one of Mon, Tue, Wed, Thu, Fri, Sat, Sun)", day)
+		}
+	}
+	if _, err := hours.location(); err != nil {
+		return fmt.Errorf("businessHours: timezone: %s", err)
+	}
 	return nil
 }
 
+// location returns the time zone of the BusinessHours.
+func (hours *BusinessHours) location() (*time.Location, error) {
+	if hours.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(hours.Timezone)
+}
+
+// Contains returns true if a given point in time falls within the
+// BusinessHours window. The BusinessHours are assumed to be valid.
+func (hours *BusinessHours) Contains(t time.Time) bool {
+	location, err := hours.location()
+	if err != nil {
+		return true
+	}
+	t = t.In(location)
+	start, _ := time.Parse(clockFormat, hours.Start)
+	end, _ := time.Parse(clockFormat, hours.End)
+	startOfDay := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	sinceMidnight := t.Sub(startOfDay(t))
+	startOffset := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+	endOffset := time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute
+
+	// the day on which the window (that t possibly falls in) started
+	day := t.Weekday()
+	if startOffset < endOffset {
+		if sinceMidnight < startOffset || sinceMidnight >= endOffset {
+			return false
+		}
+	} else {
+		// window spans midnight
+		switch {
+		case sinceMidnight >= startOffset:
+		case sinceMidnight < endOffset:
+			day = t.AddDate(0, 0, -1).Weekday()
+		default:
+			return false
+		}
+	}
+
+	if len(hours.Weekdays) == 0 {
+		return day != time.Saturday && day != time.Sunday
+	}
+	for _, name := range hours.Weekdays {
+		if weekdays[name] == day {
+			return true
+		}
+	}
+	return false
+}
+
 // Validate validates a Schedule.
 func (schedule *Schedule) Validate() error {
 	if schedule.Interval == nil {
@@ -223,6 +1155,27 @@ func (schedule *Schedule) Validate() error {
 		return fmt.Errorf("schedule: %s", err)
 	}
 
+	if schedule.ReportInterval != nil && schedule.ReportInterval.Duration < schedule.Interval.Duration {
+		return fmt.Errorf("schedule: reportInterval must not be shorter than interval")
+	}
+
+	if schedule.FailureThreshold < 0 {
+		return fmt.Errorf("schedule: failureThreshold must not be negative")
+	}
+
+	if window := schedule.FailureWindow; window != nil {
+		if window.Failures < 1 || window.Pings < window.Failures {
+			return fmt.Errorf("schedule: failureWindow: must have 1 <= failures <= pings")
+		}
+		if schedule.FailureThreshold > 1 || schedule.Retries.CountAttempts {
+			return fmt.Errorf("schedule: failureWindow cannot be combined with failureThreshold or countAttempts")
+		}
+	}
+
+	if schedule.Jitter != nil && schedule.Jitter.Duration <= 0 {
+		return fmt.Errorf("schedule: jitter must be positive")
+	}
+
 	return nil
 }
 
@@ -231,24 +1184,118 @@ func (retry *Retries) Validate() error {
 	if retry.Attempts < 0 {
 		return fmt.Errorf("retries: attempts must be a positive number")
 	}
+	if retry.Concurrency < 0 {
+		return fmt.Errorf("retries: concurrency must not be negative")
+	}
 
 	return nil
 }
 
+// HTTPMethod returns the HTTP method of the requests of a HTTPCheck.
+func (check *HTTPCheck) HTTPMethod() string {
+	if check.Method == "" {
+		return http.MethodGet
+	}
+	return check.Method
+}
+
 // Validate validates a HTTPCheck.
 func (check *HTTPCheck) Validate() error {
-	if _, err := url.Parse(check.URL); err != nil {
-		return fmt.Errorf("http check: invalid URL: %s", err)
+	// exactly one of URL and URLs must be specified
+	if check.URL == "" && len(check.URLs) == 0 {
+		return fmt.Errorf("http check: neither url nor urls given")
+	}
+	if check.URL != "" && len(check.URLs) > 0 {
+		return fmt.Errorf("http check: only one of url and urls is allowed, not both")
+	}
+	checkURLs := check.URLs
+	if check.URL != "" {
+		checkURLs = []string{check.URL}
+	}
+	for _, checkURL := range checkURLs {
+		parsed, err := url.Parse(checkURL)
+		if err != nil {
+			return fmt.Errorf("http check: invalid URL: %s", err)
+		}
+		if check.CheckOCSP && parsed.Scheme != "https" {
+			return fmt.Errorf("http check: checkOCSP requires an https URL: '%s'", checkURL)
+		}
 	}
 	if check.BasicAuth != nil {
 		if err := check.BasicAuth.Validate(); err != nil {
 			return fmt.Errorf("http check: %s", err)
 		}
 	}
+	if (check.ClientCertFile == "") != (check.ClientKeyFile == "") {
+		return fmt.Errorf("http check: clientCertFile and clientKeyFile must be given together")
+	}
+	if check.ClientCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(check.ClientCertFile, check.ClientKeyFile); err != nil {
+			return fmt.Errorf("http check: failed to load client certificate: %s", err)
+		}
+	}
+	if check.Tracing != "" && check.Tracing != "w3c" && check.Tracing != "b3" {
+		return fmt.Errorf("http check: tracing: must be one of w3c and b3: '%s'", check.Tracing)
+	}
+	if check.CACertFile != "" {
+		pem, err := ioutil.ReadFile(check.CACertFile)
+		if err != nil {
+			return fmt.Errorf("http check: failed to read caCertFile: %s", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fmt.Errorf("http check: caCertFile: no certificates found in %s", check.CACertFile)
+		}
+	}
+	if check.Method != "" && !httpMethods[check.Method] {
+		return fmt.Errorf("http check: method: not a recognized HTTP method: '%s'", check.Method)
+	}
+	if check.Body != "" && (check.Method == "" || check.Method == http.MethodGet || check.Method == http.MethodHead) {
+		return fmt.Errorf("http check: body: not allowed for %s requests (set method to, for example, POST)", check.HTTPMethod())
+	}
+	for name := range check.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("http check: headers: illegal header name: '%s'", name)
+		}
+	}
 
-	if err := check.Expect.Validate(); err != nil {
+	if check.Expr != "" {
+		if _, err := expr.Compile(check.Expr); err != nil {
+			return fmt.Errorf("http check: illegal expr: %s", err)
+		}
+	} else if err := check.Expect.Validate(); err != nil {
 		return fmt.Errorf("http check: %s", err)
 	}
+	for statusCode, expect := range check.ExpectOnStatus {
+		if expect.StatusCode != 0 && expect.StatusCode != statusCode {
+			return fmt.Errorf("http check: expectOnStatus: %d: statusCode must be left out or be %d", statusCode, statusCode)
+		}
+		if len(expect.StatusCodes) > 0 {
+			return fmt.Errorf("http check: expectOnStatus: %d: statusCodes must be left out", statusCode)
+		}
+		// the status code is implied by the key
+		expect.StatusCode = statusCode
+		if err := expect.Validate(); err != nil {
+			return fmt.Errorf("http check: expectOnStatus: %d: %s", statusCode, err)
+		}
+	}
+
+	if !ValidNetwork(check.Network) {
+		return fmt.Errorf("http check: illegal network: '%s'", check.Network)
+	}
+
+	if check.MaxOutputBytes < 0 {
+		return fmt.Errorf("http check: maxOutputBytes must not be negative")
+	}
+
+	if check.Prewarm != nil && check.Prewarm.Duration <= 0 {
+		return fmt.Errorf("http check: prewarm must be positive")
+	}
+
+	for _, statusCode := range check.RetryOnStatus {
+		if !ValidHTTPStatusCode(statusCode) {
+			return fmt.Errorf("http check: retryOnStatus: illegal status code: %d", statusCode)
+		}
+	}
 
 	return nil
 }
@@ -267,24 +1314,136 @@ func (auth *HTTPBasicAuth) Validate() error {
 
 // Validate validates a HTTPExpectation.
 func (expect *HTTPExpectation) Validate() error {
-	if !ValidHTTPStatusCode(expect.StatusCode) {
+	if expect.StatusCode == 0 && len(expect.StatusCodes) == 0 {
+		return fmt.Errorf("http expect: no statusCode or statusCodes given")
+	}
+	if expect.StatusCode != 0 && !ValidHTTPStatusCode(expect.StatusCode) {
 		return fmt.Errorf("http expect: illegal statusCode: %d", expect.StatusCode)
 	}
+	for _, code := range expect.StatusCodes {
+		if err := code.Validate(); err != nil {
+			return fmt.Errorf("http expect: statusCodes: %s", err)
+		}
+	}
+	for _, cookie := range expect.Cookies {
+		if strings.TrimSpace(cookie) == "" {
+			return fmt.Errorf("http expect: empty cookie name")
+		}
+	}
+	if expect.MinBodyBytes < 0 || expect.MaxBodyBytes < 0 {
+		return fmt.Errorf("http expect: minBodyBytes and maxBodyBytes must not be negative")
+	}
+	if expect.MaxBodyBytes > 0 && expect.MaxBodyBytes < expect.MinBodyBytes {
+		return fmt.Errorf("http expect: maxBodyBytes must not be less than minBodyBytes")
+	}
+	for _, substring := range expect.BodyContains {
+		if substring == "" {
+			return fmt.Errorf("http expect: empty bodyContains string")
+		}
+	}
+	if expect.BodyRegexp != "" {
+		if _, err := regexp.Compile(expect.BodyRegexp); err != nil {
+			return fmt.Errorf("http expect: bodyRegexp: illegal regular expression: %s", err)
+		}
+	}
+	if expect.BodyRegexpContext < 0 {
+		return fmt.Errorf("http expect: bodyRegexpContext must not be negative")
+	}
+	if expect.BodyRegexpContext > 0 && expect.BodyRegexp == "" {
+		return fmt.Errorf("http expect: bodyRegexpContext requires a bodyRegexp")
+	}
+	for path := range expect.JSONPath {
+		if !ValidJSONPath(path) {
+			return fmt.Errorf("http expect: jsonPath: illegal path: '%s'", path)
+		}
+	}
+	if expect.Dependencies != nil {
+		if err := expect.Dependencies.Validate(); err != nil {
+			return fmt.Errorf("http expect: dependencies: %s", err)
+		}
+	}
 	return nil
 }
 
-// Validate validates a SSHCheck.
-func (check *SSHCheck) Validate() error {
+// Validate validates a HealthDependencies.
+func (dependencies *HealthDependencies) Validate() error {
+	if dependencies.Path != "" && !ValidJSONPath(dependencies.Path) {
+		return fmt.Errorf("illegal path: '%s'", dependencies.Path)
+	}
+	for _, name := range dependencies.Names {
+		if name == "" {
+			return fmt.Errorf("empty dependency name")
+		}
+	}
+	return nil
+}
 
-	if !ValidHostOrIpAddr(check.Host) {
-		return fmt.Errorf("ssh check: illegal host: '%s'", check.Host)
+// Validate validates an SSHTarget.
+func (target *SSHTarget) Validate() error {
+	if !ValidHostOrIpAddr(target.Host) {
+		return fmt.Errorf("illegal host: '%s'", target.Host)
 	}
 
-	if !ValidPort(check.Port) {
-		return fmt.Errorf("ssh check: illegal port: '%d'", check.Port)
+	if !ValidPort(target.Port) {
+		return fmt.Errorf("illegal port: '%d'", target.Port)
 	}
 
-	if err := check.Auth.Validate(); err != nil {
+	if err := target.Auth.Validate(); err != nil {
+		return err
+	}
+
+	if !ValidNetwork(target.Network) {
+		return fmt.Errorf("illegal network: '%s'", target.Network)
+	}
+
+	if target.CommandTimeout != nil && target.CommandTimeout.Duration <= 0 {
+		return fmt.Errorf("commandTimeout must be positive")
+	}
+
+	for _, caKey := range target.TrustedCAKeys {
+		if _, err := os.Stat(caKey); err != nil {
+			return fmt.Errorf("trusted CA key: %s", err)
+		}
+	}
+
+	for _, fingerprint := range target.TrustedFingerprints {
+		trimmed := strings.TrimSpace(fingerprint)
+		if len(trimmed) <= len("SHA256:") || !strings.EqualFold(trimmed[:len("SHA256:")], "SHA256:") {
+			return fmt.Errorf("trusted fingerprint: must be of form SHA256:<base64>: '%s'", fingerprint)
+		}
+	}
+	if target.KnownHostsFile != "" {
+		if _, err := os.Stat(target.KnownHostsFile); err != nil {
+			return fmt.Errorf("known hosts file: %s", err)
+		}
+	}
+	verifiers := 0
+	for _, given := range []bool{len(target.TrustedCAKeys) > 0, len(target.TrustedFingerprints) > 0, target.KnownHostsFile != "", target.InsecureIgnoreHostKey} {
+		if given {
+			verifiers++
+		}
+	}
+	if verifiers > 1 {
+		return fmt.Errorf("only one of trustedCAKeys, trustedFingerprints, knownHostsFile, and insecureIgnoreHostKey is allowed")
+	}
+
+	if target.Socks5Proxy != "" {
+		host, port, err := net.SplitHostPort(target.Socks5Proxy)
+		if err != nil {
+			return fmt.Errorf("socks5Proxy: must be of form host:port: '%s'", target.Socks5Proxy)
+		}
+		if p, err := strconv.Atoi(port); err != nil || !ValidPort(p) || (!ValidHostOrIpAddr(host) && net.ParseIP(host) == nil) {
+			return fmt.Errorf("socks5Proxy: illegal proxy address: '%s'", target.Socks5Proxy)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates a SSHCheck.
+func (check *SSHCheck) Validate() error {
+
+	if err := check.SSHTarget.Validate(); err != nil {
 		return fmt.Errorf("ssh check: %s", err)
 	}
 
@@ -300,6 +1459,10 @@ func (check *SSHCheck) Validate() error {
 		return fmt.Errorf("ssh check: only one of command and commandFile is allowed, not both")
 	}
 
+	if _, err := regexp.Compile(check.ExpectServerVersion); err != nil {
+		return fmt.Errorf("ssh check: expectServerVersion: illegal regular expression: %s", err)
+	}
+
 	// validate that commandFile exists
 	if check.CommandFile != "" {
 		if _, err := os.Stat(check.CommandFile); err != nil {
@@ -310,6 +1473,46 @@ func (check *SSHCheck) Validate() error {
 	return nil
 }
 
+// Validate validates a DiskCheck.
+func (check *DiskCheck) Validate() error {
+	if err := check.SSHTarget.Validate(); err != nil {
+		return fmt.Errorf("disk check: %s", err)
+	}
+
+	if !strings.HasPrefix(check.Path, "/") {
+		return fmt.Errorf("disk check: path must be absolute: '%s'", check.Path)
+	}
+
+	if check.MinFreePercent < 0 || check.MinFreePercent > 100 {
+		return fmt.Errorf("disk check: minFreePercent must be in the range [0,100]")
+	}
+	if check.MinFreeBytes < 0 {
+		return fmt.Errorf("disk check: minFreeBytes must not be negative")
+	}
+	if check.MinFreePercent == 0 && check.MinFreeBytes == 0 {
+		return fmt.Errorf("disk check: neither minFreePercent nor minFreeBytes given")
+	}
+	return nil
+}
+
+// Validate validates a ServiceCheck.
+func (check *ServiceCheck) Validate() error {
+	if err := check.SSHTarget.Validate(); err != nil {
+		return fmt.Errorf("service check: %s", err)
+	}
+
+	if !validServiceUnit.MatchString(check.Unit) {
+		return fmt.Errorf("service check: illegal unit: '%s'", check.Unit)
+	}
+
+	switch check.Manager {
+	case "", "systemd", "sysv":
+	default:
+		return fmt.Errorf("service check: illegal manager: '%s' (must be one of systemd and sysv)", check.Manager)
+	}
+	return nil
+}
+
 // Validate validates an SSHAuth instance.
 func (auth *SSHAuth) Validate() error {
 	// ssh login name must be valid
@@ -321,6 +1524,10 @@ func (auth *SSHAuth) Validate() error {
 	if auth.Key == nil && auth.Password == nil && !auth.Agent {
 		return errors.New("auth: no auth method given (at least one of password, key, or agent auth must be specified)")
 	}
+
+	if auth.KeyPassphrase != nil && auth.Key == nil {
+		return errors.New("auth: keyPassphrase given without a key")
+	}
 	return nil
 }
 
@@ -329,16 +1536,102 @@ func (expect *SSHExpectation) Validate() error {
 	if expect.ExitCode < 0 || expect.ExitCode > 255 {
 		return errors.New("expect: exitCode must be in the range [0,255]")
 	}
+	for path := range expect.JSONPath {
+		if !ValidJSONPath(path) {
+			return fmt.Errorf("expect: jsonPath: illegal path: '%s'", path)
+		}
+	}
+	for _, pattern := range expect.OutputForbidden {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("expect: outputForbidden: illegal regular expression: %s", err)
+		}
+	}
+	return nil
+}
+
+// Validate validates a GRPCCheck.
+func (check *GRPCCheck) Validate() error {
+	host, port, err := net.SplitHostPort(check.Target)
+	if err != nil {
+		return fmt.Errorf("grpc check: target must be of form host:port: '%s'", check.Target)
+	}
+	if p, err := strconv.Atoi(port); err != nil || !ValidPort(p) || (!ValidHostOrIpAddr(host) && net.ParseIP(host) == nil) {
+		return fmt.Errorf("grpc check: illegal target: '%s'", check.Target)
+	}
+	return nil
+}
+
+// Validate validates an ICMPCheck.
+func (check *ICMPCheck) Validate() error {
+	if !ValidHostOrIpAddr(check.Host) && net.ParseIP(check.Host) == nil {
+		return fmt.Errorf("icmp check: illegal host: '%s'", check.Host)
+	}
+	if check.Count < 0 {
+		return fmt.Errorf("icmp check: count must not be negative")
+	}
+	if check.MaxPacketLoss < 0 || check.MaxPacketLoss > 100 {
+		return fmt.Errorf("icmp check: maxPacketLoss must be in the range [0,100]")
+	}
 	return nil
 }
 
+// Validate validates a DNSSerialCheck.
+func (check *DNSSerialCheck) Validate() error {
+	if check.Zone == "" {
+		return fmt.Errorf("dns serial check: missing zone")
+	}
+	if len(check.Servers) < 2 {
+		return fmt.Errorf("dns serial check: at least two servers are needed to compare serials")
+	}
+	for _, server := range check.Servers {
+		host := server
+		if h, port, err := net.SplitHostPort(server); err == nil {
+			host = h
+			if p, err := strconv.Atoi(port); err != nil || !ValidPort(p) {
+				return fmt.Errorf("dns serial check: illegal port in server: '%s'", server)
+			}
+		}
+		if !ValidHostOrIpAddr(host) && net.ParseIP(host) == nil {
+			return fmt.Errorf("dns serial check: illegal server: '%s'", server)
+		}
+	}
+	return nil
+}
+
+// AdvertisedBaseURL returns the base URL at which the watcher server is
+// advertised in alerts, taking into account the advertised scheme and path
+// prefix. The returned URL never ends with a slash.
+func (alerter *Alerter) AdvertisedBaseURL() string {
+	if alerter.AdvertisedURL != "" {
+		return strings.TrimRight(alerter.AdvertisedURL, "/")
+	}
+	baseURL := fmt.Sprintf("%s://%s:%d%s", alerter.AdvertisedScheme,
+		alerter.AdvertisedIP, alerter.AdvertisedPort, alerter.AdvertisedPathPrefix)
+	return strings.TrimRight(baseURL, "/")
+}
+
 // Validate validates an Alerter configuration.
 func (alerter *Alerter) Validate() error {
-	if !ValidHostOrIpAddr(alerter.AdvertisedIP) {
-		return fmt.Errorf("alerter: advertisedIP: illegal IP/hostname: '%s'", alerter.AdvertisedIP)
+	if alerter.AdvertisedURL != "" {
+		if err := alerter.validateAdvertisedURL(); err != nil {
+			return fmt.Errorf("alerter: %s", err)
+		}
+	} else {
+		if !ValidHostOrIpAddr(alerter.AdvertisedIP) {
+			return fmt.Errorf("alerter: advertisedIP: illegal IP/hostname: '%s'", alerter.AdvertisedIP)
+		}
+		if !ValidPort(alerter.AdvertisedPort) {
+			return fmt.Errorf("alerter: advertisedPort: illegal port: %d", alerter.AdvertisedPort)
+		}
+		if alerter.AdvertisedScheme != "http" && alerter.AdvertisedScheme != "https" {
+			return fmt.Errorf("alerter: advertisedScheme: must be one of http and https: '%s'", alerter.AdvertisedScheme)
+		}
+		if alerter.AdvertisedPathPrefix != "" && !strings.HasPrefix(alerter.AdvertisedPathPrefix, "/") {
+			return fmt.Errorf("alerter: advertisedPathPrefix: must start with '/': '%s'", alerter.AdvertisedPathPrefix)
+		}
 	}
-	if !ValidPort(alerter.AdvertisedPort) {
-		return fmt.Errorf("alerter: advertisedPort: illegal port: %d", alerter.AdvertisedPort)
+	if alerter.AlertHistoryTTL != nil && alerter.AlertHistoryTTL.Duration <= 0 {
+		return fmt.Errorf("alerter: alertHistoryTTL: must be positive: %s", alerter.AlertHistoryTTL.Duration)
 	}
 
 	if alerter.Email != nil {
@@ -346,6 +1639,145 @@ func (alerter *Alerter) Validate() error {
 			return fmt.Errorf("alerter: %s", err)
 		}
 	}
+	if alerter.Slack != nil {
+		if err := alerter.Slack.Validate(); err != nil {
+			return fmt.Errorf("alerter: %s", err)
+		}
+	}
+	if alerter.PagerDuty != nil {
+		if err := alerter.PagerDuty.Validate(); err != nil {
+			return fmt.Errorf("alerter: %s", err)
+		}
+	}
+	if alerter.ErrorNormalization != nil {
+		if err := alerter.ErrorNormalization.Validate(); err != nil {
+			return fmt.Errorf("alerter: %s", err)
+		}
+	}
+	if alerter.Retry != nil {
+		if err := alerter.Retry.Validate(); err != nil {
+			return fmt.Errorf("alerter: %s", err)
+		}
+	}
+	if alerter.FlapDetection != nil {
+		if err := alerter.FlapDetection.Validate(); err != nil {
+			return fmt.Errorf("alerter: %s", err)
+		}
+	}
+
+	takenNames := map[string]bool{EmailAlerterName: true, SlackAlerterName: true, PagerDutyAlerterName: true}
+	for _, named := range alerter.Named {
+		if takenNames[named.Name] {
+			return fmt.Errorf("alerter: alerter name '%s' is used multiple times -- alerter names must be unique (and cannot be %s, %s or %s)", named.Name, EmailAlerterName, SlackAlerterName, PagerDutyAlerterName)
+		}
+		takenNames[named.Name] = true
+
+		if err := named.Validate(); err != nil {
+			return fmt.Errorf("alerter: %s", err)
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the alerters of an Alerter configuration (which
+// may be nil) that pingers can select.
+func (alerter *Alerter) Names() map[string]bool {
+	names := make(map[string]bool)
+	if alerter == nil {
+		return names
+	}
+	if alerter.Email != nil {
+		names[EmailAlerterName] = true
+	}
+	if alerter.Slack != nil {
+		names[SlackAlerterName] = true
+	}
+	if alerter.PagerDuty != nil {
+		names[PagerDutyAlerterName] = true
+	}
+	for _, named := range alerter.Named {
+		names[named.Name] = true
+	}
+	return names
+}
+
+// Validate validates a NamedAlerter.
+func (named *NamedAlerter) Validate() error {
+	if !ValidPingerName(named.Name) {
+		return fmt.Errorf("named alerter: illegal name: '%s' (must be of form '%s')", named.Name, validPingerName)
+	}
+	given := 0
+	for _, isGiven := range []bool{named.Email != nil, named.Slack != nil, named.PagerDuty != nil} {
+		if isGiven {
+			given++
+		}
+	}
+	if given != 1 {
+		return fmt.Errorf("named alerter '%s': exactly one of email, slack and pagerDuty must be given", named.Name)
+	}
+	var err error
+	switch {
+	case named.Email != nil:
+		err = named.Email.Validate()
+	case named.Slack != nil:
+		err = named.Slack.Validate()
+	case named.PagerDuty != nil:
+		err = named.PagerDuty.Validate()
+	}
+	if err != nil {
+		return fmt.Errorf("named alerter '%s': %s", named.Name, err)
+	}
+	return nil
+}
+
+// validateAdvertisedURL validates the AdvertisedURL of an Alerter, which
+// cannot be combined with the parts that a base URL is otherwise made up of.
+func (alerter *Alerter) validateAdvertisedURL() error {
+	advertisedURL, err := url.Parse(alerter.AdvertisedURL)
+	if err != nil {
+		return fmt.Errorf("advertisedURL: illegal URL: %s", err)
+	}
+	if (advertisedURL.Scheme != "http" && advertisedURL.Scheme != "https") || advertisedURL.Host == "" {
+		return fmt.Errorf("advertisedURL: must be an http(s) URL: '%s'", alerter.AdvertisedURL)
+	}
+	if alerter.AdvertisedIP != "" || alerter.AdvertisedPort != 0 || alerter.AdvertisedScheme != "" || alerter.AdvertisedPathPrefix != "" {
+		return fmt.Errorf("advertisedURL cannot be combined with advertisedIP, advertisedPort, advertisedScheme or advertisedPathPrefix")
+	}
+	return nil
+}
+
+// Validate validates an AlertRetry configuration.
+func (retry *AlertRetry) Validate() error {
+	if retry.Retries < 0 {
+		return fmt.Errorf("retry: retries must not be negative")
+	}
+	if retry.Backoff != nil && retry.Backoff.Duration <= 0 {
+		return fmt.Errorf("retry: backoff must be positive")
+	}
+	if retry.MaxBackoff != nil && retry.MaxBackoff.Duration <= 0 {
+		return fmt.Errorf("retry: maxBackoff must be positive")
+	}
+	return nil
+}
+
+// Validate validates a FlapDetection configuration.
+func (flap *FlapDetection) Validate() error {
+	if flap.Transitions < 2 {
+		return fmt.Errorf("flapDetection: transitions must be at least 2")
+	}
+	if flap.Window != nil && flap.Window.Duration <= 0 {
+		return fmt.Errorf("flapDetection: window must be positive")
+	}
+	return nil
+}
+
+// Validate validates an ErrorNormalization configuration.
+func (normalization *ErrorNormalization) Validate() error {
+	for _, pattern := range normalization.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("errorNormalization: patterns: illegal regular expression: %s", err)
+		}
+	}
 	return nil
 }
 
@@ -378,6 +1810,57 @@ func (email *Email) Validate() error {
 	return nil
 }
 
+// Validate validates a Slack configuration.
+func (slack *Slack) Validate() error {
+	webhookURL, err := url.Parse(slack.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("slack: illegal webhookURL: %s", err)
+	}
+	if webhookURL.Scheme != "https" || webhookURL.Host == "" {
+		return fmt.Errorf("slack: webhookURL: must be an https URL: '%s'", slack.WebhookURL)
+	}
+	return nil
+}
+
+// Validate validates a StatusWebhook.
+func (webhook *StatusWebhook) Validate() error {
+	webhookURL, err := url.Parse(webhook.URL)
+	if err != nil {
+		return fmt.Errorf("statusWebhook: illegal url: %s", err)
+	}
+	if (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		return fmt.Errorf("statusWebhook: url: must be an http(s) URL: '%s'", webhook.URL)
+	}
+	if webhook.Timeout != nil && webhook.Timeout.Duration <= 0 {
+		return fmt.Errorf("statusWebhook: timeout must be positive")
+	}
+	return nil
+}
+
+// Validate validates a Liveness configuration.
+func (liveness *Liveness) Validate() error {
+	if liveness.MinReporting <= 0 || liveness.MinReporting > 1 {
+		return fmt.Errorf("liveness: minReporting must be in the range (0,1]")
+	}
+	if liveness.Window != nil && liveness.Window.Duration <= 0 {
+		return fmt.Errorf("liveness: window must be positive")
+	}
+	return nil
+}
+
+// Validate validates a PagerDuty configuration.
+func (pagerDuty *PagerDuty) Validate() error {
+	if pagerDuty.RoutingKey == "" {
+		return fmt.Errorf("pagerDuty: no routingKey given")
+	}
+	switch pagerDuty.Severity {
+	case "", "critical", "error", "warning", "info":
+	default:
+		return fmt.Errorf("pagerDuty: severity: must be one of critical, error, warning and info: '%s'", pagerDuty.Severity)
+	}
+	return nil
+}
+
 // Validate validates an EmailAuth configuration.
 func (auth *EmailAuth) Validate() error {
 	if ok, _ := regexp.MatchString("[a-z_][a-z0-9_-]*$", auth.Username); !ok {
@@ -396,6 +1879,17 @@ func ValidHostOrIpAddr(hostOrIp string) bool {
 	return ipv4AddrRegexp.MatchString(hostOrIp) || hostnameRegexp.MatchString(hostOrIp)
 }
 
+// ValidJSONPath determines if a given string is a valid dotted JSON path
+// (such as "db.status"), which must not contain empty elements.
+func ValidJSONPath(path string) bool {
+	for _, element := range strings.Split(path, ".") {
+		if element == "" {
+			return false
+		}
+	}
+	return true
+}
+
 // ValidPort determines if a given number is a valid port number.
 func ValidPort(port int) bool {
 	return port > 0 && port < 65535
@@ -406,6 +1900,17 @@ func ValidHTTPStatusCode(statusCode int) bool {
 	return statusCode >= 100 && statusCode < 600
 }
 
+// ValidNetwork determines if a given network is a valid network to connect
+// over. An empty network is valid (meaning the default, tcp).
+func ValidNetwork(network string) bool {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+		return true
+	default:
+		return false
+	}
+}
+
 // ValidPingerName determines if a given name is a valid name for a pinger.
 func ValidPingerName(name string) bool {
 	return validPingerName.MatchString(name)
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrustedFingerprintValidation(t *testing.T) {
+	password := "secret"
+	for fingerprint, valid := range map[string]bool{
+		"SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8":    true,
+		" sha256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8= ": true,
+		"SHA256:9e:1b:5b:83":                          true,
+		"nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8": false,
+		"SHA256:":         false,
+		"MD5:9e:1b:5b:83": false,
+	} {
+		target := &SSHTarget{
+			Host:                "localhost",
+			Port:                22,
+			Auth:                SSHAuth{Username: "user", Password: &password},
+			TrustedFingerprints: []string{fingerprint},
+		}
+		if err := target.Validate(); (err == nil) != valid {
+			t.Errorf("%q: expected valid: %t, got error: %v", fingerprint, valid, err)
+		}
+	}
+}
+
+func TestStatusWebhookValidation(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"https://hooks.example.com/status": true,
+		"http://localhost:8080/status":     true,
+		"ftp://hooks.example.com/status":   false,
+		"":                                 false,
+	} {
+		if err := (&StatusWebhook{URL: url}).Validate(); (err == nil) != valid {
+			t.Errorf("%q: expected valid: %t, got error: %v", url, valid, err)
+		}
+	}
+	if err := (&StatusWebhook{URL: "https://hooks.example.com", Timeout: &Duration{}}).Validate(); err == nil {
+		t.Errorf("expected zero timeout to be rejected")
+	}
+}
+
+func TestAlertRetryValidation(t *testing.T) {
+	if err := (&AlertRetry{Retries: 3, Backoff: &Duration{Duration: time.Second}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, retry := range []AlertRetry{
+		{Retries: -1},
+		{Retries: 3, Backoff: &Duration{}},
+		{Retries: 3, MaxBackoff: &Duration{Duration: -time.Second}},
+	} {
+		if err := retry.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", retry)
+		}
+	}
+}
+
+func TestAdvertisedPathPrefixAndScheme(t *testing.T) {
+	for _, test := range []struct {
+		alerter Alerter
+		want    string
+	}{
+		{Alerter{AdvertisedScheme: "https", AdvertisedIP: "10.0.0.7", AdvertisedPort: 8443}, "https://10.0.0.7:8443"},
+		{Alerter{AdvertisedScheme: "http", AdvertisedIP: "10.0.0.7", AdvertisedPort: 80, AdvertisedPathPrefix: "/watcher"}, "http://10.0.0.7:80/watcher"},
+		{Alerter{AdvertisedScheme: "https", AdvertisedIP: "proxy.example.com", AdvertisedPort: 443, AdvertisedPathPrefix: "/ops/watcher/"}, "https://proxy.example.com:443/ops/watcher"},
+	} {
+		if err := test.alerter.Validate(); err != nil {
+			t.Errorf("unexpected error for %+v: %s", test.alerter, err)
+		}
+		if baseURL := test.alerter.AdvertisedBaseURL(); baseURL != test.want {
+			t.Errorf("got advertised base URL %s, want %s", baseURL, test.want)
+		}
+	}
+
+	for _, alerter := range []Alerter{
+		{AdvertisedScheme: "ftp", AdvertisedIP: "10.0.0.7", AdvertisedPort: 21},
+		{AdvertisedScheme: "https", AdvertisedIP: "10.0.0.7", AdvertisedPort: 443, AdvertisedPathPrefix: "watcher"},
+	} {
+		if err := alerter.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", alerter)
+		}
+	}
+}
+
+func TestAdvertisedURL(t *testing.T) {
+	alerter := &Alerter{AdvertisedURL: "https://vip.example.com:8443/watcher/"}
+	if err := alerter.Validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if baseURL := alerter.AdvertisedBaseURL(); baseURL != "https://vip.example.com:8443/watcher" {
+		t.Errorf("unexpected advertised base URL: %s", baseURL)
+	}
+
+	for _, alerter := range []Alerter{
+		{AdvertisedURL: "vip.example.com"},
+		{AdvertisedURL: "ftp://vip.example.com"},
+		{AdvertisedURL: "https://vip.example.com", AdvertisedIP: "10.0.0.7"},
+		{AdvertisedURL: "https://vip.example.com", AdvertisedPort: 8080},
+	} {
+		if err := alerter.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", alerter)
+		}
+	}
+}
+
+func TestRunIfValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		pingers []Pinger
+		valid   bool
+	}{
+		{"chain", []Pinger{{Name: "a", RunIf: "b"}, {Name: "b", RunIf: "c"}, {Name: "c"}}, true},
+		{"no such pinger", []Pinger{{Name: "a", RunIf: "b"}}, false},
+		{"self", []Pinger{{Name: "a", RunIf: "a"}}, false},
+		{"cycle", []Pinger{{Name: "a", RunIf: "b"}, {Name: "b", RunIf: "a"}}, false},
+		{"depends on cycle", []Pinger{{Name: "a", RunIf: "b"}, {Name: "b", RunIf: "c"}, {Name: "c", RunIf: "b"}}, false},
+	}
+	for _, test := range tests {
+		engine := &Engine{Pingers: test.pingers}
+		if err := engine.validateRunIf(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid: %t, got error: %v", test.name, test.valid, err)
+		}
+	}
+}
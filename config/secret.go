@@ -0,0 +1,231 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SecretRefPrefix marks a secret value as a reference to a secret that is
+// kept outside of the configuration, as in secret:file:///path/to/file. Any
+// other value is a literal secret.
+const SecretRefPrefix = "secret:"
+
+// A SecretProvider resolves references to secrets that are kept outside of
+// the configuration (such as in files or in a secret store).
+type SecretProvider interface {
+	// Resolve returns the secret that a reference (of form
+	// <scheme>://<path>, without the SecretRefPrefix, where the scheme is
+	// the one that the provider is registered for) refers to.
+	Resolve(ref string) (string, error)
+}
+
+var (
+	secretProvidersLock sync.Mutex
+	// secretProviders are the registered SecretProviders (keyed on
+	// scheme).
+	secretProviders = map[string]SecretProvider{
+		"file": FileSecretProvider{},
+		"env":  EnvSecretProvider{},
+	}
+)
+
+// RegisterSecretProvider registers a SecretProvider for the references with a
+// given scheme (such as "vault"), replacing any provider already registered
+// for it.
+func RegisterSecretProvider(scheme string, provider SecretProvider) {
+	secretProvidersLock.Lock()
+	defer secretProvidersLock.Unlock()
+	secretProviders[scheme] = provider
+}
+
+// ResolveSecret resolves a secret value. A reference, of form
+// secret:<scheme>://<path>, is resolved by the SecretProvider registered for
+// its scheme, while any other value is a literal secret that is returned as
+// is.
+func ResolveSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, SecretRefPrefix) {
+		return value, nil
+	}
+	ref := strings.TrimPrefix(value, SecretRefPrefix)
+	scheme, _, ok := splitSecretRef(ref)
+	if !ok {
+		return "", fmt.Errorf("illegal secret reference: '%s' (must be of form %s<scheme>://<path>)", value, SecretRefPrefix)
+	}
+
+	secretProvidersLock.Lock()
+	provider, ok := secretProviders[scheme]
+	secretProvidersLock.Unlock()
+	if !ok {
+		return "", fmt.Errorf("no secret provider registered for scheme '%s'", scheme)
+	}
+	secret, err := provider.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s secret: %s", scheme, err)
+	}
+	return secret, nil
+}
+
+// splitSecretRef splits a secret reference (without its SecretRefPrefix) into
+// its scheme and path. ok is false if the value is not of form
+// <scheme>://<path>.
+func splitSecretRef(value string) (scheme, path string, ok bool) {
+	i := strings.Index(value, "://")
+	if i <= 0 || !validSecretScheme(value[:i]) {
+		return "", "", false
+	}
+	return value[:i], value[i+len("://"):], true
+}
+
+// validSecretScheme returns true if a string is a URL scheme (a letter
+// followed by letters, digits, '+', '-', and '.').
+func validSecretScheme(scheme string) bool {
+	for i, c := range scheme {
+		letter := ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+		if i == 0 && !letter {
+			return false
+		}
+		if !letter && !('0' <= c && c <= '9') && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// FileSecretProvider resolves references of form
+// secret:file:///path/to/file to the content of the file (with any trailing
+// newline removed).
+type FileSecretProvider struct{}
+
+// Resolve implements the SecretProvider interface.
+func (FileSecretProvider) Resolve(ref string) (string, error) {
+	_, path, _ := splitSecretRef(ref)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// EnvSecretProvider resolves references of form secret:env://NAME to the value
+// of the environment variable NAME.
+type EnvSecretProvider struct{}
+
+// Resolve implements the SecretProvider interface.
+func (EnvSecretProvider) Resolve(ref string) (string, error) {
+	_, name, _ := splitSecretRef(ref)
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// ResolveSecrets resolves the secrets of an Engine (the passwords and key
+// passphrases of pingers, the passwords of email alerters, the PagerDuty
+// routing keys, and the Slack webhook URLs) through the registered
+// SecretProviders.
+func (engine *Engine) ResolveSecrets() error {
+	for i := range engine.Pingers {
+		check, err := resolveCheckSecrets(engine.Pingers[i].Check)
+		if err != nil {
+			return fmt.Errorf("pinger '%s': %s", engine.Pingers[i].Name, err)
+		}
+		engine.Pingers[i].Check = check
+	}
+
+	alerter := engine.Alerter
+	if alerter == nil {
+		return nil
+	}
+	if err := resolveAlerterSecrets(alerter.Email, alerter.Slack, alerter.PagerDuty); err != nil {
+		return fmt.Errorf("alerter: %s", err)
+	}
+	for _, named := range alerter.Named {
+		if err := resolveAlerterSecrets(named.Email, named.Slack, named.PagerDuty); err != nil {
+			return fmt.Errorf("alerter: named alerter '%s': %s", named.Name, err)
+		}
+	}
+	return nil
+}
+
+// resolveAlerterSecrets resolves the secrets of a set of alerter
+// configurations (any of which may be nil).
+func resolveAlerterSecrets(email *Email, slack *Slack, pagerDuty *PagerDuty) error {
+	var err error
+	if email != nil && email.Auth != nil {
+		if email.Auth.Password, err = ResolveSecret(email.Auth.Password); err != nil {
+			return fmt.Errorf("email: auth: password: %s", err)
+		}
+	}
+	if pagerDuty != nil {
+		if pagerDuty.RoutingKey, err = ResolveSecret(pagerDuty.RoutingKey); err != nil {
+			return fmt.Errorf("pagerDuty: routingKey: %s", err)
+		}
+	}
+	if slack != nil {
+		if slack.WebhookURL, err = ResolveSecret(slack.WebhookURL); err != nil {
+			return fmt.Errorf("slack: webhookURL: %s", err)
+		}
+	}
+	return nil
+}
+
+// resolveCheckSecrets resolves the values of all password and keyPassphrase
+// fields (at any depth) of a check.
+func resolveCheckSecrets(check json.RawMessage) (json.RawMessage, error) {
+	if len(check) == 0 {
+		return check, nil
+	}
+	var document interface{}
+	if err := json.Unmarshal(check, &document); err != nil {
+		// left for Validate to report
+		return check, nil
+	}
+	changed, err := resolvePasswords(document)
+	if err != nil || !changed {
+		return check, err
+	}
+	return json.Marshal(document)
+}
+
+// resolvePasswords recursively resolves the password and keyPassphrase fields
+// of a decoded JSON value in place. It returns true if any of them was a
+// reference.
+func resolvePasswords(value interface{}) (bool, error) {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if password, ok := child.(string); ok && (key == "password" || key == "keyPassphrase") {
+				if !strings.HasPrefix(password, SecretRefPrefix) {
+					continue
+				}
+				secret, err := ResolveSecret(password)
+				if err != nil {
+					return false, fmt.Errorf("%s: %s", key, err)
+				}
+				v[key] = secret
+				changed = true
+				continue
+			}
+			childChanged, err := resolvePasswords(child)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || childChanged
+		}
+	case []interface{}:
+		for _, child := range v {
+			childChanged, err := resolvePasswords(child)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || childChanged
+		}
+	}
+	return changed, nil
+}
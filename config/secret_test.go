@@ -0,0 +1,76 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubSecretProvider is a SecretProvider that resolves references from a map.
+type stubSecretProvider map[string]string
+
+func (provider stubSecretProvider) Resolve(ref string) (string, error) {
+	secret, ok := provider[ref]
+	if !ok {
+		return "", fmt.Errorf("no such secret: %s", ref)
+	}
+	return secret, nil
+}
+
+func TestResolveSecretsThroughProvider(t *testing.T) {
+	RegisterSecretProvider("stub", stubSecretProvider{"stub://db#password": "s3cret"})
+	t.Setenv("WATCHER_TEST_PASSWORD", "from-env")
+
+	engineConf := &Engine{
+		Pingers: []Pinger{{
+			Name:  "test",
+			Check: json.RawMessage(`{"url": "https://example.com", "basicAuth": {"username": "user", "password": "secret:stub://db#password"}, "auth": {"password": "secret:env://WATCHER_TEST_PASSWORD"}}`),
+		}},
+		Alerter: &Alerter{
+			PagerDuty: &PagerDuty{RoutingKey: "secret:stub://db#password"},
+			Slack:     &Slack{WebhookURL: "https://hooks.slack.com/literal"},
+		},
+	}
+	if err := engineConf.ResolveSecrets(); err != nil {
+		t.Fatalf("failed to resolve secrets: %s", err)
+	}
+	check := string(engineConf.Pingers[0].Check)
+	if !strings.Contains(check, `"s3cret"`) || !strings.Contains(check, `"from-env"`) {
+		t.Errorf("check secrets not resolved: %s", check)
+	}
+	if engineConf.Alerter.PagerDuty.RoutingKey != "s3cret" {
+		t.Errorf("alerter secret not resolved: %s", engineConf.Alerter.PagerDuty.RoutingKey)
+	}
+	if engineConf.Alerter.Slack.WebhookURL != "https://hooks.slack.com/literal" {
+		t.Errorf("literal secret modified: %s", engineConf.Alerter.Slack.WebhookURL)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := ResolveSecret("secret:file://" + secretFile); err != nil || secret != "from-file" {
+		t.Errorf("expected file secret, got %q (%v)", secret, err)
+	}
+	for _, literal := range []string{"plain", "ab://cd"} {
+		if secret, err := ResolveSecret(literal); err != nil || secret != literal {
+			t.Errorf("expected literal %q to be kept, got %q (%v)", literal, secret, err)
+		}
+	}
+
+	_, err := ResolveSecret("secret:unregistered://foo")
+	if err == nil || !strings.Contains(err.Error(), "no secret provider registered for scheme 'unregistered'") {
+		t.Errorf("expected unregistered scheme to be rejected, got: %v", err)
+	}
+	if _, err := ResolveSecret("secret:no-scheme"); err == nil {
+		t.Errorf("expected illegal reference to be rejected")
+	}
+	if _, err := ResolveSecret("secret:env://WATCHER_TEST_UNSET_VARIABLE"); err == nil {
+		t.Errorf("expected unset environment variable to be rejected")
+	}
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// An Acknowledgement records that a failing pinger is being attended to.
+// While acknowledged, no reminder alerts are sent for the pinger. An
+// acknowledgement also resumes alerting for a pinger that has reached its
+// maximum number of alerts.
+type Acknowledgement struct {
+	// Name of the acknowledged pinger.
+	Pinger string
+	// Time when the acknowledgement expires (nil means that it lasts until
+	// the pinger recovers).
+	Until *time.Time
+}
+
+// ackRegistry keeps track of acknowledged pingers (keyed on pinger ID). It is shared between the
+// Dispatcher and whoever acknowledges pingers (such as the REST API) and is
+// safe for concurrent use.
+type ackRegistry struct {
+	lock sync.Mutex
+	acks map[string]Acknowledgement
+	// the number of failure alerts sent for each pinger since it was last
+	// acknowledged or recovered (keyed on pinger ID)
+	alerts map[string]int
+}
+
+func newAckRegistry() *ackRegistry {
+	return &ackRegistry{acks: make(map[string]Acknowledgement), alerts: make(map[string]int)}
+}
+
+// ack acknowledges a pinger (with a given ID and name), at a given point in
+// time, for a given snooze duration. A zero snooze acknowledges the pinger
+// until it recovers.
+func (registry *ackRegistry) ack(pingerID, pingerName string, snooze time.Duration, now time.Time) Acknowledgement {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	ack := Acknowledgement{Pinger: pingerName}
+	if snooze > 0 {
+		until := now.UTC().Add(snooze)
+		ack.Until = &until
+	}
+	registry.acks[pingerID] = ack
+	delete(registry.alerts, pingerID)
+	return ack
+}
+
+// countAlert records that a failure alert has been sent for a pinger.
+func (registry *ackRegistry) countAlert(pingerID string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.alerts[pingerID]++
+}
+
+// alertLimitReached returns true if a given (non-zero) maximum number of
+// failure alerts has been sent for a pinger since it was last acknowledged or
+// recovered.
+func (registry *ackRegistry) alertLimitReached(pingerID string, maxAlerts int) bool {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	return maxAlerts > 0 && registry.alerts[pingerID] >= maxAlerts
+}
+
+// forget removes any acknowledgement and alert count for a (removed) pinger.
+func (registry *ackRegistry) forget(pingerID string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	delete(registry.acks, pingerID)
+	delete(registry.alerts, pingerID)
+}
+
+// clear removes any acknowledgement and alert count for a (recovered)
+// pinger.
+func (registry *ackRegistry) clear(pingerID string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	delete(registry.acks, pingerID)
+	delete(registry.alerts, pingerID)
+}
+
+// isAcked returns true if a pinger has an unexpired acknowledgement at a
+// given point in time. Expired acknowledgements are removed.
+func (registry *ackRegistry) isAcked(pingerID string, now time.Time) bool {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	ack, ok := registry.acks[pingerID]
+	if !ok {
+		return false
+	}
+	if ack.Until != nil && now.After(*ack.Until) {
+		delete(registry.acks, pingerID)
+		return false
+	}
+	return true
+}
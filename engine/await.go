@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/petergardfjall/watcher/ping"
+	"time"
+)
+
+// AwaitOK repeatedly pings with a Pinger, waiting interval between pings,
+// until the pinged endpoint responds with StatusOK or the deadline passes.
+// This is useful as a one-shot check, for instance to verify that a service
+// comes up after a deploy. The latest ping result is returned along with an
+// error if no ping succeeded before the deadline. A ping that is still in
+// progress when the deadline passes is given up on (and fails).
+func AwaitOK(pinger ping.Pinger, interval, deadline time.Duration) (ping.Result, error) {
+	expiry := time.Now().Add(deadline)
+	for {
+		if cycleStarter, ok := pinger.(ping.CycleStarter); ok {
+			cycleStarter.StartCycle()
+		}
+		result := pingWithin(pinger, time.Until(expiry))
+		if result.Status == ping.StatusOK {
+			return result, nil
+		}
+		log.Debugf("not OK yet: %s", result)
+
+		if time.Now().Add(interval).After(expiry) {
+			return result, fmt.Errorf("no successful ping within %s: %v", deadline, result.Error)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// pingWithin pings with a Pinger, but gives up on the ping (and returns a
+// failed result) if it has not completed within a given timeout. A ping that
+// is given up on completes in the background.
+func pingWithin(pinger ping.Pinger, timeout time.Duration) ping.Result {
+	results := make(chan ping.Result, 1)
+	go func() {
+		result, _ := pinger.Ping()
+		results <- result
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return result
+	case <-timer.C:
+		return ping.Result{
+			Status:   ping.StatusNOK,
+			Error:    fmt.Errorf("ping did not complete within %s", timeout),
+			Category: ping.CategoryTimeout,
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/ping"
+)
+
+func TestAwaitOK(t *testing.T) {
+	result, err := AwaitOK(&fakePinger{status: ping.StatusOK}, 10*time.Millisecond, time.Second)
+	if err != nil || result.Status != ping.StatusOK {
+		t.Errorf("expected OK, got: %s (%v)", result, err)
+	}
+
+	result, err = AwaitOK(&fakePinger{status: ping.StatusNOK}, 10*time.Millisecond, 100*time.Millisecond)
+	if err == nil || result.Status != ping.StatusNOK {
+		t.Errorf("expected NOK, got: %s (%v)", result, err)
+	}
+}
+
+func TestAwaitOKCapsPingAtDeadline(t *testing.T) {
+	pinger := &fakePinger{delay: 10 * time.Second, status: ping.StatusOK}
+
+	start := time.Now()
+	result, err := AwaitOK(pinger, 10*time.Millisecond, 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected ping to be given up on at the deadline, took %s", elapsed)
+	}
+	if err == nil || result.Status != ping.StatusNOK || result.Category != ping.CategoryTimeout {
+		t.Errorf("expected timed out ping, got: %+v (%v)", result, err)
+	}
+}
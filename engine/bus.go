@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"sync"
+)
+
+// An EventBus publishes the StatusUpdates of PingerTasks to any number of
+// subscribers (such as the Dispatcher). Every subscriber receives every
+// update, in order. Updates are queued (without bound) per subscriber, so
+// that a slow subscriber neither blocks publishers nor other subscribers.
+type EventBus struct {
+	lock          sync.Mutex
+	subscriptions []*subscription
+}
+
+// subscription queues the updates for a single subscriber.
+type subscription struct {
+	lock  sync.Mutex
+	queue []StatusUpdate
+	// signal is notified when the queue becomes non-empty.
+	signal chan struct{}
+	out    chan StatusUpdate
+}
+
+// NewEventBus creates a new EventBus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe registers a new subscriber and returns the channel on which it
+// receives all updates published from now on.
+func (bus *EventBus) Subscribe() <-chan StatusUpdate {
+	sub := &subscription{signal: make(chan struct{}, 1), out: make(chan StatusUpdate)}
+	go sub.forward()
+
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.subscriptions = append(bus.subscriptions, sub)
+	return sub.out
+}
+
+// Unsubscribe removes the subscriber that receives updates on a given
+// channel (as returned by Subscribe). Updates already queued for the
+// subscriber are still delivered, after which the channel is closed.
+// Unsubscribing an unknown (or already removed) subscriber has no effect.
+func (bus *EventBus) Unsubscribe(updates <-chan StatusUpdate) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	for i, sub := range bus.subscriptions {
+		if sub.out == updates {
+			bus.subscriptions = append(bus.subscriptions[:i:i], bus.subscriptions[i+1:]...)
+			// no more updates are enqueued once removed (under lock)
+			close(sub.signal)
+			return
+		}
+	}
+}
+
+// Publish publishes an update to all subscribers. It never blocks on
+// subscribers.
+func (bus *EventBus) Publish(update StatusUpdate) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	for _, sub := range bus.subscriptions {
+		sub.enqueue(update)
+	}
+}
+
+func (sub *subscription) enqueue(update StatusUpdate) {
+	sub.lock.Lock()
+	sub.queue = append(sub.queue, update)
+	sub.lock.Unlock()
+
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+		// already signalled
+	}
+}
+
+// forward delivers queued updates to the subscriber until it unsubscribes,
+// and then closes its channel.
+func (sub *subscription) forward() {
+	defer close(sub.out)
+	for range sub.signal {
+		for {
+			sub.lock.Lock()
+			if len(sub.queue) == 0 {
+				sub.lock.Unlock()
+				break
+			}
+			update := sub.queue[0]
+			sub.queue = sub.queue[1:]
+			sub.lock.Unlock()
+
+			sub.out <- update
+		}
+	}
+}
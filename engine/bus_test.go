@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+// receive receives an update from a subscription, failing the test if none
+// arrives in time.
+func receive(t *testing.T, updates <-chan StatusUpdate) (StatusUpdate, bool) {
+	t.Helper()
+	select {
+	case update, ok := <-updates:
+		return update, ok
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no update received")
+		return StatusUpdate{}, false
+	}
+}
+
+func TestEverySubscriberReceivesEveryUpdate(t *testing.T) {
+	bus := NewEventBus()
+	subscriptions := []<-chan StatusUpdate{bus.Subscribe(), bus.Subscribe(), bus.Subscribe()}
+
+	names := []string{"a", "b", "c", "d"}
+	for _, name := range names {
+		bus.Publish(StatusUpdate{Name: name})
+	}
+	for i, updates := range subscriptions {
+		for _, name := range names {
+			if update, _ := receive(t, updates); update.Name != name {
+				t.Errorf("subscriber %d: expected update %s, got %s", i, name, update.Name)
+			}
+		}
+	}
+}
+
+func TestSlowSubscriberDoesNotStallOthers(t *testing.T) {
+	bus := NewEventBus()
+	slow := bus.Subscribe()
+	fast := bus.Subscribe()
+
+	// the slow subscriber does not receive anything until all updates
+	// have been published
+	const updates = 1000
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < updates; i++ {
+			bus.Publish(StatusUpdate{Name: fmt.Sprint(i)})
+		}
+	}()
+	for i := 0; i < updates; i++ {
+		if update, _ := receive(t, fast); update.Name != fmt.Sprint(i) {
+			t.Fatalf("expected update %d, got %s", i, update.Name)
+		}
+	}
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("publishing stalled by slow subscriber")
+	}
+
+	for i := 0; i < updates; i++ {
+		if update, _ := receive(t, slow); update.Name != fmt.Sprint(i) {
+			t.Fatalf("slow subscriber: expected update %d, got %s", i, update.Name)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bus := NewEventBus()
+	removed := bus.Subscribe()
+	kept := bus.Subscribe()
+
+	bus.Publish(StatusUpdate{Name: "first"})
+	bus.Unsubscribe(removed)
+	bus.Publish(StatusUpdate{Name: "second"})
+
+	// updates queued before unsubscribing are still delivered
+	if update, ok := receive(t, removed); !ok || update.Name != "first" {
+		t.Errorf("expected queued update, got: %+v (ok: %t)", update, ok)
+	}
+	if update, ok := receive(t, removed); ok {
+		t.Errorf("expected closed channel, got: %+v", update)
+	}
+	for _, name := range []string{"first", "second"} {
+		if update, ok := receive(t, kept); !ok || update.Name != name {
+			t.Errorf("expected update %s, got: %+v (ok: %t)", name, update, ok)
+		}
+	}
+
+	// unsubscribing again has no effect
+	bus.Unsubscribe(removed)
+	if len(bus.subscriptions) != 1 {
+		t.Errorf("expected one remaining subscription, got %d", len(bus.subscriptions))
+	}
+}
+
+func TestStopUnsubscribes(t *testing.T) {
+	engine, err := NewEngine(&config.Engine{
+		Pingers:       []config.Pinger{testHTTPPinger("test", "http://127.0.0.1:1")},
+		StatusWebhook: &config.StatusWebhook{URL: "http://127.0.0.1:1"},
+	}, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	if len(engine.Events.subscriptions) != 2 {
+		t.Fatalf("expected dispatcher and webhook subscriptions, got %d", len(engine.Events.subscriptions))
+	}
+	engine.Start()
+	stopEngine(t, engine)
+
+	if len(engine.Events.subscriptions) != 0 {
+		t.Errorf("expected no subscriptions after stop, got %d", len(engine.Events.subscriptions))
+	}
+}
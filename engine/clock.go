@@ -0,0 +1,20 @@
+package engine
+
+import (
+	"time"
+)
+
+// A Clock tells the current time. The Engine, its PingerTasks and its
+// Dispatcher read the time (for status timestamps, report deadlines,
+// acknowledgements, silences, reminders and business hours) from a Clock,
+// so that tests can control it. Timers and sleeps are not affected.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock that tells the actual time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,273 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/alerter"
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) advance(duration time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(duration)
+}
+
+// withClock configures a Dispatcher to read the time from a given Clock.
+func withClock(clock Clock) func(dispatcher *Dispatcher) {
+	return func(dispatcher *Dispatcher) {
+		dispatcher.clock = clock
+	}
+}
+
+// nokTransition is a StatusUpdate of a pinger, that is alerted on outside of
+// given business hours, that starts failing.
+func nokTransition(hours *config.BusinessHours) StatusUpdate {
+	return StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		Transition:    true,
+		AlertedStatus: ping.StatusNOK,
+		BusinessHours: hours,
+		Status: PingerTaskStatus{LatestResult: ping.Result{
+			Status: ping.StatusNOK,
+			Error:  errors.New("ping failed"),
+		}},
+	}
+}
+
+func TestTaskStatusUsesClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	task := newTestTask(&fakePinger{status: ping.StatusOK}, config.Schedule{})
+	task.clock = clock
+
+	task.updateStatus(ping.Result{Status: ping.StatusOK}, nil, 1, 0)
+	clock.advance(time.Minute)
+	task.updateStatus(ping.Result{Status: ping.StatusOK}, nil, 1, 0)
+
+	status := task.Snapshot()
+	if !status.InStateSince.Equal(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected InStateSince: %s", status.InStateSince)
+	}
+	if !status.LatestOK.Equal(time.Date(2026, 10, 17, 12, 1, 0, 0, time.UTC)) {
+		t.Errorf("unexpected LatestOK: %s", status.LatestOK)
+	}
+}
+
+func TestMaxConsecutive(t *testing.T) {
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for _, maxConsecutive := range []int{0, 3} {
+		clock := newFakeClock(start)
+		task := newTestTask(&fakePinger{}, config.Schedule{FailureThreshold: 3})
+		task.clock = clock
+		task.MaxConsecutive = maxConsecutive
+		updates := task.events.Subscribe()
+
+		var transitions []int
+		for i := 1; i <= 5; i++ {
+			task.updateStatus(ping.Result{Status: ping.StatusNOK}, nil, 1, 0)
+			if update, _ := receive(t, updates); update.Transition {
+				transitions = append(transitions, i)
+			}
+			clock.advance(time.Minute)
+		}
+		wantConsecutive := 5
+		if maxConsecutive > 0 {
+			wantConsecutive = maxConsecutive
+		}
+		status := task.Snapshot()
+		if status.Consecutive != wantConsecutive {
+			t.Errorf("max %d: got %d consecutive pings, want %d", maxConsecutive, status.Consecutive, wantConsecutive)
+		}
+		// the capped counter neither hides how long the pinger has been
+		// failing nor makes it reach the failure threshold again
+		if status.InStateSince == nil || !status.InStateSince.Equal(start) {
+			t.Errorf("max %d: got in state since %v, want %s", maxConsecutive, status.InStateSince, start)
+		}
+		if len(transitions) != 1 || transitions[0] != 3 {
+			t.Errorf("max %d: expected a transition on reaching the failure threshold only, got transitions on pings %v", maxConsecutive, transitions)
+		}
+
+		task.updateStatus(ping.Result{Status: ping.StatusOK}, nil, 1, 0)
+		receive(t, updates)
+		status = task.Snapshot()
+		if status.Consecutive != 1 || status.InStateSince == nil || !status.InStateSince.Equal(start.Add(5*time.Minute)) {
+			t.Errorf("max %d: expected the count to restart on a state change, got %+v", maxConsecutive, status)
+		}
+	}
+}
+
+func TestAcknowledgementAndSilenceExpireWithClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	dispatcher, _, _ := newTestDispatcher(t, withClock(clock))
+
+	ack := dispatcher.Acknowledge("test", "test", time.Hour)
+	if !ack.Until.Equal(time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected acknowledgement expiry: %s", ack.Until)
+	}
+	dispatcher.Silence("test", "test", 2*time.Hour, "")
+
+	clock.advance(30 * time.Minute)
+	if !dispatcher.acks.isAcked("test", clock.Now()) {
+		t.Errorf("acknowledgement expired early")
+	}
+
+	clock.advance(time.Hour)
+	if dispatcher.acks.isAcked("test", clock.Now()) {
+		t.Errorf("acknowledgement did not expire")
+	}
+	if _, ok := dispatcher.Silenced("test"); !ok {
+		t.Errorf("silence expired early")
+	}
+
+	clock.advance(time.Hour)
+	if _, ok := dispatcher.Silenced("test"); ok {
+		t.Errorf("silence did not expire")
+	}
+}
+
+func TestBusinessHoursUseClock(t *testing.T) {
+	hours := &config.BusinessHours{Start: "08:00", End: "17:00", Timezone: "UTC"}
+
+	// a Saturday: the alert is deferred
+	_, recorder, updates := newTestDispatcher(t, withClock(newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))))
+	updates <- nokTransition(hours)
+	// a second update is only received once the first has been handled
+	updates <- StatusUpdate{Name: "other", ID: "other"}
+	recorder.lock.Lock()
+	deferred := len(recorder.updates)
+	recorder.lock.Unlock()
+	if deferred != 0 {
+		t.Errorf("expected alert to be deferred outside business hours, got %d alerts", deferred)
+	}
+
+	// a Monday: the alert is sent right away
+	_, recorder, updates = newTestDispatcher(t, withClock(newFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))))
+	updates <- nokTransition(hours)
+	recorder.awaitUpdates(t, 1)
+}
+
+func TestAlertCarriesPreviousState(t *testing.T) {
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	_, recorder, updates := newTestDispatcher(t, withClock(clock))
+
+	updates <- StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		Transition:    true,
+		AlertedStatus: ping.StatusOK,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusOK}},
+	}
+	recorder.awaitUpdates(t, 1)
+	clock.advance(3 * time.Hour)
+	updates <- nokTransition(nil)
+
+	// alerts are delivered concurrently, in any order
+	for _, alert := range recorder.awaitUpdates(t, 2) {
+		if alert.Status.OK {
+			if alert.PreviousState != nil {
+				t.Errorf("expected no previous state of the first state, got %+v", alert.PreviousState)
+			}
+			continue
+		}
+		previous := alert.PreviousState
+		if previous == nil || !previous.OK || !previous.Since.Equal(start) || !previous.Until.Equal(start.Add(3*time.Hour)) {
+			t.Fatalf("unexpected previous state: %+v", previous)
+		}
+		if context := alert.StateContext(); context != "was OK for 3h0m0s, now NOT OK" {
+			t.Errorf("unexpected state context: %q", context)
+		}
+	}
+}
+
+func TestOutputChangeTime(t *testing.T) {
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for _, suppress := range []bool{false, true} {
+		clock := newFakeClock(start)
+		task := newTestTask(&fakePinger{}, config.Schedule{})
+		task.clock = clock
+		task.SuppressDuplicateOutput = suppress
+
+		first := bytes.NewBufferString("disk usage: 42%")
+		task.updateStatus(ping.Result{Status: ping.StatusOK}, first, 1, 0)
+		clock.advance(time.Minute)
+		task.updateStatus(ping.Result{Status: ping.StatusOK}, bytes.NewBufferString("disk usage: 42%"), 1, 0)
+		if changedAt := task.OutputChangeTime(); changedAt == nil || !changedAt.Equal(start) {
+			t.Errorf("suppress %t: expected identical output not to count as a change, got change at %v", suppress, changedAt)
+		}
+		task.statusLock.Lock()
+		kept := task.Output == first
+		task.statusLock.Unlock()
+		if kept != suppress {
+			t.Errorf("suppress %t: got identical output kept %t", suppress, kept)
+		}
+
+		clock.advance(time.Minute)
+		task.updateStatus(ping.Result{Status: ping.StatusOK}, bytes.NewBufferString("disk usage: 43%"), 1, 0)
+		if changedAt := task.OutputChangeTime(); changedAt == nil || !changedAt.Equal(start.Add(2*time.Minute)) {
+			t.Errorf("suppress %t: expected change of output to be recorded, got change at %v", suppress, changedAt)
+		}
+		if output := string(task.OutputBytes()); output != "disk usage: 43%" {
+			t.Errorf("suppress %t: got output %q", suppress, output)
+		}
+	}
+}
+
+func TestAlertStateKeyedOnID(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	dispatcher, err := NewDispatcher(nil, "http://localhost", nil)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err)
+	}
+	dispatcher.clock = clock
+	dispatcher.reminderDelay = time.Hour
+
+	dispatcher.dispatch(alerter.PingerUpdate{Name: "old", ID: "web"}, nil)
+	reminder := func(name, id string) StatusUpdate {
+		return StatusUpdate{
+			Name:          name,
+			ID:            id,
+			AlertedStatus: ping.StatusNOK,
+			Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK}},
+		}
+	}
+
+	clock.advance(30 * time.Minute)
+	if dispatcher.shouldPublish(reminder("new", "web"), flapNone) {
+		t.Errorf("expected no reminder of a renamed pinger before the reminder delay")
+	}
+	clock.advance(time.Hour)
+	if !dispatcher.shouldPublish(reminder("new", "web"), flapNone) {
+		t.Errorf("expected a reminder of a renamed pinger after the reminder delay")
+	}
+	if dispatcher.shouldPublish(reminder("old", "other"), flapNone) {
+		t.Errorf("expected no reminder of a pinger with another ID, which was never alerted on")
+	}
+
+	dispatcher.Acknowledge("web", "old", 0)
+	if dispatcher.shouldPublish(reminder("new", "web"), flapNone) {
+		t.Errorf("expected the acknowledgement to hold for a renamed pinger")
+	}
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/alerter"
+	"github.com/petergardfjall/watcher/config"
+
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAlertBackoff is the delay before the first retry of a failed
+	// alert delivery when no backoff is given in AlertRetry.
+	defaultAlertBackoff = 1 * time.Second
+	// defaultAlertMaxBackoff is the longest delay between two retries of
+	// a failed alert delivery when no maxBackoff is given in AlertRetry.
+	defaultAlertMaxBackoff = 5 * time.Minute
+)
+
+// deliveryRetry describes how failed alert deliveries are retried.
+type deliveryRetry struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// newDeliveryRetry creates a deliveryRetry from a configuration (which may be
+// nil, meaning no retries).
+func newDeliveryRetry(retryConf *config.AlertRetry) deliveryRetry {
+	retry := deliveryRetry{backoff: defaultAlertBackoff, maxBackoff: defaultAlertMaxBackoff}
+	if retryConf == nil {
+		return retry
+	}
+	retry.retries = retryConf.Retries
+	if retryConf.Backoff != nil {
+		retry.backoff = retryConf.Backoff.Duration
+	}
+	if retryConf.MaxBackoff != nil {
+		retry.maxBackoff = retryConf.MaxBackoff.Duration
+	}
+	return retry
+}
+
+// delay returns the delay before a given retry (counted from 1).
+func (retry deliveryRetry) delay(attempt int) time.Duration {
+	delay := retry.backoff
+	for i := 1; i < attempt && delay < retry.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > retry.maxBackoff {
+		delay = retry.maxBackoff
+	}
+	return delay
+}
+
+// deliver sends an alert with an Alerter, retrying failed attempts with
+// exponential backoff. An alert that cannot be delivered is written to the
+// dead-letter log (if any).
+func (dispatcher *Dispatcher) deliver(a alerter.Alerter, update alerter.PingerUpdate) {
+	err := a.Alert(update)
+	for retry := 1; err != nil && retry <= dispatcher.retry.retries; retry++ {
+		delay := dispatcher.retry.delay(retry)
+		log.Warningf("alert failed: retrying in %s (%d/%d): %s", delay, retry, dispatcher.retry.retries, err)
+		time.Sleep(delay)
+		err = a.Alert(update)
+	}
+	if err == nil {
+		return
+	}
+	log.Errorf("alert failed: %s", err)
+	if dispatcher.deadLetters != nil {
+		if err := dispatcher.deadLetters.write(a, update, err); err != nil {
+			log.Errorf("failed to write to dead-letter log: %s", err)
+		}
+	}
+}
+
+// deadLetter is an entry of the dead-letter log.
+type deadLetter struct {
+	Time    time.Time
+	Alerter string
+	Error   string
+	Update  alerter.PingerUpdate
+}
+
+// deadLetterLog appends alerts that could not be delivered to a file, one
+// JSON object per line.
+type deadLetterLog struct {
+	path string
+	// lock serializes writes from concurrent deliveries
+	lock sync.Mutex
+}
+
+// write appends an undeliverable alert to the dead-letter log.
+func (deadLetters *deadLetterLog) write(a alerter.Alerter, update alerter.PingerUpdate, deliveryErr error) error {
+	line, err := json.Marshal(deadLetter{
+		Time:    time.Now().UTC(),
+		Alerter: fmt.Sprintf("%T", a),
+		Error:   deliveryErr.Error(),
+		Update:  update,
+	})
+	if err != nil {
+		return err
+	}
+
+	deadLetters.lock.Lock()
+	defer deadLetters.lock.Unlock()
+	// opened on every write, so that the file can be rotated
+	file, err := os.OpenFile(deadLetters.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/alerter"
+	"github.com/petergardfjall/watcher/config"
+)
+
+// failingAlerter is an Alerter that fails a given number of times (or, if
+// negative, always) and records when it was called.
+type failingAlerter struct {
+	failures int
+	lock     sync.Mutex
+	calls    []time.Time
+}
+
+func (failing *failingAlerter) Alert(update alerter.PingerUpdate) error {
+	failing.lock.Lock()
+	defer failing.lock.Unlock()
+	failing.calls = append(failing.calls, time.Now())
+	if failing.failures >= 0 && len(failing.calls) > failing.failures {
+		return nil
+	}
+	return errors.New("smtp server unavailable")
+}
+
+func TestDeliveryRetryDelay(t *testing.T) {
+	retry := newDeliveryRetry(&config.AlertRetry{
+		Retries:    5,
+		Backoff:    &config.Duration{Duration: time.Second},
+		MaxBackoff: &config.Duration{Duration: 5 * time.Second},
+	})
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if delay := retry.delay(attempt + 1); delay != want {
+			t.Errorf("retry %d: got delay %s, want %s", attempt+1, delay, want)
+		}
+	}
+
+	if retry := newDeliveryRetry(nil); retry.retries != 0 {
+		t.Errorf("expected no retries without configuration, got %d", retry.retries)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	dispatcher, err := NewDispatcher(&config.Alerter{
+		Retry: &config.AlertRetry{
+			Retries:    3,
+			Backoff:    &config.Duration{Duration: 20 * time.Millisecond},
+			MaxBackoff: &config.Duration{Duration: 50 * time.Millisecond},
+		},
+		DeadLetterFile: deadLetterFile,
+	}, "http://localhost", nil)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err)
+	}
+
+	failing := &failingAlerter{failures: -1}
+	dispatcher.deliver(failing, alerter.PingerUpdate{Name: "test"})
+	if len(failing.calls) != 4 {
+		t.Fatalf("expected an attempt and 3 retries, got %d attempts", len(failing.calls))
+	}
+	for i, backoff := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond} {
+		if delay := failing.calls[i+1].Sub(failing.calls[i]); delay < backoff {
+			t.Errorf("retry %d: got delay %s, want at least %s", i+1, delay, backoff)
+		}
+	}
+
+	file, err := os.Open(deadLetterFile)
+	if err != nil {
+		t.Fatalf("failed to open dead-letter log: %s", err)
+	}
+	defer file.Close()
+	var deadLetters []deadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("failed to parse dead letter: %s", err)
+		}
+		deadLetters = append(deadLetters, letter)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("expected one dead letter, got %d", len(deadLetters))
+	}
+	if letter := deadLetters[0]; letter.Update.Name != "test" || letter.Error != "smtp server unavailable" || letter.Alerter != "*engine.failingAlerter" {
+		t.Errorf("unexpected dead letter: %+v", letter)
+	}
+}
+
+func TestDeliverRecoversBeforeDeadLetter(t *testing.T) {
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	dispatcher, err := NewDispatcher(&config.Alerter{
+		Retry:          &config.AlertRetry{Retries: 3, Backoff: &config.Duration{Duration: time.Millisecond}},
+		DeadLetterFile: deadLetterFile,
+	}, "http://localhost", nil)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err)
+	}
+
+	failing := &failingAlerter{failures: 2}
+	dispatcher.deliver(failing, alerter.PingerUpdate{Name: "test"})
+	if len(failing.calls) != 3 {
+		t.Errorf("expected delivery to stop once it succeeds, got %d attempts", len(failing.calls))
+	}
+	if _, err := os.Stat(deadLetterFile); !os.IsNotExist(err) {
+		t.Errorf("expected no dead letter for a delivered alert: %v", err)
+	}
+}
@@ -5,25 +5,90 @@ import (
 	"github.com/petergardfjall/watcher/config"
 	"github.com/petergardfjall/watcher/ping"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
+// deferredFlushInterval is how often the Dispatcher checks if deferred alerts
+// have entered business hours.
+const deferredFlushInterval = time.Minute
+
+// defaultAlertHistoryTTL is how long the latest alert of a pinger that is no
+// longer failing is remembered, unless configured otherwise.
+const defaultAlertHistoryTTL = 24 * time.Hour
+
+// pingerState tracks the (alerted) state of a pinger over time.
+type pingerState struct {
+	status   ping.Status
+	since    time.Time
+	previous *alerter.PreviousState
+	// consecutive failed pings as of the latest failed ping
+	failures int
+}
+
+// A deferredAlert is an alert held back until the business hours of its
+// pinger.
+type deferredAlert struct {
+	update   alerter.PingerUpdate
+	hours    *config.BusinessHours
+	alerters []string
+}
+
 // A Dispatcher pushes pinger status updates to its set of configured Alerters.
 type Dispatcher struct {
-	statusChan        <-chan StatusUpdate
-	alerters          []alerter.Alerter
+	statusChan <-chan StatusUpdate
+	// the configured Alerters (keyed on name)
+	alerters map[string]alerter.Alerter
+	// the names of the Alerters that pingers that select none are alerted
+	// through
+	defaultAlerters   []string
 	alertHistory      map[string]time.Time
+	alertHistoryTTL   time.Duration
 	reminderDelay     time.Duration
 	advertisedBaseURL string
+	acks              *ackRegistry
+	silences          *silenceRegistry
+	// alerts deferred until business hours (keyed on pinger ID)
+	deferred map[string]deferredAlert
+	// decides if this instance is to dispatch alerts (nil: always)
+	leader Leader
+	// the (alerted) state of each pinger (keyed on pinger ID)
+	states map[string]*pingerState
+	// masks the volatile parts of errors in alerts (nil: none)
+	normalizer *alerter.ErrorNormalizer
+	// detects pingers that change state too often (nil: none)
+	flaps *flapDetector
+	// how failed alert deliveries are retried
+	retry deliveryRetry
+	// where undeliverable alerts are written (nil: nowhere)
+	deadLetters *deadLetterLog
+	// tells the time to the Dispatcher
+	clock Clock
+
+	// pauseLock protects paused, which is set when all alerting is paused.
+	pauseLock sync.Mutex
+	paused    bool
 }
 
 // NewDispatcher creates a new Dispatcher with a set of Alerters as configured
-// in an alertsConfig. The Dispatcher will listen for incoming Pinger status
-// updates on a channel and push those updates to its set of configured
-// Alerters.
-func NewDispatcher(alertsConfig *config.Alerter,
+// in an alertsConfig (which may be nil, if no alerting is to be done). The
+// Dispatcher will listen for incoming Pinger status updates on a channel and
+// push those updates to the Alerters selected by each pinger (by default, the
+// email, slack and pagerDuty alerters). The advertisedBaseURL is used to
+// point out pinger output in alerts.
+func NewDispatcher(alertsConfig *config.Alerter, advertisedBaseURL string,
 	statusChan <-chan StatusUpdate) (*Dispatcher, error) {
-	var alerters []alerter.Alerter
+	alerters := make(map[string]alerter.Alerter)
+	alertHistory := make(map[string]time.Time)
+	if alertsConfig == nil {
+		return &Dispatcher{statusChan: statusChan, alerters: alerters, clock: realClock{},
+			alertHistory: alertHistory, alertHistoryTTL: defaultAlertHistoryTTL,
+			advertisedBaseURL: advertisedBaseURL, acks: newAckRegistry(),
+			silences: newSilenceRegistry(),
+			retry:    newDeliveryRetry(nil),
+			deferred: make(map[string]deferredAlert), states: make(map[string]*pingerState)}, nil
+	}
 
 	if alertsConfig.Email != nil {
 		log.Debugf("setting up email alerter ...")
@@ -31,81 +96,412 @@ func NewDispatcher(alertsConfig *config.Alerter,
 		if err != nil {
 			return nil, fmt.Errorf("dispatcher: failed to initialize email alerter: %s", err)
 		}
-		alerters = append(alerters, alerter)
+		alerters[config.EmailAlerterName] = alerter
 	}
 
-	alertHistory := make(map[string]time.Time)
-	baseURL := fmt.Sprintf("https://%s:%d", alertsConfig.AdvertisedIP, alertsConfig.AdvertisedPort)
-	return &Dispatcher{statusChan, alerters, alertHistory, alertsConfig.ReminderDelay.Duration, baseURL}, nil
+	if alertsConfig.Slack != nil {
+		log.Debugf("setting up slack alerter ...")
+		alerter, err := alerter.NewSlackAlerter(alertsConfig.Slack)
+		if err != nil {
+			return nil, fmt.Errorf("dispatcher: failed to initialize slack alerter: %s", err)
+		}
+		alerters[config.SlackAlerterName] = alerter
+	}
+
+	if alertsConfig.PagerDuty != nil {
+		log.Debugf("setting up pagerduty alerter ...")
+		alerter, err := alerter.NewPagerDutyAlerter(alertsConfig.PagerDuty)
+		if err != nil {
+			return nil, fmt.Errorf("dispatcher: failed to initialize pagerduty alerter: %s", err)
+		}
+		alerters[config.PagerDutyAlerterName] = alerter
+	}
+
+	var defaultAlerters []string
+	for _, name := range []string{config.EmailAlerterName, config.SlackAlerterName, config.PagerDutyAlerterName} {
+		if _, ok := alerters[name]; ok {
+			defaultAlerters = append(defaultAlerters, name)
+		}
+	}
+
+	for _, named := range alertsConfig.Named {
+		log.Debugf("setting up alerter %s ...", named.Name)
+		alerter, err := newNamedAlerter(named)
+		if err != nil {
+			return nil, fmt.Errorf("dispatcher: failed to initialize alerter %s: %s", named.Name, err)
+		}
+		alerters[named.Name] = alerter
+	}
+
+	var normalizer *alerter.ErrorNormalizer
+	if alertsConfig.ErrorNormalization != nil {
+		var err error
+		normalizer, err = alerter.NewErrorNormalizer(alertsConfig.ErrorNormalization)
+		if err != nil {
+			return nil, fmt.Errorf("dispatcher: %s", err)
+		}
+	}
+
+	alertHistoryTTL := defaultAlertHistoryTTL
+	if alertsConfig.AlertHistoryTTL != nil {
+		alertHistoryTTL = alertsConfig.AlertHistoryTTL.Duration
+	}
+
+	var deadLetters *deadLetterLog
+	if alertsConfig.DeadLetterFile != "" {
+		deadLetters = &deadLetterLog{path: alertsConfig.DeadLetterFile}
+	}
+
+	return &Dispatcher{statusChan: statusChan, alerters: alerters, clock: realClock{},
+		defaultAlerters: defaultAlerters,
+		alertHistory:    alertHistory, alertHistoryTTL: alertHistoryTTL,
+		normalizer:        normalizer,
+		flaps:             newFlapDetector(alertsConfig.FlapDetection),
+		retry:             newDeliveryRetry(alertsConfig.Retry),
+		deadLetters:       deadLetters,
+		reminderDelay:     alertsConfig.ReminderDelay.Duration,
+		advertisedBaseURL: advertisedBaseURL, acks: newAckRegistry(),
+		silences: newSilenceRegistry(),
+		deferred: make(map[string]deferredAlert), states: make(map[string]*pingerState)}, nil
+}
+
+// newNamedAlerter creates the Alerter of a NamedAlerter configuration.
+func newNamedAlerter(named config.NamedAlerter) (alerter.Alerter, error) {
+	switch {
+	case named.Email != nil:
+		return alerter.NewEmailAlerter(named.Email)
+	case named.Slack != nil:
+		return alerter.NewSlackAlerter(named.Slack)
+	case named.PagerDuty != nil:
+		return alerter.NewPagerDutyAlerter(named.PagerDuty)
+	default:
+		return nil, fmt.Errorf("no alerter configured")
+	}
+}
+
+// Acknowledge acknowledges a (failing) pinger with a given ID and name,
+// suppressing reminder alerts for it until the snooze duration has passed or
+// the pinger recovers. A zero snooze acknowledges the pinger until it
+// recovers.
+func (dispatcher *Dispatcher) Acknowledge(pingerID, pingerName string, snooze time.Duration) Acknowledgement {
+	return dispatcher.acks.ack(pingerID, pingerName, snooze, dispatcher.clock.Now())
+}
+
+// Silence mutes all alerts for a pinger with a given ID and name for a given
+// duration (replacing any earlier silence of the pinger).
+func (dispatcher *Dispatcher) Silence(pingerID, pingerName string, duration time.Duration, reason string) Silence {
+	return dispatcher.silences.silence(pingerID, pingerName, duration, reason, dispatcher.clock.Now())
+}
+
+// Unsilence ends the silence (if any) of a pinger with a given ID. It
+// returns false if the pinger was not silenced.
+func (dispatcher *Dispatcher) Unsilence(pingerID string) bool {
+	return dispatcher.silences.unsilence(pingerID)
+}
+
+// Flapping returns the time since which a pinger with a given ID has been
+// flapping, if it is flapping.
+func (dispatcher *Dispatcher) Flapping(pingerID string) (time.Time, bool) {
+	return dispatcher.flaps.Flapping(pingerID)
+}
+
+// Silenced returns the silence of a pinger with a given ID, if it is
+// silenced.
+func (dispatcher *Dispatcher) Silenced(pingerID string) (Silence, bool) {
+	return dispatcher.silences.get(pingerID, dispatcher.clock.Now())
+}
+
+// SetPaused pauses (or resumes) all alerting. While paused, no alerts are
+// sent out, but pinger statuses are still updated.
+func (dispatcher *Dispatcher) SetPaused(paused bool) {
+	dispatcher.pauseLock.Lock()
+	defer dispatcher.pauseLock.Unlock()
+	dispatcher.paused = paused
+}
+
+// Paused returns true if all alerting is paused.
+func (dispatcher *Dispatcher) Paused() bool {
+	dispatcher.pauseLock.Lock()
+	defer dispatcher.pauseLock.Unlock()
+	return dispatcher.paused
 }
 
 // Start activates this Dispatcher, making it start listening for pinger status
-// updates on its status channel.
+// updates on its status channel, until the status channel is closed.
 func (dispatcher *Dispatcher) Start() {
+	flushTicker := time.NewTicker(deferredFlushInterval)
+	defer flushTicker.Stop()
 	for {
 		select {
-		case statusUpdate := <-dispatcher.statusChan:
-			if !dispatcher.shouldPublish(statusUpdate) {
+		case <-flushTicker.C:
+			dispatcher.flushDeferred(dispatcher.clock.Now())
+			dispatcher.evictAlertHistory(dispatcher.clock.Now().UTC())
+		case statusUpdate, ok := <-dispatcher.statusChan:
+			if !ok {
+				log.Debugf("status channel closed: stopping dispatcher")
+				return
+			}
+			if statusUpdate.Removed {
+				dispatcher.forget(statusUpdate.ID)
+				continue
+			}
+			state := dispatcher.trackState(statusUpdate, dispatcher.clock.Now().UTC())
+			dispatcher.runHooks(statusUpdate, state)
+			flap := dispatcher.flaps.observe(statusUpdate, state, dispatcher.clock.Now())
+			if !dispatcher.shouldPublish(statusUpdate, flap) {
 				log.Debugf("suppressing: %+v", statusUpdate)
 				continue
 			}
+			// recoveries are always alerted, whatever the number of alerts
+			recovery := statusUpdate.Status.LatestResult.Status == ping.StatusOK
+			if !recovery && dispatcher.acks.alertLimitReached(statusUpdate.ID, statusUpdate.MaxAlerts) {
+				log.Infof("[%s] maximum number of alerts (%d) reached: not alerting until acknowledged", statusUpdate.Name, statusUpdate.MaxAlerts)
+				continue
+			}
 			pingResult := statusUpdate.Status.LatestResult
 			var error string
-			if pingResult.Error != nil {
+			if pingResult.Error != nil && statusUpdate.RedactOutput {
+				error = ping.RedactOutput(pingResult.Error)
+			} else if pingResult.Error != nil {
 				error = pingResult.Error.Error()
 			}
 			status := alerter.PingerStatus{
-				OK:        pingResult.Status == ping.StatusOK,
-				Error:     error,
-				OutputURL: outputURL(dispatcher.advertisedBaseURL, statusUpdate.Name),
+				OK:            pingResult.Status == ping.StatusOK,
+				Error:         error,
+				ErrorCategory: string(pingResult.Category),
+				OutputURL:     outputURL(dispatcher.advertisedBaseURL, statusUpdate.Name),
+				OutputChanged: pingResult.OutputChanged,
+			}
+			if statusUpdate.RedactOutput {
+				status.OutputURL = ""
+			}
+			if dispatcher.normalizer != nil && error != "" {
+				status.NormalizedError = dispatcher.normalizer.Normalize(error)
 			}
 
 			update := alerter.PingerUpdate{
-				Name:        statusUpdate.Name,
-				Status:      status,
-				Consecutive: statusUpdate.Status.Consecutive,
-				LatestOK:    statusUpdate.Status.LatestOK,
-				LatestNOK:   statusUpdate.Status.LatestNOK,
+				SchemaVersion: alerter.SchemaVersion,
+				Name:          statusUpdate.Name,
+				Description:   statusUpdate.Description,
+				ID:            statusUpdate.ID,
+				Labels:        statusUpdate.Labels,
+				Status:        status,
+				Consecutive:   statusUpdate.Status.Consecutive,
+				LatestOK:      statusUpdate.Status.LatestOK,
+				LatestNOK:     statusUpdate.Status.LatestNOK,
 			}
+			if state != nil {
+				since := state.since
+				update.InStateSince = &since
+				update.PreviousState = state.previous
+			}
+			update.Recovered = recovered(statusUpdate, state)
+			update.Flapping = flap == flapStarted
+
+			if hours := statusUpdate.BusinessHours; hours != nil && !hours.Contains(dispatcher.clock.Now()) {
+				// only the latest alert is kept for the pinger
+				log.Infof("[%s] outside business hours: deferring alert", update.Name)
+				dispatcher.deferred[update.ID] = deferredAlert{update, hours, statusUpdate.Alerters}
+				continue
+			}
+			delete(dispatcher.deferred, update.ID)
 
 			log.Debugf("dispatching %+v", statusUpdate)
-			dispatcher.dispatch(update)
+			dispatcher.dispatch(update, statusUpdate.Alerters)
+		}
+	}
+
+}
+
+// trackState records state transitions of a pinger (as alerted on) and
+// returns its tracked state (nil if it has not yet been in any state).
+func (dispatcher *Dispatcher) trackState(update StatusUpdate, now time.Time) *pingerState {
+	state, ok := dispatcher.states[update.ID]
+	if update.AlertedStatus == ping.StatusUnknown {
+		return state
+	}
+	if !ok {
+		state = &pingerState{status: update.AlertedStatus, since: now}
+		dispatcher.states[update.ID] = state
+	} else if state.status != update.AlertedStatus {
+		state.previous = &alerter.PreviousState{
+			OK:    state.status == ping.StatusOK,
+			Since: state.since,
+			Until: now,
+		}
+		if state.status == ping.StatusNOK {
+			state.previous.Failures = state.failures
+		}
+		state.status = update.AlertedStatus
+		state.since = now
+	}
+	if update.Status.LatestResult.Status == ping.StatusNOK {
+		state.failures = update.Status.Consecutive
+	}
+	return state
+}
+
+// recovered returns true if a status update conveys that a pinger went from
+// NOK to OK, given its tracked state. A pinger that is OK from the start (that
+// is, after being in state unknown) has not recovered.
+func recovered(update StatusUpdate, state *pingerState) bool {
+	return update.Transition && update.AlertedStatus == ping.StatusOK &&
+		state != nil && state.previous != nil && !state.previous.OK
+}
+
+// flushDeferred dispatches the deferred alerts whose pingers have entered
+// business hours at a given point in time.
+func (dispatcher *Dispatcher) flushDeferred(now time.Time) {
+	for id, deferred := range dispatcher.deferred {
+		if deferred.hours.Contains(now) {
+			log.Infof("[%s] within business hours: dispatching deferred alert", deferred.update.Name)
+			delete(dispatcher.deferred, id)
+			dispatcher.dispatch(deferred.update, deferred.alerters)
 		}
 	}
+}
 
+// evictAlertHistory removes the alert history of pingers that are not
+// failing and have not been alerted on within the alert history TTL, to keep
+// the history from growing without bounds.
+func (dispatcher *Dispatcher) evictAlertHistory(now time.Time) {
+	for id, lastAlert := range dispatcher.alertHistory {
+		if state, ok := dispatcher.states[id]; ok && state.status == ping.StatusNOK {
+			continue
+		}
+		if now.Sub(lastAlert) > dispatcher.alertHistoryTTL {
+			log.Debugf("evicting alert history of pinger with id %s", id)
+			delete(dispatcher.alertHistory, id)
+		}
+	}
 }
 
-func (dispatcher *Dispatcher) dispatch(update alerter.PingerUpdate) {
+// forget discards the alert history, state, deferred alert, acknowledgement
+// and flapping state of a (removed) pinger.
+func (dispatcher *Dispatcher) forget(pingerID string) {
+	delete(dispatcher.alertHistory, pingerID)
+	delete(dispatcher.states, pingerID)
+	delete(dispatcher.deferred, pingerID)
+	dispatcher.acks.forget(pingerID)
+	dispatcher.silences.unsilence(pingerID)
+	dispatcher.flaps.forget(pingerID)
+}
+
+// runHooks runs the hook (if any) of a pinger that has changed state: the
+// OnDown hook when it goes down and the OnUp hook when it recovers. Like
+// alerts, hooks are not run while alerting is paused or on a standby
+// instance.
+func (dispatcher *Dispatcher) runHooks(update StatusUpdate, state *pingerState) {
+	if update.Hooks == nil || !update.Transition {
+		return
+	}
+	var command string
+	switch {
+	case update.AlertedStatus == ping.StatusNOK:
+		command = update.Hooks.OnDown
+	case recovered(update, state):
+		command = update.Hooks.OnUp
+	}
+	if command == "" {
+		return
+	}
+	if dispatcher.Paused() {
+		log.Infof("[%s] alerting paused: not running hook", update.Name)
+		return
+	}
+	if dispatcher.leader != nil && !dispatcher.leader.IsLeader() {
+		log.Infof("[%s] standby instance: not running hook", update.Name)
+		return
+	}
+	go runHook(command, update.Hooks.Timeout, update)
+}
+
+// dispatch sends an alert through the Alerters with the given names (or, if
+// none are given, through the default Alerters).
+func (dispatcher *Dispatcher) dispatch(update alerter.PingerUpdate, alerterNames []string) {
+	if dispatcher.Paused() {
+		log.Infof("alerting paused: not dispatching pinger update: %+v", update)
+		return
+	}
+	if dispatcher.leader != nil && !dispatcher.leader.IsLeader() {
+		// keep the alert history up-to-date in case of a takeover
+		log.Infof("standby instance: not dispatching pinger update: %+v", update)
+		dispatcher.alertHistory[update.ID] = dispatcher.clock.Now().UTC()
+		return
+	}
 	log.Infof("dispatching pinger update: %+v", update)
 
-	for _, a := range dispatcher.alerters {
-		go func(a alerter.Alerter) {
-			if err := a.Alert(update); err != nil {
-				log.Errorf("alert failed: %s", err)
-			}
-		}(a)
+	if len(alerterNames) == 0 {
+		alerterNames = dispatcher.defaultAlerters
+	}
+	for _, name := range alerterNames {
+		a, ok := dispatcher.alerters[name]
+		if !ok {
+			// selected alerters are only set up on restart
+			log.Warningf("[%s] no such alerter: %s", update.Name, name)
+			continue
+		}
+		go dispatcher.deliver(a, update)
 	}
 
-	dispatcher.alertHistory[update.Name] = time.Now().UTC()
+	dispatcher.alertHistory[update.ID] = dispatcher.clock.Now().UTC()
+	if !update.Status.OK {
+		dispatcher.acks.countAlert(update.ID)
+	}
 }
 
-// shouldPublish returns true if a given status update warrants an alert.
-// This is the case if a state transition has taken place for the pinger or
-// if the pinger failed and the reminder delay has been exceeded since the
-// last alert.
-func (dispatcher *Dispatcher) shouldPublish(update StatusUpdate) bool {
+// shouldPublish returns true if a given status update (with a given effect on
+// the flapping of its pinger) warrants an alert. This is the case, unless the
+// pinger is silenced, if the pinger started or stopped flapping, if a state
+// transition has taken place for the (non-flapping) pinger or if the pinger
+// failed and the reminder delay has been exceeded since the last alert.
+func (dispatcher *Dispatcher) shouldPublish(update StatusUpdate, flap flapState) bool {
 	pingerName := update.Name
+	// a recovered pinger is no longer acknowledged and its alerts are
+	// counted anew
+	if update.Status.LatestResult.Status == ping.StatusOK {
+		dispatcher.acks.clear(update.ID)
+	}
+
+	if silence, ok := dispatcher.silences.get(update.ID, dispatcher.clock.Now()); ok {
+		log.Debugf("[%s] is silenced until %s", pingerName, silence.Until)
+		return false
+	}
+
+	switch flap {
+	case flapStarted:
+		log.Infof("[%s] is flapping", pingerName)
+		return true
+	case flapStopped:
+		log.Infof("[%s] stopped flapping", pingerName)
+		return true
+	case flapOngoing:
+		log.Debugf("[%s] is flapping: suppressing", pingerName)
+		return false
+	}
+
 	// state transistions are always to be published
-	if statusChanged(update.Status) {
+	if update.Transition {
 		log.Debugf("state transition on [%s]", pingerName)
 		return true
 	}
 
-	// if not a state transition, we only alert of error states in
-	// case the reminder delay has passed since the last alert.
-	if update.Status.LatestResult.Status == ping.StatusNOK {
-		if lastAlert, ok := dispatcher.alertHistory[pingerName]; ok {
-			timeUntilReminder := dispatcher.reminderDelay - time.Since(lastAlert)
+	// changed output is always to be published
+	if update.Status.LatestResult.OutputChanged {
+		log.Debugf("output changed on [%s]", pingerName)
+		return true
+	}
+
+	// if not a state transition, we only alert of error states (that have
+	// been alerted on) in case the reminder delay has passed since the last
+	// alert.
+	if update.Status.LatestResult.Status == ping.StatusNOK && update.AlertedStatus == ping.StatusNOK {
+		if dispatcher.acks.isAcked(update.ID, dispatcher.clock.Now()) {
+			log.Debugf("[%s] is acknowledged: suppressing reminder", pingerName)
+			return false
+		}
+		if lastAlert, ok := dispatcher.alertHistory[update.ID]; ok {
+			timeUntilReminder := dispatcher.reminderDelay - dispatcher.clock.Now().Sub(lastAlert)
 			log.Debugf("time until reminder for [%s]: %s", pingerName, timeUntilReminder.String())
 			return timeUntilReminder <= 0
 		}
@@ -114,14 +510,8 @@ func (dispatcher *Dispatcher) shouldPublish(update StatusUpdate) bool {
 	return false
 }
 
-// statusChanged returns true if a StatusUpdate conveys a state transition
-// (for example, from StatusOK to StatusNOK) indicated by the Consecutive
-// field being equal to 1. A pinger being in state unknown does not count
-// as a state change either (it is the initial state of the pinger).
-func statusChanged(status PingerTaskStatus) bool {
-	return status.Consecutive == 1 && status.LatestResult.Status != ping.StatusUnknown
-}
-
+// outputURL returns the URL at which the latest output of a pinger can be
+// retrieved, given the advertised base URL (possibly with a path prefix).
 func outputURL(baseURL string, pingerName string) string {
-	return fmt.Sprintf("%s/pingers/%s/output", baseURL, pingerName)
+	return fmt.Sprintf("%s/pingers/%s/output", strings.TrimRight(baseURL, "/"), pingerName)
 }
@@ -0,0 +1,388 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/alerter"
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// recordingAlerter is an Alerter that records the updates it is sent.
+type recordingAlerter struct {
+	lock    sync.Mutex
+	updates []alerter.PingerUpdate
+}
+
+func (recorder *recordingAlerter) Alert(update alerter.PingerUpdate) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.updates = append(recorder.updates, update)
+	return nil
+}
+
+// awaitUpdates waits for the recordingAlerter to have been sent a given
+// number of updates and returns them.
+func (recorder *recordingAlerter) awaitUpdates(t *testing.T, n int) []alerter.PingerUpdate {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder.lock.Lock()
+		updates := append([]alerter.PingerUpdate(nil), recorder.updates...)
+		recorder.lock.Unlock()
+		if len(updates) >= n {
+			return updates
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d alerts, got %d", n, len(updates))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestDispatcher starts a Dispatcher (without alerter configuration) that
+// alerts a recordingAlerter, and returns the channel to send it status
+// updates on. The Dispatcher is modified by configure (unless nil) before it
+// is started.
+func newTestDispatcher(t *testing.T, configure func(dispatcher *Dispatcher)) (*Dispatcher, *recordingAlerter, chan<- StatusUpdate) {
+	t.Helper()
+	updates := make(chan StatusUpdate)
+	dispatcher, err := NewDispatcher(nil, "http://localhost", updates)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err)
+	}
+	recorder := &recordingAlerter{}
+	dispatcher.alerters = map[string]alerter.Alerter{"test": recorder}
+	dispatcher.defaultAlerters = []string{"test"}
+	if configure != nil {
+		configure(dispatcher)
+	}
+	go dispatcher.Start()
+	return dispatcher, recorder, updates
+}
+
+func TestAlertCarriesErrorCategory(t *testing.T) {
+	_, recorder, updates := newTestDispatcher(t, nil)
+	updates <- StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		Transition:    true,
+		AlertedStatus: ping.StatusNOK,
+		Status: PingerTaskStatus{LatestResult: ping.Result{
+			Status:   ping.StatusNOK,
+			Error:    errors.New("ping failed: i/o timeout"),
+			Category: ping.CategoryTimeout,
+		}},
+	}
+	alert := recorder.awaitUpdates(t, 1)[0]
+	if alert.Status.Error != "ping failed: i/o timeout" || alert.Status.ErrorCategory != "timeout" {
+		t.Errorf("unexpected alert status: %+v", alert.Status)
+	}
+}
+
+func TestAlertCarriesDescription(t *testing.T) {
+	_, recorder, updates := newTestDispatcher(t, nil)
+	updates <- StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		Description:   "Public web site, see runbook/web.md",
+		Transition:    true,
+		AlertedStatus: ping.StatusNOK,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK, Error: errors.New("ping failed")}},
+	}
+	if alert := recorder.awaitUpdates(t, 1)[0]; alert.Description != "Public web site, see runbook/web.md" {
+		t.Errorf("got description %q", alert.Description)
+	}
+}
+
+func TestAlertCarriesNormalizedError(t *testing.T) {
+	normalizer, err := alerter.NewErrorNormalizer(&config.ErrorNormalization{})
+	if err != nil {
+		t.Fatalf("failed to create normalizer: %s", err)
+	}
+	_, recorder, updates := newTestDispatcher(t, func(dispatcher *Dispatcher) {
+		dispatcher.normalizer = normalizer
+	})
+
+	for _, port := range []string{"8080", "9090"} {
+		updates <- StatusUpdate{
+			Name:          "test-" + port,
+			ID:            "test-" + port,
+			Transition:    true,
+			AlertedStatus: ping.StatusNOK,
+			Status: PingerTaskStatus{LatestResult: ping.Result{
+				Status: ping.StatusNOK,
+				Error:  errors.New("dial tcp 10.0.0.1:" + port + ": connection refused"),
+			}},
+		}
+	}
+	// alerts are delivered concurrently, in any order
+	statuses := make(map[string]alerter.PingerStatus)
+	for _, alert := range recorder.awaitUpdates(t, 2) {
+		statuses[alert.Name] = alert.Status
+	}
+	if statuses["test-8080"].NormalizedError != statuses["test-9090"].NormalizedError {
+		t.Errorf("expected same normalized error, got %q and %q", statuses["test-8080"].NormalizedError, statuses["test-9090"].NormalizedError)
+	}
+	if statuses["test-8080"].Error != "dial tcp 10.0.0.1:8080: connection refused" {
+		t.Errorf("expected full error to be kept, got %q", statuses["test-8080"].Error)
+	}
+}
+
+// count returns the number of updates that the recordingAlerter has been
+// sent, once no more arrive for a while (alerts are delivered
+// asynchronously).
+func (recorder *recordingAlerter) count() int {
+	time.Sleep(50 * time.Millisecond)
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	return len(recorder.updates)
+}
+
+func TestMaxAlerts(t *testing.T) {
+	dispatcher, recorder, updates := newTestDispatcher(t, nil)
+	failing := StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		MaxAlerts:     2,
+		Transition:    true,
+		AlertedStatus: ping.StatusNOK,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK}},
+	}
+
+	for i := 0; i < 5; i++ {
+		updates <- failing
+	}
+	recorder.awaitUpdates(t, 2)
+	if alerts := recorder.count(); alerts != 2 {
+		t.Fatalf("expected alerting to stop after 2 alerts, got %d", alerts)
+	}
+
+	// alerting resumes once acknowledged
+	dispatcher.Acknowledge("test", "test", 0)
+	for i := 0; i < 5; i++ {
+		updates <- failing
+	}
+	recorder.awaitUpdates(t, 4)
+	if alerts := recorder.count(); alerts != 4 {
+		t.Errorf("expected alerting to stop after 2 more alerts, got %d", alerts)
+	}
+}
+
+func TestAlertOnOutputChange(t *testing.T) {
+	_, recorder, updates := newTestDispatcher(t, nil)
+	for _, changed := range []bool{false, true, false} {
+		updates <- StatusUpdate{
+			Name:          "test",
+			ID:            "test",
+			AlertedStatus: ping.StatusOK,
+			Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusOK, OutputChanged: changed}},
+		}
+	}
+	alerts := recorder.awaitUpdates(t, 1)
+	if !alerts[0].Status.OK || !alerts[0].Status.OutputChanged {
+		t.Errorf("expected an alert of the changed output, got %+v", alerts[0].Status)
+	}
+	if alerts := recorder.count(); alerts != 1 {
+		t.Errorf("expected only the changed output to be alerted, got %d alerts", alerts)
+	}
+}
+
+func TestPausedAlerting(t *testing.T) {
+	dispatcher, recorder, updates := newTestDispatcher(t, nil)
+	transition := func(name string) StatusUpdate {
+		return StatusUpdate{
+			Name:          name,
+			ID:            name,
+			Transition:    true,
+			AlertedStatus: ping.StatusNOK,
+			Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK}},
+		}
+	}
+
+	dispatcher.SetPaused(true)
+	updates <- transition("paused")
+	if alerts := recorder.count(); alerts != 0 {
+		t.Errorf("expected no alerts while paused, got %d", alerts)
+	}
+
+	dispatcher.SetPaused(false)
+	updates <- transition("resumed")
+	alerts := recorder.awaitUpdates(t, 1)
+	if len(alerts) != 1 || alerts[0].Name != "resumed" {
+		t.Errorf("expected only the alert after resuming, got %+v", alerts)
+	}
+}
+
+func TestMaxAlertsResetOnRecovery(t *testing.T) {
+	_, recorder, updates := newTestDispatcher(t, nil)
+	failing := StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		MaxAlerts:     2,
+		Transition:    true,
+		AlertedStatus: ping.StatusNOK,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK}},
+	}
+	recovery := StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		MaxAlerts:     2,
+		Transition:    true,
+		AlertedStatus: ping.StatusOK,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusOK}},
+	}
+
+	for i := 0; i < 3; i++ {
+		updates <- failing
+	}
+	// a recovery is alerted even though the limit has been reached
+	updates <- recovery
+	recovered := false
+	for _, alert := range recorder.awaitUpdates(t, 3) {
+		recovered = recovered || alert.Status.OK
+	}
+	if !recovered {
+		t.Errorf("expected the recovery to be alerted")
+	}
+
+	// a new incident is alerted up to the limit again
+	for i := 0; i < 3; i++ {
+		updates <- failing
+	}
+	recorder.awaitUpdates(t, 5)
+	updates <- recovery
+	recorder.awaitUpdates(t, 6)
+	if alerts := recorder.count(); alerts != 6 {
+		t.Errorf("expected 2 alerts for the new incident and its recovery, got %d alerts in total", alerts)
+	}
+}
+
+func TestRedactOutputInAlerts(t *testing.T) {
+	_, recorder, updates := newTestDispatcher(t, nil)
+
+	outputErr := &ping.OutputError{Message: `output matches forbidden pattern 'token': "token=abc"`, Excerpt: `"token=abc"`}
+	err := fmt.Errorf("2 of 3 pings failed, latest: %w", outputErr)
+	for _, name := range []string{"redacted", "plain"} {
+		updates <- StatusUpdate{
+			Name:          name,
+			ID:            name,
+			RedactOutput:  name == "redacted",
+			Transition:    true,
+			AlertedStatus: ping.StatusNOK,
+			Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK, Error: err}},
+		}
+	}
+	statuses := make(map[string]alerter.PingerStatus)
+	for _, alert := range recorder.awaitUpdates(t, 2) {
+		statuses[alert.Name] = alert.Status
+	}
+
+	redacted := statuses["redacted"]
+	if strings.Contains(redacted.Error, "token=abc") || !strings.Contains(redacted.Error, "forbidden pattern 'token'") {
+		t.Errorf("expected output to be left out of the error, got %q", redacted.Error)
+	}
+	if redacted.OutputURL != "" {
+		t.Errorf("expected no output URL, got %q", redacted.OutputURL)
+	}
+	plain := statuses["plain"]
+	if plain.Error != err.Error() || plain.OutputURL == "" {
+		t.Errorf("expected output to be kept for a pinger that is not redacted, got %+v", plain)
+	}
+}
+
+func TestOutputURLHonorsPathPrefix(t *testing.T) {
+	alerterConf := &config.Alerter{AdvertisedScheme: "http", AdvertisedIP: "proxy.example.com", AdvertisedPort: 80, AdvertisedPathPrefix: "/watcher/"}
+	_, recorder, updates := newTestDispatcher(t, func(dispatcher *Dispatcher) {
+		dispatcher.advertisedBaseURL = alerterConf.AdvertisedBaseURL()
+	})
+	updates <- StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		Transition:    true,
+		AlertedStatus: ping.StatusNOK,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK}},
+	}
+	alert := recorder.awaitUpdates(t, 1)[0]
+	if alert.Status.OutputURL != "http://proxy.example.com:80/watcher/pingers/test/output" {
+		t.Errorf("unexpected output URL: %s", alert.Status.OutputURL)
+	}
+}
+
+func TestOutputURLUsesAdvertisedURL(t *testing.T) {
+	advertisedURL := (&config.Alerter{AdvertisedURL: "https://vip.example.com:8443/watcher/"}).AdvertisedBaseURL()
+	_, recorder, updates := newTestDispatcher(t, func(dispatcher *Dispatcher) {
+		dispatcher.advertisedBaseURL = advertisedURL
+	})
+	updates <- StatusUpdate{
+		Name:          "test",
+		ID:            "test",
+		Transition:    true,
+		AlertedStatus: ping.StatusNOK,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: ping.StatusNOK}},
+	}
+	alert := recorder.awaitUpdates(t, 1)[0]
+	if alert.Status.OutputURL != "https://vip.example.com:8443/watcher/pingers/test/output" {
+		t.Errorf("unexpected output URL: %s", alert.Status.OutputURL)
+	}
+}
+
+func TestAlertHistoryEviction(t *testing.T) {
+	dispatcher, err := NewDispatcher(&config.Alerter{AlertHistoryTTL: &config.Duration{Duration: time.Hour}}, "http://localhost", make(chan StatusUpdate))
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err)
+	}
+	now := time.Now().UTC()
+	expired := now.Add(-61 * time.Minute)
+	dispatcher.alertHistory["recovered"] = expired
+	dispatcher.states["recovered"] = &pingerState{status: ping.StatusOK}
+	dispatcher.alertHistory["failing"] = expired
+	dispatcher.states["failing"] = &pingerState{status: ping.StatusNOK}
+	// the state of a pinger may be unknown, such as after a restart
+	dispatcher.alertHistory["stateless"] = expired
+	dispatcher.alertHistory["recent"] = now.Add(-59 * time.Minute)
+	dispatcher.states["recent"] = &pingerState{status: ping.StatusOK}
+
+	dispatcher.evictAlertHistory(now)
+	for id, kept := range map[string]bool{"recovered": false, "failing": true, "stateless": false, "recent": true} {
+		if _, ok := dispatcher.alertHistory[id]; ok != kept {
+			t.Errorf("%s: expected alert history to be kept: %t", id, kept)
+		}
+	}
+}
+
+func TestRemovedPingerIsForgotten(t *testing.T) {
+	updates := make(chan StatusUpdate)
+	dispatcher, err := NewDispatcher(nil, "http://localhost", updates)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err)
+	}
+	dispatcher.alertHistory["removed"] = time.Now().UTC()
+	dispatcher.states["removed"] = &pingerState{status: ping.StatusNOK}
+	dispatcher.alertHistory["kept"] = time.Now().UTC()
+	dispatcher.states["kept"] = &pingerState{status: ping.StatusNOK}
+
+	stopped := make(chan struct{})
+	go func() {
+		dispatcher.Start()
+		close(stopped)
+	}()
+	updates <- StatusUpdate{Name: "removed", ID: "removed", Removed: true}
+	close(updates)
+	<-stopped
+
+	if _, ok := dispatcher.alertHistory["removed"]; ok {
+		t.Errorf("expected the alert history of a removed pinger to be discarded")
+	}
+	if _, ok := dispatcher.states["removed"]; ok {
+		t.Errorf("expected the state of a removed pinger to be discarded")
+	}
+	if _, ok := dispatcher.alertHistory["kept"]; !ok {
+		t.Errorf("expected the alert history of other pingers to be kept")
+	}
+}
@@ -1,6 +1,9 @@
 package engine
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"github.com/petergardfjall/watcher/config"
 	"github.com/petergardfjall/watcher/ping"
 	"fmt"
@@ -14,8 +17,11 @@ var log = logging.MustGetLogger("engine")
 var (
 	// default DefaultSchedule to use when no defaultSchedule is given
 	// in EngineConfig
-	defaultInterval         = config.Duration{Duration: 10 * time.Minute}
-	defaultRetryDelay       = config.Duration{Duration: 3 * time.Second}
+	defaultInterval   = config.Duration{Duration: 10 * time.Minute}
+	defaultRetryDelay = config.Duration{Duration: 3 * time.Second}
+	// default time to wait for ongoing pings of removed and replaced
+	// pingers on reload when no drainTimeout is given in EngineConfig
+	defaultDrainTimeout     = 30 * time.Second
 	standardDefaultSchedule = config.Schedule{
 		Interval: &defaultInterval,
 		Retries: &config.Retries{
@@ -29,80 +35,728 @@ var (
 // An Engine drives the execution of a set of Pingers, each according to their
 // configured schedule.
 type Engine struct {
-	Pingers         map[string]*PingerTask
 	WaitGroup       sync.WaitGroup
 	DefaultSchedule config.Schedule
+	// Events publishes the StatusUpdates of all Pingers. Subscribe to it
+	// to observe pinger statuses.
+	Events *EventBus
+	// Metrics of the Pingers, recorded as they run.
+	Metrics *Metrics
+
+	dispatcher *Dispatcher
+	// the subscriptions to Events of the Dispatcher and the status
+	// webhook, which end when the Engine is stopped
+	subscriptions []<-chan StatusUpdate
+	// configuration parts kept for Config()
+	alerterConf              *config.Alerter
+	maxSSHConnectionsPerHost int
+	haConf                   *config.HA
+	vantagesConf             []config.Vantage
+	globalLabels             map[string]string
+	maxConsecutive           int
+	minInterval              *config.Duration
+	drainTimeout             *config.Duration
+	historySize              *int
+	statusWebhookConf        *config.StatusWebhook
+	livenessConf             *config.Liveness
+	randSeed                 *int64
+
+	// random is the source of the jitter of all PingerTasks, which is
+	// seeded once (with randSeed, if given) and kept across reloads.
+	random *lockedRand
+	// clock tells the time to the Engine, its PingerTasks and its
+	// Dispatcher.
+	clock Clock
+
+	// lock serializes starting, reloading and stopping the Engine.
+	lock    sync.Mutex
+	started bool
+	stopped bool
+
+	// stateLock protects the PingerTasks and the configuration parts
+	// recorded by setConf (including the DefaultSchedule), which are
+	// replaced on reload while being read concurrently (such as by the
+	// REST API). They are only replaced while also holding lock.
+	stateLock sync.RWMutex
+	// the PingerTasks (keyed on task name)
+	pingers map[string]*PingerTask
+	// the tasks (by name) of pingers that run from several vantages
+	// (keyed on pinger name)
+	vantageGroups map[string][]string
+	// pingers that were skipped, in best-effort mode or on reload, since
+	// they could not be set up (keyed on pinger name)
+	failedPingers map[string]error
 }
 
 //
 // Engine functions and methods
 //
 
-// NewEngine creates a new Engine from a configuration.
-func NewEngine(engineConf *config.Engine, advertisedBaseURL string) (engine *Engine, err error) {
+// NewEngine creates a new Engine from a configuration. The advertisedBaseURL
+// is the externally reachable base URL of the watcher to use in alerts. In
+// bestEffort mode, pingers that cannot be instantiated are skipped (and
+// recorded in FailedPingers) rather than failing the Engine.
+func NewEngine(engineConf *config.Engine, advertisedBaseURL string, bestEffort bool) (*Engine, error) {
+	return NewEngineWithClock(engineConf, advertisedBaseURL, bestEffort, realClock{})
+}
+
+// NewEngineWithClock creates a new Engine, as NewEngine does, that reads the
+// time from a given Clock rather than the system clock.
+func NewEngineWithClock(engineConf *config.Engine, advertisedBaseURL string, bestEffort bool, clock Clock) (engine *Engine, err error) {
 	engine = new(Engine)
+	engine.clock = clock
+
+	ping.SetMaxSSHConnectionsPerHost(engineConf.MaxSSHConnectionsPerHost)
+	engine.maxSSHConnectionsPerHost = engineConf.MaxSSHConnectionsPerHost
+	engine.alerterConf = engineConf.Alerter
+	engine.haConf = engineConf.HA
+
+	// bus that PingerTasks will use to publish their StatusUpdates
+	// (to alert.Dispatcher and any other subscribers)
+	engine.Events = NewEventBus()
+	engine.Metrics = NewMetrics()
+	engine.randSeed = engineConf.RandSeed
+	engine.random = newLockedRand(engineConf.RandSeed)
+
+	engine.pingers, engine.vantageGroups, engine.failedPingers, err = engine.newTasks(engineConf, bestEffort)
+	if err != nil {
+		return nil, err
+	}
+	engine.setConf(engineConf)
+
+	dispatcherUpdates := engine.Events.Subscribe()
+	engine.subscriptions = append(engine.subscriptions, dispatcherUpdates)
+	dispatcher, err := NewDispatcher(engineConf.Alerter, advertisedBaseURL, dispatcherUpdates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate alert dispatcher: %s", err)
+	}
+	if engineConf.HA != nil {
+		lease, err := NewFileLease(engineConf.HA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up high availability: %s", err)
+		}
+		go lease.Start()
+		dispatcher.leader = lease
+	}
+	dispatcher.clock = engine.clock
+	go dispatcher.Start()
+	engine.dispatcher = dispatcher
 
+	engine.statusWebhookConf = engineConf.StatusWebhook
+	if engineConf.StatusWebhook != nil {
+		webhookUpdates := engine.Events.Subscribe()
+		engine.subscriptions = append(engine.subscriptions, webhookUpdates)
+		go NewStatusWebhook(engineConf.StatusWebhook, webhookUpdates).Start()
+	}
+
+	return engine, nil
+}
+
+// Pingers returns the PingerTasks of the Engine (keyed on task name). The
+// returned map is replaced, rather than modified, on reload and must not be
+// modified.
+func (engine *Engine) Pingers() map[string]*PingerTask {
+	engine.stateLock.RLock()
+	defer engine.stateLock.RUnlock()
+	return engine.pingers
+}
+
+// Pinger returns the PingerTask with a given name, if there is one.
+func (engine *Engine) Pinger(name string) (*PingerTask, bool) {
+	engine.stateLock.RLock()
+	defer engine.stateLock.RUnlock()
+	task, ok := engine.pingers[name]
+	return task, ok
+}
+
+// FailedPingers returns the errors of the pingers that were skipped, in
+// best-effort mode or on reload, since they could not be set up (keyed on
+// pinger name).
+// The returned map must not be modified.
+func (engine *Engine) FailedPingers() map[string]error {
+	engine.stateLock.RLock()
+	defer engine.stateLock.RUnlock()
+	return engine.failedPingers
+}
+
+// AddFailedPingers records pingers that were skipped, in best-effort mode,
+// before the Engine was created (such as pingers with an illegal
+// configuration).
+func (engine *Engine) AddFailedPingers(failed map[string]error) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	merged := make(map[string]error, len(engine.failedPingers)+len(failed))
+	for name, err := range engine.failedPingers {
+		merged[name] = err
+	}
+	for name, err := range failed {
+		merged[name] = err
+	}
+	engine.failedPingers = merged
+}
+
+// tasks returns the PingerTasks, vantage groups and failed pingers of the
+// Engine, as of the same configuration. None of the maps may be modified.
+func (engine *Engine) tasks() (map[string]*PingerTask, map[string][]string, map[string]error) {
+	engine.stateLock.RLock()
+	defer engine.stateLock.RUnlock()
+	return engine.pingers, engine.vantageGroups, engine.failedPingers
+}
+
+// setConf records the parts of a configuration that apply to all pingers.
+func (engine *Engine) setConf(engineConf *config.Engine) {
+	engine.DefaultSchedule = defaultSchedule(engineConf)
+	engine.vantagesConf = engineConf.Vantages
+	engine.globalLabels = engineConf.GlobalLabels
+	engine.maxConsecutive = engineConf.MaxConsecutive
+	engine.minInterval = engineConf.MinInterval
+	engine.drainTimeout = engineConf.DrainTimeout
+	engine.historySize = engineConf.HistorySize
+	engine.livenessConf = engineConf.Liveness
+}
+
+// defaultSchedule returns the schedule of pingers that have no schedule of
+// their own in a configuration.
+func defaultSchedule(engineConf *config.Engine) config.Schedule {
 	if engineConf.DefaultSchedule != nil {
-		engine.DefaultSchedule = *engineConf.DefaultSchedule
-	} else {
-		engine.DefaultSchedule = standardDefaultSchedule
+		return *engineConf.DefaultSchedule
 	}
+	return standardDefaultSchedule
+}
 
-	// channel that PingerTasks will use to send PingerTaskStatuses
-	// to alert.Dispatcher
-	statusChannel := make(chan StatusUpdate, 100)
+// minInterval returns the floor on pinger intervals of a configuration (0 if
+// there is none).
+func minInterval(engineConf *config.Engine) time.Duration {
+	if engineConf.MinInterval != nil {
+		return engineConf.MinInterval.Duration
+	}
+	return 0
+}
 
-	engine.Pingers = make(map[string]*PingerTask)
-	for _, pingerConf := range engineConf.Pingers {
-		log.Debugf("instantiating %s pinger", pingerConf.Type)
-		var pinger ping.Pinger
-		switch pingerConf.Type {
-		case "ssh":
-			pinger, err = ping.NewSSHPinger(&pingerConf)
-		case "http":
-			pinger, err = ping.NewHTTPPinger(&pingerConf)
+// newTasks creates the (not yet started) PingerTasks of a configuration,
+// keyed on task name, along with the tasks of pingers that run from several
+// vantages (keyed on pinger name). Pingers that cannot be instantiated fail
+// the whole configuration, unless skipFailed is true, in which case they are
+// skipped and their errors returned (keyed on task name).
+func (engine *Engine) newTasks(engineConf *config.Engine, skipFailed bool) (map[string]*PingerTask, map[string][]string, map[string]error, error) {
+	vantages := make(map[string]*config.Vantage)
+	for i := range engineConf.Vantages {
+		vantages[engineConf.Vantages[i].Name] = &engineConf.Vantages[i]
+	}
 
-		default:
-			err = fmt.Errorf("unknown pinger type: %s", pingerConf.Type)
+	tasks := make(map[string]*PingerTask)
+	vantageGroups := make(map[string][]string)
+	failed := make(map[string]error)
+	for i := range engineConf.Pingers {
+		pingerConf := engineConf.Pingers[i]
+		if len(pingerConf.Vantages) == 0 {
+			pinger, err := NewPinger(&pingerConf)
+			if err != nil && skipFailed {
+				log.Errorf("[%s] skipping pinger: failed to instantiate pinger: %s", pingerConf.Name, err)
+				failed[pingerConf.Name] = err
+				continue
+			}
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to instantiate pinger: %s", err)
+			}
+			tasks[pingerConf.Name] = engine.newTask(pingerConf.Name, pingerConf.PingerID(), engineConf, &pingerConf, nil, pinger)
+			continue
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to instantiate pinger: %s", err)
+
+		// run one task per vantage
+		for _, vantageName := range pingerConf.Vantages {
+			taskName := VantageTaskName(pingerConf.Name, vantageName)
+			pinger, err := newVantagePinger(&pingerConf, vantages[vantageName])
+			if err != nil && skipFailed {
+				log.Errorf("[%s] skipping pinger: failed to instantiate pinger: %s", taskName, err)
+				failed[taskName] = err
+				continue
+			}
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to instantiate pinger %s from vantage %s: %s", pingerConf.Name, vantageName, err)
+			}
+			tasks[taskName] = engine.newTask(taskName, VantageTaskName(pingerConf.PingerID(), vantageName),
+				engineConf, &pingerConf, vantages[vantageName], pinger)
+			vantageGroups[pingerConf.Name] = append(vantageGroups[pingerConf.Name], taskName)
 		}
+	}
+	return tasks, vantageGroups, failed, nil
+}
 
-		// either set schedule given in pinger config or use default
-		var pingerSchedule config.Schedule
-		if pingerConf.Schedule != nil {
-			pingerSchedule = *pingerConf.Schedule
-		} else {
-			pingerSchedule = engine.DefaultSchedule
+// newTask creates a PingerTask with a given name and ID that runs a Pinger
+// created from a pinger configuration (from a given vantage, if not nil).
+func (engine *Engine) newTask(name, id string, engineConf *config.Engine, pingerConf *config.Pinger,
+	vantage *config.Vantage, pinger ping.Pinger) *PingerTask {
+	// either set schedule given in pinger config or use default
+	var pingerSchedule config.Schedule
+	if pingerConf.Schedule != nil {
+		pingerSchedule = *pingerConf.Schedule
+	} else {
+		pingerSchedule = defaultSchedule(engineConf)
+	}
+	if floor := minInterval(engineConf); floor > 0 && pingerSchedule.Interval.Duration < floor {
+		log.Warningf("[%s] interval %s is shorter than minInterval: using %s", name, pingerSchedule.Interval.Duration, floor)
+		pingerSchedule.Interval = &config.Duration{Duration: floor}
+	}
+	task := &PingerTask{
+		Name:                    name,
+		Description:             pingerConf.Description,
+		BusinessHours:           pingerConf.BusinessHours,
+		Hooks:                   pingerConf.Hooks,
+		MaxAlerts:               pingerConf.MaxAlerts,
+		RedactOutputInAlerts:    pingerConf.RedactOutputInAlerts,
+		Alerters:                pingerConf.Alerters,
+		Weight:                  weight(pingerConf.Weight),
+		MaxConsecutive:          engineConf.MaxConsecutive,
+		RunIf:                   pingerConf.RunIf,
+		Type:                    pingerConf.Type,
+		Pinger:                  pinger,
+		Schedule:                pingerSchedule,
+		Config:                  pingerConf,
+		ID:                      id,
+		Labels:                  mergeLabels(engineConf.GlobalLabels, pingerConf.Labels),
+		WaitGroup:               &engine.WaitGroup,
+		SuppressDuplicateOutput: pingerConf.SuppressDuplicateOutput,
+		events:                  engine.Events,
+		metrics:                 engine.Metrics,
+		random:                  engine.random,
+		clock:                   engine.clock,
+		history:                 newPingHistory(historySize(engineConf)),
+		stop:                    make(chan struct{}),
+		done:                    make(chan struct{}),
+		trigger:                 make(chan chan PingerTaskStatus),
+		alertedStatus:           assumedStatus(pingerConf.AssumeInitialState)}
+	if task.RunIf != "" {
+		task.prerequisiteStatus = engine.statusOf(task.RunIf)
+	}
+	// everything that the task is created from
+	task.spec, _ = json.Marshal(struct {
+		Pinger         *config.Pinger
+		Vantage        *config.Vantage
+		Schedule       config.Schedule
+		Labels         map[string]string
+		MaxConsecutive int
+	}{pingerConf, vantage, pingerSchedule, task.Labels, task.MaxConsecutive})
+	return task
+}
+
+// statusOf returns a function that reports the current status of a pinger
+// (for a pinger with several vantages, its aggregated status). A pinger that
+// does not exist (such as one skipped in best-effort mode) is reported as
+// having an unknown status.
+func (engine *Engine) statusOf(pingerName string) func() ping.Status {
+	return func() ping.Status {
+		if aggregate, ok := engine.VantageAggregate(pingerName); ok {
+			return aggregate.Status
+		}
+		if task, ok := engine.Pinger(pingerName); ok {
+			return task.Snapshot().LatestResult.Status
 		}
-		engine.WaitGroup.Add(1)
-		engine.Pingers[pingerConf.Name] = &PingerTask{
-			Name:       pingerConf.Name,
-			Type:       pingerConf.Type,
-			Pinger:     pinger,
-			Schedule:   pingerSchedule,
-			WaitGroup:  engine.WaitGroup,
-			statusChan: statusChannel}
+		return ping.StatusUnknown
 	}
+}
 
-	dispatcher, err := NewDispatcher(engineConf.Alerter, statusChannel)
-	if err != nil {
-		return nil, fmt.Errorf("failed to instantiate alert dispatcher: %s", err)
+// weight returns the health score weight of a pinger given its configured
+// weight (if any).
+func weight(configured *int) int {
+	if configured == nil {
+		return 1
 	}
-	go dispatcher.Start()
+	return *configured
+}
 
-	return engine, nil
+// mergeLabels merges global labels with the labels of a pinger, which take
+// precedence. Nil is returned if there are no labels.
+func mergeLabels(globalLabels, pingerLabels map[string]string) map[string]string {
+	if len(globalLabels) == 0 && len(pingerLabels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string)
+	for key, value := range globalLabels {
+		labels[key] = value
+	}
+	for key, value := range pingerLabels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// assumedStatus returns the status that a pinger is assumed to start in,
+// given its configured assumeInitialState.
+func assumedStatus(assumeInitialState string) ping.Status {
+	switch assumeInitialState {
+	case "ok":
+		return ping.StatusOK
+	case "nok":
+		return ping.StatusNOK
+	default:
+		return ping.StatusUnknown
+	}
+}
+
+// NewPinger creates a Pinger of the type given in a pinger configuration.
+func NewPinger(pingerConf *config.Pinger) (ping.Pinger, error) {
+	log.Debugf("instantiating %s pinger", pingerConf.Type)
+	switch pingerConf.Type {
+	case "ssh":
+		return ping.NewSSHPinger(pingerConf)
+	case "http":
+		return ping.NewHTTPPinger(pingerConf)
+	case "dnsserial":
+		return ping.NewDNSSerialPinger(pingerConf)
+	case "service":
+		return ping.NewServicePinger(pingerConf)
+	case "disk":
+		return ping.NewDiskPinger(pingerConf)
+	case "icmp":
+		return ping.NewICMPPinger(pingerConf)
+	case "grpc":
+		return ping.NewGRPCPinger(pingerConf)
+	default:
+		return nil, fmt.Errorf("unknown pinger type: %s", pingerConf.Type)
+	}
 }
 
 // Start activates the Engine, starting all configured Pingers.
 func (engine *Engine) Start() {
-	for _, pinger := range engine.Pingers {
-		go pinger.Start()
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	engine.started = true
+	engine.logSummary()
+	for _, task := range engine.pingers {
+		engine.startTask(task)
+	}
+}
+
+// startTask starts a PingerTask.
+func (engine *Engine) startTask(task *PingerTask) {
+	engine.WaitGroup.Add(1)
+	go task.Start()
+}
+
+// Reload reconfigures the Engine according to a new configuration. Added
+// pingers are started and removed pingers are stopped. Pingers whose
+// configuration changed are replaced, but keep their status (as do pingers
+// renamed with the same ID), while unchanged pingers keep running
+// undisturbed. Changes to the alerter, high
+// availability, and SSH connection settings only take effect on restart.
+// Pingers that cannot be set up (whether in best-effort mode or not) are
+// skipped and recorded in FailedPingers, so that a bad pinger does not hold
+// back the rest of the configuration (nor vanish without a trace). If the
+// new configuration is otherwise invalid, an error is returned and the Engine
+// keeps running with its current configuration.
+func (engine *Engine) Reload(engineConf *config.Engine) error {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	if engine.stopped {
+		return fmt.Errorf("engine is stopped")
+	}
+
+	skipped := engineConf.SkipInvalidPingers()
+	for name, err := range skipped {
+		log.Errorf("[%s] skipping pinger: illegal configuration: %s", name, err)
 	}
+	if err := engineConf.Validate(); err != nil {
+		return fmt.Errorf("illegal configuration: %s", err)
+	}
+	tasks, vantageGroups, failed, err := engine.newTasks(engineConf, true)
+	if err != nil {
+		return err
+	}
+	for name, err := range skipped {
+		failed[name] = err
+	}
+	engine.warnOnRestartRequired(engineConf)
+
+	// stop removed and replaced pingers, letting them finish their
+	// ongoing pings before being replaced
+	var halted []*PingerTask
+	var removed []string
+	// removed pingers keyed on ID, which renamed pingers carry on from
+	removedIDs := make(map[string]*PingerTask)
+	for name, running := range engine.pingers {
+		task, ok := tasks[name]
+		switch {
+		case !ok:
+			log.Infof("[%s] pinger removed", name)
+			removed = append(removed, name)
+			removedIDs[running.ID] = running
+		case !bytes.Equal(running.spec, task.spec):
+			log.Infof("[%s] configuration changed: replacing pinger", name)
+		default:
+			continue
+		}
+		running.halt()
+		halted = append(halted, running)
+	}
+	if engine.started {
+		drain(halted, drainTimeout(engineConf))
+	}
+	// removed after the final pings of the halted pingers (which record
+	// no metrics, should they outlast the drain)
+	for _, name := range removed {
+		engine.Metrics.remove(name)
+	}
+
+	ids := make(map[string]bool)
+	for name, task := range tasks {
+		ids[task.ID] = true
+		running, ok := engine.pingers[name]
+		if ok && bytes.Equal(running.spec, task.spec) {
+			tasks[name] = running
+			continue
+		}
+		if renamed, wasRenamed := removedIDs[task.ID]; !ok && wasRenamed {
+			log.Infof("[%s] pinger renamed from %s", name, renamed.Name)
+			running, ok = renamed, true
+		}
+		if ok {
+			task.takeOver(running)
+		} else {
+			log.Infof("[%s] pinger added", name)
+		}
+		if engine.started {
+			engine.startTask(task)
+		}
+	}
+	// published after the final updates of the halted pingers, so that
+	// subscribers (such as the dispatcher) can forget about them
+	for _, running := range halted {
+		if !ids[running.ID] {
+			engine.Events.Publish(StatusUpdate{Name: running.Name, ID: running.ID, Removed: true})
+		}
+	}
+
+	// the maps are replaced (rather than modified), so that readers can
+	// keep using the maps that they got
+	engine.stateLock.Lock()
+	engine.pingers, engine.vantageGroups, engine.failedPingers = tasks, vantageGroups, failed
+	engine.setConf(engineConf)
+	engine.stateLock.Unlock()
+	log.Infof("engine reloaded with %d pingers", len(tasks))
+	return nil
+}
+
+// drainTimeout returns the longest time to wait for ongoing pings of removed
+// and replaced pingers on reload (0 means not to wait).
+func drainTimeout(engineConf *config.Engine) time.Duration {
+	if engineConf.DrainTimeout != nil {
+		return engineConf.DrainTimeout.Duration
+	}
+	return defaultDrainTimeout
+}
+
+// drain waits for a set of halted PingerTasks to stop, but at most for a
+// given timeout.
+func drain(tasks []*PingerTask, timeout time.Duration) {
+	if len(tasks) == 0 || timeout <= 0 {
+		return
+	}
+	log.Infof("draining %d pingers ...", len(tasks))
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for _, task := range tasks {
+		select {
+		case <-task.done:
+		case <-deadline.C:
+			log.Warningf("pingers not drained within %s: replacing them anyway", timeout)
+			return
+		}
+	}
+}
+
+// warnOnRestartRequired logs a warning for each part of a new configuration
+// that differs from the running one, but only takes effect on restart.
+func (engine *Engine) warnOnRestartRequired(engineConf *config.Engine) {
+	differs := func(a, b interface{}) bool {
+		aJSON, _ := json.Marshal(a)
+		bJSON, _ := json.Marshal(b)
+		return !bytes.Equal(aJSON, bJSON)
+	}
+	if differs(engine.alerterConf, engineConf.Alerter) {
+		log.Warningf("alerter configuration changed: restart to apply")
+	}
+	if differs(engine.haConf, engineConf.HA) {
+		log.Warningf("ha configuration changed: restart to apply")
+	}
+	if differs(engine.statusWebhookConf, engineConf.StatusWebhook) {
+		log.Warningf("statusWebhook configuration changed: restart to apply")
+	}
+	if engine.maxSSHConnectionsPerHost != engineConf.MaxSSHConnectionsPerHost {
+		log.Warningf("maxSSHConnectionsPerHost changed: restart to apply")
+	}
+}
+
+// Acknowledge acknowledges a failing pinger, suppressing reminder alerts for
+// it until the snooze duration has passed or the pinger recovers. A zero
+// snooze acknowledges the pinger until it recovers. Acknowledging a pinger
+// that runs from several vantages acknowledges it from all of its vantages.
+func (engine *Engine) Acknowledge(pingerName string, snooze time.Duration) (Acknowledgement, error) {
+	tasks, ok := engine.resolveTasks(pingerName)
+	if !ok {
+		return Acknowledgement{}, fmt.Errorf("no such pinger: %s", pingerName)
+	}
+	var ack Acknowledgement
+	for _, task := range tasks {
+		ack = engine.dispatcher.Acknowledge(task.ID, task.Name, snooze)
+	}
+	ack.Pinger = pingerName
+	return ack, nil
+}
+
+// Trigger makes a pinger ping right away and returns its resulting status
+// (keyed on task name). Triggering a pinger that runs from several vantages
+// makes it ping from all of its vantages at once. If the pinger (from any of
+// its vantages) is busy pinging, ErrPingInFlight is returned, and if it is
+// not running, ErrNotRunning is returned.
+func (engine *Engine) Trigger(pingerName string) (map[string]PingerTaskStatus, error) {
+	tasks, ok := engine.resolveTasks(pingerName)
+	if !ok {
+		return nil, fmt.Errorf("no such pinger: %s", pingerName)
+	}
+
+	statuses := make([]PingerTaskStatus, len(tasks))
+	errs := make([]error, len(tasks))
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+		go func(i int, task *PingerTask) {
+			defer wg.Done()
+			statuses[i], errs[i] = task.Trigger()
+		}(i, task)
+	}
+	wg.Wait()
+
+	result := make(map[string]PingerTaskStatus, len(tasks))
+	for i, task := range tasks {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		result[task.Name] = statuses[i]
+	}
+	return result, nil
+}
+
+// Silence mutes all alerts for a pinger for a given duration, with an
+// (optional) reason. Silencing a pinger that runs from several vantages
+// silences it from all of its vantages.
+func (engine *Engine) Silence(pingerName string, duration time.Duration, reason string) (Silence, error) {
+	tasks, ok := engine.resolveTasks(pingerName)
+	if !ok {
+		return Silence{}, fmt.Errorf("no such pinger: %s", pingerName)
+	}
+	var silence Silence
+	for _, task := range tasks {
+		silence = engine.dispatcher.Silence(task.ID, task.Name, duration, reason)
+	}
+	log.Infof("[%s] silenced for %s", pingerName, duration)
+	silence.Pinger = pingerName
+	return silence, nil
+}
+
+// Unsilence ends the silence of a pinger (from all of its vantages, for a
+// pinger that runs from several vantages). An error is returned if the pinger
+// does not exist or is not silenced.
+func (engine *Engine) Unsilence(pingerName string) error {
+	tasks, ok := engine.resolveTasks(pingerName)
+	if !ok {
+		return fmt.Errorf("no such pinger: %s", pingerName)
+	}
+	silenced := false
+	for _, task := range tasks {
+		if engine.dispatcher.Unsilence(task.ID) {
+			silenced = true
+		}
+	}
+	if !silenced {
+		return fmt.Errorf("pinger is not silenced: %s", pingerName)
+	}
+	log.Infof("[%s] unsilenced", pingerName)
+	return nil
+}
+
+// Flapping returns the time since which a pinger has been flapping, if it is
+// flapping (see the flapDetection of the alerter configuration).
+func (engine *Engine) Flapping(pingerName string) (time.Time, bool) {
+	task, ok := engine.Pinger(pingerName)
+	if !ok {
+		return time.Time{}, false
+	}
+	return engine.dispatcher.Flapping(task.ID)
+}
+
+// Silenced returns the silence of a pinger, if it is silenced. A pinger that
+// runs from several vantages is silenced if it is silenced from all of its
+// vantages (until the earliest of their silences expires).
+func (engine *Engine) Silenced(pingerName string) (Silence, bool) {
+	tasks, ok := engine.resolveTasks(pingerName)
+	if !ok {
+		return Silence{}, false
+	}
+	var earliest Silence
+	for i, task := range tasks {
+		silence, ok := engine.dispatcher.Silenced(task.ID)
+		if !ok {
+			return Silence{}, false
+		}
+		if i == 0 || silence.Until.Before(earliest.Until) {
+			earliest = silence
+		}
+	}
+	earliest.Pinger = pingerName
+	return earliest, true
+}
+
+// PauseAlerting pauses all alerting. Pingers keep running and their statuses
+// are updated, but no alerts are sent out until ResumeAlerting is called.
+func (engine *Engine) PauseAlerting() {
+	log.Infof("pausing alerting")
+	engine.dispatcher.SetPaused(true)
+}
+
+// ResumeAlerting resumes alerting after a call to PauseAlerting.
+func (engine *Engine) ResumeAlerting() {
+	log.Infof("resuming alerting")
+	engine.dispatcher.SetPaused(false)
+}
+
+// AlertingPaused returns true if alerting is paused.
+func (engine *Engine) AlertingPaused() bool {
+	return engine.dispatcher.Paused()
 }
 
 // Await awaits the completion of all Pingers
 func (engine *Engine) Await() {
 	engine.WaitGroup.Wait()
 }
+
+// Stop signals all Pingers to stop and waits for them to finish their
+// ongoing pings. Once they have, the Dispatcher and the status webhook are
+// unsubscribed from Events (after handling the updates already published)
+// and stop. An error is returned if the Pingers have not all finished when
+// the context is done.
+func (engine *Engine) Stop(ctx context.Context) error {
+	log.Infof("stopping engine ...")
+	engine.lock.Lock()
+	engine.stopped = true
+	for _, task := range engine.pingers {
+		task.halt()
+	}
+	engine.lock.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		engine.Await()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		for _, updates := range engine.subscriptions {
+			engine.Events.Unsubscribe(updates)
+		}
+		log.Infof("engine stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("engine: pingers did not stop in time: %s", ctx.Err())
+	}
+}
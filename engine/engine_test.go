@@ -0,0 +1,477 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	stdlog "log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// testHTTPPinger returns the configuration of an http pinger against a given
+// URL, which runs once an hour.
+func testHTTPPinger(name, url string) config.Pinger {
+	return config.Pinger{
+		Name:  name,
+		Type:  "http",
+		Check: json.RawMessage(fmt.Sprintf(`{"url": %q, "expect": {"statusCode": 200}}`, url)),
+		Schedule: &config.Schedule{
+			Interval: &config.Duration{Duration: time.Hour},
+			Retries:  &config.Retries{Attempts: 1},
+		},
+	}
+}
+
+// stopEngine stops an Engine, failing the test if it does not stop in time.
+func stopEngine(t *testing.T, engine *Engine) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := engine.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop engine: %s", err)
+	}
+}
+
+// logBuffer collects log output and is safe for concurrent use.
+type logBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (logs *logBuffer) Write(p []byte) (int, error) {
+	logs.lock.Lock()
+	defer logs.lock.Unlock()
+	return logs.buffer.Write(p)
+}
+
+func (logs *logBuffer) String() string {
+	logs.lock.Lock()
+	defer logs.lock.Unlock()
+	return logs.buffer.String()
+}
+
+// captureLog collects what is logged for the rest of a test.
+func captureLog(t *testing.T) *logBuffer {
+	logs := &logBuffer{}
+	logging.SetBackend(logging.NewLogBackend(logs, "", 0))
+	t.Cleanup(func() { logging.SetBackend(logging.NewLogBackend(os.Stderr, "", stdlog.LstdFlags)) })
+	return logs
+}
+
+func TestMinInterval(t *testing.T) {
+	tests := []struct {
+		name         string
+		minInterval  *config.Duration
+		wantInterval time.Duration
+	}{
+		{"no floor by default", nil, time.Millisecond},
+		{"disabled floor", &config.Duration{}, time.Millisecond},
+		{"raised to floor", &config.Duration{Duration: 5 * time.Second}, 5 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLog(t)
+			pingerConf := testHTTPPinger("fast", "http://127.0.0.1:1")
+			pingerConf.Schedule.Interval = &config.Duration{Duration: time.Millisecond}
+			engine, err := NewEngine(&config.Engine{MinInterval: test.minInterval, Pingers: []config.Pinger{pingerConf}}, "", false)
+			if err != nil {
+				t.Fatalf("failed to create engine: %s", err)
+			}
+			task, _ := engine.Pinger("fast")
+			if interval := task.Schedule.Interval.Duration; interval != test.wantInterval {
+				t.Errorf("got interval %s, want %s", interval, test.wantInterval)
+			}
+			if pingerConf.Schedule.Interval.Duration != time.Millisecond {
+				t.Errorf("configured interval modified: %s", pingerConf.Schedule.Interval)
+			}
+			raised := test.wantInterval != time.Millisecond
+			if warned := strings.Contains(logs.String(), "shorter than minInterval"); warned != raised {
+				t.Errorf("expected a warning: %t, got log: %q", raised, logs.String())
+			}
+		})
+	}
+}
+
+func TestBestEffortStart(t *testing.T) {
+	dependent := testHTTPPinger("dependent", "http://127.0.0.1:4")
+	dependent.RunIf = "untyped"
+	engineConf := &config.Engine{Pingers: []config.Pinger{
+		testHTTPPinger("good1", "http://127.0.0.1:1"),
+		testHTTPPinger("broken", ""),
+		testHTTPPinger("good2", "http://127.0.0.1:2"),
+		{Name: "untyped"},
+		dependent,
+		testHTTPPinger("good3", "http://127.0.0.1:3"),
+	}}
+
+	// pingers with invalid configurations are skipped, along with the
+	// pingers that depend on them
+	skipped := engineConf.SkipInvalidPingers()
+	if len(skipped) != 2 || skipped["untyped"] == nil || skipped["dependent"] == nil {
+		t.Errorf("unexpected skipped pingers: %v", skipped)
+	}
+	if err := engineConf.Validate(); err != nil {
+		t.Fatalf("expected the remaining configuration to be valid: %s", err)
+	}
+
+	// pingers that cannot be set up are skipped in best-effort mode only
+	if _, err := NewEngine(engineConf, "http://localhost", false); err == nil {
+		t.Errorf("expected a pinger that cannot be set up to fail the engine")
+	}
+	engine, err := NewEngine(engineConf, "http://localhost", true)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	engine.AddFailedPingers(skipped)
+	engine.Start()
+	defer stopEngine(t, engine)
+
+	for _, name := range []string{"good1", "good2", "good3"} {
+		if _, ok := engine.Pinger(name); !ok {
+			t.Errorf("%s: valid pinger not started", name)
+		}
+	}
+	failed := engine.FailedPingers()
+	for _, name := range []string{"broken", "untyped", "dependent"} {
+		if _, ok := engine.Pinger(name); ok {
+			t.Errorf("%s: pinger that cannot be set up is running", name)
+		}
+		if failed[name] == nil {
+			t.Errorf("%s: pinger that cannot be set up not recorded as failed", name)
+		}
+	}
+}
+
+func TestReloadRecordsFailedPingers(t *testing.T) {
+	engine, err := NewEngine(&config.Engine{Pingers: []config.Pinger{
+		testHTTPPinger("kept", "http://127.0.0.1:1"),
+		testHTTPPinger("broken", "http://127.0.0.1:2"),
+	}}, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	engine.Start()
+	defer stopEngine(t, engine)
+
+	added := testHTTPPinger("added", "")
+	broken := testHTTPPinger("broken", "")
+	err = engine.Reload(&config.Engine{Pingers: []config.Pinger{
+		testHTTPPinger("kept", "http://127.0.0.1:1"), added, broken,
+		{Name: "untyped"},
+	}})
+	if err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+
+	if _, ok := engine.Pinger("kept"); !ok {
+		t.Errorf("valid pinger not kept on reload")
+	}
+	failed := engine.FailedPingers()
+	for _, name := range []string{"added", "broken", "untyped"} {
+		if _, ok := engine.Pinger(name); ok {
+			t.Errorf("%s: pinger that cannot be set up is running", name)
+		}
+		if err, ok := failed[name]; !ok {
+			t.Errorf("%s: pinger that cannot be set up not recorded as failed", name)
+		} else if err == nil || err.Error() == "" {
+			t.Errorf("%s: no construction error recorded", name)
+		}
+	}
+	if err := failed["added"]; err != nil && !strings.Contains(err.Error(), "url") {
+		t.Errorf("unexpected construction error: %s", err)
+	}
+
+	// fixing the pinger clears its failure
+	if err := engine.Reload(&config.Engine{Pingers: []config.Pinger{testHTTPPinger("added", "http://127.0.0.1:3")}}); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	if _, ok := engine.Pinger("added"); !ok || len(engine.FailedPingers()) != 0 {
+		t.Errorf("fixed pinger not running: failed pingers: %v", engine.FailedPingers())
+	}
+}
+
+func TestReloadRejectsInvalidConfiguration(t *testing.T) {
+	engine, err := NewEngine(&config.Engine{Pingers: []config.Pinger{testHTTPPinger("kept", "http://127.0.0.1:1")}},
+		"http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	task, _ := engine.Pinger("kept")
+
+	err = engine.Reload(&config.Engine{Pingers: []config.Pinger{
+		testHTTPPinger("dup", "http://127.0.0.1:1"),
+		testHTTPPinger("dup", "http://127.0.0.1:2"),
+	}})
+	if err == nil {
+		t.Fatalf("expected reload with duplicate pinger names to fail")
+	}
+	if current, _ := engine.Pinger("kept"); current != task {
+		t.Errorf("failed reload replaced the running pingers")
+	}
+}
+
+func TestReloadTakesOverFromPingingTask(t *testing.T) {
+	engineConf := &config.Engine{
+		DrainTimeout: &config.Duration{Duration: 10 * time.Millisecond},
+		Pingers:      []config.Pinger{testHTTPPinger("replaced", "http://127.0.0.1:1")},
+	}
+	engine, err := NewEngine(engineConf, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	task, _ := engine.Pinger("replaced")
+	pinger := &fakePinger{delay: 200 * time.Millisecond, status: ping.StatusNOK}
+	task.Pinger = pinger
+	engine.Start()
+	defer stopEngine(t, engine)
+
+	trigger := func() {
+		for {
+			if _, err := task.Trigger(); err != ErrNotRunning {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	trigger()
+
+	// the replaced task recovers while the replacing task takes over
+	pinger.setStatus(ping.StatusOK)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		trigger()
+	}()
+	for pinger.pingCount() < 2 {
+		task.statusLock.Lock()
+		pinging := task.pinging
+		task.statusLock.Unlock()
+		if pinging {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	engineConf = &config.Engine{
+		DrainTimeout: engineConf.DrainTimeout,
+		Pingers:      []config.Pinger{testHTTPPinger("replaced", "http://127.0.0.1:2")},
+	}
+	if err := engine.Reload(engineConf); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+	<-done
+
+	replacing, _ := engine.Pinger("replaced")
+	replacing.statusLock.Lock()
+	defer replacing.statusLock.Unlock()
+	if replacing.alertedStatus != ping.StatusNOK {
+		t.Errorf("expected the alerted status to be taken over, got %q", replacing.alertedStatus)
+	}
+}
+
+func TestReloadDrainsPingers(t *testing.T) {
+	const pingDelay = 500 * time.Millisecond
+	tests := []struct {
+		drainTimeout time.Duration
+		minWait      time.Duration
+		maxWait      time.Duration
+	}{
+		// waits for the ongoing ping to finish
+		{5 * time.Second, 300 * time.Millisecond, 2 * time.Second},
+		// gives up waiting on the drain timeout
+		{50 * time.Millisecond, 50 * time.Millisecond, 300 * time.Millisecond},
+		// does not wait
+		{0, 0, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		drainTimeout := &config.Duration{Duration: test.drainTimeout}
+		engine, err := NewEngine(&config.Engine{
+			DrainTimeout: drainTimeout,
+			Pingers:      []config.Pinger{testHTTPPinger("replaced", "http://127.0.0.1:1")},
+		}, "http://localhost", false)
+		if err != nil {
+			t.Fatalf("failed to create engine: %s", err)
+		}
+		task, _ := engine.Pinger("replaced")
+		pinger := &fakePinger{delay: pingDelay, status: ping.StatusOK}
+		task.Pinger = pinger
+		engine.Start()
+
+		triggered := make(chan struct{})
+		go func() {
+			defer close(triggered)
+			for {
+				if _, err := task.Trigger(); err != ErrNotRunning {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		for {
+			task.statusLock.Lock()
+			pinging := task.pinging
+			task.statusLock.Unlock()
+			if pinging {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		start := time.Now()
+		err = engine.Reload(&config.Engine{
+			DrainTimeout: drainTimeout,
+			Pingers:      []config.Pinger{testHTTPPinger("replaced", "http://127.0.0.1:2")},
+		})
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("reload failed: %s", err)
+		}
+		if elapsed < test.minWait || elapsed > test.maxWait {
+			t.Errorf("drain timeout %s: expected reload to take between %s and %s, took %s", test.drainTimeout, test.minWait, test.maxWait, elapsed)
+		}
+		if test.drainTimeout > pingDelay {
+			select {
+			case <-task.done:
+			default:
+				t.Errorf("drain timeout %s: expected the replaced pinger to have stopped", test.drainTimeout)
+			}
+		}
+		<-triggered
+		stopEngine(t, engine)
+	}
+}
+
+func TestReloadKeepsRenamedPinger(t *testing.T) {
+	old := testHTTPPinger("old", "http://127.0.0.1:1")
+	old.ID = "web"
+	engine, err := NewEngine(&config.Engine{Pingers: []config.Pinger{old}}, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	task, _ := engine.Pinger("old")
+	task.Pinger = &fakePinger{status: ping.StatusNOK}
+	engine.Start()
+	defer stopEngine(t, engine)
+	updates := engine.Events.Subscribe()
+
+	for {
+		if _, err := task.Trigger(); err != ErrNotRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := engine.Acknowledge("old", 0); err != nil {
+		t.Fatalf("failed to acknowledge: %s", err)
+	}
+
+	renamed := testHTTPPinger("new", "http://127.0.0.1:1")
+	renamed.ID = "web"
+	if err := engine.Reload(&config.Engine{Pingers: []config.Pinger{renamed}}); err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+
+	if _, ok := engine.Pinger("old"); ok {
+		t.Errorf("expected the old name to be gone")
+	}
+	task, ok := engine.Pinger("new")
+	if !ok {
+		t.Fatalf("renamed pinger not running")
+	}
+	if status := task.Snapshot(); status.LatestResult.Status != ping.StatusNOK || status.Consecutive != 1 {
+		t.Errorf("expected the status to be kept, got %+v", status)
+	}
+	if history := task.History(); len(history) != 1 {
+		t.Errorf("expected the history to be kept, got %d entries", len(history))
+	}
+	if !engine.dispatcher.acks.isAcked("web", time.Now()) {
+		t.Errorf("expected the acknowledgement to be kept")
+	}
+	// the dispatcher is not told to forget the alert state of the pinger
+	deadline := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case update := <-updates:
+			if update.Removed {
+				t.Fatalf("renamed pinger reported as removed: %+v", update)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func TestGlobalLabels(t *testing.T) {
+	web := testHTTPPinger("web", "http://127.0.0.1:1")
+	web.Labels = map[string]string{"env": "staging", "tier": "frontend"}
+	engineConf := &config.Engine{
+		GlobalLabels: map[string]string{"env": "prod", "team": "ops"},
+		Pingers:      []config.Pinger{web, testHTTPPinger("db", "http://127.0.0.1:2")},
+	}
+	engine, err := NewEngine(engineConf, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	want := map[string]map[string]string{
+		"web": {"env": "staging", "team": "ops", "tier": "frontend"},
+		"db":  {"env": "prod", "team": "ops"},
+	}
+	for name := range want {
+		task, _ := engine.Pinger(name)
+		task.Pinger = &fakePinger{status: ping.StatusNOK}
+	}
+	updates := engine.Events.Subscribe()
+	engine.Start()
+	defer stopEngine(t, engine)
+
+	for name, labels := range want {
+		task, _ := engine.Pinger(name)
+		for {
+			if _, err := task.Trigger(); err != ErrNotRunning {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for {
+			update, _ := receive(t, updates)
+			if update.Name != name {
+				continue
+			}
+			if !reflect.DeepEqual(update.Labels, labels) {
+				t.Errorf("%s: got update labels %v, want %v", name, update.Labels, labels)
+			}
+			break
+		}
+	}
+
+	var metrics bytes.Buffer
+	if err := engine.Metrics.WriteText(&metrics); err != nil {
+		t.Fatalf("failed to write metrics: %s", err)
+	}
+	for _, sample := range []string{
+		`watcher_pinger_up{env="staging",name="web",team="ops",tier="frontend"} 0`,
+		`watcher_pinger_up{env="prod",name="db",team="ops"} 0`,
+	} {
+		if !strings.Contains(metrics.String(), sample) {
+			t.Errorf("expected sample %s in metrics:\n%s", sample, metrics.String())
+		}
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	merged := mergeLabels(map[string]string{"env": "prod", "region": "eu"}, map[string]string{"env": "dev"})
+	if !reflect.DeepEqual(merged, map[string]string{"env": "dev", "region": "eu"}) {
+		t.Errorf("expected pinger labels to override global labels, got %v", merged)
+	}
+	if merged := mergeLabels(nil, nil); merged != nil {
+		t.Errorf("expected no labels, got %v", merged)
+	}
+}
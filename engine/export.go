@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/config"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// redacted is the value that secrets are replaced with in exported
+// configurations.
+const redacted = "<redacted>"
+
+// secretFields are the names of check fields whose values are secret. The
+// body of an HTTP request often carries credentials (such as in a login
+// request) and is redacted as a whole.
+var secretFields = map[string]bool{
+	"password":      true,
+	"keyPassphrase": true,
+	"body":          true,
+}
+
+// secretHeaders are the (canonical) names of request headers whose values
+// are secret.
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+}
+
+// Config reconstructs the effective configuration of the Engine from its
+// running PingerTasks. Secrets (such as passwords) are redacted.
+func (engine *Engine) Config() (*config.Engine, error) {
+	engine.stateLock.RLock()
+	defer engine.stateLock.RUnlock()
+
+	defaultSchedule := engine.DefaultSchedule
+	engineConf := &config.Engine{
+		DefaultSchedule:          &defaultSchedule,
+		MaxSSHConnectionsPerHost: engine.maxSSHConnectionsPerHost,
+		HA:                       engine.haConf,
+		Vantages:                 redactVantages(engine.vantagesConf),
+		GlobalLabels:             engine.globalLabels,
+		MaxConsecutive:           engine.maxConsecutive,
+		MinInterval:              engine.minInterval,
+		DrainTimeout:             engine.drainTimeout,
+		HistorySize:              engine.historySize,
+		Liveness:                 engine.livenessConf,
+		RandSeed:                 engine.randSeed,
+	}
+	if engine.statusWebhookConf != nil {
+		webhook := *engine.statusWebhookConf
+		webhook.URL = redact(webhook.URL).(string)
+		engineConf.StatusWebhook = &webhook
+	}
+
+	names := make([]string, 0, len(engine.pingers))
+	for name := range engine.pingers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	exported := make(map[string]bool)
+	for _, name := range names {
+		pingerConf := *engine.pingers[name].Config
+		// the tasks of a pinger with several vantages share its config
+		if exported[pingerConf.Name] {
+			continue
+		}
+		exported[pingerConf.Name] = true
+		check, err := redactSecrets(pingerConf.Check)
+		if err != nil {
+			return nil, fmt.Errorf("pinger '%s': failed to redact check: %s", name, err)
+		}
+		pingerConf.Check = check
+		engineConf.Pingers = append(engineConf.Pingers, pingerConf)
+	}
+
+	if engine.alerterConf != nil {
+		alerterConf := *engine.alerterConf
+		alerterConf.Email, alerterConf.Slack, alerterConf.PagerDuty = redactAlerters(alerterConf.Email, alerterConf.Slack, alerterConf.PagerDuty)
+		alerterConf.Named = nil
+		for _, named := range engine.alerterConf.Named {
+			named.Email, named.Slack, named.PagerDuty = redactAlerters(named.Email, named.Slack, named.PagerDuty)
+			alerterConf.Named = append(alerterConf.Named, named)
+		}
+		engineConf.Alerter = &alerterConf
+	}
+
+	return engineConf, nil
+}
+
+// redactVantages returns a copy of a set of vantages with their source
+// addresses and proxies, which reveal the internal network, redacted.
+func redactVantages(vantages []config.Vantage) []config.Vantage {
+	var redactedVantages []config.Vantage
+	for _, vantage := range vantages {
+		if vantage.SourceAddress != "" {
+			vantage.SourceAddress = redacted
+		}
+		if vantage.Socks5Proxy != "" {
+			vantage.Socks5Proxy = redacted
+		}
+		redactedVantages = append(redactedVantages, vantage)
+	}
+	return redactedVantages
+}
+
+// redactAlerters returns copies of a set of alerter configurations (any of
+// which may be nil) with their secrets redacted.
+func redactAlerters(email *config.Email, slack *config.Slack, pagerDuty *config.PagerDuty) (*config.Email, *config.Slack, *config.PagerDuty) {
+	if email != nil {
+		redactedEmail := *email
+		if email.Auth != nil {
+			redactedEmail.Auth = &config.EmailAuth{Username: email.Auth.Username, Password: redacted}
+		}
+		email = &redactedEmail
+	}
+	if slack != nil {
+		redactedSlack := *slack
+		redactedSlack.WebhookURL = redacted
+		slack = &redactedSlack
+	}
+	if pagerDuty != nil {
+		redactedPagerDuty := *pagerDuty
+		redactedPagerDuty.RoutingKey = redacted
+		pagerDuty = &redactedPagerDuty
+	}
+	return email, slack, pagerDuty
+}
+
+// redactSecrets replaces the values of all secret fields (at any depth) of a
+// JSON object.
+func redactSecrets(raw json.RawMessage) (json.RawMessage, error) {
+	var document interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redact(document))
+}
+
+// redact recursively replaces secret fields of a decoded JSON value, as well
+// as passwords embedded in URLs.
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if secretFields[key] {
+				v[key] = redacted
+			} else if headers, ok := child.(map[string]interface{}); ok && key == "headers" {
+				v[key] = redactHeaders(headers)
+			} else {
+				v[key] = redact(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redact(child)
+		}
+	case string:
+		if u, err := url.Parse(v); err == nil && u.Scheme != "" && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+				return u.String()
+			}
+		}
+	}
+	return value
+}
+
+// redactHeaders replaces the values of secret headers in a decoded JSON
+// object of request headers.
+func redactHeaders(headers map[string]interface{}) map[string]interface{} {
+	for name := range headers {
+		if secretHeaders[http.CanonicalHeaderKey(name)] {
+			headers[name] = redacted
+		}
+	}
+	return headers
+}
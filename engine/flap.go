@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/config"
+	"sync"
+	"time"
+)
+
+// defaultFlapWindow is the period within which a number of state transitions
+// makes a pinger flapping, unless configured otherwise.
+const defaultFlapWindow = 10 * time.Minute
+
+// flapState describes how a status update affects the flapping of a pinger.
+type flapState int
+
+const (
+	// the pinger is not flapping
+	flapNone flapState = iota
+	// the pinger started flapping with the update
+	flapStarted
+	// the pinger is (still) flapping
+	flapOngoing
+	// the pinger stopped flapping with the update
+	flapStopped
+)
+
+// flapDetector detects pingers that change state so often that alerting on
+// every state transition would cause an alert storm. A pinger is flapping
+// when it has made a given number of state transitions within a window, and
+// stops flapping once it has not made any state transition for a window. It
+// is only used by the Dispatcher, except for Flapping, which is safe for
+// concurrent use. A nil flapDetector detects no flapping.
+type flapDetector struct {
+	transitions int
+	window      time.Duration
+	// the times of the most recent state transitions of each pinger
+	// (keyed on pinger ID), oldest first
+	history map[string][]time.Time
+
+	// lock protects flapping, which holds the time since which each
+	// flapping pinger has been flapping (keyed on pinger ID).
+	lock     sync.Mutex
+	flapping map[string]time.Time
+}
+
+// newFlapDetector creates a flapDetector from a flap detection configuration
+// (which may be nil, in which case no flapping is detected).
+func newFlapDetector(flapConfig *config.FlapDetection) *flapDetector {
+	if flapConfig == nil {
+		return nil
+	}
+	window := defaultFlapWindow
+	if flapConfig.Window != nil {
+		window = flapConfig.Window.Duration
+	}
+	return &flapDetector{
+		transitions: flapConfig.Transitions,
+		window:      window,
+		history:     make(map[string][]time.Time),
+		flapping:    make(map[string]time.Time),
+	}
+}
+
+// observe records a status update of a pinger (given its tracked state) at a
+// given point in time and returns how it affects the flapping of the pinger.
+// Only transitions between known states count (not the first state of a
+// pinger).
+func (detector *flapDetector) observe(update StatusUpdate, state *pingerState, now time.Time) flapState {
+	if detector == nil {
+		return flapNone
+	}
+	_, flapping := detector.Flapping(update.ID)
+
+	if update.Transition && state != nil && state.previous != nil {
+		history := append(detector.history[update.ID], now)
+		for len(history) > 0 && now.Sub(history[0]) > detector.window {
+			history = history[1:]
+		}
+		if len(history) > detector.transitions {
+			history = history[len(history)-detector.transitions:]
+		}
+		detector.history[update.ID] = history
+
+		if flapping {
+			return flapOngoing
+		}
+		if len(history) >= detector.transitions {
+			detector.setFlapping(update.ID, &now)
+			return flapStarted
+		}
+		return flapNone
+	}
+
+	if !flapping {
+		return flapNone
+	}
+	history := detector.history[update.ID]
+	if len(history) > 0 && now.Sub(history[len(history)-1]) <= detector.window {
+		return flapOngoing
+	}
+	delete(detector.history, update.ID)
+	detector.setFlapping(update.ID, nil)
+	return flapStopped
+}
+
+// setFlapping marks a pinger as flapping since a given time (or, if nil, as
+// not flapping).
+func (detector *flapDetector) setFlapping(pingerID string, since *time.Time) {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	if since == nil {
+		delete(detector.flapping, pingerID)
+		return
+	}
+	detector.flapping[pingerID] = since.UTC()
+}
+
+// Flapping returns the time since which a pinger has been flapping, if it is
+// flapping.
+func (detector *flapDetector) Flapping(pingerID string) (time.Time, bool) {
+	if detector == nil {
+		return time.Time{}, false
+	}
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	since, ok := detector.flapping[pingerID]
+	return since, ok
+}
+
+// forget discards the transitions and flapping state of a (removed) pinger.
+func (detector *flapDetector) forget(pingerID string) {
+	if detector == nil {
+		return
+	}
+	delete(detector.history, pingerID)
+	detector.setFlapping(pingerID, nil)
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/ping"
+
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// groupTriggerRetryInterval is how often a busy member of a group run is
+// triggered again, until it is done pinging.
+const groupTriggerRetryInterval = 100 * time.Millisecond
+
+// A GroupRun is the combined result of pinging all members of a group of
+// pingers at once.
+type GroupRun struct {
+	Group string
+	// OK is true if every member of the group is OK.
+	OK bool
+	// The results of the members (keyed on pinger name).
+	Pingers map[string]GroupMemberRun
+}
+
+// A GroupMemberRun is the result of a member of a GroupRun.
+type GroupMemberRun struct {
+	Status *PingerTaskStatus `json:",omitempty"`
+	// Error is set if the member could not be pinged.
+	Error string `json:",omitempty"`
+}
+
+// groupMembers returns the (names of the) PingerTasks, among a set of
+// PingerTasks, of the pingers that belong to a given group, in lexical order.
+func groupMembers(pingers map[string]*PingerTask, group string) []string {
+	var members []string
+	for name, task := range pingers {
+		if task.Config == nil {
+			continue
+		}
+		for _, g := range task.Config.Groups {
+			if g == group {
+				members = append(members, name)
+				break
+			}
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// RunGroup pings all members of a group of pingers concurrently, right away,
+// and waits for them to complete. A member that is busy pinging is pinged
+// again once it is done. An error is returned if the group has no members.
+func (engine *Engine) RunGroup(group string) (*GroupRun, error) {
+	pingers := engine.Pingers()
+	members := groupMembers(pingers, group)
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no pingers in group '%s'", group)
+	}
+	log.Infof("running group %s: %v", group, members)
+
+	results := make([]GroupMemberRun, len(members))
+	var wg sync.WaitGroup
+	for i, name := range members {
+		wg.Add(1)
+		go func(i int, task *PingerTask) {
+			defer wg.Done()
+			status, err := task.Trigger()
+			for err == ErrPingInFlight {
+				time.Sleep(groupTriggerRetryInterval)
+				status, err = task.Trigger()
+			}
+			if err != nil {
+				results[i] = GroupMemberRun{Error: err.Error()}
+				return
+			}
+			results[i] = GroupMemberRun{Status: &status}
+		}(i, pingers[name])
+	}
+	wg.Wait()
+
+	run := &GroupRun{Group: group, OK: true, Pingers: make(map[string]GroupMemberRun, len(members))}
+	for i, name := range members {
+		run.Pingers[name] = results[i]
+		if results[i].Status == nil || results[i].Status.LatestResult.Status != ping.StatusOK {
+			run.OK = false
+		}
+	}
+	return run, nil
+}
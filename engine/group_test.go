@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// newGroupEngine creates an Engine (that is not started) with running
+// PingerTasks for a set of fakePingers (keyed on name) that belong to given
+// groups.
+func newGroupEngine(t *testing.T, pingers map[string]*fakePinger, groups map[string][]string) *Engine {
+	t.Helper()
+	engine := &Engine{pingers: make(map[string]*PingerTask)}
+	for name, pinger := range pingers {
+		task := newTestTask(pinger, config.Schedule{})
+		task.Name, task.ID = name, name
+		task.Config = &config.Pinger{Name: name, Groups: groups[name]}
+		startTestTask(t, task)
+		t.Cleanup(func() { stopTestTask(task) })
+		engine.pingers[name] = task
+	}
+	return engine
+}
+
+func TestRunGroup(t *testing.T) {
+	delay := 200 * time.Millisecond
+	pingers := map[string]*fakePinger{
+		"a": {delay: delay, status: ping.StatusOK},
+		"b": {delay: delay, status: ping.StatusOK},
+		"c": {delay: delay, status: ping.StatusNOK},
+	}
+	engine := newGroupEngine(t, pingers, map[string][]string{"a": {"web"}, "b": {"web", "db"}, "c": {"db"}})
+
+	start := time.Now()
+	run, err := engine.RunGroup("web")
+	if err != nil {
+		t.Fatalf("group run failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Errorf("expected members to be pinged concurrently, took %s", elapsed)
+	}
+	if !run.OK || len(run.Pingers) != 2 {
+		t.Errorf("unexpected group run: %+v", run)
+	}
+	for name, want := range map[string]int{"a": 1, "b": 1, "c": 0} {
+		if pings := pingers[name].pingCount(); pings != want {
+			t.Errorf("%s: got %d pings, want %d", name, pings, want)
+		}
+	}
+
+	run, err = engine.RunGroup("db")
+	if err != nil {
+		t.Fatalf("group run failed: %s", err)
+	}
+	if run.OK {
+		t.Errorf("expected group with a failing member to fail: %+v", run)
+	}
+	if member := run.Pingers["c"]; member.Status == nil || member.Status.LatestResult.Status != ping.StatusNOK {
+		t.Errorf("unexpected result of failing member: %+v", member)
+	}
+	if member := run.Pingers["b"]; member.Status == nil || member.Status.LatestResult.Status != ping.StatusOK {
+		t.Errorf("unexpected result of passing member: %+v", member)
+	}
+
+	if _, err := engine.RunGroup("none"); err == nil {
+		t.Errorf("expected group without members to be rejected")
+	}
+}
+
+func TestRunGroupWaitsForBusyMember(t *testing.T) {
+	pinger := &fakePinger{delay: 200 * time.Millisecond, status: ping.StatusOK}
+	engine := newGroupEngine(t, map[string]*fakePinger{"busy": pinger}, map[string][]string{"busy": {"web"}})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		engine.pingers["busy"].Trigger()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	run, err := engine.RunGroup("web")
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("group run failed: %s", err)
+	}
+	if !run.OK || run.Pingers["busy"].Error != "" {
+		t.Errorf("unexpected group run: %+v", run)
+	}
+	if pings := pinger.pingCount(); pings != 2 {
+		t.Errorf("expected busy member to be pinged again, got %d pings", pings)
+	}
+}
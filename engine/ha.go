@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/config"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultLeaseDuration is the default duration of a lease on the HA lock file.
+const defaultLeaseDuration = 30 * time.Second
+
+// leaseSafetyMarginFraction is the fraction of the lease duration by which
+// the active instance stops acting as such before its lease expires, to allow
+// for clock skew between the instances and for a renewal that is delayed
+// (such as by unresponsive shared storage).
+const leaseSafetyMarginFraction = 5
+
+// A Leader decides whether this watcher instance is the active one of a group
+// of redundant instances. Only the active instance dispatches alerts.
+type Leader interface {
+	IsLeader() bool
+}
+
+// lease is the content of an HA lock file.
+type lease struct {
+	Holder  string
+	Expires time.Time
+}
+
+// A FileLease is a Leader that coordinates with other watcher instances via a
+// lease stored in a lock file on storage shared between the instances. The
+// instance that holds an unexpired lease is the leader. The leader renews its
+// lease periodically and other instances take over once it expires. The
+// leader stops acting as such a safety margin before its lease expires,
+// unless it has renewed the lease by then, so that two instances never act as
+// leaders at the same time.
+type FileLease struct {
+	path       string
+	instanceID string
+	duration   time.Duration
+
+	// lock protects leader, which is set if this instance holds the
+	// lease, and validUntil, which is the time at which it stops acting as
+	// the leader unless the lease is renewed.
+	lock       sync.Mutex
+	leader     bool
+	validUntil time.Time
+}
+
+// NewFileLease creates a FileLease from an HA configuration.
+func NewFileLease(haConfig *config.HA) (*FileLease, error) {
+	instanceID := haConfig.InstanceID
+	if instanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("ha: failed to determine instance id: %s", err)
+		}
+		instanceID = fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	}
+	duration := defaultLeaseDuration
+	if haConfig.LeaseDuration != nil {
+		duration = haConfig.LeaseDuration.Duration
+	}
+	return &FileLease{path: haConfig.LockFile, instanceID: instanceID, duration: duration}, nil
+}
+
+// IsLeader returns true if this instance currently holds the lease (and is
+// not within the safety margin of its expiry).
+func (fileLease *FileLease) IsLeader() bool {
+	return fileLease.isLeaderAt(time.Now())
+}
+
+// isLeaderAt returns true if this instance holds the lease, and is to act as
+// the leader, at a given point in time.
+func (fileLease *FileLease) isLeaderAt(now time.Time) bool {
+	fileLease.lock.Lock()
+	defer fileLease.lock.Unlock()
+	return fileLease.leader && now.Before(fileLease.validUntil)
+}
+
+// safetyMargin returns how long before its lease expires the leader stops
+// acting as such.
+func (fileLease *FileLease) safetyMargin() time.Duration {
+	return fileLease.duration / leaseSafetyMarginFraction
+}
+
+// Start tries to acquire (or renew) the lease at regular intervals (a third
+// of the lease duration). It blocks forever.
+func (fileLease *FileLease) Start() {
+	for {
+		now := time.Now()
+		leader, err := fileLease.tryAcquire(now)
+		if err != nil {
+			log.Errorf("ha: failed to acquire lease: %s", err)
+		}
+		fileLease.setLeader(leader, now.Add(fileLease.duration-fileLease.safetyMargin()))
+		time.Sleep(fileLease.duration / 3)
+	}
+}
+
+// setLeader records whether this instance holds the lease and, if so, until
+// when it is to act as the leader.
+func (fileLease *FileLease) setLeader(leader bool, validUntil time.Time) {
+	fileLease.lock.Lock()
+	defer fileLease.lock.Unlock()
+	if leader != fileLease.leader {
+		if leader {
+			log.Infof("ha: %s is now the active instance", fileLease.instanceID)
+		} else {
+			log.Infof("ha: %s is now a standby instance", fileLease.instanceID)
+		}
+	}
+	fileLease.leader = leader
+	fileLease.validUntil = validUntil
+}
+
+// tryAcquire acquires the lease if it is held by this instance or if it has
+// expired, and returns true if this instance holds the lease afterwards.
+func (fileLease *FileLease) tryAcquire(now time.Time) (bool, error) {
+	current, err := fileLease.read()
+	if err != nil {
+		return false, err
+	}
+	if current != nil && current.Holder != fileLease.instanceID && now.Before(current.Expires) {
+		return false, nil
+	}
+
+	if err := fileLease.write(lease{Holder: fileLease.instanceID, Expires: now.Add(fileLease.duration)}); err != nil {
+		return false, err
+	}
+	// another instance may have written the lease concurrently: the last
+	// writer wins.
+	current, err = fileLease.read()
+	if err != nil {
+		return false, err
+	}
+	return current != nil && current.Holder == fileLease.instanceID, nil
+}
+
+// read reads the lease file (a nil lease is returned if there is none).
+func (fileLease *FileLease) read() (*lease, error) {
+	data, err := ioutil.ReadFile(fileLease.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var current lease
+	if err := json.Unmarshal(data, &current); err != nil {
+		// a corrupt lease is treated as expired
+		log.Warningf("ha: ignoring malformed lock file %s: %s", fileLease.path, err)
+		return nil, nil
+	}
+	return &current, nil
+}
+
+// write atomically replaces the lease file.
+func (fileLease *FileLease) write(newLease lease) error {
+	data, err := json.Marshal(newLease)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fileLease.path), filepath.Base(fileLease.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fileLease.path)
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+func TestLeaseTakeover(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "lock")
+	leaseDuration := &config.Duration{Duration: 30 * time.Second}
+	first, _ := NewFileLease(&config.HA{LockFile: lockFile, InstanceID: "first", LeaseDuration: leaseDuration})
+	second, _ := NewFileLease(&config.HA{LockFile: lockFile, InstanceID: "second", LeaseDuration: leaseDuration})
+
+	now := time.Now()
+	if leader, err := first.tryAcquire(now); !leader || err != nil {
+		t.Fatalf("expected first instance to acquire lease: %t, %v", leader, err)
+	}
+	if leader, _ := second.tryAcquire(now.Add(time.Second)); leader {
+		t.Errorf("expected unexpired lease to be kept")
+	}
+	if leader, _ := second.tryAcquire(now.Add(time.Minute)); !leader {
+		t.Errorf("expected expired lease to be taken over")
+	}
+	if leader, _ := first.tryAcquire(now.Add(time.Minute + time.Second)); leader {
+		t.Errorf("expected lease of second instance to be kept")
+	}
+}
+
+func TestLeadershipEndsBeforeLeaseExpiry(t *testing.T) {
+	lease, _ := NewFileLease(&config.HA{
+		LockFile:      filepath.Join(t.TempDir(), "lock"),
+		InstanceID:    "test",
+		LeaseDuration: &config.Duration{Duration: 30 * time.Second},
+	})
+
+	now := time.Now()
+	leader, err := lease.tryAcquire(now)
+	if !leader || err != nil {
+		t.Fatalf("failed to acquire lease: %t, %v", leader, err)
+	}
+	lease.setLeader(leader, now.Add(lease.duration-lease.safetyMargin()))
+
+	if !lease.isLeaderAt(now.Add(20 * time.Second)) {
+		t.Errorf("expected leadership to last until the safety margin")
+	}
+	// a standby takes over at 30s: leadership ends at 24s without renewal
+	if lease.isLeaderAt(now.Add(25 * time.Second)) {
+		t.Errorf("expected leadership to end a safety margin before lease expiry")
+	}
+}
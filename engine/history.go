@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+
+	"sync"
+	"time"
+)
+
+// defaultHistorySize is the number of past pings to keep for each pinger when
+// no historySize is given in EngineConfig.
+const defaultHistorySize = 100
+
+// A HistoryEntry records a past ping of a PingerTask.
+type HistoryEntry struct {
+	// Time at which the status of the PingerTask was updated.
+	Time   time.Time
+	Result ping.Result
+	// Number of attempts used by the ping.
+	Attempts int
+	// Time taken by the ping, including retries (nil for skipped pings
+	// and aggregated reports).
+	Duration *config.Duration `json:",omitempty"`
+}
+
+// pingHistory is a bounded ring buffer of the most recent pings of a
+// PingerTask. It is safe for concurrent use. A nil *pingHistory records
+// nothing.
+type pingHistory struct {
+	lock    sync.Mutex
+	entries []HistoryEntry
+	// index of the entry to overwrite next (once the buffer is full)
+	next int
+}
+
+// newPingHistory creates a pingHistory that keeps a given number of entries
+// (nil if size is 0).
+func newPingHistory(size int) *pingHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &pingHistory{entries: make([]HistoryEntry, 0, size)}
+}
+
+// add records an entry, replacing the oldest one if the history is full.
+func (history *pingHistory) add(entry HistoryEntry) {
+	if history == nil {
+		return
+	}
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	if len(history.entries) < cap(history.entries) {
+		history.entries = append(history.entries, entry)
+		return
+	}
+	history.entries[history.next] = entry
+	history.next = (history.next + 1) % len(history.entries)
+}
+
+// list returns the recorded entries, oldest first.
+func (history *pingHistory) list() []HistoryEntry {
+	entries := []HistoryEntry{}
+	if history == nil {
+		return entries
+	}
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	entries = append(entries, history.entries[history.next:]...)
+	return append(entries, history.entries[:history.next]...)
+}
+
+// historySize returns the number of past pings to keep for each pinger
+// according to a configuration.
+func historySize(engineConf *config.Engine) int {
+	if engineConf.HistorySize != nil {
+		return *engineConf.HistorySize
+	}
+	return defaultHistorySize
+}
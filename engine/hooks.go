@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// defaultHookTimeout is the longest time that a hook command may run unless
+// a timeout is configured.
+const defaultHookTimeout = 5 * time.Minute
+
+// runHook runs a hook command (with "sh -c") for a pinger that has changed
+// state. The pinger is described to the command via environment variables:
+// WATCHER_PINGER_NAME, WATCHER_PINGER_ID, WATCHER_PINGER_STATUS (OK or NOK),
+// and WATCHER_PINGER_ERROR (empty if the pinger is OK).
+func runHook(command string, timeout *config.Duration, update StatusUpdate) {
+	hookTimeout := defaultHookTimeout
+	if timeout != nil {
+		hookTimeout = timeout.Duration
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	result := update.Status.LatestResult
+	var pingerError string
+	if result.Error != nil {
+		pingerError = result.Error.Error()
+	}
+	status := "NOK"
+	if update.AlertedStatus == ping.StatusOK {
+		status = "OK"
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	// once the shell is killed, its output is only waited for a little
+	// longer, since processes that it started may hold on to it
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"WATCHER_PINGER_NAME="+update.Name,
+		"WATCHER_PINGER_ID="+update.ID,
+		"WATCHER_PINGER_STATUS="+status,
+		"WATCHER_PINGER_ERROR="+pingerError)
+
+	log.Infof("[%s] running hook: %s", update.Name, command)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Errorf("[%s] hook failed: %s: %s", update.Name, err, output)
+		return
+	}
+	log.Infof("[%s] hook succeeded: %s", update.Name, output)
+}
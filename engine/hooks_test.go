@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// hookLog returns hooks that append a line describing the pinger (from the
+// environment that hooks run with) to a file, and a function that returns
+// the lines written so far.
+func hookLog(t *testing.T) (*config.Hooks, func() []string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "hooks.log")
+	describe := `"$WATCHER_PINGER_NAME $WATCHER_PINGER_ID $WATCHER_PINGER_STATUS $WATCHER_PINGER_ERROR" >> ` + file
+	hooks := &config.Hooks{OnDown: "echo down " + describe, OnUp: "echo up " + describe}
+	lines := func() []string {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+	return hooks, lines
+}
+
+// awaitLines waits for a given number of lines to have been written to a
+// hook log and returns them.
+func awaitLines(t *testing.T, lines func() []string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if written := lines(); len(written) >= n {
+			return written
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d hook runs, got %q", n, lines())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// hookUpdate is a StatusUpdate of a pinger with given hooks.
+func hookUpdate(hooks *config.Hooks, status ping.Status, transition bool) StatusUpdate {
+	update := StatusUpdate{
+		Name:          "web",
+		ID:            "web-id",
+		Hooks:         hooks,
+		Transition:    transition,
+		AlertedStatus: status,
+		Status:        PingerTaskStatus{LatestResult: ping.Result{Status: status}},
+	}
+	if status == ping.StatusNOK {
+		update.Status.LatestResult.Error = errors.New("connection refused")
+	}
+	return update
+}
+
+func TestHooks(t *testing.T) {
+	hooks, lines := hookLog(t)
+	_, recorder, updates := newTestDispatcher(t, nil)
+
+	// the first state is not a recovery
+	updates <- hookUpdate(hooks, ping.StatusOK, true)
+	updates <- hookUpdate(hooks, ping.StatusNOK, true)
+	awaitLines(t, lines, 1)
+	// reminders do not run hooks
+	updates <- hookUpdate(hooks, ping.StatusNOK, false)
+	updates <- hookUpdate(hooks, ping.StatusOK, true)
+	recorder.awaitUpdates(t, 3)
+	awaitLines(t, lines, 2)
+
+	// give any unexpected hook runs time to finish
+	time.Sleep(200 * time.Millisecond)
+	want := []string{"down web web-id NOK connection refused", "up web web-id OK"}
+	if got := lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got hook runs %q, want %q", got, want)
+	}
+}
+
+func TestHooksNotRunWhilePaused(t *testing.T) {
+	hooks, lines := hookLog(t)
+	dispatcher, _, updates := newTestDispatcher(t, nil)
+	dispatcher.SetPaused(true)
+
+	updates <- hookUpdate(hooks, ping.StatusNOK, true)
+	// a second update is only received once the first has been handled
+	updates <- hookUpdate(hooks, ping.StatusNOK, false)
+	time.Sleep(200 * time.Millisecond)
+	if got := lines(); len(got) != 0 {
+		t.Errorf("expected no hook runs while paused, got %q", got)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	hooks, lines := hookLog(t)
+	hooks.OnDown = "sleep 10; " + hooks.OnDown
+	hooks.Timeout = &config.Duration{Duration: 100 * time.Millisecond}
+	logs := captureLog(t)
+
+	start := time.Now()
+	runHook(hooks.OnDown, hooks.Timeout, hookUpdate(hooks, ping.StatusNOK, true))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the hook to be killed on timeout, took %s", elapsed)
+	}
+	if got := lines(); len(got) != 0 {
+		t.Errorf("expected the hook not to finish, got %q", got)
+	}
+	if !strings.Contains(logs.String(), "hook failed") {
+		t.Errorf("expected the failure of the hook to be logged, got log: %q", logs.String())
+	}
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"time"
+)
+
+// defaultLivenessWindow is the window within which pingers must have produced
+// a result to count as reporting, when no window is given in config.Liveness.
+const defaultLivenessWindow = 5 * time.Minute
+
+// A Liveness tells whether the pingers of an Engine are producing results,
+// which indicates whether the Engine itself works (regardless of the statuses
+// of the pinged endpoints).
+type Liveness struct {
+	// The number of pingers.
+	Pingers int
+	// The number of pingers that produced a result within the window.
+	Reporting int
+	// Live is false if fewer than the required fraction of the pingers
+	// are reporting.
+	Live bool
+}
+
+// Liveness reports on whether the pingers of the Engine are producing
+// results. A pinger counts as reporting if it produced a result within the
+// window or, for a pinger whose runs are further apart than that, within the
+// longest expected time between two of its runs. A pinger that has not yet
+// produced a result counts as reporting until then since it started. nil is
+// returned unless a liveness configuration is given.
+func (engine *Engine) Liveness() *Liveness {
+	engine.stateLock.RLock()
+	livenessConf := engine.livenessConf
+	engine.stateLock.RUnlock()
+	if livenessConf == nil {
+		return nil
+	}
+	window := defaultLivenessWindow
+	if livenessConf.Window != nil {
+		window = livenessConf.Window.Duration
+	}
+
+	now := engine.clock.Now()
+	pingers := engine.Pingers()
+	liveness := &Liveness{Pingers: len(pingers), Live: true}
+	for _, task := range pingers {
+		taskWindow := window
+		if gap := task.maxRunGap(); gap > taskWindow {
+			taskWindow = gap
+		}
+		if task.LatestRun().After(now.Add(-taskWindow)) {
+			liveness.Reporting++
+		}
+	}
+	if liveness.Pingers > 0 {
+		liveness.Live = float64(liveness.Reporting)/float64(liveness.Pingers) >= livenessConf.MinReporting
+	}
+	return liveness
+}
+
+// maxRunGap returns the longest expected time between the end of one run of
+// the PingerTask and the end of the next: its interval and jitter, plus the
+// delays between the retry attempts of a ping.
+func (task *PingerTask) maxRunGap() time.Duration {
+	schedule := task.Schedule
+	if schedule.Interval == nil {
+		return 0
+	}
+	gap := schedule.Interval.Duration
+	if schedule.Jitter != nil {
+		gap += schedule.Jitter.Duration
+	}
+	if retries := schedule.Retries; retries != nil && retries.Concurrency <= 1 {
+		delay := retries.Delay.Duration
+		for attempt := 1; attempt < retries.Attempts; attempt++ {
+			if retries.ExponentialBackoff {
+				delay *= 2
+			}
+			gap += delay
+		}
+	}
+	return gap
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+func TestLivenessFollowsResultsNotStatus(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	engine := &Engine{clock: clock, pingers: make(map[string]*PingerTask)}
+	if engine.Liveness() != nil {
+		t.Fatalf("expected no liveness without configuration")
+	}
+	engine.livenessConf = &config.Liveness{MinReporting: 0.6, Window: &config.Duration{Duration: time.Minute}}
+
+	for name, status := range map[string]ping.Status{"up": ping.StatusOK, "down": ping.StatusNOK, "stuck": ping.StatusOK} {
+		task := newTestTask(&fakePinger{status: status}, config.Schedule{Interval: &config.Duration{Duration: 30 * time.Second}})
+		task.Name, task.ID = name, name
+		task.clock = clock
+		startTestTask(t, task)
+		t.Cleanup(func() { stopTestTask(task) })
+		engine.pingers[name] = task
+	}
+	trigger := func(names ...string) {
+		t.Helper()
+		for _, name := range names {
+			if _, err := engine.pingers[name].Trigger(); err != nil {
+				t.Fatalf("failed to trigger %s: %s", name, err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name          string
+		advance       time.Duration
+		trigger       []string
+		wantReporting int
+		wantLive      bool
+	}{
+		{"just started", 0, nil, 3, true},
+		{"no results within window", 2 * time.Minute, nil, 0, false},
+		{"results of any status", 0, []string{"up", "down"}, 2, true},
+		{"too few results", 2 * time.Minute, []string{"down"}, 1, false},
+	}
+	for _, test := range tests {
+		clock.advance(test.advance)
+		trigger(test.trigger...)
+		liveness := engine.Liveness()
+		if liveness.Pingers != 3 || liveness.Reporting != test.wantReporting || liveness.Live != test.wantLive {
+			t.Errorf("%s: got %+v, want %d reporting (live: %t)", test.name, liveness, test.wantReporting, test.wantLive)
+		}
+	}
+}
+
+func TestLivenessWindowCoversRunGap(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	task := newTestTask(&fakePinger{status: ping.StatusOK}, config.Schedule{
+		Interval: &config.Duration{Duration: 10 * time.Minute},
+		Retries:  &config.Retries{Attempts: 3, Delay: config.Duration{Duration: time.Second}, ExponentialBackoff: true},
+	})
+	if gap := task.maxRunGap(); gap != 10*time.Minute+6*time.Second {
+		t.Fatalf("unexpected run gap: %s", gap)
+	}
+	task.latestRun = clock.Now()
+	engine := &Engine{
+		clock:        clock,
+		pingers:      map[string]*PingerTask{"slow": task},
+		livenessConf: &config.Liveness{MinReporting: 1, Window: &config.Duration{Duration: time.Minute}},
+	}
+
+	clock.advance(10 * time.Minute)
+	if liveness := engine.Liveness(); !liveness.Live {
+		t.Errorf("expected pinger to be reporting within its run gap: %+v", liveness)
+	}
+	clock.advance(time.Minute)
+	if liveness := engine.Liveness(); liveness.Live {
+		t.Errorf("expected pinger to be stalled beyond its run gap: %+v", liveness)
+	}
+}
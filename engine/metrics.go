@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/ping"
+
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// durationBuckets are the upper bounds (in seconds) of the buckets of the ping
+// duration histogram.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// maxErrorLabelLength is the longest error message (in bytes) to include as
+// a label value. Longer messages are truncated.
+const maxErrorLabelLength = 200
+
+// Metrics records metrics of PingerTasks as they run, to be exposed in the
+// Prometheus text format. It is safe for concurrent use. A nil *Metrics
+// records nothing.
+type Metrics struct {
+	lock sync.Mutex
+	// keyed on task name
+	pingers map[string]*pingerMetrics
+}
+
+// pingerMetrics are the metrics of a single PingerTask.
+type pingerMetrics struct {
+	labels      map[string]string
+	status      ping.Status
+	consecutive int
+	// the latest failed ping (if any) and its error
+	lastError     string
+	lastErrorTime *time.Time
+	// number of pings by result (keyed on lower-case status)
+	pings map[string]uint64
+	// histogram of ping durations (cumulative counts per bucket)
+	durationBuckets []uint64
+	durationCount   uint64
+	durationSum     float64
+}
+
+// NewMetrics creates an empty Metrics registry.
+func NewMetrics() *Metrics {
+	return &Metrics{pingers: make(map[string]*pingerMetrics)}
+}
+
+// pinger returns the metrics of a PingerTask, creating them if needed.
+// Callers must hold the lock.
+func (metrics *Metrics) pinger(name string) *pingerMetrics {
+	pinger, ok := metrics.pingers[name]
+	if !ok {
+		pinger = &pingerMetrics{pings: make(map[string]uint64), durationBuckets: make([]uint64, len(durationBuckets))}
+		metrics.pingers[name] = pinger
+	}
+	return pinger
+}
+
+// observePing records a ping of a PingerTask with a given result and
+// duration.
+func (metrics *Metrics) observePing(name string, status ping.Status, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	pinger := metrics.pinger(name)
+	pinger.pings[strings.ToLower(status.String())]++
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			pinger.durationBuckets[i]++
+		}
+	}
+	pinger.durationCount++
+	pinger.durationSum += seconds
+}
+
+// setStatus records the current status of a PingerTask.
+func (metrics *Metrics) setStatus(name string, labels map[string]string, status PingerTaskStatus) {
+	if metrics == nil {
+		return
+	}
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	pinger := metrics.pinger(name)
+	pinger.labels = labels
+	pinger.status = status.LatestResult.Status
+	pinger.consecutive = status.Consecutive
+	if status.LatestResult.Status == ping.StatusNOK {
+		pinger.lastErrorTime = status.LatestNOK
+		pinger.lastError = ""
+		if err := status.LatestResult.Error; err != nil {
+			pinger.lastError = truncateUTF8(err.Error(), maxErrorLabelLength)
+		}
+	}
+}
+
+// truncateUTF8 cuts a string down to at most a given number of bytes without
+// splitting a multi-byte character.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// remove discards the metrics of a (removed) PingerTask.
+func (metrics *Metrics) remove(name string) {
+	if metrics == nil {
+		return
+	}
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	delete(metrics.pingers, name)
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format. The
+// labels of each pinger are included, along with its (task) name. Pingers
+// whose status is not yet known have no watcher_pinger_up sample.
+func (metrics *Metrics) WriteText(writer io.Writer) error {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	names := make([]string, 0, len(metrics.pingers))
+	for name := range metrics.pingers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(writer)
+	fmt.Fprintln(w, "# HELP watcher_pinger_up Whether the latest ping of the pinger succeeded (1) or failed (0).")
+	fmt.Fprintln(w, "# TYPE watcher_pinger_up gauge")
+	for _, name := range names {
+		pinger := metrics.pingers[name]
+		switch pinger.status {
+		case ping.StatusOK:
+			fmt.Fprintf(w, "watcher_pinger_up%s 1\n", formatLabels(name, pinger.labels))
+		case ping.StatusNOK:
+			fmt.Fprintf(w, "watcher_pinger_up%s 0\n", formatLabels(name, pinger.labels))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP watcher_pinger_consecutive The number of consecutive pings with the same result as the latest one.")
+	fmt.Fprintln(w, "# TYPE watcher_pinger_consecutive gauge")
+	for _, name := range names {
+		pinger := metrics.pingers[name]
+		fmt.Fprintf(w, "watcher_pinger_consecutive%s %d\n", formatLabels(name, pinger.labels), pinger.consecutive)
+	}
+
+	fmt.Fprintln(w, "# HELP watcher_pinger_last_error_timestamp_seconds The time (in seconds since the epoch) of the latest failed ping.")
+	fmt.Fprintln(w, "# TYPE watcher_pinger_last_error_timestamp_seconds gauge")
+	for _, name := range names {
+		pinger := metrics.pingers[name]
+		if pinger.lastErrorTime != nil {
+			timestamp := float64(pinger.lastErrorTime.UnixNano()) / float64(time.Second)
+			fmt.Fprintf(w, "watcher_pinger_last_error_timestamp_seconds%s %.3f\n", formatLabels(name, pinger.labels), timestamp)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP watcher_pinger_last_error_info The error of the latest failed ping (in the error label).")
+	fmt.Fprintln(w, "# TYPE watcher_pinger_last_error_info gauge")
+	for _, name := range names {
+		pinger := metrics.pingers[name]
+		if pinger.lastErrorTime != nil {
+			fmt.Fprintf(w, "watcher_pinger_last_error_info%s 1\n", formatLabels(name, pinger.labels, "error", pinger.lastError))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP watcher_ping_total The number of pings performed, by result.")
+	fmt.Fprintln(w, "# TYPE watcher_ping_total counter")
+	for _, name := range names {
+		pinger := metrics.pingers[name]
+		results := make([]string, 0, len(pinger.pings))
+		for result := range pinger.pings {
+			results = append(results, result)
+		}
+		sort.Strings(results)
+		for _, result := range results {
+			fmt.Fprintf(w, "watcher_ping_total%s %d\n", formatLabels(name, pinger.labels, "result", result), pinger.pings[result])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP watcher_ping_duration_seconds The time taken by pings (including retries).")
+	fmt.Fprintln(w, "# TYPE watcher_ping_duration_seconds histogram")
+	for _, name := range names {
+		pinger := metrics.pingers[name]
+		for i, bound := range durationBuckets {
+			le := fmt.Sprintf("%g", bound)
+			fmt.Fprintf(w, "watcher_ping_duration_seconds_bucket%s %d\n", formatLabels(name, pinger.labels, "le", le), pinger.durationBuckets[i])
+		}
+		fmt.Fprintf(w, "watcher_ping_duration_seconds_bucket%s %d\n", formatLabels(name, pinger.labels, "le", "+Inf"), pinger.durationCount)
+		fmt.Fprintf(w, "watcher_ping_duration_seconds_sum%s %g\n", formatLabels(name, pinger.labels), pinger.durationSum)
+		fmt.Fprintf(w, "watcher_ping_duration_seconds_count%s %d\n", formatLabels(name, pinger.labels), pinger.durationCount)
+	}
+	return w.Flush()
+}
+
+// formatLabels formats the label set of a sample: the labels of a pinger, its
+// name and any extra label pairs (which take precedence, in that order).
+func formatLabels(name string, labels map[string]string, extra ...string) string {
+	all := make(map[string]string)
+	for key, value := range labels {
+		all[key] = value
+	}
+	all["name"] = name
+	for i := 0; i+1 < len(extra); i += 2 {
+		all[extra[i]] = extra[i+1]
+	}
+
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", key, labelValueEscaper.Replace(all[key]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelValueEscaper escapes label values as required by the Prometheus text
+// format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
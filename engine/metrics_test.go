@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// writeMetrics returns the metrics in the Prometheus text format.
+func writeMetrics(t *testing.T, metrics *Metrics) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := metrics.WriteText(&buffer); err != nil {
+		t.Fatalf("failed to write metrics: %s", err)
+	}
+	return buffer.String()
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observePing("web", ping.StatusOK, 30*time.Millisecond)
+	metrics.observePing("web", ping.StatusNOK, 2*time.Second)
+	var status PingerTaskStatus
+	status.LatestResult.Status = ping.StatusNOK
+	status.Consecutive = 3
+	metrics.setStatus("web", map[string]string{"env": "prod \"eu\""}, status)
+
+	text := writeMetrics(t, metrics)
+	for _, want := range []string{
+		`watcher_pinger_up{env="prod \"eu\"",name="web"} 0`,
+		`watcher_pinger_consecutive{env="prod \"eu\"",name="web"} 3`,
+		`watcher_ping_total{env="prod \"eu\"",name="web",result="nok"} 1`,
+		`watcher_ping_total{env="prod \"eu\"",name="web",result="ok"} 1`,
+		`watcher_ping_duration_seconds_bucket{env="prod \"eu\"",le="0.05",name="web"} 1`,
+		`watcher_ping_duration_seconds_bucket{env="prod \"eu\"",le="+Inf",name="web"} 2`,
+		`watcher_ping_duration_seconds_count{env="prod \"eu\"",name="web"} 2`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected metrics to contain %s, got:\n%s", want, text)
+		}
+	}
+
+	metrics.remove("web")
+	if text := writeMetrics(t, metrics); strings.Contains(text, `name="web"`) {
+		t.Errorf("expected the metrics of a removed pinger to be discarded, got:\n%s", text)
+	}
+	// a nil *Metrics records nothing
+	var disabled *Metrics
+	disabled.observePing("web", ping.StatusOK, time.Second)
+	disabled.setStatus("web", nil, status)
+}
+
+func TestLastErrorMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	var status PingerTaskStatus
+	status.LatestResult.Status = ping.StatusOK
+	metrics.setStatus("web", nil, status)
+	if text := writeMetrics(t, metrics); strings.Contains(text, "watcher_pinger_last_error_timestamp_seconds{") || strings.Contains(text, "watcher_pinger_last_error_info{") {
+		t.Errorf("expected no last error of a pinger that has not failed, got:\n%s", text)
+	}
+
+	failed := time.Unix(1700000000, 500000000)
+	status.LatestResult = ping.Result{Status: ping.StatusNOK, Error: errors.New("connection refused:\n\"no route\"")}
+	status.LatestNOK = &failed
+	metrics.setStatus("web", nil, status)
+	// the last error is kept once the pinger recovers
+	status.LatestResult = ping.Result{Status: ping.StatusOK}
+	metrics.setStatus("web", nil, status)
+
+	text := writeMetrics(t, metrics)
+	for _, want := range []string{
+		`watcher_pinger_last_error_timestamp_seconds{name="web"} 1700000000.500`,
+		`watcher_pinger_last_error_info{error="connection refused:\n\"no route\"",name="web"} 1`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected metrics to contain %s, got:\n%s", want, text)
+		}
+	}
+
+	status.LatestResult = ping.Result{Status: ping.StatusNOK, Error: errors.New(strings.Repeat("é", maxErrorLabelLength))}
+	metrics.setStatus("web", nil, status)
+	want := `watcher_pinger_last_error_info{error="` + strings.Repeat("é", maxErrorLabelLength/2) + `",name="web"} 1`
+	if text := writeMetrics(t, metrics); !strings.Contains(text, want) {
+		t.Errorf("expected a long error to be truncated, got:\n%s", text)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		value string
+		max   int
+		want  string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 2, "he"},
+		// does not split the two-byte é
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"é", 1, ""},
+	}
+	for _, test := range tests {
+		if got := truncateUTF8(test.value, test.max); got != test.want {
+			t.Errorf("%q truncated to %d bytes: got %q, want %q", test.value, test.max, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// lockedRand is a source of random numbers for the PingerTasks of an Engine.
+// Unlike a bare rand.Rand, it is safe for concurrent use. Seeding it with a
+// fixed seed makes it produce the same sequence of numbers every time (for
+// example, to reproduce the jitter of a run).
+type lockedRand struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// newLockedRand creates a lockedRand from a seed (or, if nil, from the
+// current time).
+func newLockedRand(seed *int64) *lockedRand {
+	s := time.Now().UnixNano()
+	if seed != nil {
+		s = *seed
+	}
+	return &lockedRand{rand: rand.New(rand.NewSource(s))}
+}
+
+// Int63n returns a random number in [0, n). It panics if n <= 0.
+func (r *lockedRand) Int63n(n int64) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rand.Int63n(n)
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+// jitterSequence returns the first jitters that a PingerTask of an Engine
+// created from a given configuration waits.
+func jitterSequence(t *testing.T, engineConf *config.Engine, n int) []time.Duration {
+	t.Helper()
+	engine, err := NewEngine(engineConf, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	task := &PingerTask{
+		random:   engine.random,
+		Schedule: config.Schedule{Jitter: &config.Duration{Duration: time.Hour}},
+	}
+	jitters := make([]time.Duration, n)
+	for i := range jitters {
+		jitters[i] = task.jitter()
+	}
+	return jitters
+}
+
+func TestJitterWithFixedSeed(t *testing.T) {
+	seed := int64(42)
+	first := jitterSequence(t, &config.Engine{RandSeed: &seed}, 10)
+	second := jitterSequence(t, &config.Engine{RandSeed: &seed}, 10)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("jitter sequences differ: %v != %v", first, second)
+		}
+	}
+
+	otherSeed := int64(43)
+	other := jitterSequence(t, &config.Engine{RandSeed: &otherSeed}, 10)
+	same := true
+	for i := range first {
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Errorf("different seeds gave the same jitter sequence: %v", first)
+	}
+}
+
+func TestJitterWithinBounds(t *testing.T) {
+	for _, jitter := range jitterSequence(t, &config.Engine{}, 100) {
+		if jitter < 0 || jitter >= time.Hour {
+			t.Fatalf("jitter out of bounds: %s", jitter)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// A HealthScore summarizes the health of all pingers as a single number,
+// where each pinger counts according to its weight.
+type HealthScore struct {
+	// The weighted share of healthy pingers, in the range [0,100]. It is
+	// 100 if no pinger has a known status.
+	Score float64
+	// The total weight of the pingers with a known status.
+	Weight int
+	// The total weight of the pingers that are OK.
+	HealthyWeight int
+}
+
+// HealthScore computes the weighted health score of the current pinger
+// statuses. Pingers whose status is not yet known do not count. A pinger that
+// runs from several vantages counts once per vantage.
+func (engine *Engine) HealthScore() HealthScore {
+	var score HealthScore
+	for _, task := range engine.Pingers() {
+		switch task.Snapshot().LatestResult.Status {
+		case ping.StatusOK:
+			score.Weight += task.Weight
+			score.HealthyWeight += task.Weight
+		case ping.StatusNOK:
+			score.Weight += task.Weight
+		}
+	}
+
+	score.Score = 100
+	if score.Weight > 0 {
+		score.Score = 100 * float64(score.HealthyWeight) / float64(score.Weight)
+	}
+	return score
+}
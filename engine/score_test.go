@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// scoredPinger is a pinger with a weight and a status, for computing a
+// HealthScore.
+type scoredPinger struct {
+	weight int
+	status ping.Status
+}
+
+func TestHealthScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		pingers map[string]scoredPinger
+		want    HealthScore
+	}{
+		{"no pingers", nil, HealthScore{Score: 100}},
+		{"unknown status", map[string]scoredPinger{"a": {1, ping.StatusUnknown}}, HealthScore{Score: 100}},
+		{"all ok", map[string]scoredPinger{"a": {10, ping.StatusOK}, "b": {1, ping.StatusOK}}, HealthScore{Score: 100, Weight: 11, HealthyWeight: 11}},
+		{"heavy pinger fails", map[string]scoredPinger{"a": {9, ping.StatusNOK}, "b": {1, ping.StatusOK}}, HealthScore{Score: 10, Weight: 10, HealthyWeight: 1}},
+		{"light pinger fails", map[string]scoredPinger{"a": {9, ping.StatusOK}, "b": {1, ping.StatusNOK}}, HealthScore{Score: 90, Weight: 10, HealthyWeight: 9}},
+		{"unknown status does not count", map[string]scoredPinger{"a": {3, ping.StatusOK}, "b": {1, ping.StatusNOK}, "c": {100, ping.StatusUnknown}}, HealthScore{Score: 75, Weight: 4, HealthyWeight: 3}},
+		{"zero weight does not count", map[string]scoredPinger{"a": {1, ping.StatusOK}, "b": {0, ping.StatusNOK}}, HealthScore{Score: 100, Weight: 1, HealthyWeight: 1}},
+		{"all failing", map[string]scoredPinger{"a": {2, ping.StatusNOK}, "b": {1, ping.StatusNOK}}, HealthScore{Score: 0, Weight: 3}},
+	}
+	for _, test := range tests {
+		engine := &Engine{pingers: make(map[string]*PingerTask)}
+		for name, pinger := range test.pingers {
+			task := &PingerTask{Name: name, Weight: pinger.weight}
+			task.Status.LatestResult.Status = pinger.status
+			engine.pingers[name] = task
+		}
+		if score := engine.HealthScore(); score != test.want {
+			t.Errorf("%s: got score %+v, want %+v", test.name, score, test.want)
+		}
+	}
+}
+
+func TestPingerWeight(t *testing.T) {
+	zero, five := 0, 5
+	unweighted := testHTTPPinger("unweighted", "http://127.0.0.1:1")
+	ignored := testHTTPPinger("ignored", "http://127.0.0.1:2")
+	ignored.Weight = &zero
+	weighted := testHTTPPinger("weighted", "http://127.0.0.1:3")
+	weighted.Weight = &five
+
+	engine, err := NewEngine(&config.Engine{Pingers: []config.Pinger{unweighted, ignored, weighted}}, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	for name, want := range map[string]int{"unweighted": 1, "ignored": 0, "weighted": 5} {
+		if task, _ := engine.Pinger(name); task.Weight != want {
+			t.Errorf("%s: got weight %d, want %d", name, task.Weight, want)
+		}
+	}
+}
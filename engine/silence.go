@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// A Silence mutes all alerts for a pinger until it expires, regardless of the
+// state of the pinger. Unlike an Acknowledgement, it does not end when the
+// pinger recovers.
+type Silence struct {
+	// Name of the silenced pinger.
+	Pinger string
+	// Time when the silence expires.
+	Until time.Time
+	// Why the pinger was silenced (optional).
+	Reason string `json:",omitempty"`
+}
+
+// silenceRegistry keeps track of silenced pingers (keyed on pinger ID, so
+// that a silence outlives a reload of the configuration of its pinger, but
+// not the removal of its pinger). It is shared between the Dispatcher and
+// whoever silences pingers (such as the REST API) and is safe for concurrent
+// use.
+type silenceRegistry struct {
+	lock     sync.Mutex
+	silences map[string]Silence
+}
+
+func newSilenceRegistry() *silenceRegistry {
+	return &silenceRegistry{silences: make(map[string]Silence)}
+}
+
+// silence silences a pinger (with a given ID and name), from a given point in
+// time, for a given duration.
+func (registry *silenceRegistry) silence(pingerID, pingerName string, duration time.Duration, reason string, now time.Time) Silence {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	silence := Silence{Pinger: pingerName, Until: now.UTC().Add(duration), Reason: reason}
+	registry.silences[pingerID] = silence
+	return silence
+}
+
+// unsilence removes any silence for a pinger and returns true if there was
+// one.
+func (registry *silenceRegistry) unsilence(pingerID string) bool {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	_, ok := registry.silences[pingerID]
+	delete(registry.silences, pingerID)
+	return ok
+}
+
+// get returns the silence (if any) of a pinger that is unexpired at a given
+// point in time. Expired silences are removed.
+func (registry *silenceRegistry) get(pingerID string, now time.Time) (Silence, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	silence, ok := registry.silences[pingerID]
+	if !ok {
+		return Silence{}, false
+	}
+	if now.After(silence.Until) {
+		delete(registry.silences, pingerID)
+		return Silence{}, false
+	}
+	return silence, true
+}
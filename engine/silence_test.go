@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+func TestSilenceVantageGroup(t *testing.T) {
+	pingerConf := testHTTPPinger("api", "http://127.0.0.1:1")
+	pingerConf.Vantages = []string{"eu", "us"}
+	engine, err := NewEngine(&config.Engine{
+		Vantages: []config.Vantage{{Name: "eu"}, {Name: "us"}},
+		Pingers:  []config.Pinger{pingerConf},
+	}, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+
+	silence, err := engine.Silence("api", time.Hour, "maintenance")
+	if err != nil {
+		t.Fatalf("failed to silence vantage group: %s", err)
+	}
+	if silence.Pinger != "api" || silence.Reason != "maintenance" {
+		t.Errorf("unexpected silence: %+v", silence)
+	}
+	for _, taskName := range []string{"api@eu", "api@us"} {
+		task, _ := engine.Pinger(taskName)
+		if _, ok := engine.dispatcher.Silenced(task.ID); !ok {
+			t.Errorf("%s: vantage not silenced", taskName)
+		}
+	}
+	if _, ok := engine.Silenced("api"); !ok {
+		t.Errorf("vantage group not reported as silenced")
+	}
+
+	// unsilencing a single vantage leaves the group partly silenced
+	if err := engine.Unsilence("api@eu"); err != nil {
+		t.Fatalf("failed to unsilence vantage: %s", err)
+	}
+	if _, ok := engine.Silenced("api"); ok {
+		t.Errorf("partly silenced vantage group reported as silenced")
+	}
+	if err := engine.Unsilence("api"); err != nil {
+		t.Fatalf("failed to unsilence vantage group: %s", err)
+	}
+	if err := engine.Unsilence("api"); err == nil {
+		t.Errorf("expected unsilencing an unsilenced pinger to fail")
+	}
+	if _, err := engine.Silence("nope", time.Hour, ""); err == nil {
+		t.Errorf("expected silencing an unknown pinger to fail")
+	}
+}
+
+func TestSilenceEndsWithRemovedPinger(t *testing.T) {
+	updates := make(chan StatusUpdate)
+	dispatcher, err := NewDispatcher(nil, "http://localhost", updates)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err)
+	}
+	go dispatcher.Start()
+
+	dispatcher.Silence("id-1", "name", time.Hour, "")
+	dispatcher.Silence("id-2", "name", time.Hour, "")
+	if _, ok := dispatcher.Silenced("id-1"); !ok {
+		t.Fatalf("pinger not silenced")
+	}
+	updates <- StatusUpdate{Name: "name", ID: "id-1", Removed: true}
+	// a second update is only received once the first has been handled
+	updates <- StatusUpdate{Name: "other", ID: "other"}
+	if _, ok := dispatcher.Silenced("id-1"); ok {
+		t.Errorf("silence of removed pinger not cleared")
+	}
+	if _, ok := dispatcher.Silenced("id-2"); !ok {
+		t.Errorf("silence of another pinger with the same name cleared")
+	}
+}
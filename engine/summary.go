@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/config"
+
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A PingerSummary describes a configured pinger, so that operators can
+// confirm that the configuration was loaded as intended.
+type PingerSummary struct {
+	Name string
+	Type string `json:",omitempty"`
+	// What the pinger checks (such as a URL or a host:port).
+	Target   string `json:",omitempty"`
+	Interval string `json:",omitempty"`
+	// Enabled is false for pingers that were skipped, in best-effort mode,
+	// since they could not be set up.
+	Enabled bool
+	// Error is the reason that a pinger was skipped.
+	Error string `json:",omitempty"`
+}
+
+// Summary summarizes all configured pingers (ordered by name).
+func (engine *Engine) Summary() []PingerSummary {
+	pingers, _, failed := engine.tasks()
+	summaries := make([]PingerSummary, 0, len(pingers)+len(failed))
+	for _, task := range pingers {
+		summaries = append(summaries, PingerSummary{
+			Name:     task.Name,
+			Type:     task.Type,
+			Target:   checkTarget(task.Config),
+			Interval: task.Schedule.Interval.Duration.String(),
+			Enabled:  true,
+		})
+	}
+	for name, err := range failed {
+		summaries = append(summaries, PingerSummary{Name: name, Error: err.Error()})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// logSummary logs a summary of all configured pingers.
+func (engine *Engine) logSummary() {
+	summaries := engine.Summary()
+	log.Infof("configured pingers: %d", len(summaries))
+	for _, summary := range summaries {
+		if !summary.Enabled {
+			log.Infof("  %s: disabled: %s", summary.Name, summary.Error)
+			continue
+		}
+		log.Infof("  %s: %s %s every %s", summary.Name, summary.Type, summary.Target, summary.Interval)
+	}
+}
+
+// checkTarget describes what a pinger checks (empty if unknown). Credentials
+// embedded in URLs are left out.
+func checkTarget(pingerConf *config.Pinger) string {
+	if pingerConf == nil {
+		return ""
+	}
+	switch pingerConf.Type {
+	case "http":
+		var check config.HTTPCheck
+		if json.Unmarshal(pingerConf.Check, &check) != nil {
+			return ""
+		}
+		urls := check.URLs
+		if check.URL != "" {
+			urls = []string{check.URL}
+		}
+		targets := make([]string, len(urls))
+		for i, rawURL := range urls {
+			targets[i] = withoutUserinfo(rawURL)
+		}
+		return strings.Join(targets, ", ")
+	case "ssh":
+		var check config.SSHCheck
+		if json.Unmarshal(pingerConf.Check, &check) != nil {
+			return ""
+		}
+		return sshTarget(&check.SSHTarget)
+	case "service":
+		var check config.ServiceCheck
+		if json.Unmarshal(pingerConf.Check, &check) != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s on %s", check.Unit, sshTarget(&check.SSHTarget))
+	case "disk":
+		var check config.DiskCheck
+		if json.Unmarshal(pingerConf.Check, &check) != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s on %s", check.Path, sshTarget(&check.SSHTarget))
+	case "dnsserial":
+		var check config.DNSSerialCheck
+		if json.Unmarshal(pingerConf.Check, &check) != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s (%s)", check.Zone, strings.Join(check.Servers, ", "))
+	case "grpc":
+		var check config.GRPCCheck
+		if json.Unmarshal(pingerConf.Check, &check) != nil {
+			return ""
+		}
+		return check.Target
+	case "icmp":
+		var check config.ICMPCheck
+		if json.Unmarshal(pingerConf.Check, &check) != nil {
+			return ""
+		}
+		return check.Host
+	default:
+		return ""
+	}
+}
+
+// sshTarget describes an SSH server as host:port (or host, if no port is
+// given).
+func sshTarget(target *config.SSHTarget) string {
+	if target.Port == 0 {
+		return target.Host
+	}
+	return net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+}
+
+// withoutUserinfo strips any userinfo (user:password@) from a URL.
+func withoutUserinfo(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		return rawURL
+	}
+	parsed.User = nil
+	return parsed.String()
+}
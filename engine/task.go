@@ -4,16 +4,56 @@ import (
 	"github.com/petergardfjall/watcher/config"
 	"github.com/petergardfjall/watcher/ping"
 	"bytes"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
-// A StatusUpdate is sent by a PingerTask to its status channel for every
+// ErrPingInFlight is returned when triggering a PingerTask that is busy
+// pinging.
+var ErrPingInFlight = errors.New("a ping is already in flight")
+
+// ErrNotRunning is returned when triggering a PingerTask that has not been
+// started or has stopped.
+var ErrNotRunning = errors.New("pinger is not running")
+
+// A StatusUpdate is published by a PingerTask on its event bus for every
 // execution of its Pinger to notify interested parties of the Pinger's status.
 type StatusUpdate struct {
-	Name   string
+	Name        string
+	Description string
+	// The stable identifier of the pinger, by which its alert state is
+	// tracked.
+	ID     string
 	Status PingerTaskStatus
+	// Labels of the pinger (global labels merged with those of the
+	// pinger).
+	Labels map[string]string
+	// BusinessHours, if set, is the window outside of which alerts for
+	// the pinger are to be deferred.
+	BusinessHours *config.BusinessHours
+	// Hooks, if set, are commands to run on state transitions.
+	Hooks *config.Hooks
+	// MaxAlerts, if non-zero, is the number of failure alerts after which
+	// alerting for the pinger is paused until it is acknowledged or recovers.
+	MaxAlerts int
+	// RedactOutput is true if output is to be left out of the alerts of
+	// the pinger.
+	RedactOutput bool
+	// Alerters are the names of the alerters to alert through (if none are
+	// given, the default alerters are used).
+	Alerters []string
+	// Transition is true if the update conveys a state transition to be
+	// alerted on (with the failure threshold of the pinger accounted for).
+	Transition bool
+	// AlertedStatus is the status of the latest state transition that was
+	// conveyed for the pinger.
+	AlertedStatus ping.Status
+	// Removed is true if the pinger has been removed (on reload). Such an
+	// update is the last one for the pinger and carries no status.
+	Removed bool
 }
 
 // PingerTaskStatus describes the current status of a PingerTask.
@@ -27,26 +67,119 @@ type PingerTaskStatus struct {
 	LatestOK *time.Time
 	// Time of last unsuccessful ping (or nil if none has failed).
 	LatestNOK *time.Time
+	// Time since which the pinger has had the status of the most recent
+	// ping result.
+	InStateSince *time.Time
+	// Number of attempts used by the most recent ping.
+	Attempts int
+	// Number of failed attempts (including retries) since the latest
+	// successful ping.
+	FailedAttempts int
+	// Aggregate of the pings performed during the latest report interval
+	// (nil unless the schedule has a report interval).
+	Aggregate *Aggregate
+}
+
+// An Aggregate summarizes the pings performed during a report interval.
+type Aggregate struct {
+	// Number of pings performed.
+	Pings int
+	// Number of successful pings.
+	OK int
+	// Fraction of successful pings, in the range [0,1].
+	SuccessRate float64
 }
 
 // A PingerTask is responsible for periodically executing a given Pinger and
-// pushing the ping result as a StatusUpdate on its status channel.
+// pushing the ping result as a StatusUpdate on its event bus.
 type PingerTask struct {
-	Name     string
-	Type     string
-	Pinger   ping.Pinger
-	Schedule config.Schedule
+	Name        string
+	Description string
+	Type        string
+	Pinger      ping.Pinger
+	Schedule    config.Schedule
+	// Window outside of which alerts are deferred (nil if none).
+	BusinessHours *config.BusinessHours
+	// Commands to run on state transitions (nil if none).
+	Hooks *config.Hooks
+	// The number of failure alerts after which alerting is paused until the
+	// pinger is acknowledged or recovers (0 means no limit).
+	MaxAlerts int
+	// If true, output (and links to it) is left out of alerts.
+	RedactOutputInAlerts bool
+	// Names of the alerters to alert through (none means the default).
+	Alerters []string
+	// How much the pinger counts in the health score.
+	Weight int
+	// The largest value that Status.Consecutive may reach (0 means no
+	// limit).
+	MaxConsecutive int
+	// The name of a pinger that must be OK for this one to run (if any).
+	RunIf string
+	// The configuration that the PingerTask was created from.
+	Config *config.Pinger
+	// Stable identifier of the pinger (see config.Pinger.ID).
+	ID string
+	// Labels of the pinger (global labels merged with those of the
+	// pinger).
+	Labels map[string]string
 	// Engine WaitGroup that PingerTask will notify when done.
-	WaitGroup sync.WaitGroup
+	WaitGroup *sync.WaitGroup
 
-	// Current task status
+	// Current task status. Use Snapshot to read it from other goroutines.
 	Status PingerTaskStatus
-	// Latest output returned by pinger
+	// Latest output returned by pinger. Use OutputBytes to read it from
+	// other goroutines.
 	Output *bytes.Buffer
+	// Time at which the output last changed (nil if no output recorded).
+	// Use OutputChangeTime to read it from other goroutines.
+	OutputChangedAt *time.Time
+	// True if the Output is binary (not UTF-8 text). Use OutputIsBinary to
+	// read it from other goroutines.
+	OutputBinary bool
+	// If true, output identical to the stored Output is not stored again.
+	SuppressDuplicateOutput bool
+	// statusLock protects Status, Output, OutputChangedAt and OutputBinary,
+	// which are written by the PingerTask and read concurrently (such as by
+	// the REST API).
+	statusLock sync.Mutex
+	// latestRun is the time at which the PingerTask started or last
+	// completed a run (protected by statusLock).
+	latestRun time.Time
+	// running is true while the PingerTask is started and pinging is true
+	// while it runs a ping (both protected by statusLock).
+	running bool
+	pinging bool
 
-	// statusUpdateChannel is a write-only channel that the PingerTask
-	// sends PingerStatusUpdates on.
-	statusChan chan<- StatusUpdate
+	// events is the bus that the PingerTask publishes StatusUpdates on.
+	events *EventBus
+	// random is the (shared) source of the jitter of the PingerTask.
+	random *lockedRand
+	// clock tells the time to the PingerTask (nil: the system clock).
+	clock Clock
+	// metrics records the pings and status of the PingerTask.
+	metrics *Metrics
+	// the most recent pings of the PingerTask
+	history *pingHistory
+	// status of the latest published state transition (protected by
+	// statusLock, as a replacing PingerTask takes it over while the
+	// PingerTask may still be finishing a ping)
+	alertedStatus ping.Status
+	// statuses of the most recent pings (when using a failure window)
+	recentStatuses []ping.Status
+	// reports the current status of the RunIf pinger
+	prerequisiteStatus func() ping.Status
+	// closed when the PingerTask is to stop
+	stop     chan struct{}
+	stopOnce sync.Once
+	// closed when the PingerTask has stopped
+	done chan struct{}
+	// receives manual triggers, each with a channel on which to reply with
+	// the resulting status
+	trigger chan chan PingerTaskStatus
+	// the (JSON-encoded) configuration that the PingerTask was created
+	// from, to tell if it changes on reload
+	spec []byte
 }
 
 //
@@ -54,75 +187,575 @@ type PingerTask struct {
 //
 
 // Start starts the execution of the PingerTask. It will execute the Pinger
-// according to the given schedule and post StatusUpdates on its status channel.
+// according to the given schedule and post StatusUpdates on its event bus.
 func (task *PingerTask) Start() {
 	// signal to Engine when we're done
 	defer task.WaitGroup.Done()
+	defer close(task.done)
+	defer func() {
+		task.statusLock.Lock()
+		task.running = false
+		task.statusLock.Unlock()
+	}()
 
-	task.Status = PingerTaskStatus{
-		LatestResult: ping.Result{
-			Status: ping.StatusUnknown,
-			Error:  fmt.Errorf("no ping performed yet")},
-		Consecutive: 1,
+	// a task that took over from a replaced one keeps its status
+	task.statusLock.Lock()
+	task.latestRun = task.now()
+	task.running = true
+	if task.Status.InStateSince == nil {
+		started := task.now().UTC()
+		task.Status = PingerTaskStatus{
+			LatestResult: ping.Result{
+				Status: ping.StatusUnknown,
+				Error:  fmt.Errorf("no ping performed yet")},
+			Consecutive:  1,
+			InStateSince: &started,
+		}
+	}
+	task.statusLock.Unlock()
+
+	if warmer, ok := task.Pinger.(ping.Warmer); ok {
+		go warmer.KeepWarm(task.stop)
+	}
+	if closer, ok := task.Pinger.(ping.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				log.Warningf("[%s] failed to close pinger: %s", task.Name, err)
+			}
+		}()
 	}
 
 	delay := task.Schedule.Interval.Duration
 	log.Infof("[%s] started. interval: %s. retries: %+v", task.Name, delay, *task.Schedule.Retries)
+
+	var aggregator resultAggregator
+	var reportDeadline time.Time
+	if task.Schedule.ReportInterval != nil {
+		log.Infof("[%s] reporting aggregated status every %s", task.Name, task.Schedule.ReportInterval.Duration)
+		reportDeadline = task.now().Add(task.Schedule.ReportInterval.Duration)
+	}
 	for {
-		log.Debugf("[%s] waiting %s before next run ...", task.Name, delay)
-		time.Sleep(delay)
-		log.Infof("[%s] pinging ...", task.Name)
-		result, output := task.ping()
-		log.Debugf("[%s] result: %s", task.Name, result)
-		if output != nil {
-			log.Debugf("[%s] output: %s", task.Name, output.String())
+		wait := delay + task.jitter()
+		log.Debugf("[%s] waiting %s before next run ...", task.Name, wait)
+		reply, ok := task.wait(wait)
+		if !ok {
+			log.Infof("[%s] stopped", task.Name)
+			return
+		}
+		task.setPinging(true)
+		task.run(&aggregator, &reportDeadline, reply != nil)
+		task.setPinging(false)
+		if reply != nil {
+			reply <- task.Snapshot()
+		}
+	}
+
+}
+
+// run performs a single run of the PingerTask: a ping whose result updates
+// the status (or, with a report interval, is aggregated until the report is
+// due). A triggered run reports right away, so that its ping is reflected in
+// the status. The ping is skipped if the prerequisite of the PingerTask is not
+// OK.
+func (task *PingerTask) run(aggregator *resultAggregator, reportDeadline *time.Time, triggered bool) {
+	defer func() {
+		task.statusLock.Lock()
+		task.latestRun = task.now()
+		task.statusLock.Unlock()
+	}()
+
+	if task.prerequisiteStatus != nil && task.prerequisiteStatus() != ping.StatusOK {
+		log.Infof("[%s] prerequisite %s is not OK: skipping ping", task.Name, task.RunIf)
+		skipped := ping.Result{Status: ping.StatusUnknown, Error: fmt.Errorf("skipped: prerequisite %s is not OK", task.RunIf)}
+		task.updateStatus(skipped, nil, 0, 0)
+		return
+	}
+	log.Infof("[%s] pinging ...", task.Name)
+	pingStart := time.Now()
+	result, output, attempts := task.ping()
+	duration := time.Since(pingStart)
+	// the metrics of a halted (removed or replaced) task are discarded,
+	// so it must not record them anew
+	if !task.halted() {
+		task.metrics.observePing(task.Name, result.Status, duration)
+	}
+	log.Debugf("[%s] result: %s", task.Name, result)
+	if output != nil {
+		log.Debugf("[%s] output: %s", task.Name, output.String())
+	}
+
+	if task.Schedule.ReportInterval != nil {
+		aggregator.add(result, output, attempts)
+		if !triggered && task.now().Before(*reportDeadline) {
+			return
 		}
-		task.updateStatus(result, output)
-		log.Infof("[%s] status: %+v", task.Name, task.Status)
+		var aggregate Aggregate
+		result, output, attempts, aggregate = aggregator.report()
+		task.statusLock.Lock()
+		task.Status.Aggregate = &aggregate
+		task.statusLock.Unlock()
+		*reportDeadline = task.now().Add(task.Schedule.ReportInterval.Duration)
+		// the report is not the result of a single ping
+		duration = 0
+	}
+	task.updateStatus(result, output, attempts, duration)
+	log.Infof("[%s] status: %+v", task.Name, task.Status)
+}
+
+// now returns the current time, as told by the clock of the PingerTask.
+func (task *PingerTask) now() time.Time {
+	if task.clock == nil {
+		return time.Now()
+	}
+	return task.clock.Now()
+}
+
+// jitter returns a random offset in [0, Jitter) to add to a wait for the next
+// run of the PingerTask (zero if its schedule has no jitter).
+func (task *PingerTask) jitter() time.Duration {
+	if task.Schedule.Jitter == nil {
+		return 0
 	}
+	return time.Duration(task.random.Int63n(int64(task.Schedule.Jitter.Duration)))
+}
 
+// wait waits for the next run of the PingerTask, which is due after a given
+// interval or when the PingerTask is triggered manually (in which case the
+// channel on which to reply with the resulting status is returned). It
+// returns false if the PingerTask was stopped.
+func (task *PingerTask) wait(interval time.Duration) (chan<- PingerTaskStatus, bool) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil, true
+	case reply := <-task.trigger:
+		log.Infof("[%s] triggered manually", task.Name)
+		return reply, true
+	case <-task.stop:
+		return nil, false
+	}
+}
+
+// Trigger makes the PingerTask ping right away, rather than waiting for its
+// interval to pass, and returns the resulting status (with a report
+// interval, the status reported right away, including the triggered ping).
+// The interval starts over after the ping. ErrPingInFlight is returned if the
+// PingerTask is busy pinging and ErrNotRunning if it is not started or has
+// stopped.
+func (task *PingerTask) Trigger() (PingerTaskStatus, error) {
+	reply := make(chan PingerTaskStatus, 1)
+	select {
+	case task.trigger <- reply:
+		return <-reply, nil
+	case <-task.done:
+		return PingerTaskStatus{}, ErrNotRunning
+	default:
+	}
+
+	task.statusLock.Lock()
+	running, pinging := task.running, task.pinging
+	task.statusLock.Unlock()
+	if !running {
+		return PingerTaskStatus{}, ErrNotRunning
+	}
+	if pinging {
+		return PingerTaskStatus{}, ErrPingInFlight
+	}
+	// in between runs: the PingerTask is about to wait for its next run
+	select {
+	case task.trigger <- reply:
+		return <-reply, nil
+	case <-task.done:
+		return PingerTaskStatus{}, ErrNotRunning
+	}
+}
+
+// setPinging records whether the PingerTask is running a ping.
+func (task *PingerTask) setPinging(pinging bool) {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	task.pinging = pinging
+}
+
+// halt signals the PingerTask to stop. An ongoing ping is completed first.
+func (task *PingerTask) halt() {
+	task.stopOnce.Do(func() { close(task.stop) })
+}
+
+// halted returns true if the PingerTask has been signalled to stop.
+func (task *PingerTask) halted() bool {
+	select {
+	case <-task.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// takeOver makes the (not yet started) PingerTask carry on the status and
+// output of a PingerTask that it replaces.
+func (task *PingerTask) takeOver(replaced *PingerTask) {
+	replaced.statusLock.Lock()
+	task.Status = replaced.Status
+	task.Output = replaced.Output
+	task.OutputChangedAt = replaced.OutputChangedAt
+	task.OutputBinary = replaced.OutputBinary
+	task.alertedStatus = replaced.alertedStatus
+	replaced.statusLock.Unlock()
+	for _, entry := range replaced.history.list() {
+		task.history.add(entry)
+	}
+}
+
+// Snapshot returns the current status of the PingerTask.
+func (task *PingerTask) Snapshot() PingerTaskStatus {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	return task.Status
+}
+
+// OutputBytes returns the latest output of the PingerTask (nil if no output
+// has been recorded).
+func (task *PingerTask) OutputBytes() []byte {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	if task.Output == nil {
+		return nil
+	}
+	return append([]byte{}, task.Output.Bytes()...)
+}
+
+// OutputIsBinary returns true if the latest output of the PingerTask is binary
+// (rather than UTF-8 text).
+func (task *PingerTask) OutputIsBinary() bool {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	return task.OutputBinary
+}
+
+// LatestRun returns the time at which the PingerTask last completed a run
+// (whether the ping was OK, not OK, or skipped), or at which it started if it
+// has not yet completed one.
+func (task *PingerTask) LatestRun() time.Time {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	return task.latestRun
+}
+
+// OutputChangeTime returns the time at which the output of the PingerTask
+// last changed (nil if no output has been recorded).
+func (task *PingerTask) OutputChangeTime() *time.Time {
+	task.statusLock.Lock()
+	defer task.statusLock.Unlock()
+	return task.OutputChangedAt
+}
+
+// History returns the most recent pings of the PingerTask, oldest first.
+func (task *PingerTask) History() []HistoryEntry {
+	return task.history.list()
 }
 
 // ping performs a ping (with the configured number of attempts for the
-// PingerTask)
-func (task *PingerTask) ping() (result ping.Result, output *bytes.Buffer) {
+// PingerTask) and returns the result along with the number of attempts used.
+func (task *PingerTask) ping() (result ping.Result, output *bytes.Buffer, attempts int) {
+	if cycleStarter, ok := task.Pinger.(ping.CycleStarter); ok {
+		cycleStarter.StartCycle()
+	}
+	if task.Schedule.Retries.Concurrency > 1 {
+		return task.pingConcurrently()
+	}
+
 	attemptDelay := task.Schedule.Retries.Delay.Duration
 	maxAttempts := task.Schedule.Retries.Attempts
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		attempts = attempt
 		log.Debugf("[%s] attempt %d ...", task.Name, attempt)
 		result, output = task.Pinger.Ping()
 		log.Debugf("[%s] attempt %d result: %s", task.Name, attempt, result)
 		if result.Status == ping.StatusOK {
 			return
 		}
+		if retrier, ok := task.Pinger.(ping.Retrier); ok && !retrier.ShouldRetry(result) {
+			log.Debugf("[%s] not retrying: %s", task.Name, result)
+			return
+		}
 		// make new attempt (possibly with exponential backoff)
 		if task.Schedule.Retries.ExponentialBackoff {
 			attemptDelay = attemptDelay * 2
 		}
-		if attempt < maxAttempts {
-			time.Sleep(attemptDelay)
+		if attempt < maxAttempts && !task.sleep(attemptDelay) {
+			return
+		}
+	}
+	return
+}
+
+// sleep waits for a given duration, unless the PingerTask is stopped in the
+// meantime. It returns false if the PingerTask was stopped.
+func (task *PingerTask) sleep(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-task.stop:
+		return false
+	}
+}
+
+// pingConcurrently performs a ping by making the configured number of attempts
+// concurrently (with at most Concurrency attempts in flight). The first
+// successful attempt decides the result, in which case attempts that have not
+// yet started are skipped. If all attempts fail, the result of the last one
+// to finish is returned.
+func (task *PingerTask) pingConcurrently() (result ping.Result, output *bytes.Buffer, attempts int) {
+	type outcome struct {
+		result ping.Result
+		output *bytes.Buffer
+	}
+
+	maxAttempts := task.Schedule.Retries.Attempts
+	outcomes := make(chan outcome, maxAttempts)
+	slots := make(chan struct{}, task.Schedule.Retries.Concurrency)
+	done := make(chan struct{})
+	defer close(done)
+
+	var wg sync.WaitGroup
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		wg.Add(1)
+		go func(attempt int) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			defer func() { <-slots }()
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			log.Debugf("[%s] attempt %d ...", task.Name, attempt)
+			result, output := task.Pinger.Ping()
+			log.Debugf("[%s] attempt %d result: %s", task.Name, attempt, result)
+			outcomes <- outcome{result, output}
+		}(attempt)
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	for o := range outcomes {
+		attempts++
+		result, output = o.result, o.output
+		if result.Status == ping.StatusOK {
+			return
 		}
 	}
 	return
 }
 
-// updateStatus sets the status for the PingerTask and sends a
-// PingerStatusUpdate on the statusUpdateChannel
-func (task *PingerTask) updateStatus(result ping.Result, output *bytes.Buffer) {
+// updateStatus sets the status for the PingerTask, records the ping (which
+// took a given duration, 0 if unknown) in its history and publishes a
+// StatusUpdate on its event bus
+func (task *PingerTask) updateStatus(result ping.Result, output *bytes.Buffer, attempts int, duration time.Duration) {
+	now := task.now().UTC()
+	task.statusLock.Lock()
 	if result.Status == task.Status.LatestResult.Status {
-		task.Status.Consecutive++
+		// for a long-stable pinger, InStateSince says more than a huge
+		// counter
+		if task.MaxConsecutive == 0 || task.Status.Consecutive < task.MaxConsecutive {
+			task.Status.Consecutive++
+		}
 	} else {
 		task.Status.Consecutive = 1
+		task.Status.InStateSince = &now
 	}
-	now := time.Now().UTC()
 	switch result.Status {
 	case ping.StatusOK:
 		task.Status.LatestOK = &now
+		task.Status.FailedAttempts = 0
 	case ping.StatusNOK:
 		task.Status.LatestNOK = &now
+		task.Status.FailedAttempts += attempts
 	}
 	task.Status.LatestResult = result
+	task.Status.Attempts = attempts
+	if window := task.Schedule.FailureWindow; window != nil {
+		task.recentStatuses = append(task.recentStatuses, result.Status)
+		if len(task.recentStatuses) > window.Pings {
+			task.recentStatuses = task.recentStatuses[1:]
+		}
+	}
+	task.storeOutput(output, now)
+	transition := task.checkTransition()
+	alertedStatus := task.alertedStatus
+	task.statusLock.Unlock()
+
+	entry := HistoryEntry{Time: now, Result: result, Attempts: attempts}
+	if duration > 0 {
+		entry.Duration = &config.Duration{Duration: duration}
+	}
+	task.history.add(entry)
+
+	if !task.halted() {
+		task.metrics.setStatus(task.Name, task.Labels, task.Status)
+	}
+
+	task.events.Publish(StatusUpdate{
+		Name:          task.Name,
+		Description:   task.Description,
+		ID:            task.ID,
+		Labels:        task.Labels,
+		Status:        task.Status,
+		BusinessHours: task.BusinessHours,
+		Hooks:         task.Hooks,
+		MaxAlerts:     task.MaxAlerts,
+		RedactOutput:  task.RedactOutputInAlerts,
+		Alerters:      task.Alerters,
+		Transition:    transition,
+		AlertedStatus: alertedStatus,
+	})
+}
+
+// storeOutput stores the latest output of the PingerTask, recording the time
+// at which it last changed. With SuppressDuplicateOutput, output identical to
+// the stored output is not stored again. Callers must hold the statusLock.
+func (task *PingerTask) storeOutput(output *bytes.Buffer, now time.Time) {
+	unchanged := output != nil && task.Output != nil && bytes.Equal(output.Bytes(), task.Output.Bytes())
+	if unchanged && task.SuppressDuplicateOutput {
+		log.Debugf("[%s] output unchanged: not storing it", task.Name)
+		return
+	}
+	if output != nil && !unchanged {
+		task.OutputChangedAt = &now
+	}
 	task.Output = output
+	task.OutputBinary = output != nil && isBinary(output.Bytes())
+}
+
+// isBinary returns true if output is not text, that is, if it is not valid
+// UTF-8 or contains NUL bytes.
+func isBinary(output []byte) bool {
+	return !utf8.Valid(output) || bytes.IndexByte(output, 0) >= 0
+}
+
+// checkTransition returns true (and records the new state) if the current
+// status conveys a state transition to be alerted on. A pinger being in state
+// unknown does not count as a state change (it is the initial state of the
+// pinger) and a failure only counts once the failure threshold is reached.
+// With a failure window, a pinger only recovers once the number of failures
+// in the window drops below the threshold. Callers must hold the statusLock.
+func (task *PingerTask) checkTransition() bool {
+	status := task.Status.LatestResult.Status
+	if status == ping.StatusUnknown || status == task.alertedStatus {
+		return false
+	}
+	if status == ping.StatusNOK && task.failures() < task.failureThreshold() {
+		log.Debugf("[%s] failure threshold not yet reached", task.Name)
+		return false
+	}
+	if status == ping.StatusOK && task.Schedule.FailureWindow != nil && task.failures() >= task.failureThreshold() {
+		log.Debugf("[%s] too many failures in window to recover", task.Name)
+		return false
+	}
+	task.alertedStatus = status
+	return true
+}
+
+// failures returns the number of failures of the PingerTask. With a failure
+// window, these are the failed pings within the window. Otherwise, they are
+// the consecutive failures, counted either as failed pings or as failed
+// attempts depending on the retry configuration.
+func (task *PingerTask) failures() int {
+	if task.Schedule.FailureWindow != nil {
+		failures := 0
+		for _, status := range task.recentStatuses {
+			if status == ping.StatusNOK {
+				failures++
+			}
+		}
+		return failures
+	}
+	if task.Status.LatestResult.Status != ping.StatusNOK {
+		return 0
+	}
+	if task.Schedule.Retries.CountAttempts {
+		return task.Status.FailedAttempts
+	}
+	return task.Status.Consecutive
+}
+
+// failureThreshold returns the number of consecutive failures required
+// before the PingerTask is to be alerted on as failing.
+func (task *PingerTask) failureThreshold() int {
+	if task.Schedule.FailureWindow != nil {
+		return task.Schedule.FailureWindow.Failures
+	}
+	if task.Schedule.FailureThreshold < 1 {
+		return 1
+	}
+	return task.Schedule.FailureThreshold
+}
+
+// resultAggregator accumulates the ping results of a report interval.
+type resultAggregator struct {
+	pings         int
+	attempts      int
+	ok            int
+	latestNOK     ping.Result
+	output        *bytes.Buffer
+	outputChanged bool
+	// summary of the latest ping
+	summary string
+	// trace ID of the latest ping
+	traceID string
+}
+
+// add adds a ping result to the aggregator.
+func (aggregator *resultAggregator) add(result ping.Result, output *bytes.Buffer, attempts int) {
+	aggregator.pings++
+	aggregator.attempts += attempts
+	if result.Status == ping.StatusOK {
+		aggregator.ok++
+	} else {
+		aggregator.latestNOK = result
+	}
+	aggregator.output = output
+	aggregator.outputChanged = aggregator.outputChanged || result.OutputChanged
+	aggregator.summary = result.Summary
+	aggregator.traceID = result.TraceID
+}
+
+// report returns the majority result of the accumulated pings (ties count as
+// failures), the latest output, the total number of attempts used, and an
+// Aggregate summary. The aggregator is reset for the next report interval.
+func (aggregator *resultAggregator) report() (ping.Result, *bytes.Buffer, int, Aggregate) {
+	aggregate := Aggregate{
+		Pings:       aggregator.pings,
+		OK:          aggregator.ok,
+		SuccessRate: float64(aggregator.ok) / float64(aggregator.pings),
+	}
+
+	result := ping.Result{Status: ping.StatusOK}
+	if 2*aggregator.ok <= aggregator.pings {
+		failed := aggregator.pings - aggregator.ok
+		result = ping.Result{
+			Status:   ping.StatusNOK,
+			Error:    fmt.Errorf("%d of %d pings failed, latest: %w", failed, aggregator.pings, aggregator.latestNOK.Error),
+			Category: aggregator.latestNOK.Category,
+		}
+	}
+	result.OutputChanged = aggregator.outputChanged
+	result.Summary = aggregator.summary
+	result.TraceID = aggregator.traceID
+	output := aggregator.output
+	attempts := aggregator.attempts
 
-	task.statusChan <- StatusUpdate{Name: task.Name, Status: task.Status}
+	*aggregator = resultAggregator{}
+	return result, output, attempts, aggregate
 }
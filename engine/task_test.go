@@ -0,0 +1,570 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// fakePinger is a Pinger that takes a given time to ping and reports a given
+// status.
+type fakePinger struct {
+	delay time.Duration
+
+	lock   sync.Mutex
+	status ping.Status
+	pings  int
+}
+
+func (pinger *fakePinger) Ping() (ping.Result, *bytes.Buffer) {
+	time.Sleep(pinger.delay)
+	pinger.lock.Lock()
+	defer pinger.lock.Unlock()
+	pinger.pings++
+	return ping.Result{Status: pinger.status}, nil
+}
+
+// setStatus sets the status that the fakePinger reports from now on.
+func (pinger *fakePinger) setStatus(status ping.Status) {
+	pinger.lock.Lock()
+	defer pinger.lock.Unlock()
+	pinger.status = status
+}
+
+// pingCount returns the number of pings made by the fakePinger.
+func (pinger *fakePinger) pingCount() int {
+	pinger.lock.Lock()
+	defer pinger.lock.Unlock()
+	return pinger.pings
+}
+
+// newTestTask creates a (not yet started) PingerTask that runs a Pinger with
+// a given schedule. Its status updates are discarded.
+func newTestTask(pinger ping.Pinger, schedule config.Schedule) *PingerTask {
+	events := NewEventBus()
+	updates := events.Subscribe()
+	go func() {
+		for range updates {
+		}
+	}()
+	if schedule.Interval == nil {
+		schedule.Interval = &config.Duration{Duration: time.Hour}
+	}
+	if schedule.Retries == nil {
+		schedule.Retries = &config.Retries{Attempts: 1}
+	}
+	return &PingerTask{
+		Name:      "test",
+		ID:        "test",
+		Pinger:    pinger,
+		Schedule:  schedule,
+		WaitGroup: &sync.WaitGroup{},
+		events:    events,
+		metrics:   NewMetrics(),
+		history:   newPingHistory(0),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		trigger:   make(chan chan PingerTaskStatus),
+	}
+}
+
+// startTestTask starts a PingerTask and waits for it to be running.
+func startTestTask(t *testing.T, task *PingerTask) {
+	t.Helper()
+	task.WaitGroup.Add(1)
+	go task.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task.statusLock.Lock()
+		running := task.running
+		task.statusLock.Unlock()
+		if running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stopTestTask stops a PingerTask and waits for it to finish.
+func stopTestTask(task *PingerTask) {
+	task.halt()
+	<-task.done
+}
+
+func TestRunIf(t *testing.T) {
+	pinger := &fakePinger{status: ping.StatusNOK}
+	task := newTestTask(pinger, config.Schedule{})
+	task.RunIf = "gateway"
+	var prerequisiteLock sync.Mutex
+	var prerequisite ping.Status = ping.StatusNOK
+	task.prerequisiteStatus = func() ping.Status {
+		prerequisiteLock.Lock()
+		defer prerequisiteLock.Unlock()
+		return prerequisite
+	}
+	updates := task.events.Subscribe()
+
+	// a dependent pinger is neither pinged nor alerted on while its
+	// prerequisite is not OK
+	for i := 0; i < 3; i++ {
+		task.run(nil, &time.Time{}, false)
+		update, _ := receive(t, updates)
+		if update.Transition || update.Status.LatestResult.Status != ping.StatusUnknown {
+			t.Fatalf("expected a skipped ping without a transition, got %+v", update)
+		}
+	}
+	if pinger.pingCount() != 0 {
+		t.Errorf("expected no pings while the prerequisite is not OK, got %d", pinger.pingCount())
+	}
+	if err := task.Snapshot().LatestResult.Error; err == nil || err.Error() != "skipped: prerequisite gateway is not OK" {
+		t.Errorf("unexpected skip reason: %v", err)
+	}
+
+	prerequisiteLock.Lock()
+	prerequisite = ping.StatusOK
+	prerequisiteLock.Unlock()
+	task.run(nil, &time.Time{}, false)
+	update, _ := receive(t, updates)
+	if pinger.pingCount() != 1 || !update.Transition || update.Status.LatestResult.Status != ping.StatusNOK {
+		t.Errorf("expected a ping once the prerequisite is OK, got %d pings and %+v", pinger.pingCount(), update)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	pinger := &fakePinger{delay: 200 * time.Millisecond, status: ping.StatusOK}
+	task := newTestTask(pinger, config.Schedule{})
+	startTestTask(t, task)
+
+	var concurrentErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(50 * time.Millisecond)
+		_, concurrentErr = task.Trigger()
+	}()
+	status, err := task.Trigger()
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("trigger failed: %s", err)
+	}
+	if status.LatestResult.Status != ping.StatusOK || pinger.pingCount() != 1 {
+		t.Errorf("unexpected status after trigger: %+v (%d pings)", status, pinger.pingCount())
+	}
+	if concurrentErr != ErrPingInFlight {
+		t.Errorf("expected ErrPingInFlight for trigger during ping, got: %v", concurrentErr)
+	}
+
+	status, err = task.Trigger()
+	if err != nil || status.Consecutive != 2 {
+		t.Errorf("unexpected second trigger: %+v, %v", status, err)
+	}
+
+	stopTestTask(task)
+	if _, err := task.Trigger(); err != ErrNotRunning {
+		t.Errorf("expected ErrNotRunning for stopped task, got: %v", err)
+	}
+}
+
+func TestTriggerNotStarted(t *testing.T) {
+	task := newTestTask(&fakePinger{status: ping.StatusOK}, config.Schedule{})
+	if _, err := task.Trigger(); err != ErrNotRunning {
+		t.Errorf("expected ErrNotRunning for task that is not started, got: %v", err)
+	}
+}
+
+func TestTriggerWithReportInterval(t *testing.T) {
+	pinger := &fakePinger{status: ping.StatusOK}
+	task := newTestTask(pinger, config.Schedule{ReportInterval: &config.Duration{Duration: 24 * time.Hour}})
+	startTestTask(t, task)
+	defer stopTestTask(task)
+
+	status, err := task.Trigger()
+	if err != nil {
+		t.Fatalf("trigger failed: %s", err)
+	}
+	if status.LatestResult.Status != ping.StatusOK || status.Aggregate == nil || status.Aggregate.Pings != 1 {
+		t.Errorf("triggered ping not reported: %+v", status)
+	}
+
+	// the triggered ping is reported, rather than a stale status
+	pinger.setStatus(ping.StatusNOK)
+	status, err = task.Trigger()
+	if err != nil {
+		t.Fatalf("trigger failed: %s", err)
+	}
+	if status.LatestResult.Status != ping.StatusNOK || status.Aggregate.Pings != 1 {
+		t.Errorf("unexpected status after second trigger: %+v", status)
+	}
+}
+
+func TestRetryOnStatus(t *testing.T) {
+	var lock sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for path, wantAttempts := range map[string]int{"/unavailable": 3, "/missing": 1} {
+		check := fmt.Sprintf(`{"url": %q, "retryOnStatus": [502, 503], "expect": {"statusCode": 200}}`, server.URL+path)
+		pinger, err := ping.NewHTTPPinger(&config.Pinger{Name: "test", Type: "http", Check: json.RawMessage(check)})
+		if err != nil {
+			t.Fatalf("failed to create pinger: %s", err)
+		}
+		task := newTestTask(pinger, config.Schedule{
+			Retries: &config.Retries{Attempts: 3, Delay: config.Duration{Duration: time.Millisecond}},
+		})
+		result, _, attempts := task.ping()
+		if result.Status != ping.StatusNOK || attempts != wantAttempts {
+			t.Errorf("%s: got %s after %d attempts, want NOK after %d", path, result.Status, attempts, wantAttempts)
+		}
+		lock.Lock()
+		if requests[path] != wantAttempts {
+			t.Errorf("%s: got %d requests, want %d", path, requests[path], wantAttempts)
+		}
+		lock.Unlock()
+	}
+}
+
+func TestCountAttempts(t *testing.T) {
+	for _, countAttempts := range []bool{false, true} {
+		task := newTestTask(&fakePinger{status: ping.StatusNOK}, config.Schedule{
+			FailureThreshold: 4,
+			Retries:          &config.Retries{Attempts: 3, Delay: config.Duration{Duration: time.Millisecond}, CountAttempts: countAttempts},
+		})
+		updates := task.events.Subscribe()
+		startTestTask(t, task)
+
+		// with three attempts per ping, counting attempts reaches the
+		// threshold on the second ping rather than on the fourth
+		wantTransition := 4
+		if countAttempts {
+			wantTransition = 2
+		}
+		for i := 1; i <= 4; i++ {
+			status, err := task.Trigger()
+			if err != nil {
+				t.Fatalf("trigger failed: %s", err)
+			}
+			if status.Attempts != 3 || status.FailedAttempts != 3*i || status.Consecutive != i {
+				t.Errorf("count attempts %t, ping %d: unexpected attempt metadata: %+v", countAttempts, i, status)
+			}
+			update, _ := receive(t, updates)
+			if update.Transition != (i == wantTransition) {
+				t.Errorf("count attempts %t, ping %d: got transition %t", countAttempts, i, update.Transition)
+			}
+		}
+		stopTestTask(task)
+	}
+}
+
+func TestFailureWindow(t *testing.T) {
+	tests := []struct {
+		window config.FailureWindow
+		// statuses to ping (O for OK, F for NOK)
+		pings string
+		// pings that transition (T) and pings that don't (.)
+		want string
+	}{
+		// failures scattered over the window add up to the threshold
+		{config.FailureWindow{Failures: 3, Pings: 5}, "OFOFOFFOOOO", "T....T..T.."},
+		// recovery waits for failures to drop out of the window
+		{config.FailureWindow{Failures: 2, Pings: 3}, "FFOFFOO", ".T....T"},
+		// isolated failures never reach the threshold
+		{config.FailureWindow{Failures: 2, Pings: 3}, "OFOOFOOF", "T......."},
+		// a window of one behaves like alerting on every failure
+		{config.FailureWindow{Failures: 1, Pings: 1}, "OFOF", "TTTT"},
+	}
+	for _, test := range tests {
+		window := test.window
+		pinger := &fakePinger{}
+		task := newTestTask(pinger, config.Schedule{FailureWindow: &window})
+		updates := task.events.Subscribe()
+		startTestTask(t, task)
+
+		var got strings.Builder
+		for _, c := range test.pings {
+			status := ping.StatusOK
+			if c == 'F' {
+				status = ping.StatusNOK
+			}
+			pinger.setStatus(status)
+			if _, err := task.Trigger(); err != nil {
+				t.Fatalf("trigger failed: %s", err)
+			}
+			if update, _ := receive(t, updates); update.Transition {
+				got.WriteByte('T')
+			} else {
+				got.WriteByte('.')
+			}
+		}
+		stopTestTask(task)
+		if got.String() != test.want {
+			t.Errorf("%d of %d, pings %s: got transitions %s, want %s", window.Failures, window.Pings, test.pings, got.String(), test.want)
+		}
+	}
+}
+
+func TestAssumeInitialState(t *testing.T) {
+	tests := []struct {
+		assume string
+		first  ping.Status
+		want   bool
+	}{
+		{"", ping.StatusOK, true},
+		{"", ping.StatusNOK, true},
+		{"unknown", ping.StatusOK, true},
+		{"ok", ping.StatusOK, false},
+		{"ok", ping.StatusNOK, true},
+		{"nok", ping.StatusOK, true},
+		{"nok", ping.StatusNOK, false},
+	}
+	for _, test := range tests {
+		task := newTestTask(&fakePinger{status: test.first}, config.Schedule{})
+		task.alertedStatus = assumedStatus(test.assume)
+		updates := task.events.Subscribe()
+		startTestTask(t, task)
+		if _, err := task.Trigger(); err != nil {
+			t.Fatalf("trigger failed: %s", err)
+		}
+		if update, _ := receive(t, updates); update.Transition != test.want {
+			t.Errorf("assume %q, first ping %s: got transition %t, want %t", test.assume, test.first, update.Transition, test.want)
+		}
+		stopTestTask(task)
+	}
+
+	pingerConf := config.Pinger{
+		Name:               "test",
+		Type:               "http",
+		Check:              json.RawMessage(`{"url": "http://127.0.0.1:1", "expect": {"statusCode": 200}}`),
+		AssumeInitialState: "nok",
+	}
+	engine, err := NewEngine(&config.Engine{Pingers: []config.Pinger{pingerConf}}, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	if task, _ := engine.Pinger("test"); task.alertedStatus != ping.StatusNOK {
+		t.Errorf("expected the configured initial state to be assumed, got %q", task.alertedStatus)
+	}
+}
+
+// attemptPinger is a Pinger that takes a given time to ping and only
+// succeeds on a given ping (counting from 1, or never if 0).
+type attemptPinger struct {
+	delay  time.Duration
+	okPing int
+
+	lock  sync.Mutex
+	pings int
+}
+
+func (pinger *attemptPinger) Ping() (ping.Result, *bytes.Buffer) {
+	pinger.lock.Lock()
+	pinger.pings++
+	ok := pinger.pings == pinger.okPing
+	pinger.lock.Unlock()
+	time.Sleep(pinger.delay)
+	if ok {
+		return ping.Result{Status: ping.StatusOK}, nil
+	}
+	return ping.Result{Status: ping.StatusNOK, Error: fmt.Errorf("attempt failed")}, nil
+}
+
+// pingCount returns the number of pings made by the attemptPinger.
+func (pinger *attemptPinger) pingCount() int {
+	pinger.lock.Lock()
+	defer pinger.lock.Unlock()
+	return pinger.pings
+}
+
+func TestConcurrentAttempts(t *testing.T) {
+	delay := 100 * time.Millisecond
+	tests := []struct {
+		name        string
+		attempts    int
+		concurrency int
+		okPing      int
+		wantStatus  ping.Status
+		// the attempts counted (at most, for a successful ping, as
+		// attempts that finish at the same time count in any order)
+		wantAttempts int
+		// the number of rounds of concurrent attempts
+		wantRounds int
+	}{
+		{"all at once", 4, 4, 0, ping.StatusNOK, 4, 1},
+		{"two at a time", 4, 2, 0, ping.StatusNOK, 4, 2},
+		{"first succeeds", 6, 2, 1, ping.StatusOK, 2, 1},
+		{"last succeeds", 3, 3, 3, ping.StatusOK, 3, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pinger := &attemptPinger{delay: delay, okPing: test.okPing}
+			task := newTestTask(pinger, config.Schedule{Retries: &config.Retries{Attempts: test.attempts, Concurrency: test.concurrency}})
+
+			start := time.Now()
+			result, _, attempts := task.ping()
+			elapsed := time.Since(start)
+			if result.Status != test.wantStatus {
+				t.Errorf("got status %s, want %s", result.Status, test.wantStatus)
+			}
+			if test.wantStatus == ping.StatusOK && attempts > test.wantAttempts || test.wantStatus == ping.StatusNOK && attempts != test.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, test.wantAttempts)
+			}
+			if test.wantStatus == ping.StatusNOK && (result.Error == nil || result.Error.Error() != "attempt failed") {
+				t.Errorf("expected the error of a failed attempt, got %v", result.Error)
+			}
+			wantElapsed := time.Duration(test.wantRounds) * delay
+			if elapsed < wantElapsed || elapsed >= wantElapsed+delay {
+				t.Errorf("expected the ping to take %d round(s) of %s, took %s", test.wantRounds, delay, elapsed)
+			}
+		})
+	}
+}
+
+func TestConcurrentAttemptsStopOnSuccess(t *testing.T) {
+	pinger := &attemptPinger{delay: 50 * time.Millisecond, okPing: 1}
+	task := newTestTask(pinger, config.Schedule{Retries: &config.Retries{Attempts: 10, Concurrency: 2}})
+	if result, _, _ := task.ping(); result.Status != ping.StatusOK {
+		t.Fatalf("expected the ping to succeed: %v", result.Error)
+	}
+	// attempts that started before the ping succeeded finish, but the
+	// remaining ones are abandoned
+	time.Sleep(200 * time.Millisecond)
+	if pings := pinger.pingCount(); pings > 4 {
+		t.Errorf("expected remaining attempts to be abandoned, got %d pings", pings)
+	}
+}
+
+// scriptedPinger is a Pinger that reports the statuses sent to it, one per
+// ping.
+type scriptedPinger chan ping.Status
+
+func (pinger scriptedPinger) Ping() (ping.Result, *bytes.Buffer) {
+	return ping.Result{Status: <-pinger}, nil
+}
+
+func TestReportInterval(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	pinger := make(scriptedPinger)
+	task := newTestTask(pinger, config.Schedule{
+		Interval:       &config.Duration{Duration: time.Millisecond},
+		ReportInterval: &config.Duration{Duration: time.Hour},
+	})
+	task.clock = clock
+	updates := task.events.Subscribe()
+	startTestTask(t, task)
+	defer stopTestTask(task)
+	defer close(pinger)
+
+	tests := []struct {
+		statuses []ping.Status
+		want     ping.Status
+		wantOK   int
+	}{
+		{[]ping.Status{ping.StatusNOK, ping.StatusNOK, ping.StatusOK, ping.StatusOK}, ping.StatusNOK, 2},
+		{[]ping.Status{ping.StatusOK, ping.StatusNOK, ping.StatusOK}, ping.StatusOK, 2},
+	}
+	for _, test := range tests {
+		// pings are aggregated, rather than reported, within the interval
+		last := len(test.statuses) - 1
+		for _, status := range test.statuses[:last] {
+			pinger <- status
+		}
+		select {
+		case update := <-updates:
+			t.Fatalf("unexpected update within report interval: %+v", update.Status)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		clock.advance(time.Hour)
+		pinger <- test.statuses[last]
+		update, _ := receive(t, updates)
+		status := update.Status
+		if status.LatestResult.Status != test.want {
+			t.Errorf("got reported status %s, want %s (%v)", status.LatestResult.Status, test.want, status.LatestResult.Error)
+		}
+		if err := status.LatestResult.Error; test.want == ping.StatusNOK && (err == nil || !strings.HasPrefix(err.Error(), "2 of 4 pings failed")) {
+			t.Errorf("expected the failed pings to be summarized, got error: %v", err)
+		}
+		if aggregate := status.Aggregate; aggregate == nil || aggregate.Pings != len(test.statuses) || aggregate.OK != test.wantOK {
+			t.Errorf("unexpected aggregate: %+v", aggregate)
+		}
+	}
+}
+
+// TestReadsDuringUpdates reads the status and output of a PingerTask while it
+// is being updated (run with -race).
+func TestReadsDuringUpdates(t *testing.T) {
+	task := newTestTask(&fakePinger{status: ping.StatusOK}, config.Schedule{})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				task.Snapshot()
+				task.OutputBytes()
+				task.OutputIsBinary()
+				task.OutputChangeTime()
+				task.LatestRun()
+				task.History()
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		status := ping.StatusOK
+		if i%3 == 0 {
+			status = ping.StatusNOK
+		}
+		task.updateStatus(ping.Result{Status: status}, bytes.NewBufferString(fmt.Sprintf("output %d", i)), 1, time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	if output := string(task.OutputBytes()); output != "output 199" {
+		t.Errorf("unexpected output after updates: %q", output)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	for output, want := range map[string]bool{
+		"":                   false,
+		"plain text\n":       false,
+		"räksmörgås":         false,
+		"nul\x00byte":        true,
+		"\x30\x82\x03\xff":   true,
+		string([]byte{0xc3}): true,
+	} {
+		if got := isBinary([]byte(output)); got != want {
+			t.Errorf("%q: got binary %t, want %t", output, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+
+	"fmt"
+)
+
+// VantageTaskName returns the name of the PingerTask that runs a pinger from
+// a given vantage.
+func VantageTaskName(pingerName, vantageName string) string {
+	return fmt.Sprintf("%s@%s", pingerName, vantageName)
+}
+
+// newVantagePinger creates a Pinger that makes its connections from a given
+// vantage.
+func newVantagePinger(pingerConf *config.Pinger, vantage *config.Vantage) (ping.Pinger, error) {
+	pinger, err := NewPinger(pingerConf)
+	if err != nil {
+		return nil, err
+	}
+	dialerSetter, ok := pinger.(ping.DialerSetter)
+	if !ok {
+		return nil, fmt.Errorf("%s pinger does not support vantages", pingerConf.Type)
+	}
+	dialer, err := ping.NewVantageDialer(vantage)
+	if err != nil {
+		return nil, err
+	}
+	dialerSetter.SetDialer(dialer)
+	return pinger, nil
+}
+
+// resolveTasks returns the PingerTasks that a name refers to: the task with
+// that name or, for a pinger that runs from several vantages, the tasks of
+// all of its vantages. If there are no such tasks, false is returned.
+func (engine *Engine) resolveTasks(name string) ([]*PingerTask, bool) {
+	pingers, vantageGroups, _ := engine.tasks()
+	if task, ok := pingers[name]; ok {
+		return []*PingerTask{task}, true
+	}
+	var tasks []*PingerTask
+	for _, taskName := range vantageGroups[name] {
+		if task, ok := pingers[taskName]; ok {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, len(tasks) > 0
+}
+
+// A VantageAggregate summarizes the statuses of a pinger that runs from
+// several vantages.
+type VantageAggregate struct {
+	// NOK if the pinger fails from any vantage, OK if it succeeds from all
+	// vantages, and Unknown otherwise.
+	Status ping.Status
+	// Number of vantages from which the pinger succeeds.
+	OK int
+	// Number of vantages from which the pinger fails.
+	NOK int
+	// The status of the pinger from each vantage (keyed on task name).
+	Vantages map[string]PingerTaskStatus
+}
+
+// VantageAggregate returns the aggregated status of a pinger that runs from
+// several vantages. If there is no such pinger, false is returned.
+func (engine *Engine) VantageAggregate(pingerName string) (*VantageAggregate, bool) {
+	pingers, vantageGroups, _ := engine.tasks()
+	taskNames, ok := vantageGroups[pingerName]
+	if !ok {
+		return nil, false
+	}
+
+	aggregate := &VantageAggregate{Vantages: make(map[string]PingerTaskStatus)}
+	for _, taskName := range taskNames {
+		task, ok := pingers[taskName]
+		if !ok {
+			// the pinger is being reloaded
+			continue
+		}
+		status := task.Snapshot()
+		aggregate.Vantages[taskName] = status
+		switch status.LatestResult.Status {
+		case ping.StatusOK:
+			aggregate.OK++
+		case ping.StatusNOK:
+			aggregate.NOK++
+		}
+	}
+	switch {
+	case aggregate.NOK > 0:
+		aggregate.Status = ping.StatusNOK
+	case aggregate.OK == len(taskNames):
+		aggregate.Status = ping.StatusOK
+	default:
+		aggregate.Status = ping.StatusUnknown
+	}
+	return aggregate, true
+}
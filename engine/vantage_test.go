@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/ping"
+)
+
+// newVantageEngine creates an Engine with a pinger, named api, of a given
+// url that runs from the vantages eu and us.
+func newVantageEngine(t *testing.T, url string) *Engine {
+	t.Helper()
+	pingerConf := testHTTPPinger("api", url)
+	pingerConf.Vantages = []string{"eu", "us"}
+	engine, err := NewEngine(&config.Engine{
+		Vantages: []config.Vantage{{Name: "eu"}, {Name: "us"}},
+		Pingers:  []config.Pinger{pingerConf},
+	}, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	return engine
+}
+
+func TestAcknowledgeVantageGroup(t *testing.T) {
+	engine := newVantageEngine(t, "http://127.0.0.1:1")
+
+	ack, err := engine.Acknowledge("api", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to acknowledge vantage group: %s", err)
+	}
+	if ack.Pinger != "api" || ack.Until == nil {
+		t.Errorf("unexpected acknowledgement: %+v", ack)
+	}
+	for _, taskName := range []string{"api@eu", "api@us"} {
+		task, _ := engine.Pinger(taskName)
+		if !engine.dispatcher.acks.isAcked(task.ID, time.Now()) {
+			t.Errorf("%s: vantage not acknowledged", taskName)
+		}
+	}
+	if _, err := engine.Acknowledge("nope", 0); err == nil {
+		t.Errorf("expected acknowledging an unknown pinger to fail")
+	}
+}
+
+func TestTriggerVantageGroup(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	engine := newVantageEngine(t, backend.URL)
+	engine.Start()
+	defer stopEngine(t, engine)
+
+	// the tasks may not have started, or still be busy with their first ping
+	statuses, err := engine.Trigger("api")
+	for deadline := time.Now().Add(5 * time.Second); err != nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		statuses, err = engine.Trigger("api")
+	}
+	if err != nil {
+		t.Fatalf("failed to trigger vantage group: %s", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("expected a status per vantage, got: %v", statuses)
+	}
+	for _, taskName := range []string{"api@eu", "api@us"} {
+		if status := statuses[taskName].LatestResult.Status; status != ping.StatusOK {
+			t.Errorf("%s: unexpected status after trigger: %s", taskName, status)
+		}
+	}
+	if _, err := engine.Trigger("nope"); err == nil {
+		t.Errorf("expected triggering an unknown pinger to fail")
+	}
+}
+
+func TestVantageAggregate(t *testing.T) {
+	engine := newVantageEngine(t, "http://127.0.0.1:1")
+	pingers := map[string]*fakePinger{
+		"api@eu": {status: ping.StatusOK},
+		"api@us": {status: ping.StatusOK},
+	}
+	for taskName, pinger := range pingers {
+		task, ok := engine.Pinger(taskName)
+		if !ok {
+			t.Fatalf("%s: no task for vantage", taskName)
+		}
+		task.Pinger = pinger
+	}
+	if _, ok := engine.Pinger("api"); ok {
+		t.Errorf("expected a task per vantage only")
+	}
+	if aggregate, _ := engine.VantageAggregate("api"); aggregate.Status != ping.StatusUnknown || len(aggregate.Vantages) != 2 {
+		t.Errorf("unexpected aggregate before any ping: %+v", aggregate)
+	}
+	engine.Start()
+	defer stopEngine(t, engine)
+
+	trigger := func() {
+		_, err := engine.Trigger("api")
+		for deadline := time.Now().Add(5 * time.Second); err != nil && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			_, err = engine.Trigger("api")
+		}
+		if err != nil {
+			t.Fatalf("failed to trigger vantage group: %s", err)
+		}
+	}
+	trigger()
+	aggregate, _ := engine.VantageAggregate("api")
+	if aggregate.Status != ping.StatusOK || aggregate.OK != 2 || aggregate.NOK != 0 {
+		t.Errorf("unexpected aggregate when OK from all vantages: %+v", aggregate)
+	}
+
+	pingers["api@eu"].setStatus(ping.StatusNOK)
+	trigger()
+	aggregate, _ = engine.VantageAggregate("api")
+	if aggregate.Status != ping.StatusNOK || aggregate.OK != 1 || aggregate.NOK != 1 {
+		t.Errorf("unexpected aggregate when failing from one vantage: %+v", aggregate)
+	}
+	for taskName, want := range map[string]ping.Status{"api@eu": ping.StatusNOK, "api@us": ping.StatusOK} {
+		if status := aggregate.Vantages[taskName].LatestResult.Status; status != want {
+			t.Errorf("%s: got status %s, want %s", taskName, status, want)
+		}
+	}
+	if _, ok := engine.VantageAggregate("nope"); ok {
+		t.Errorf("expected no aggregate for an unknown pinger")
+	}
+}
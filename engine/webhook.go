@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"github.com/petergardfjall/watcher/alerter"
+	"github.com/petergardfjall/watcher/config"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultStatusWebhookTimeout is the longest time to wait for a status webhook
+// to respond when no timeout is given in its configuration.
+const defaultStatusWebhookTimeout = 10 * time.Second
+
+// A StatusWebhook posts every StatusUpdate that it receives to a webhook as
+// JSON, along with the SchemaVersion of the alert payload format. Unlike the
+// Dispatcher, it does not suppress any updates.
+type StatusWebhook struct {
+	Config *config.StatusWebhook
+	Client *http.Client
+	// channel on which StatusUpdates are received
+	updates <-chan StatusUpdate
+}
+
+// NewStatusWebhook creates a new StatusWebhook that posts the StatusUpdates
+// received on a given channel.
+func NewStatusWebhook(webhookConf *config.StatusWebhook, updates <-chan StatusUpdate) *StatusWebhook {
+	timeout := defaultStatusWebhookTimeout
+	if webhookConf.Timeout != nil {
+		timeout = webhookConf.Timeout.Duration
+	}
+	return &StatusWebhook{Config: webhookConf, Client: &http.Client{Timeout: timeout}, updates: updates}
+}
+
+// Start posts updates, in the order they are received, until the update
+// channel is closed. Failed posts are logged and not retried.
+func (webhook *StatusWebhook) Start() {
+	for update := range webhook.updates {
+		if err := webhook.post(update); err != nil {
+			log.Errorf("pinger %s: %s", update.Name, err)
+		}
+	}
+}
+
+func (webhook *StatusWebhook) post(update StatusUpdate) error {
+	payload, err := json.Marshal(struct {
+		SchemaVersion int
+		StatusUpdate
+	}{alerter.SchemaVersion, update})
+	if err != nil {
+		return fmt.Errorf("failed to post to status webhook: %s", err)
+	}
+
+	resp, err := webhook.Client.Post(webhook.Config.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to post to status webhook: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to post to status webhook: webhook responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
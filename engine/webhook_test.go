@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/alerter"
+	"github.com/petergardfjall/watcher/config"
+)
+
+func TestStatusWebhookPostsEveryUpdate(t *testing.T) {
+	posted := make(chan StatusUpdate, 10)
+	failFirst := true
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var update StatusUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			t.Errorf("failed to decode posted update: %s", err)
+		}
+		posted <- update
+		// a failed post does not stop later posts
+		if failFirst {
+			failFirst = false
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer target.Close()
+
+	bus := NewEventBus()
+	updates := bus.Subscribe()
+	stopped := make(chan struct{})
+	go func() {
+		NewStatusWebhook(&config.StatusWebhook{URL: target.URL}, updates).Start()
+		close(stopped)
+	}()
+
+	// updates that are not state transitions are posted as well
+	for i := 0; i < 3; i++ {
+		bus.Publish(StatusUpdate{Name: "test", Transition: i == 0})
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case update := <-posted:
+			if update.Name != "test" || update.Transition != (i == 0) {
+				t.Errorf("unexpected update %d: %+v", i, update)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("update %d not posted", i)
+		}
+	}
+
+	bus.Unsubscribe(updates)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("status webhook did not stop when unsubscribed")
+	}
+}
+
+func TestStatusWebhookPayloadVersion(t *testing.T) {
+	posted := make(chan map[string]interface{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode posted update: %s", err)
+		}
+		posted <- payload
+	}))
+	defer target.Close()
+
+	webhook := NewStatusWebhook(&config.StatusWebhook{URL: target.URL}, nil)
+	if err := webhook.post(StatusUpdate{Name: "test"}); err != nil {
+		t.Fatalf("failed to post update: %s", err)
+	}
+	payload := <-posted
+	if version, ok := payload["SchemaVersion"].(float64); !ok || int(version) != alerter.SchemaVersion {
+		t.Errorf("expected schema version %d in payload: %v", alerter.SchemaVersion, payload)
+	}
+	if payload["Name"] != "test" {
+		t.Errorf("expected the update in payload: %v", payload)
+	}
+}
@@ -0,0 +1,531 @@
+// Package expr implements a small expression language for describing success
+// criteria of ping checks, such as:
+//
+//	status == 200 && latency < 500ms && body matches "ok"
+//
+// An expression is evaluated against a set of named variables. Supported
+// values are integers, durations (such as 500ms or 1m30s), strings
+// (double-quoted), and booleans (true/false). Variables may also be lookup
+// functions or string maps that are indexed as in header["Content-Type"].
+//
+// Supported operators, in order of increasing precedence, are || (or), &&
+// (and), ! (not), and the comparison operators ==, !=, <, <=, >, >=,
+// matches (regular expression match), and contains (substring match).
+// Parentheses can be used for grouping.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Expression is a compiled expression that can be evaluated against a set
+// of variables.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression.
+func Compile(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s", p.peek())
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// Eval evaluates the Expression against a set of variables. Integer
+// variables may be given as int or int64. Indexable variables may be given
+// as a map[string]string or a func(string) string. It is an error for the
+// expression to not produce a boolean value.
+func (e *Expression) Eval(variables map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(variables)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression does not produce a boolean value")
+	}
+	return b, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+//
+// Lexical analysis
+//
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenDuration
+	tokenString
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// parsed value of literals
+	value interface{}
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// operators, longest first so that for instance "<=" is preferred over "<"
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]"}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(source) && source[j] != '"'; j++ {
+				if source[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(source) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			text := source[i : j+1]
+			unquoted, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("illegal string %s: %s", text, err)
+			}
+			tokens = append(tokens, token{tokenString, text, unquoted})
+			i = j + 1
+		case isDigit(c):
+			j := i
+			for j < len(source) && (isDigit(source[j]) || isLetter(source[j]) || source[j] == '.') {
+				j++
+			}
+			text := source[i:j]
+			t, err := numberToken(text)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i = j
+		case isLetter(c):
+			j := i
+			for j < len(source) && (isLetter(source[j]) || isDigit(source[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// numberToken parses an integer (such as 200) or a duration (such as 500ms).
+func numberToken(text string) (token, error) {
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return token{tokenInt, text, n}, nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return token{}, fmt.Errorf("illegal number or duration: '%s'", text)
+	}
+	return token{tokenDuration, text, d}, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+//
+// Parsing
+//
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the given operator or keyword.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenOp || t.kind == tokenIdent) && t.text == text {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected '%s' but got %s", op, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOperators = []string{"==", "!=", "<", "<=", ">", ">=", "matches", "contains"}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range comparisonOperators {
+		if !p.accept(op) {
+			continue
+		}
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		c := &comparison{op: op, left: left, right: right}
+		// precompile constant regular expressions
+		if lit, ok := right.(*literal); ok && op == "matches" {
+			pattern, ok := lit.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("matches: pattern must be a string")
+			}
+			if c.regexp, err = regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("matches: %s", err)
+			}
+		}
+		return c, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenInt, tokenDuration, tokenString:
+		return &literal{value: t.value}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		}
+		if p.accept("[") {
+			key := p.next()
+			if key.kind != tokenString {
+				return nil, fmt.Errorf("%s: index must be a string", t.text)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return &index{name: t.text, key: key.value.(string)}, nil
+		}
+		return &variable{name: t.text}, nil
+	case tokenOp:
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+//
+// Evaluation
+//
+
+type node interface {
+	eval(variables map[string]interface{}) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(variables map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type variable struct {
+	name string
+}
+
+func (n *variable) eval(variables map[string]interface{}) (interface{}, error) {
+	v, ok := variables[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable: %s", n.name)
+	}
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int64, time.Duration, string, bool:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported value type %T", n.name, v)
+	}
+}
+
+type index struct {
+	name string
+	key  string
+}
+
+func (n *index) eval(variables map[string]interface{}) (interface{}, error) {
+	v, ok := variables[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable: %s", n.name)
+	}
+	switch v := v.(type) {
+	case map[string]string:
+		return v[n.key], nil
+	case func(string) string:
+		return v(n.key), nil
+	default:
+		return nil, fmt.Errorf("%s: cannot be indexed", n.name)
+	}
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) eval(variables map[string]interface{}) (interface{}, error) {
+	b, err := evalBool(n.operand, variables)
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (n *logical) eval(variables map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, variables)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit
+	if (n.op == "&&" && !left) || (n.op == "||" && left) {
+		return left, nil
+	}
+	return evalBool(n.right, variables)
+}
+
+type comparison struct {
+	op          string
+	left, right node
+	// precompiled pattern of a matches operation (if constant)
+	regexp *regexp.Regexp
+}
+
+func (n *comparison) eval(variables map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(variables)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "matches", "contains":
+		s, ok1 := left.(string)
+		arg, ok2 := right.(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s: operands must be strings", n.op)
+		}
+		if n.op == "contains" {
+			return strings.Contains(s, arg), nil
+		}
+		pattern := n.regexp
+		if pattern == nil {
+			if pattern, err = regexp.Compile(arg); err != nil {
+				return nil, fmt.Errorf("matches: %s", err)
+			}
+		}
+		return pattern.MatchString(s), nil
+	}
+
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			return nil, mismatch(n.op, left, right)
+		}
+		return compareOrdered(n.op, l, r)
+	case time.Duration:
+		r, ok := right.(time.Duration)
+		if !ok {
+			return nil, mismatch(n.op, left, right)
+		}
+		return compareOrdered(n.op, int64(l), int64(r))
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, mismatch(n.op, left, right)
+		}
+		return compareEquality(n.op, l == r)
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, mismatch(n.op, left, right)
+		}
+		return compareEquality(n.op, l == r)
+	}
+	return nil, mismatch(n.op, left, right)
+}
+
+func compareOrdered(op string, l, r int64) (bool, error) {
+	switch op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	}
+	return compareEquality(op, l == r)
+}
+
+func compareEquality(op string, equal bool) (bool, error) {
+	switch op {
+	case "==":
+		return equal, nil
+	case "!=":
+		return !equal, nil
+	}
+	return false, fmt.Errorf("%s: operator not supported for operands", op)
+}
+
+func mismatch(op string, left, right interface{}) error {
+	return fmt.Errorf("%s: cannot compare %s with %s", op, typeName(left), typeName(right))
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case int64:
+		return "integer"
+	case time.Duration:
+		return "duration"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func evalBool(n node, variables map[string]interface{}) (bool, error) {
+	v, err := n.eval(variables)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean value, got %s", typeName(v))
+	}
+	return b, nil
+}
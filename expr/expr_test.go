@@ -0,0 +1,95 @@
+package expr
+
+import (
+	"testing"
+	"time"
+)
+
+// responseVariables are the variables of an HTTP response to evaluate
+// expressions against.
+var responseVariables = map[string]interface{}{
+	"status":  200,
+	"latency": 300 * time.Millisecond,
+	"body":    "all ok here",
+	"size":    int64(11),
+	"header": func(name string) string {
+		return map[string]string{"Content-Type": "text/plain"}[name]
+	},
+	"labels": map[string]string{"env": "prod"},
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{`status == 200 && latency < 500ms && body matches "ok"`, true},
+		{`status == 200 && latency > 1s`, false},
+		{`status >= 200 && status <= 299`, true},
+		{`status != 200 || body contains "error"`, false},
+		{`!(status != 200) || false`, true},
+		{`true && !true`, false},
+		{`latency <= 300ms && latency >= 1m30s`, false},
+		{`size == 11`, true},
+		{`body matches "^all"`, true},
+		{`body matches "^ok"`, false},
+		{`header["Content-Type"] contains "text"`, true},
+		{`header["Accept"] == ""`, true},
+		{`labels["env"] == "prod"`, true},
+		// && binds tighter than ||
+		{`false && false || true`, true},
+		{`false && (false || true)`, false},
+	}
+	for _, test := range tests {
+		expression, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("%s: failed to compile: %s", test.source, err)
+			continue
+		}
+		got, err := expression.Eval(responseVariables)
+		if err != nil {
+			t.Errorf("%s: failed to evaluate: %s", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %t, want %t", test.source, got, test.want)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, source := range []string{
+		``,
+		`status ==`,
+		`status == 200 &&`,
+		`status = 200`,
+		`(status == 200`,
+		`status == 200)`,
+		`body == "unterminated`,
+		`body matches "("`,
+		`latency < 5parsecs`,
+	} {
+		if _, err := Compile(source); err == nil {
+			t.Errorf("%q: expected compile error", source)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	for _, source := range []string{
+		`latency < 200`,
+		`status`,
+		`missing == 1`,
+		`status matches "2.."`,
+		`!status`,
+	} {
+		expression, err := Compile(source)
+		if err != nil {
+			t.Errorf("%s: failed to compile: %s", source, err)
+			continue
+		}
+		if _, err := expression.Eval(responseVariables); err == nil {
+			t.Errorf("%s: expected evaluation error", source)
+		}
+	}
+}
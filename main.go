@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,8 +13,10 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -38,10 +41,36 @@ var (
 	advertisedIP   = ""
 	advertisedPort = 0
 	ipDetectionURL = "http://ipecho.net/plain"
+	// Attempts to make against the IP detection URL before falling back
+	// to network interfaces. The delay is doubled after each attempt.
+	ipDetectionAttempts   = 3
+	ipDetectionRetryDelay = 1 * time.Second
+	// Format of IP detection URL responses: text (a bare IP address) or
+	// json (an object with the IP address in ipDetectionJSONField).
+	ipDetectionFormat    = "text"
+	ipDetectionJSONField = "ip"
 
 	// Server certificate and key for HTTPS
 	certFile = "/etc/watcher/cert.pem"
 	keyFile  = "/etc/watcher/key.pem"
+
+	// One-shot mode: poll a single pinger until it reports OK
+	awaitPingerName = ""
+	awaitInterval   = 5 * time.Second
+	awaitDeadline   = 5 * time.Minute
+
+	// Best-effort mode: skip pingers that cannot be set up
+	bestEffort = false
+
+	// Seed of the random numbers of the engine (only used if given)
+	randSeed    int64
+	randSeedSet = false
+
+	// How long to wait for ongoing pings and requests on shutdown
+	shutdownTimeout = 30 * time.Second
+
+	// The advertised IP determined when none is given in the config
+	detectedAdvertisedIP = ""
 )
 
 func initLogging() {
@@ -94,25 +123,61 @@ func determineIPFromNetworkInterface() (string, error) {
 
 // determineExternalIP tries to determine the externally reachable IP address to
 // advertise for this machine by first contacting an IP detection URL service
-// or, in case that check is unsuccessful, by looking at the local network
-// interfaces.
+// (with a number of retries) or, in case that check is unsuccessful, by
+// looking at the local network interfaces.
 func determineExternalIP() (string, error) {
 	client := &http.Client{Timeout: 5 * time.Second}
-	log.Infof("attempt to determine external IP address via %s ...", ipDetectionURL)
+	delay := ipDetectionRetryDelay
+	for attempt := 1; attempt <= ipDetectionAttempts; attempt++ {
+		log.Infof("attempt %d to determine external IP address via %s ...", attempt, ipDetectionURL)
+		ip, err := fetchExternalIP(client)
+		if err == nil {
+			return ip, nil
+		}
+		log.Warningf("%s", err)
+		if attempt < ipDetectionAttempts {
+			time.Sleep(delay)
+			delay = delay * 2
+		}
+	}
+	return determineIPFromNetworkInterface()
+}
+
+// fetchExternalIP asks the IP detection URL service for the external IP
+// address of this machine.
+func fetchExternalIP(client *http.Client) (string, error) {
 	resp, err := client.Get(ipDetectionURL)
 	if err != nil {
-		log.Warningf("failed to contact %s: %s", ipDetectionURL, err)
-		return determineIPFromNetworkInterface()
+		return "", fmt.Errorf("failed to contact %s: %s", ipDetectionURL, err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response from %s: %s", ipDetectionURL, resp.Status)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Warningf("failed to read response from %s: %s", ipDetectionURL, err)
-		return determineIPFromNetworkInterface()
+		return "", fmt.Errorf("failed to read response from %s: %s", ipDetectionURL, err)
+	}
+	if ipDetectionFormat == "json" {
+		return parseIPFromJSON(body)
 	}
 	return strings.TrimSpace(string(body)), nil
 }
 
+// parseIPFromJSON extracts the IP address from a JSON-formatted IP detection
+// response, where it is expected to be found in the --ip-detection-json-field.
+func parseIPFromJSON(body []byte) (string, error) {
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to parse JSON response from %s: %s", ipDetectionURL, err)
+	}
+	ip, ok := response[ipDetectionJSONField].(string)
+	if !ok {
+		return "", fmt.Errorf("no '%s' string field in JSON response from %s", ipDetectionJSONField, ipDetectionURL)
+	}
+	return strings.TrimSpace(ip), nil
+}
+
 // determineAdvertisedIP tries to determine the externally reachable IP address
 // of this machine first by checking if it was given on the command-line or,
 // second, by checking a IP detection URL or, third, by checking the local
@@ -147,7 +212,17 @@ func init() {
 
 	flag.StringVar(&advertisedIP, "advertised-ip", "", "The IP address/hostname advertised in alerts (unless given in config). This should be an externally facing IP address/hostname. If no IP/hostname is explicitly given, a best-effort attempt is made to determine the external IP by first checking with an external IP detection service and, if that fails, by falling back to a non-loopback interface on the local machine.")
 	flag.IntVar(&advertisedPort, "advertised-port", 0, "The server port advertised in alerts (unless given in config). This should be an externally facing port that the server can be reached on. If no advertisedPort is specified in the config, and this option is left unspecified, the --port value is used as the advertised port.")
-	flag.StringVar(&ipDetectionURL, "ip-detection-url", ipDetectionURL, "URL to a an external IP detection service that will be used to determine the external IP of this host in case no advertised IP is specified (via config or --advertised-ip). The URL must only respond with an IP address string, no attempt will be used to parse html output.")
+	flag.StringVar(&ipDetectionURL, "ip-detection-url", ipDetectionURL, "URL to a an external IP detection service that will be used to determine the external IP of this host in case no advertised IP is specified (via config or --advertised-ip). Unless --ip-detection-format is json, the URL must only respond with an IP address string, no attempt will be used to parse html output.")
+	flag.IntVar(&ipDetectionAttempts, "ip-detection-attempts", ipDetectionAttempts, "The number of attempts to make against the --ip-detection-url before falling back to checking the local network interfaces.")
+	flag.StringVar(&ipDetectionFormat, "ip-detection-format", ipDetectionFormat, "The response format of the --ip-detection-url. One of: text (a bare IP address string) and json (a JSON object with the IP address in the --ip-detection-json-field).")
+	flag.StringVar(&ipDetectionJSONField, "ip-detection-json-field", ipDetectionJSONField, "The field holding the IP address in responses from the --ip-detection-url when --ip-detection-format is json.")
+
+	flag.StringVar(&awaitPingerName, "await", "", "Name of a configured pinger to poll until it reports OK, instead of starting the server. The program exits with status 0 if the pinger reported OK before the --await-deadline passed, otherwise with status 1.")
+	flag.DurationVar(&awaitInterval, "await-interval", awaitInterval, "Delay between pings in --await mode.")
+	flag.DurationVar(&awaitDeadline, "await-deadline", awaitDeadline, "Maximum time to wait for the pinger to report OK in --await mode.")
+	flag.BoolVar(&bestEffort, "best-effort", bestEffort, "Skip pingers that cannot be set up (for example, due to an invalid check configuration) instead of exiting. Skipped pingers are logged and reported as failed by the REST API.")
+	flag.Int64Var(&randSeed, "rand-seed", 0, "Seed of the random numbers (such as schedule jitter) used by the engine, which makes them the same on every run. Overrides any randSeed in the config. If neither is given, the random numbers are seeded from the current time.")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "Maximum time to wait for ongoing pings and REST API requests to finish when shutting down on SIGINT/SIGTERM.")
 }
 
 // parseCommandLine parses the command-line and returns the configuration
@@ -158,61 +233,213 @@ func parseCommandLine() string {
 		failWithError("no config file given")
 	}
 	setLogLevel(logLevel)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "rand-seed" {
+			randSeedSet = true
+		}
+	})
 
-	if _, err := os.Stat(certFile); err != nil {
-		failWithError("TLS certificate file: %s", err)
+	if ipDetectionFormat != "text" && ipDetectionFormat != "json" {
+		failWithError("illegal ip detection format: '%s'", ipDetectionFormat)
 	}
-	if _, err := os.Stat(keyFile); err != nil {
-		failWithError("TLS key file: %s", err)
+
+	if awaitPingerName == "" {
+		if _, err := os.Stat(certFile); err != nil {
+			failWithError("TLS certificate file: %s", err)
+		}
+		if _, err := os.Stat(keyFile); err != nil {
+			failWithError("TLS key file: %s", err)
+		}
 	}
 
 	configFile := flag.Arg(0)
 	return configFile
 }
 
-func main() {
-	configFile := parseCommandLine()
+// awaitPinger polls a given pinger from the configuration until it reports
+// OK. An error is returned if the pinger did not report OK before the
+// --await-deadline passed.
+func awaitPinger(engineConf *config.Engine, name string) error {
+	var pingerConf *config.Pinger
+	for i := range engineConf.Pingers {
+		if engineConf.Pingers[i].Name == name {
+			pingerConf = &engineConf.Pingers[i]
+		}
+	}
+	if pingerConf == nil {
+		return fmt.Errorf("no pinger named '%s' in config", name)
+	}
+	if err := pingerConf.Validate(); err != nil {
+		return fmt.Errorf("illegal configuration: %s", err)
+	}
+	pinger, err := engine.NewPinger(pingerConf)
+	if err != nil {
+		return fmt.Errorf("failed to instantiate pinger: %s", err)
+	}
+
+	log.Infof("[%s] awaiting OK (deadline: %s) ...", name, awaitDeadline)
+	if _, err := engine.AwaitOK(pinger, awaitInterval, awaitDeadline); err != nil {
+		return fmt.Errorf("[%s] %s", name, err)
+	}
+	log.Infof("[%s] OK", name)
+	return nil
+}
+
+// reload reloads the configuration of a running engine from a file. On
+// failure, the engine keeps running with its current configuration.
+func reload(engine *engine.Engine, configFile string) {
+	log.Infof("reloading %s ...", configFile)
+	config, err := readConfig(configFile)
+	if err != nil {
+		log.Errorf("reload failed: %s", err)
+		return
+	}
+	applyDefaults(config)
+	if err := engine.Reload(config); err != nil {
+		log.Errorf("reload failed: keeping current configuration: %s", err)
+		return
+	}
+	log.Infof("reloaded %s", configFile)
+}
+
+// readConfig reads an engine configuration from a file.
+func readConfig(configFile string) (*config.Engine, error) {
 	configJSON, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		failWithError("failed to read config file: %s\n", err)
+		return nil, fmt.Errorf("failed to read config file: %s", err)
 	}
 
-	var config config.Engine
-	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
-		failWithError("failed to parse %s: %s", configFile, err)
+	var engineConf config.Engine
+	if err := json.Unmarshal([]byte(configJSON), &engineConf); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", configFile, err)
 	}
+	if err := engineConf.ResolveSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets of %s: %s", configFile, err)
+	}
+	return &engineConf, nil
+}
 
-	// apply default values for values not given in config
-	if config.Alerter != nil && config.Alerter.AdvertisedIP == "" {
+// applyDefaults applies default values for values not given in a
+// configuration. The advertised IP is only determined once, and is reused on
+// reload. Nothing is detected for a configuration with an advertisedURL.
+func applyDefaults(engineConf *config.Engine) {
+	if engineConf.Alerter != nil && engineConf.Alerter.AdvertisedURL != "" {
+		return
+	}
+	if engineConf.Alerter != nil && engineConf.Alerter.AdvertisedIP == "" {
 		log.Infof("no advertisedIP in config: determining advertised IP ...")
-		config.Alerter.AdvertisedIP = determineAdvertisedIP()
-		log.Infof("using %s as advertised IP", config.Alerter.AdvertisedIP)
+		if detectedAdvertisedIP == "" {
+			detectedAdvertisedIP = determineAdvertisedIP()
+		}
+		engineConf.Alerter.AdvertisedIP = detectedAdvertisedIP
+		log.Infof("using %s as advertised IP", engineConf.Alerter.AdvertisedIP)
 	}
-	if config.Alerter != nil && config.Alerter.AdvertisedPort == 0 {
+	if engineConf.Alerter != nil && engineConf.Alerter.AdvertisedPort == 0 {
 		if advertisedPort != 0 {
-			config.Alerter.AdvertisedPort = advertisedPort
+			engineConf.Alerter.AdvertisedPort = advertisedPort
 			log.Infof("no advertisedPort in config: using --advertised-port: %d", advertisedPort)
 		} else {
-			config.Alerter.AdvertisedPort = port
+			engineConf.Alerter.AdvertisedPort = port
 			log.Infof("no advertisedPort in config: using --port: %d", port)
 		}
 	}
 
+	if engineConf.Alerter != nil && engineConf.Alerter.AdvertisedScheme == "" {
+		// unless overridden (e.g. by a TLS-terminating reverse proxy),
+		// the advertised scheme follows that of the server.
+		engineConf.Alerter.AdvertisedScheme = server.Scheme
+	}
+}
+
+// advertisedBaseURL returns the base URL to advertise in alerts for an
+// alerter configuration (to which defaults have been applied). A warning is
+// logged if the advertised port is not the port that the server listens on
+// and was not explicitly overridden.
+func advertisedBaseURL(alerterConf *config.Alerter) string {
+	if alerterConf.AdvertisedURL == "" && alerterConf.AdvertisedPort != port && alerterConf.AdvertisedPort != advertisedPort {
+		log.Warningf("advertisedPort (%d) differs from --port (%d): make sure that it is forwarded to the server (or explicitly override with --advertised-port)", alerterConf.AdvertisedPort, port)
+	}
+	return alerterConf.AdvertisedBaseURL()
+}
+
+func main() {
+	configFile := parseCommandLine()
+	config, err := readConfig(configFile)
+	if err != nil {
+		failWithError("%s", err)
+	}
+
+	if awaitPingerName != "" {
+		if err := awaitPinger(config, awaitPingerName); err != nil {
+			failWithError("%s", err)
+		}
+		os.Exit(0)
+	}
+
+	applyDefaults(config)
+	if randSeedSet {
+		config.RandSeed = &randSeed
+	}
+
+	var skippedPingers map[string]error
+	if bestEffort {
+		skippedPingers = config.SkipInvalidPingers()
+		for name, err := range skippedPingers {
+			log.Errorf("[%s] skipping pinger: illegal configuration: %s", name, err)
+		}
+	}
 	if err := config.Validate(); err != nil {
 		failWithError("illegal configuration: %s", err)
 	}
 
+	// base URL to advertise in alerts.
+	var baseURL string
+	if config.Alerter != nil {
+		baseURL = advertisedBaseURL(config.Alerter)
+		log.Infof("advertising %s in alerts", baseURL)
+	}
+
 	log.Infof("setting up engine ...")
-	advertisedBaseURL := fmt.Sprintf("https://%s:%d", advertisedIP, port)
-	engine, err := engine.NewEngine(&config, advertisedBaseURL)
+	engine, err := engine.NewEngine(config, baseURL, bestEffort)
 	if err != nil {
 		log.Fatalf("engine setup failed: %s", err)
 	}
-	log.Infof("engine set up with %d pingers", len(engine.Pingers))
+	engine.AddFailedPingers(skippedPingers)
+	if failed := engine.FailedPingers(); len(failed) > 0 {
+		log.Warningf("skipped %d pingers that could not be set up", len(failed))
+	}
+	log.Infof("engine set up with %d pingers", len(engine.Pingers()))
 
 	server, err := server.NewServer(engine, port, certFile, keyFile)
 	if err != nil {
 		failWithError("failed to create server: %s", err)
 	}
-	failWithError("server failed: %s", server.Start())
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.Start() }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for shutdown := false; !shutdown; {
+		select {
+		case err := <-serverErr:
+			failWithError("server failed: %s", err)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload(engine, configFile)
+				continue
+			}
+			log.Infof("received %s: shutting down ...", sig)
+			shutdown = true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := engine.Stop(ctx); err != nil {
+		log.Errorf("failed to stop engine: %s", err)
+	}
+	if err := server.Stop(ctx); err != nil {
+		log.Errorf("failed to stop server: %s", err)
+	}
+	log.Infof("shut down")
 }
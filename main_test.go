@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/server"
+)
+
+// captureLog collects what is logged for the rest of a test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var logs bytes.Buffer
+	logging.SetBackend(logging.NewLogBackend(&logs, "", 0))
+	t.Cleanup(func() { logging.SetBackend(logging.NewLogBackend(os.Stderr, "", stdlog.LstdFlags)) })
+	return &logs
+}
+
+// useIPDetectionURL makes IP detection contact a given URL (without delay
+// between attempts) for the rest of a test.
+func useIPDetectionURL(t *testing.T, url string) {
+	savedURL, savedDelay, savedAttempts := ipDetectionURL, ipDetectionRetryDelay, ipDetectionAttempts
+	ipDetectionURL, ipDetectionRetryDelay, ipDetectionAttempts = url, time.Millisecond, 3
+	t.Cleanup(func() {
+		ipDetectionURL, ipDetectionRetryDelay, ipDetectionAttempts = savedURL, savedDelay, savedAttempts
+	})
+}
+
+func TestDetectExternalIPRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "203.0.113.7")
+	}))
+	defer server.Close()
+	useIPDetectionURL(t, server.URL)
+
+	ip, err := determineExternalIP()
+	if err != nil {
+		t.Fatalf("failed to detect external IP: %s", err)
+	}
+	if ip != "203.0.113.7" {
+		t.Errorf("got IP %q, want the one detected on retry", ip)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 2 {
+		t.Errorf("expected a retry after the failure, got %d requests", requests)
+	}
+}
+
+func TestApplyDefaultsKeepsAdvertisedURL(t *testing.T) {
+	detectedAdvertisedIP = "10.0.0.7"
+	defer func() { detectedAdvertisedIP = "" }()
+
+	engineConf := &config.Engine{Alerter: &config.Alerter{AdvertisedURL: "https://vip.example.com/watcher"}}
+	applyDefaults(engineConf)
+	if alerter := engineConf.Alerter; alerter.AdvertisedIP != "" || alerter.AdvertisedPort != 0 || alerter.AdvertisedScheme != "" {
+		t.Errorf("expected nothing to be detected with an advertisedURL, got %+v", alerter)
+	}
+	if baseURL := engineConf.Alerter.AdvertisedBaseURL(); baseURL != "https://vip.example.com/watcher" {
+		t.Errorf("unexpected advertised base URL: %s", baseURL)
+	}
+
+	engineConf = &config.Engine{Alerter: &config.Alerter{}}
+	applyDefaults(engineConf)
+	if engineConf.Alerter.AdvertisedIP != "10.0.0.7" {
+		t.Errorf("expected the detected IP to be advertised, got %q", engineConf.Alerter.AdvertisedIP)
+	}
+}
+
+func TestDetectExternalIPFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		response string
+		wantIP   string
+		wantErr  bool
+	}{
+		{"text", "203.0.113.7\n", "203.0.113.7", false},
+		{"json", `{"ip": "203.0.113.8", "country": "SE"}`, "203.0.113.8", false},
+		{"json", `{"address": "203.0.113.9"}`, "", true},
+		{"json", "203.0.113.7", "", true},
+	}
+	defer func(format string) { ipDetectionFormat = format }(ipDetectionFormat)
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, test.response)
+		}))
+		useIPDetectionURL(t, server.URL)
+		ipDetectionFormat = test.format
+
+		ip, err := fetchExternalIP(&http.Client{Timeout: 5 * time.Second})
+		server.Close()
+		if (err != nil) != test.wantErr || ip != test.wantIP {
+			t.Errorf("%s response %q: got IP %q (error: %v), want %q", test.format, test.response, ip, err, test.wantIP)
+		}
+	}
+}
+
+func TestAdvertisedBaseURL(t *testing.T) {
+	detectedAdvertisedIP = "10.0.0.7"
+	defer func() { detectedAdvertisedIP = "" }()
+	defer func(listenPort, overridePort int) { port, advertisedPort = listenPort, overridePort }(port, advertisedPort)
+	port = 8443
+
+	tests := []struct {
+		name           string
+		alerter        config.Alerter
+		advertisedPort int
+		wantURL        string
+		wantWarning    bool
+	}{
+		{"defaults", config.Alerter{}, 0, server.Scheme + "://10.0.0.7:8443", false},
+		{"forwarded port", config.Alerter{AdvertisedPort: 443}, 0, server.Scheme + "://10.0.0.7:443", true},
+		{"overridden port", config.Alerter{}, 443, server.Scheme + "://10.0.0.7:443", false},
+		{"advertised url", config.Alerter{AdvertisedURL: "https://vip.example.com"}, 0, "https://vip.example.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLog(t)
+			advertisedPort = test.advertisedPort
+			engineConf := &config.Engine{Alerter: &test.alerter}
+			applyDefaults(engineConf)
+			if baseURL := advertisedBaseURL(engineConf.Alerter); baseURL != test.wantURL {
+				t.Errorf("got base URL %s, want %s", baseURL, test.wantURL)
+			}
+			if warned := strings.Contains(logs.String(), "differs from --port"); warned != test.wantWarning {
+				t.Errorf("expected a port warning: %t, got log: %q", test.wantWarning, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package ping
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Problems with the certificate chain presented by a server that a
+// CertificateChainError can report.
+const (
+	// The server presented a self-signed certificate.
+	CertSelfSigned = "self-signed certificate"
+	// The server did not present the intermediate certificates needed to
+	// reach a trusted root.
+	CertIncompleteChain = "incomplete certificate chain"
+	// The certificate chain leads up to a root that is not trusted.
+	CertUntrustedRoot = "untrusted root certificate"
+)
+
+// A CertificateChainError is the error of a ping where the server presented a
+// certificate chain that cannot be verified since it does not lead up to a
+// trusted root. The Problem tells why (for example, CertSelfSigned).
+type CertificateChainError struct {
+	URL     string
+	Problem string
+	// Subject of the server certificate.
+	Subject string
+}
+
+func (err *CertificateChainError) Error() string {
+	return fmt.Sprintf("%s: %s (subject: %s)", err.URL, err.Problem, err.Subject)
+}
+
+// chainError classifies the error of a failed TLS handshake: if the server
+// presented a certificate chain that does not lead up to a trusted root, a
+// CertificateChainError telling why is returned. Otherwise (including for
+// any other verification failure, such as a hostname mismatch) nil is
+// returned.
+func chainError(err error) *CertificateChainError {
+	var verificationErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	if !errors.As(err, &verificationErr) || !errors.As(verificationErr.Err, &authorityErr) {
+		return nil
+	}
+	chain := verificationErr.UnverifiedCertificates
+	if len(chain) == 0 {
+		return nil
+	}
+	return &CertificateChainError{Problem: chainProblem(chain), Subject: chain[0].Subject.String()}
+}
+
+// loadCertPool creates a pool of the (PEM-encoded) certificates in a file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// chainProblem tells why a presented certificate chain (leaf first), which
+// does not lead up to a trusted root, cannot be verified.
+func chainProblem(chain []*x509.Certificate) string {
+	if isSelfSigned(chain[0]) {
+		return CertSelfSigned
+	}
+	if len(chain) > 1 && isSelfSigned(chain[len(chain)-1]) {
+		return CertUntrustedRoot
+	}
+	// the issuer of the topmost certificate is neither presented nor
+	// trusted
+	return CertIncompleteChain
+}
+
+// isSelfSigned returns true if a certificate is signed by its own key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
@@ -0,0 +1,150 @@
+package ping
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its private key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate for a given common name, IP addresses and
+// DNS names that is signed by a given issuer (or, if nil, self-signed).
+func newTestCert(t *testing.T, commonName string, isCA bool, issuer *testCert, ips []net.IP, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           ips,
+		DNSNames:              dnsNames,
+	}
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// startTLSServer starts a server that presents a given certificate chain
+// (leaf first) and returns its URL.
+func startTLSServer(t *testing.T, chain ...*testCert) string {
+	t.Helper()
+	return startStaplingTLSServer(t, nil, chain...)
+}
+
+// writeCACert writes a certificate to a PEM file and returns its path.
+func writeCACert(t *testing.T, cert *testCert) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCertificateChainProblems(t *testing.T) {
+	localhost := []net.IP{net.ParseIP("127.0.0.1")}
+	root := newTestCert(t, "root", true, nil, nil)
+	intermediate := newTestCert(t, "intermediate", true, root, nil)
+	leaf := newTestCert(t, "leaf", false, intermediate, localhost)
+
+	tests := []struct {
+		name        string
+		url         string
+		wantProblem string
+	}{
+		{"self-signed", startTLSServer(t, newTestCert(t, "self", false, nil, localhost)), CertSelfSigned},
+		{"incomplete chain", startTLSServer(t, leaf), CertIncompleteChain},
+		{"untrusted root", startTLSServer(t, leaf, intermediate, root), CertUntrustedRoot},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _ := newTestHTTPPinger(t, map[string]interface{}{
+				"url":        test.url,
+				"verifyCert": true,
+				"expect":     map[string]int{"statusCode": 200},
+			}).Ping()
+			if result.Status != StatusNOK || result.Category != CategoryTLS {
+				t.Fatalf("expected TLS failure, got %s (%s): %v", result.Status, result.Category, result.Error)
+			}
+			var chainErr *CertificateChainError
+			if !errors.As(result.Error, &chainErr) || chainErr.Problem != test.wantProblem {
+				t.Errorf("got error %v, want problem %q", result.Error, test.wantProblem)
+			}
+
+			// not verified without verifyCert
+			result, _ = newTestHTTPPinger(t, map[string]interface{}{
+				"url":    test.url,
+				"expect": map[string]int{"statusCode": 200},
+			}).Ping()
+			if result.Status != StatusOK {
+				t.Errorf("expected unverified ping to pass: %v", result.Error)
+			}
+		})
+	}
+}
+
+func TestCertificateVerification(t *testing.T) {
+	ca := newTestCert(t, "ca", true, nil, nil)
+	caCertFile := writeCACert(t, ca)
+
+	tests := []struct {
+		name   string
+		leaf   *testCert
+		wantOK bool
+	}{
+		{"trusted for IP", newTestCert(t, "leaf", false, ca, []net.IP{net.ParseIP("127.0.0.1")}), true},
+		// the host name is verified against the dialed IP, for which
+		// there is no SNI
+		{"trusted for other host", newTestCert(t, "leaf", false, ca, nil, "other.example"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _ := newTestHTTPPinger(t, map[string]interface{}{
+				"url":        startTLSServer(t, test.leaf),
+				"verifyCert": true,
+				"caCertFile": caCertFile,
+				"expect":     map[string]int{"statusCode": 200},
+			}).Ping()
+			if (result.Status == StatusOK) != test.wantOK {
+				t.Fatalf("got status %s, want OK: %t (%v)", result.Status, test.wantOK, result.Error)
+			}
+			var chainErr *CertificateChainError
+			if !test.wantOK && (result.Category != CategoryTLS || errors.As(result.Error, &chainErr)) {
+				t.Errorf("expected a (non-chain) TLS failure, got %s: %v", result.Category, result.Error)
+			}
+		})
+	}
+}
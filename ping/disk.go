@@ -0,0 +1,170 @@
+package ping
+
+import (
+	"github.com/petergardfjall/watcher/config"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A DiskPinger pings a file system by verifying over SSH that it has enough
+// free space.
+type DiskPinger struct {
+	Client CommandRunner
+	// A path on the file system to check.
+	Path string
+	// The least free space allowed, as a percentage of the file system
+	// size (0 means no limit).
+	MinFreePercent float64
+	// The least free space allowed, in bytes (0 means no limit).
+	MinFreeBytes int64
+}
+
+// diskUsage is the usage of a file system, as reported by df.
+type diskUsage struct {
+	usedBytes      int64
+	availableBytes int64
+}
+
+// freePercent returns the free space as a percentage of the space usable by
+// non-privileged users (which is how df computes its capacity).
+func (usage diskUsage) freePercent() float64 {
+	total := usage.usedBytes + usage.availableBytes
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(usage.availableBytes) / float64(total)
+}
+
+// NewDiskPinger creates a new ping.DiskPinger from a pinger configuration.
+func NewDiskPinger(pingerConfig *config.Pinger) (Pinger, error) {
+	log.Debugf("setting up disk pinger ...")
+	var diskCheck config.DiskCheck
+	err := json.Unmarshal(pingerConfig.Check, &diskCheck)
+	if err != nil {
+		return nil, fmt.Errorf("disk pinger: illegal check: %s", err)
+	}
+	if err := diskCheck.Validate(); err != nil {
+		return nil, fmt.Errorf("disk pinger: invalid check: %s", err)
+	}
+
+	sshClient, err := NewSSHClient(NewSSHClientConfig(&diskCheck.SSHTarget))
+	if err != nil {
+		return nil, fmt.Errorf("disk pinger: failed to set up ssh client: %s", err)
+	}
+
+	pinger := &DiskPinger{
+		Client:         sshClient,
+		Path:           diskCheck.Path,
+		MinFreePercent: diskCheck.MinFreePercent,
+		MinFreeBytes:   diskCheck.MinFreeBytes,
+	}
+	return pinger, nil
+}
+
+// Ping pings the configured file system for this ping.DiskPinger.
+func (diskPinger *DiskPinger) Ping() (result Result, output *bytes.Buffer) {
+	// -P gives POSIX output (one line per file system), -k 1024-byte blocks
+	command := fmt.Sprintf("df -P -k %s", shellQuote(diskPinger.Path))
+	response, err := diskPinger.Client.Run(command)
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: connectionErrorCategory(err)}, nil
+	}
+	output = response.Output
+	if response.ExitStatus != 0 {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("df failed with exit code %d", response.ExitStatus), Category: CategoryStatus}, output
+	}
+
+	usage, err := parseDiskUsage(response.Output.String())
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("failed to parse df output: %s", err), Category: CategoryContent}, output
+	}
+	if err := diskPinger.checkUsage(usage); err != nil {
+		return Result{Status: StatusNOK, Error: err, Category: CategoryContent}, output
+	}
+	return Result{Status: StatusOK}, output
+}
+
+// SetDialer implements the DialerSetter interface.
+func (diskPinger *DiskPinger) SetDialer(dialer Dialer) {
+	if sshClient, ok := diskPinger.Client.(*SSHClient); ok {
+		sshClient.Dialer = dialer
+	}
+}
+
+// Close implements the Closer interface. It closes any connection kept open to
+// the server.
+func (diskPinger *DiskPinger) Close() error {
+	if closer, ok := diskPinger.Client.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// checkUsage returns an error if the free space of a file system is below
+// any of the configured thresholds.
+func (diskPinger *DiskPinger) checkUsage(usage diskUsage) error {
+	if diskPinger.MinFreePercent > 0 && usage.freePercent() < diskPinger.MinFreePercent {
+		return fmt.Errorf("%s: free space (%.1f%%) below threshold (%.1f%%)", diskPinger.Path, usage.freePercent(), diskPinger.MinFreePercent)
+	}
+	if diskPinger.MinFreeBytes > 0 && usage.availableBytes < diskPinger.MinFreeBytes {
+		return fmt.Errorf("%s: free space (%d bytes) below threshold (%d bytes)", diskPinger.Path, usage.availableBytes, diskPinger.MinFreeBytes)
+	}
+	return nil
+}
+
+// parseDiskUsage parses the output of "df -P -k <path>", which holds a header
+// line followed by a line of the form:
+//
+//	<filesystem> <1024-blocks> <used> <available> <capacity>% <mount point>
+//
+// Since file system names and mount points may contain spaces, the usage
+// columns are located as the first run of three numbers followed by a
+// percentage.
+func parseDiskUsage(output string) (diskUsage, error) {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 2 {
+		return diskUsage{}, fmt.Errorf("expected a header and a usage line, got %d lines", len(lines))
+	}
+
+	fields := strings.Fields(lines[len(lines)-1])
+	for i := 0; i+3 < len(fields); i++ {
+		if !strings.HasSuffix(fields[i+3], "%") {
+			continue
+		}
+		numbers, ok := parseInts(fields[i : i+3])
+		if !ok {
+			continue
+		}
+		return diskUsage{usedBytes: numbers[1] * 1024, availableBytes: numbers[2] * 1024}, nil
+	}
+	return diskUsage{}, fmt.Errorf("no usage columns found in '%s'", lines[len(lines)-1])
+}
+
+// parseInts parses a set of non-negative integers. False is returned if any
+// of them is not one.
+func parseInts(values []string) ([]int64, bool) {
+	var numbers []int64
+	for _, value := range values {
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || number < 0 {
+			return nil, false
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, true
+}
+
+// shellQuote quotes a string for use as a single (POSIX) shell word.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,110 @@
+package ping
+
+import "testing"
+
+// dfOutput is the output of "df -P -k" for a file system (whose name and
+// mount point contain spaces) with 90000 of 100000 blocks used.
+const dfOutput = `Filesystem     1024-blocks      Used Available Capacity Mounted on
+/dev/my disk        100000     90000     10000      90% /mnt/my data
+`
+
+func TestParseDiskUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    diskUsage
+		wantErr bool
+	}{
+		{"spaces in names", dfOutput, diskUsage{usedBytes: 90000 * 1024, availableBytes: 10000 * 1024}, false},
+		{"numeric file system name", "Filesystem 1024-blocks Used Available Capacity Mounted on\n42 200 50 150 25% /\n", diskUsage{usedBytes: 50 * 1024, availableBytes: 150 * 1024}, false},
+		{"no usage line", "Filesystem 1024-blocks Used Available Capacity Mounted on\n", diskUsage{}, true},
+		{"no usage columns", "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 n/a n/a n/a - /\n", diskUsage{}, true},
+		{"error message", "df: /data: No such file or directory\n", diskUsage{}, true},
+	}
+	for _, test := range tests {
+		usage, err := parseDiskUsage(test.output)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.wantErr, err)
+			continue
+		}
+		if usage != test.want {
+			t.Errorf("%s: got usage %+v, want %+v", test.name, usage, test.want)
+		}
+	}
+}
+
+func TestDiskPinger(t *testing.T) {
+	tests := []struct {
+		name           string
+		minFreePercent float64
+		minFreeBytes   int64
+		wantErr        string
+	}{
+		{"above percentage", 5, 0, ""},
+		{"below percentage", 20, 0, "/data: free space (10.0%) below threshold (20.0%)"},
+		{"above bytes", 0, 10000 * 1024, ""},
+		{"below bytes", 0, 10000*1024 + 1, "/data: free space (10240000 bytes) below threshold (10240001 bytes)"},
+		{"no thresholds", 0, 0, ""},
+	}
+	for _, test := range tests {
+		runner := &fakeRunner{output: dfOutput}
+		pinger := &DiskPinger{Client: runner, Path: "/data", MinFreePercent: test.minFreePercent, MinFreeBytes: test.minFreeBytes}
+		result, _ := pinger.Ping()
+		if len(runner.commands) != 1 || runner.commands[0] != "df -P -k '/data'" {
+			t.Errorf("%s: unexpected commands: %q", test.name, runner.commands)
+		}
+		if test.wantErr == "" {
+			if result.Status != StatusOK {
+				t.Errorf("%s: expected enough free space to pass: %v", test.name, result.Error)
+			}
+			continue
+		}
+		if result.Status != StatusNOK || result.Category != CategoryContent {
+			t.Errorf("%s: expected too little free space to fail the ping: %+v", test.name, result)
+			continue
+		}
+		if result.Error.Error() != test.wantErr {
+			t.Errorf("%s: got error %q, want %q", test.name, result.Error, test.wantErr)
+		}
+	}
+}
+
+func TestDiskPingerFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		runner       *fakeRunner
+		wantCategory ErrorCategory
+		wantErr      string
+	}{
+		{"df fails", &fakeRunner{exitStatus: 1, output: "df: /data: No such file or directory\n"}, CategoryStatus, "df failed with exit code 1"},
+		{"unparseable output", &fakeRunner{output: "garbage"}, CategoryContent, "failed to parse df output: expected a header and a usage line, got 1 lines"},
+	}
+	for _, test := range tests {
+		pinger := &DiskPinger{Client: test.runner, Path: "/data", MinFreePercent: 5}
+		result, output := pinger.Ping()
+		if result.Status != StatusNOK || result.Category != test.wantCategory {
+			t.Errorf("%s: expected a %s failure, got %+v", test.name, test.wantCategory, result)
+			continue
+		}
+		if result.Error.Error() != test.wantErr {
+			t.Errorf("%s: got error %q, want %q", test.name, result.Error, test.wantErr)
+		}
+		if output == nil || output.String() != test.runner.output {
+			t.Errorf("%s: expected command output to be kept, got %v", test.name, output)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for value, want := range map[string]string{
+		"/data":         `'/data'`,
+		"/mnt/my data":  `'/mnt/my data'`,
+		"/it's":         `'/it'\''s'`,
+		"/data; reboot": `'/data; reboot'`,
+		"/$(reboot)":    `'/$(reboot)'`,
+	} {
+		if quoted := shellQuote(value); quoted != want {
+			t.Errorf("%q: got %s, want %s", value, quoted, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package ping
+
+import (
+	"github.com/miekg/dns"
+	"github.com/petergardfjall/watcher/config"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	defaultDNSTimeout = 10 * time.Second
+	defaultDNSPort    = "53"
+)
+
+// DNSSerialPinger is a Pinger that queries the SOA record of a zone from a set
+// of name servers and checks that their serials do not drift apart. This is
+// useful to detect secondary name servers that fail to pick up zone
+// transfers from their primary.
+type DNSSerialPinger struct {
+	Check config.DNSSerialCheck
+}
+
+// NewDNSSerialPinger creates a new pinger that compares the SOA serial of a
+// zone across a set of name servers.
+func NewDNSSerialPinger(dnsConfig *config.Pinger) (Pinger, error) {
+	log.Debugf("setting up dns serial pinger ...")
+	var dnsCheck config.DNSSerialCheck
+	err := json.Unmarshal(dnsConfig.Check, &dnsCheck)
+	if err != nil {
+		return nil, fmt.Errorf("dns serial pinger: illegal check: %s", err)
+	}
+	log.Debugf("dns serial check: %#v", dnsCheck)
+	if err := dnsCheck.Validate(); err != nil {
+		return nil, fmt.Errorf("dns serial pinger: invalid check: %s", err)
+	}
+
+	return &DNSSerialPinger{Check: dnsCheck}, nil
+}
+
+// Ping queries each name server for the serial of the zone. The ping fails if
+// any name server cannot be queried or if the serials of any two name servers
+// differ by more than the configured tolerance. The output lists the serial
+// reported by each name server.
+func (dnsPinger *DNSSerialPinger) Ping() (result Result, output *bytes.Buffer) {
+	output = new(bytes.Buffer)
+	serials := make([]uint32, 0, len(dnsPinger.Check.Servers))
+	for _, server := range dnsPinger.Check.Servers {
+		serial, err := dnsPinger.querySerial(server)
+		if err != nil {
+			result = Result{Status: StatusNOK, Error: err, Category: connectionErrorCategory(err)}
+			return
+		}
+		fmt.Fprintf(output, "%s: %d\n", server, serial)
+		serials = append(serials, serial)
+	}
+
+	if drift := maxSerialDrift(serials); drift > dnsPinger.Check.Tolerance {
+		result = Result{
+			Status: StatusNOK,
+			Error: fmt.Errorf("%s: serials drift by %d (tolerance: %d)",
+				dnsPinger.Check.Zone, drift, dnsPinger.Check.Tolerance),
+			Category: CategoryContent,
+		}
+		return
+	}
+
+	result = Result{Status: StatusOK}
+	return
+}
+
+// querySerial queries a name server for the SOA serial of the checked zone.
+func (dnsPinger *DNSSerialPinger) querySerial(server string) (uint32, error) {
+	timeout := defaultDNSTimeout
+	if dnsPinger.Check.Timeout != nil {
+		timeout = dnsPinger.Check.Timeout.Duration
+	}
+	address := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		address = net.JoinHostPort(server, defaultDNSPort)
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(dnsPinger.Check.Zone), dns.TypeSOA)
+	client := &dns.Client{Timeout: timeout}
+	response, _, err := client.Exchange(query, address)
+	if err != nil {
+		return 0, fmt.Errorf("%s: SOA query failed: %s", server, err)
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("%s: SOA query failed: %s", server, dns.RcodeToString[response.Rcode])
+	}
+	for _, record := range response.Answer {
+		if soa, ok := record.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: no SOA record for %s", server, dnsPinger.Check.Zone)
+}
+
+// maxSerialDrift returns the largest difference between any two serials.
+// Differences are calculated using serial number arithmetic (RFC 1982) to
+// account for serials that wrap around.
+func maxSerialDrift(serials []uint32) uint32 {
+	var max uint32
+	for i := range serials {
+		for j := i + 1; j < len(serials); j++ {
+			if drift := serialDistance(serials[i], serials[j]); drift > max {
+				max = drift
+			}
+		}
+	}
+	return max
+}
+
+// serialDistance returns the distance between two serials in serial number
+// arithmetic.
+func serialDistance(a, b uint32) uint32 {
+	if distance := a - b; distance <= 1<<31 {
+		return distance
+	}
+	return b - a
+}
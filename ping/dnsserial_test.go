@@ -0,0 +1,89 @@
+package ping
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/petergardfjall/watcher/config"
+)
+
+// startStubNameServer starts a name server that answers every query with a
+// SOA record with a given serial, and returns its address.
+func startStubNameServer(t *testing.T, serial uint32) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(request)
+		reply.Answer = append(reply.Answer, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET},
+			Ns:     "ns1.example.com.",
+			Mbox:   "hostmaster.example.com.",
+			Serial: serial,
+		})
+		w.WriteMsg(reply)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func TestDNSSerial(t *testing.T) {
+	primary := startStubNameServer(t, 2026101700)
+	inSync := startStubNameServer(t, 2026101700)
+	lagging := startStubNameServer(t, 2026101697)
+
+	tests := []struct {
+		name      string
+		servers   []string
+		tolerance uint32
+		want      Status
+	}{
+		{"matching", []string{primary, inSync}, 0, StatusOK},
+		{"mismatched", []string{primary, inSync, lagging}, 2, StatusNOK},
+		{"within tolerance", []string{primary, lagging}, 3, StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			check, _ := json.Marshal(config.DNSSerialCheck{Zone: "example.com", Servers: test.servers, Tolerance: test.tolerance})
+			pinger, err := NewDNSSerialPinger(&config.Pinger{Name: "test", Type: "dnsserial", Check: check})
+			if err != nil {
+				t.Fatalf("failed to create pinger: %s", err)
+			}
+			result, output := pinger.Ping()
+			if result.Status != test.want {
+				t.Fatalf("got %s, want %s (%v)", result.Status, test.want, result.Error)
+			}
+			if result.Status == StatusNOK && (result.Category != CategoryContent || !strings.Contains(result.Error.Error(), "drift by 3")) {
+				t.Errorf("unexpected failure: %s: %v", result.Category, result.Error)
+			}
+			for _, server := range test.servers {
+				if !strings.Contains(output.String(), server+": ") {
+					t.Errorf("output lacks the serial of %s: %q", server, output)
+				}
+			}
+		})
+	}
+}
+
+func TestSerialDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b uint32
+		want uint32
+	}{
+		{100, 103, 3},
+		{103, 100, 3},
+		// serial arithmetic wraps around
+		{0xfffffffe, 1, 3},
+	} {
+		if distance := serialDistance(test.a, test.b); distance != test.want {
+			t.Errorf("distance between %d and %d: got %d, want %d", test.a, test.b, distance, test.want)
+		}
+	}
+}
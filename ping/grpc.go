@@ -0,0 +1,90 @@
+package ping
+
+import (
+	"github.com/petergardfjall/watcher/config"
+
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const defaultGRPCTimeout = 10 * time.Second
+
+// GRPCPinger is a Pinger that checks the health of gRPC servers via the
+// standard gRPC health checking protocol (grpc.health.v1.Health).
+type GRPCPinger struct {
+	Check config.GRPCCheck
+}
+
+// NewGRPCPinger creates a new pinger that checks the health of a gRPC server.
+func NewGRPCPinger(grpcConfig *config.Pinger) (Pinger, error) {
+	log.Debugf("setting up grpc pinger ...")
+	var grpcCheck config.GRPCCheck
+	err := json.Unmarshal(grpcConfig.Check, &grpcCheck)
+	if err != nil {
+		return nil, fmt.Errorf("grpc pinger: illegal check: %s", err)
+	}
+	if err := grpcCheck.Validate(); err != nil {
+		return nil, fmt.Errorf("grpc pinger: invalid check: %s", err)
+	}
+
+	return &GRPCPinger{Check: grpcCheck}, nil
+}
+
+// Ping calls the health check service of the gRPC server. The ping succeeds
+// if the server reports the checked service as SERVING. The output holds the
+// reported serving status.
+func (grpcPinger *GRPCPinger) Ping() (result Result, output *bytes.Buffer) {
+	timeout := defaultGRPCTimeout
+	if grpcPinger.Check.Timeout != nil {
+		timeout = grpcPinger.Check.Timeout.Duration
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	transportCredentials := insecure.NewCredentials()
+	if grpcPinger.Check.TLS {
+		transportCredentials = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(grpcPinger.Check.Target, grpc.WithTransportCredentials(transportCredentials))
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: CategorySetup}, nil
+	}
+	defer conn.Close()
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: grpcPinger.Check.ServiceName})
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: grpcErrorCategory(err)}, nil
+	}
+
+	status := response.GetStatus()
+	output = bytes.NewBufferString(fmt.Sprintf("%d (%s)\n", status, status))
+	if status != healthpb.HealthCheckResponse_SERVING {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("%s: not serving: %s", grpcPinger.Check.Target, status), Category: CategoryStatus}, output
+	}
+	return Result{Status: StatusOK}, output
+}
+
+// grpcErrorCategory returns the category of an error from a health check
+// call: a timeout, a failure to connect (or to complete a TLS handshake) or,
+// for any other error returned by the server, an unexpected status.
+func grpcErrorCategory(err error) ErrorCategory {
+	switch grpcstatus.Code(err) {
+	case codes.DeadlineExceeded:
+		return CategoryTimeout
+	case codes.Unavailable:
+		return connectionErrorCategory(err)
+	default:
+		return CategoryStatus
+	}
+}
@@ -2,23 +2,64 @@ package ping
 
 import (
 	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/expr"
 
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	defaultHTTPTimeout = 30 * time.Second
+	// defaultHTTPMaxOutputBytes is the default maximum number of bytes to
+	// read from a response body.
+	defaultHTTPMaxOutputBytes = 1024 * 1024
+	// maxBodySnippetLength is the maximum length of the excerpt of a
+	// response body included in errors.
+	maxBodySnippetLength = 200
 )
 
 // HTTPPinger is a Pinger that checks endpoints using the HTTP(S) protocol.
 type HTTPPinger struct {
 	Check config.HTTPCheck
+	// Expr is the compiled Check.Expr (if any).
+	Expr *expr.Expression
+
+	// index of the URL to ping in the current ping cycle and of the URL
+	// to ping in the next one (when the check has several URLs)
+	currentURL int
+	nextURL    int
+	lock       sync.Mutex
+	// dialer to make connections with (nil means the default)
+	dialer Dialer
+	// basic auth credentials given as userinfo of the URLs (keyed on the
+	// URL with the userinfo stripped)
+	urlAuth map[string]*config.HTTPBasicAuth
+	// transport that keeps connections alive (only with Check.Prewarm)
+	// and the modification times of the TLS files that it was created
+	// from
+	warmTransport *http.Transport
+	warmTLSFiles  string
+	// compiled bodyRegexps of the expectations of the check (keyed on
+	// pattern)
+	bodyRegexps map[string]*regexp.Regexp
+}
+
+// SetDialer implements the DialerSetter interface.
+func (httpPinger *HTTPPinger) SetDialer(dialer Dialer) {
+	httpPinger.dialer = dialer
 }
 
 // NewHTTPPinger creates a new pinger that checks endpoints using the HTTP(S)
@@ -35,55 +76,540 @@ func NewHTTPPinger(httpConfig *config.Pinger) (Pinger, error) {
 		return nil, fmt.Errorf("http pinger: invalid check: %s", err)
 	}
 
-	httpPinger := HTTPPinger{Check: httpCheck}
-	return &httpPinger, nil
+	// credentials in URLs are used as basic auth (unless basicAuth is
+	// given) and are kept out of requested (and logged) URLs
+	urlAuth := make(map[string]*config.HTTPBasicAuth)
+	if httpCheck.URL != "" {
+		url, auth, err := stripUserinfo(httpCheck.URL)
+		if err != nil {
+			return nil, fmt.Errorf("http pinger: invalid check: %s", err)
+		}
+		httpCheck.URL, urlAuth[url] = url, auth
+	}
+	for i, rawURL := range httpCheck.URLs {
+		url, auth, err := stripUserinfo(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("http pinger: invalid check: %s", err)
+		}
+		httpCheck.URLs[i], urlAuth[url] = url, auth
+	}
+
+	// patterns have been verified to compile by Validate
+	bodyRegexps := make(map[string]*regexp.Regexp)
+	compileBodyRegexp := func(expect config.HTTPExpectation) {
+		if expect.BodyRegexp != "" {
+			bodyRegexps[expect.BodyRegexp] = regexp.MustCompile(expect.BodyRegexp)
+		}
+	}
+	compileBodyRegexp(httpCheck.Expect)
+	for _, expect := range httpCheck.ExpectOnStatus {
+		compileBodyRegexp(expect)
+	}
+
+	httpPinger := &HTTPPinger{Check: httpCheck, urlAuth: urlAuth, bodyRegexps: bodyRegexps}
+	if httpCheck.Expr != "" {
+		httpPinger.Expr, err = expr.Compile(httpCheck.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("http pinger: invalid expr: %s", err)
+		}
+	}
+	return httpPinger, nil
 
 }
 
-// Ping checks the health of the endpoint configured for this HTTPPinger.
-func (httpPinger *HTTPPinger) Ping() (result Result, output *bytes.Buffer) {
-	timeout := defaultHTTPTimeout
+// stripUserinfo removes the userinfo (user:password@) from a URL and returns
+// the stripped URL along with the userinfo as basic auth credentials (nil if
+// the URL has no userinfo).
+func stripUserinfo(rawURL string) (string, *config.HTTPBasicAuth, error) {
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("illegal url: %s", err)
+	}
+	if parsed.User == nil {
+		return rawURL, nil, nil
+	}
+	password, _ := parsed.User.Password()
+	auth := &config.HTTPBasicAuth{Username: parsed.User.Username(), Password: password}
+	parsed.User = nil
+	return parsed.String(), auth, nil
+}
+
+// ShouldRetry implements the Retrier interface. If the check restricts retries
+// to a set of status codes (RetryOnStatus), a ping that failed due to an
+// unexpected status code is only retried if the status code is in that set.
+// Pings that failed for other reasons (such as connection errors) are always
+// retried.
+func (httpPinger *HTTPPinger) ShouldRetry(result Result) bool {
+	statusErr, ok := result.Error.(*StatusCodeError)
+	if !ok || len(httpPinger.Check.RetryOnStatus) == 0 {
+		return true
+	}
+	for _, statusCode := range httpPinger.Check.RetryOnStatus {
+		if statusCode == statusErr.Actual {
+			return true
+		}
+	}
+	return false
+}
+
+// StartCycle implements the CycleStarter interface. If the check has several
+// URLs, successive ping cycles rotate through them, while all attempts of a
+// ping cycle are made against the same URL. That way, a failing URL fails its
+// ping cycle rather than being hidden by a retry against another URL.
+func (httpPinger *HTTPPinger) StartCycle() {
+	if len(httpPinger.Check.URLs) == 0 {
+		return
+	}
+	httpPinger.lock.Lock()
+	defer httpPinger.lock.Unlock()
+	httpPinger.currentURL = httpPinger.nextURL
+	httpPinger.nextURL = (httpPinger.nextURL + 1) % len(httpPinger.Check.URLs)
+}
+
+// url returns the URL to ping in the current ping cycle.
+func (httpPinger *HTTPPinger) url() string {
+	if len(httpPinger.Check.URLs) == 0 {
+		return httpPinger.Check.URL
+	}
+	httpPinger.lock.Lock()
+	defer httpPinger.lock.Unlock()
+	return httpPinger.Check.URLs[httpPinger.currentURL]
+}
+
+// timeout returns the timeout of requests made by the HTTPPinger.
+func (httpPinger *HTTPPinger) timeout() time.Duration {
 	if httpPinger.Check.Timeout != nil {
-		timeout = httpPinger.Check.Timeout.Duration
+		return httpPinger.Check.Timeout.Duration
 	}
+	return defaultHTTPTimeout
+}
+
+// newTransport creates a transport for the requests of the HTTPPinger. Unless
+// keepAlive is true, each connection is only used for a single request.
+func (httpPinger *HTTPPinger) newTransport(keepAlive bool) (*http.Transport, error) {
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: !httpPinger.Check.VerifyCert,
-		},
-		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: !httpPinger.Check.VerifyCert},
+		DisableKeepAlives: !keepAlive,
+	}
+	if httpPinger.Check.CACertFile != "" {
+		roots, err := loadCertPool(httpPinger.Check.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA certificates: %s", err)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+	if httpPinger.Check.ClientCertFile != "" {
+		// loaded for every transport, so that a renewed certificate
+		// is picked up
+		clientCert, err := tls.LoadX509KeyPair(httpPinger.Check.ClientCertFile, httpPinger.Check.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	if network := httpPinger.Check.Network; network != "" || httpPinger.dialer != nil {
+		if network == "" {
+			network = "tcp"
+		}
+		var dialer Dialer = &net.Dialer{Timeout: httpPinger.timeout()}
+		if httpPinger.dialer != nil {
+			dialer = httpPinger.dialer
+		}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return transport, nil
+}
+
+// transport returns the transport to make a ping with. With Check.Prewarm,
+// this is a transport shared with KeepWarm, whose connections are kept alive.
+// It is replaced when any of the TLS files of the check (such as a renewed
+// client certificate) changes.
+func (httpPinger *HTTPPinger) transport() (*http.Transport, error) {
+	if httpPinger.Check.Prewarm == nil {
+		return httpPinger.newTransport(false)
+	}
+	httpPinger.lock.Lock()
+	defer httpPinger.lock.Unlock()
+	// created on first use, since the dialer may be set after the
+	// HTTPPinger is created
+	tlsFiles := httpPinger.tlsFilesVersion()
+	if httpPinger.warmTransport == nil || tlsFiles != httpPinger.warmTLSFiles {
+		transport, err := httpPinger.newTransport(true)
+		if err != nil {
+			return nil, err
+		}
+		if httpPinger.warmTransport != nil {
+			log.Infof("TLS files changed: reconnecting")
+			httpPinger.warmTransport.CloseIdleConnections()
+		}
+		httpPinger.warmTransport, httpPinger.warmTLSFiles = transport, tlsFiles
 	}
-	client := &http.Client{Timeout: timeout, Transport: transport}
+	return httpPinger.warmTransport, nil
+}
 
-	req, err := http.NewRequest("GET", httpPinger.Check.URL, nil)
+// tlsFilesVersion returns the modification times of the TLS files (client
+// certificate and key, and CA certificates) of the check, which changes when
+// any of them is modified. Files that cannot be read are left out (and fail
+// when the transport is created).
+func (httpPinger *HTTPPinger) tlsFilesVersion() string {
+	var version strings.Builder
+	for _, path := range []string{httpPinger.Check.ClientCertFile, httpPinger.Check.ClientKeyFile, httpPinger.Check.CACertFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&version, "%s:%d;", path, info.ModTime().UnixNano())
+		}
+	}
+	return version.String()
+}
+
+// KeepWarm implements the Warmer interface. With Check.Prewarm, a keep-alive
+// connection to each URL of the check is established right away and, at the
+// prewarm interval, refreshed with a HEAD request. A connection that has
+// been dropped is thereby re-established before the next ping.
+func (httpPinger *HTTPPinger) KeepWarm(stop <-chan struct{}) {
+	if httpPinger.Check.Prewarm == nil {
+		return
+	}
+	urls := httpPinger.Check.URLs
+	if len(urls) == 0 {
+		urls = []string{httpPinger.Check.URL}
+	}
+	ticker := time.NewTicker(httpPinger.Check.Prewarm.Duration)
+	defer ticker.Stop()
+	for {
+		// looked up on every round, since it is replaced when the
+		// TLS files of the check change
+		transport, err := httpPinger.transport()
+		if err != nil {
+			log.Errorf("failed to keep connections warm: %s", err)
+		} else {
+			client := &http.Client{Timeout: httpPinger.timeout(), Transport: transport}
+			for _, url := range urls {
+				httpPinger.warm(client, url)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if err == nil {
+				transport.CloseIdleConnections()
+			}
+			return
+		}
+	}
+}
+
+// warm makes a HEAD request to a URL, leaving the connection idle (for reuse)
+// in the pool of the client. Whatever the response, the connection is warm.
+// The request carries the same headers and credentials as a ping, so that
+// the server treats it like one.
+func (httpPinger *HTTPPinger) warm(client *http.Client, url string) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
 	if err != nil {
-		result = Result{StatusNOK, fmt.Errorf("ping failed: %s", err)}
-		output = nil
+		log.Debugf("failed to warm connection to %s: %s", url, err)
+		return
+	}
+	httpPinger.setHeaders(req, url)
+	response, err := client.Do(req)
+	if err != nil {
+		log.Debugf("failed to warm connection to %s: %s", url, err)
 		return
 	}
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+}
+
+// setHeaders sets the configured headers and basic auth credentials of the
+// check on a request to a given URL.
+func (httpPinger *HTTPPinger) setHeaders(req *http.Request, url string) {
+	for name, value := range httpPinger.Check.Headers {
+		// the Host header is taken from the request rather than from
+		// its headers
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
 
 	if httpPinger.Check.BasicAuth != nil {
 		req.SetBasicAuth(
 			httpPinger.Check.BasicAuth.Username,
 			httpPinger.Check.BasicAuth.Password)
+	} else if auth := httpPinger.urlAuth[url]; auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+}
+
+// Ping checks the health of the endpoint configured for this HTTPPinger.
+func (httpPinger *HTTPPinger) Ping() (result Result, output *bytes.Buffer) {
+	transport, err := httpPinger.transport()
+	if err != nil {
+		result = Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: CategorySetup}
+		output = nil
+		return
+	}
+	client := &http.Client{Timeout: httpPinger.timeout(), Transport: transport}
+
+	url := httpPinger.url()
+	log.Debugf("pinging %s ...", url)
+	var requestBody io.Reader
+	if httpPinger.Check.Body != "" {
+		requestBody = strings.NewReader(httpPinger.Check.Body)
+	}
+	req, err := http.NewRequest(httpPinger.Check.HTTPMethod(), url, requestBody)
+	if err != nil {
+		result = Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: CategorySetup}
+		output = nil
+		return
+	}
+	httpPinger.setHeaders(req, url)
+
+	if httpPinger.Check.Tracing != "" {
+		traceID, err := setTraceHeaders(req, httpPinger.Check.Tracing)
+		if err != nil {
+			result = Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: CategorySetup}
+			output = nil
+			return
+		}
+		// recorded whatever the outcome of the ping
+		defer func() { result.TraceID = traceID }()
 	}
 
+	start := time.Now()
 	response, err := client.Do(req)
+	// tell what is wrong with a certificate chain that cannot be verified
+	if chainErr := chainError(err); chainErr != nil {
+		chainErr.URL = url
+		result = Result{Status: StatusNOK, Error: chainErr, Category: CategoryTLS}
+		output = nil
+		return
+	}
 	if err != nil {
-		result = Result{StatusNOK, fmt.Errorf("ping failed: %s", err)}
+		result = Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: connectionErrorCategory(err)}
 		output = nil
 		return
 	}
 	defer response.Body.Close()
 
-	expectedCode := httpPinger.Check.Expect.StatusCode
-	if expectedCode != response.StatusCode {
-		result = Result{StatusNOK, fmt.Errorf("expected status code (%d) differs from actual (%d)", expectedCode, response.StatusCode)}
+	if httpPinger.Check.CheckOCSP {
+		if err := checkOCSPStaple(response.TLS); err != nil {
+			result = Result{Status: StatusNOK, Error: err, Category: CategoryTLS}
+			output = nil
+			return
+		}
+	}
+
+	body, bodyLength, err := httpPinger.readBody(url, response)
+	if err != nil {
+		result = Result{Status: StatusNOK, Error: fmt.Errorf("failed to read response: %s", err), Category: connectionErrorCategory(err)}
+		output = nil
+		return
+	}
+	latency := time.Since(start)
+	truncated := bodyLength > int64(len(body)) && !httpPinger.countsBodyLength()
+	summary := summarizeHTTPResponse(response, bodyLength, truncated)
+
+	if httpPinger.Expr != nil {
+		result = httpPinger.evalExpr(response, body, latency)
+		result.Summary = summary
+		output = bytes.NewBuffer(body)
+		return
+	}
+
+	expect, conditional := httpPinger.Check.ExpectOnStatus[response.StatusCode]
+	if !conditional {
+		expect = httpPinger.Check.Expect
+	}
+	if !conditional && !expect.Accepts(response.StatusCode) {
+		result = Result{Status: StatusNOK, Error: &StatusCodeError{URL: url, Expected: expect.Accepted(), Actual: response.StatusCode}, Category: CategoryStatus, Summary: summary}
 		output = nil
 		return
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	result = Result{StatusOK, nil}
-	output = bytes.NewBuffer(body)
+	bodyRegexp := httpPinger.bodyRegexps[expect.BodyRegexp]
+	if err := checkResponse(&expect, bodyRegexp, response, body, bodyLength); err != nil {
+		result = Result{Status: StatusNOK, Error: err, Category: CategoryContent, Summary: summary}
+		output = bytes.NewBuffer(regexpContext(bodyRegexp, body, expect.BodyRegexpContext))
+		return
+	}
+
+	result = Result{Status: StatusOK, Summary: summary}
+	output = bytes.NewBuffer(regexpContext(bodyRegexp, body, expect.BodyRegexpContext))
 	return
 }
+
+// regexpContext returns the region of a response body around the match of a
+// bodyRegexp, with a given number of bytes of context on either side. If the
+// body does not match, the region is centered on the location where the body
+// comes closest to matching: the longest start of the literal prefix of the
+// bodyRegexp found in the body (or, failing that, the start of the body). The
+// full body is returned if there is no bodyRegexp or no context is given.
+func regexpContext(bodyRegexp *regexp.Regexp, body []byte, context int) []byte {
+	if bodyRegexp == nil || context <= 0 {
+		return body
+	}
+	start, end := 0, 0
+	if loc := bodyRegexp.FindIndex(body); loc != nil {
+		start, end = loc[0], loc[1]
+	} else {
+		prefix, _ := bodyRegexp.LiteralPrefix()
+		for n := len(prefix); n > 0; n-- {
+			if i := bytes.Index(body, []byte(prefix[:n])); i >= 0 {
+				start, end = i, i+n
+				break
+			}
+		}
+	}
+	if start -= context; start < 0 {
+		start = 0
+	}
+	if end += context; end > len(body) {
+		end = len(body)
+	}
+	return body[start:end]
+}
+
+// checkResponse verifies that a response (with the expected status code)
+// meets the remaining expectations. The bodyLength is the full length of the
+// (possibly truncated) body. The bodyRegexp is the compiled BodyRegexp of the
+// expectation (nil if it has none).
+func checkResponse(expect *config.HTTPExpectation, bodyRegexp *regexp.Regexp, response *http.Response, body []byte, bodyLength int64) error {
+	if bodyLength < expect.MinBodyBytes {
+		return fmt.Errorf("response body too short: %d bytes (expected at least %d)", bodyLength, expect.MinBodyBytes)
+	}
+	if expect.MaxBodyBytes > 0 && bodyLength > expect.MaxBodyBytes {
+		return fmt.Errorf("response body too long: %d bytes (expected at most %d)", bodyLength, expect.MaxBodyBytes)
+	}
+
+	for _, name := range expect.Cookies {
+		if !hasCookie(response, name) {
+			return fmt.Errorf("expected cookie '%s' not set by response", name)
+		}
+	}
+
+	for _, substring := range expect.BodyContains {
+		if !bytes.Contains(body, []byte(substring)) {
+			return fmt.Errorf("response body does not contain '%s'", substring)
+		}
+	}
+
+	if bodyRegexp != nil && !bodyRegexp.Match(body) {
+		excerpt := bodySnippet(regexpContext(bodyRegexp, body, expect.BodyRegexpContext))
+		return &OutputError{Message: fmt.Sprintf("response body does not match '%s': %s", bodyRegexp, excerpt), Excerpt: excerpt}
+	}
+
+	if len(expect.JSONPath) > 0 {
+		if err := checkJSONPaths(body, expect.JSONPath); err != nil {
+			return fmt.Errorf("response body: %s", err)
+		}
+	}
+
+	if expect.Dependencies != nil {
+		if err := checkDependencies(body, expect.Dependencies); err != nil {
+			return fmt.Errorf("response body: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// bodySnippet returns a short, single-line excerpt of the start of a response
+// body for use in errors.
+func bodySnippet(body []byte) string {
+	snippet := strings.Join(strings.Fields(string(body)), " ")
+	if snippet == "" {
+		return "<empty>"
+	}
+	return "\"" + cutLine(snippet, maxBodySnippetLength) + "\""
+}
+
+// countsBodyLength returns true if any of the expectations of the check has
+// bounds on the length of the response body.
+func (httpPinger *HTTPPinger) countsBodyLength() bool {
+	hasBounds := func(expect config.HTTPExpectation) bool {
+		return expect.MinBodyBytes > 0 || expect.MaxBodyBytes > 0
+	}
+	if hasBounds(httpPinger.Check.Expect) {
+		return true
+	}
+	for _, expect := range httpPinger.Check.ExpectOnStatus {
+		if hasBounds(expect) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCookie returns true if a response sets a cookie with a given name.
+func hasCookie(response *http.Response, name string) bool {
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readBody reads the body of a response from a given URL and returns it
+// together with its length. At most MaxOutputBytes are read; any remainder
+// of the body is discarded. The remainder is only counted towards the length
+// if the check has bounds on the body length.
+func (httpPinger *HTTPPinger) readBody(url string, response *http.Response) ([]byte, int64, error) {
+	limit := int64(defaultHTTPMaxOutputBytes)
+	if httpPinger.Check.MaxOutputBytes > 0 {
+		limit = int64(httpPinger.Check.MaxOutputBytes)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	length := int64(len(body))
+	if length > limit {
+		log.Warningf("response body from %s exceeds %d bytes: truncating", url, limit)
+		body = body[:limit]
+		if httpPinger.countsBodyLength() {
+			remainder, err := io.Copy(ioutil.Discard, response.Body)
+			if err != nil {
+				return nil, 0, err
+			}
+			length += remainder
+		}
+	}
+	return body, length, nil
+}
+
+// evalExpr evaluates the Expr of the HTTPPinger against a response.
+func (httpPinger *HTTPPinger) evalExpr(response *http.Response, body []byte, latency time.Duration) Result {
+	variables := map[string]interface{}{
+		"status":  response.StatusCode,
+		"latency": latency,
+		"body":    string(body),
+		"header":  response.Header.Get,
+	}
+	ok, err := httpPinger.Expr.Eval(variables)
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("failed to evaluate expr: %s", err), Category: CategoryContent}
+	}
+	if !ok {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("expr not satisfied: %s (status: %d, latency: %s)", httpPinger.Expr, response.StatusCode, latency), Category: CategoryContent}
+	}
+	return Result{Status: StatusOK}
+}
+
+// A StatusCodeError is the error of a ping where the endpoint responded with
+// an unexpected status code.
+type StatusCodeError struct {
+	URL string
+	// the accepted status codes (for example "200, 204, 3xx")
+	Expected string
+	Actual   int
+}
+
+func (err *StatusCodeError) Error() string {
+	return fmt.Sprintf("%s: expected status code (%s) differs from actual (%d)", err.URL, err.Expected, err.Actual)
+}
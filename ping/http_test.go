@@ -0,0 +1,737 @@
+package ping
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/petergardfjall/watcher/config"
+)
+
+// captureLog collects what is logged for the rest of a test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var logs bytes.Buffer
+	logging.SetBackend(logging.NewLogBackend(&logs, "", 0))
+	t.Cleanup(func() { logging.SetBackend(logging.NewLogBackend(os.Stderr, "", stdlog.LstdFlags)) })
+	return &logs
+}
+
+// newTestHTTPPinger creates an HTTPPinger for a check given as a JSON-able
+// value.
+func newTestHTTPPinger(t *testing.T, check interface{}) Pinger {
+	t.Helper()
+	rawCheck, err := json.Marshal(check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinger, err := NewHTTPPinger(&config.Pinger{Name: "test", Type: "http", Check: rawCheck})
+	if err != nil {
+		t.Fatalf("failed to create pinger: %s", err)
+	}
+	return pinger
+}
+
+func TestBodyRegexpContext(t *testing.T) {
+	body := strings.Repeat("a", 100) + `{"status": "down"}` + strings.Repeat("b", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		bodyRegexp string
+		context    int
+		wantStatus Status
+		wantOutput string
+	}{
+		{"full body without context", `"status": "down"`, 0, StatusOK, body},
+		{"match with context", `"status": "down"`, 5, StatusOK, `aaaa{"status": "down"}bbbb`},
+		{"context beyond body", `"status": "down"`, 1000, StatusOK, body},
+		{"mismatch at closest location", `"status": "up"`, 3, StatusNOK, `aa{"status": "dow`},
+		{"mismatch without literal prefix", `[0-9]+`, 4, StatusNOK, "aaaa"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pinger := newTestHTTPPinger(t, map[string]interface{}{
+				"url": server.URL,
+				"expect": map[string]interface{}{
+					"statusCode":        200,
+					"bodyRegexp":        test.bodyRegexp,
+					"bodyRegexpContext": test.context,
+				},
+			})
+			result, output := pinger.Ping()
+			if result.Status != test.wantStatus {
+				t.Fatalf("unexpected status: %s (%v)", result.Status, result.Error)
+			}
+			if output.String() != test.wantOutput {
+				t.Errorf("unexpected output: %q, want %q", output.String(), test.wantOutput)
+			}
+			if result.Error != nil && !strings.Contains(result.Error.Error(), test.wantOutput) {
+				t.Errorf("error does not quote the captured region: %s", result.Error)
+			}
+		})
+	}
+}
+
+func TestBodyRegexpContextValidation(t *testing.T) {
+	for _, expect := range []config.HTTPExpectation{
+		{StatusCode: 200, BodyRegexpContext: 10},
+		{StatusCode: 200, BodyRegexp: "ok", BodyRegexpContext: -1},
+	} {
+		if err := expect.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", expect)
+		}
+	}
+}
+
+func TestPrewarmSendsCredentialsAndHeaders(t *testing.T) {
+	var lock sync.Mutex
+	var warmRequests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			lock.Lock()
+			warmRequests = append(warmRequests, r)
+			lock.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		name  string
+		check map[string]interface{}
+	}{
+		{"basic auth", map[string]interface{}{
+			"url":       server.URL,
+			"basicAuth": map[string]string{"username": "user", "password": "secret"},
+		}},
+		{"userinfo", map[string]interface{}{
+			"url": strings.Replace(server.URL, "http://", "http://user:secret@", 1),
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lock.Lock()
+			warmRequests = nil
+			lock.Unlock()
+
+			test.check["expect"] = map[string]int{"statusCode": 200}
+			test.check["headers"] = map[string]string{"X-Api-Key": "key"}
+			test.check["prewarm"] = "1h"
+			pinger := newTestHTTPPinger(t, test.check)
+			stop := make(chan struct{})
+			defer close(stop)
+			go pinger.(Warmer).KeepWarm(stop)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				lock.Lock()
+				warmed := len(warmRequests)
+				lock.Unlock()
+				if warmed > 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("no keep-warm request received")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			request := warmRequests[0]
+			if username, password, ok := request.BasicAuth(); !ok || username != "user" || password != "secret" {
+				t.Errorf("keep-warm request lacks basic auth credentials")
+			}
+			if request.Header.Get("X-Api-Key") != "key" {
+				t.Errorf("keep-warm request lacks configured header")
+			}
+		})
+	}
+}
+
+// slowListener is a net.Listener that is slow to accept connections, so that
+// setting up a connection dominates the latency of a request on it.
+type slowListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (listener slowListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err == nil {
+		time.Sleep(listener.delay)
+	}
+	return conn, err
+}
+
+// connCounter counts the connections of a server that were opened and that
+// went idle (after serving a request).
+type connCounter struct {
+	lock       sync.Mutex
+	opened     int
+	idleEvents int
+}
+
+func (counter *connCounter) track(conn net.Conn, state http.ConnState) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	switch state {
+	case http.StateNew:
+		counter.opened++
+	case http.StateIdle:
+		counter.idleEvents++
+	}
+}
+
+func (counter *connCounter) counts() (opened, idleEvents int) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	return counter.opened, counter.idleEvents
+}
+
+// awaitIdle waits for a connection of a server to go idle more than a given
+// number of times.
+func (counter *connCounter) awaitIdle(t *testing.T, idleEvents int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, idle := counter.counts(); idle > idleEvents {
+			// let the client return the connection to its pool
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no connection warmed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPrewarmReusesConnection(t *testing.T) {
+	connectDelay := 300 * time.Millisecond
+	var counter connCounter
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = slowListener{Listener: server.Listener, delay: connectDelay}
+	server.Config.ConnState = counter.track
+	server.StartTLS()
+	defer server.Close()
+
+	timedPing := func(pinger Pinger) time.Duration {
+		t.Helper()
+		start := time.Now()
+		if result, _ := pinger.Ping(); result.Status != StatusOK {
+			t.Fatalf("ping failed: %+v", result)
+		}
+		return time.Since(start)
+	}
+	check := map[string]interface{}{"url": server.URL, "expect": map[string]int{"statusCode": 200}}
+
+	// without pre-warming, the first ping has to connect
+	if latency := timedPing(newTestHTTPPinger(t, check)); latency < connectDelay {
+		t.Fatalf("expected cold ping to connect, took %s", latency)
+	}
+	server.CloseClientConnections()
+
+	check["prewarm"] = "300ms"
+	pinger := newTestHTTPPinger(t, check)
+	stop := make(chan struct{})
+	defer close(stop)
+	_, idle := counter.counts()
+	go pinger.(Warmer).KeepWarm(stop)
+	counter.awaitIdle(t, idle)
+
+	opened, _ := counter.counts()
+	for i := 0; i < 2; i++ {
+		if latency := timedPing(pinger); latency >= connectDelay {
+			t.Errorf("ping %d: expected warm connection to be reused, took %s", i+1, latency)
+		}
+	}
+	if reopened, _ := counter.counts(); reopened != opened {
+		t.Errorf("expected pings to reuse the warm connection, %d connections opened", reopened-opened)
+	}
+
+	// a dropped connection is reconnected by the next warm-up
+	_, idle = counter.counts()
+	server.CloseClientConnections()
+	counter.awaitIdle(t, idle)
+	if reopened, _ := counter.counts(); reopened != opened+1 {
+		t.Errorf("expected dropped connection to be reconnected, %d connections opened", reopened-opened)
+	}
+	if latency := timedPing(pinger); latency >= connectDelay {
+		t.Errorf("expected reconnected connection to be reused, took %s", latency)
+	}
+}
+
+// writeClientCert writes a self-signed client certificate with a given serial
+// number, and its key, to files in a given directory.
+func writeClientCert(t *testing.T, dir string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// make sure that a rewrite is seen as a modification, whatever the
+	// resolution of the file system timestamps
+	modTime := time.Now().Add(time.Duration(serial) * time.Second)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestPrewarmPicksUpRenewedClientCert(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].SerialNumber)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir, 1)
+	pinger := newTestHTTPPinger(t, map[string]interface{}{
+		"url":            server.URL,
+		"expect":         map[string]int{"statusCode": 200},
+		"clientCertFile": certFile,
+		"clientKeyFile":  keyFile,
+		"prewarm":        "1h",
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	go pinger.(Warmer).KeepWarm(stop)
+
+	result, output := pinger.Ping()
+	if result.Status != StatusOK || output.String() != "1" {
+		t.Fatalf("unexpected ping with first certificate: %+v, %q", result, output)
+	}
+	writeClientCert(t, dir, 2)
+	result, output = pinger.Ping()
+	if result.Status != StatusOK || output.String() != "2" {
+		t.Errorf("renewed certificate not used: %+v, %q", result, output)
+	}
+}
+
+func TestHTTPErrorCategory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprint(w, "error page")
+	}))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	tests := []struct {
+		name  string
+		check map[string]interface{}
+		want  ErrorCategory
+	}{
+		{"connection refused", map[string]interface{}{"url": "http://127.0.0.1:1"}, CategoryConnection},
+		{"timeout", map[string]interface{}{"url": server.URL + "/slow", "timeout": "100ms"}, CategoryTimeout},
+		{"status code", map[string]interface{}{"url": server.URL + "/missing"}, CategoryStatus},
+		{"body", map[string]interface{}{"url": server.URL, "expect": map[string]interface{}{"statusCode": 200, "bodyRegexp": "healthy"}}, CategoryContent},
+		{"untrusted certificate", map[string]interface{}{"url": tlsServer.URL, "verifyCert": true}, CategoryTLS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, ok := test.check["expect"]; !ok {
+				test.check["expect"] = map[string]int{"statusCode": 200}
+			}
+			result, _ := newTestHTTPPinger(t, test.check).Ping()
+			if result.Status != StatusNOK {
+				t.Fatalf("expected ping to fail: %+v", result)
+			}
+			if result.Category != test.want {
+				t.Errorf("got category %q, want %q (error: %s)", result.Category, test.want, result.Error)
+			}
+		})
+	}
+
+	// a client certificate that is removed after the pinger is set up
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir, 1)
+	pinger := newTestHTTPPinger(t, map[string]interface{}{
+		"url":            server.URL,
+		"expect":         map[string]int{"statusCode": 200},
+		"clientCertFile": certFile,
+		"clientKeyFile":  keyFile,
+	})
+	if err := os.Remove(certFile); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := pinger.Ping(); result.Category != CategorySetup {
+		t.Errorf("got category %q for unreadable client certificate, want %q (error: %s)", result.Category, CategorySetup, result.Error)
+	}
+}
+
+func TestHTTPExpr(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"status": "ok"}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		expr       string
+		wantStatus Status
+	}{
+		{`status == 202 && latency < 5s && body matches "\"ok\""`, StatusOK},
+		{`status >= 200 && status < 300 && header["Content-Type"] contains "json"`, StatusOK},
+		{`status == 200`, StatusNOK},
+		{`body contains "degraded"`, StatusNOK},
+	}
+	for _, test := range tests {
+		// the expr supersedes the expectations (which the response fails)
+		result, _ := newTestHTTPPinger(t, map[string]interface{}{
+			"url":    server.URL,
+			"expr":   test.expr,
+			"expect": map[string]int{"statusCode": 200},
+		}).Ping()
+		if result.Status != test.wantStatus {
+			t.Errorf("%s: got status %s, want %s (%v)", test.expr, result.Status, test.wantStatus, result.Error)
+		}
+		if result.Status == StatusNOK && !strings.Contains(result.Error.Error(), "expr not satisfied") {
+			t.Errorf("%s: unexpected error: %s", test.expr, result.Error)
+		}
+	}
+
+	result, _ := newTestHTTPPinger(t, map[string]interface{}{"url": server.URL, "expr": `latency < 200`}).Ping()
+	if result.Status != StatusNOK || !strings.Contains(result.Error.Error(), "failed to evaluate expr") {
+		t.Errorf("expected evaluation error to fail the ping, got %+v", result)
+	}
+}
+
+func TestHTTPExprValidation(t *testing.T) {
+	check := config.HTTPCheck{URL: "http://localhost", Expr: `status == 200 && latency < 500ms`}
+	if err := check.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	check.Expr = `status == 200 &&`
+	if err := check.Validate(); err == nil || !strings.Contains(err.Error(), "illegal expr") {
+		t.Errorf("expected illegal expr to be rejected, got: %v", err)
+	}
+}
+
+func TestRoundRobinURLs(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		if r.URL.Path == "/b" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	pinger := newTestHTTPPinger(t, map[string]interface{}{
+		"urls":   []string{server.URL + "/a", server.URL + "/b", server.URL + "/c"},
+		"expect": map[string]int{"statusCode": 200},
+	})
+	cycleStarter := pinger.(CycleStarter)
+	var statuses []Status
+	for cycle := 0; cycle < 4; cycle++ {
+		cycleStarter.StartCycle()
+		// all attempts of a cycle target the same URL
+		for attempt := 0; attempt < 2; attempt++ {
+			result, _ := pinger.Ping()
+			if attempt == 0 {
+				statuses = append(statuses, result.Status)
+			}
+			if result.Status == StatusNOK && !strings.Contains(result.Error.Error(), "/b") {
+				t.Errorf("error does not name the failing URL: %s", result.Error)
+			}
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got := strings.Join(paths, ","); got != "/a,/a,/b,/b,/c,/c,/a,/a" {
+		t.Errorf("unexpected order of pinged URLs: %s", got)
+	}
+	if want := []Status{StatusOK, StatusNOK, StatusOK, StatusOK}; fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("expected only the cycle of the failing URL to fail, got %v", statuses)
+	}
+}
+
+// startHTTPServer starts a test HTTP server on a given network and address,
+// skipping the test if the network is not available.
+func startHTTPServer(t *testing.T, network, address string) *httptest.Server {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %s", network, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNetwork(t *testing.T) {
+	ipv4 := startHTTPServer(t, "tcp4", "127.0.0.1:0")
+	ipv6 := startHTTPServer(t, "tcp6", "[::1]:0")
+
+	tests := []struct {
+		network string
+		url     string
+		want    Status
+	}{
+		{"tcp4", ipv4.URL, StatusOK},
+		{"tcp4", ipv6.URL, StatusNOK},
+		{"tcp6", ipv4.URL, StatusNOK},
+		{"tcp6", ipv6.URL, StatusOK},
+		{"tcp", ipv4.URL, StatusOK},
+		{"tcp", ipv6.URL, StatusOK},
+	}
+	for _, test := range tests {
+		pinger := newTestHTTPPinger(t, map[string]interface{}{
+			"url":     test.url,
+			"network": test.network,
+			"timeout": "2s",
+			"expect":  map[string]int{"statusCode": 200},
+		})
+		if result, _ := pinger.Ping(); result.Status != test.want {
+			t.Errorf("%s to %s: got %s, want %s (%v)", test.network, test.url, result.Status, test.want, result.Error)
+		}
+	}
+}
+
+// countingReader is an endless stream of bytes that counts how many bytes
+// have been read from it.
+type countingReader struct {
+	read int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	reader.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestReadBodyCap(t *testing.T) {
+	for _, countsLength := range []bool{false, true} {
+		check := map[string]interface{}{
+			"url":            "http://127.0.0.1:1",
+			"maxOutputBytes": 1024,
+			"expect":         map[string]int{"statusCode": 200},
+		}
+		if countsLength {
+			check["expect"] = map[string]int{"statusCode": 200, "maxBodyBytes": 4096}
+		}
+		pinger := newTestHTTPPinger(t, check).(*HTTPPinger)
+		logs := captureLog(t)
+		stream := &countingReader{}
+		body := io.LimitReader(stream, 1<<20)
+		if !countsLength {
+			// an endless body must not be read to its end
+			body = stream
+		}
+
+		read, length, err := pinger.readBody("http://127.0.0.1:1", &http.Response{Body: io.NopCloser(body)})
+		if err != nil {
+			t.Fatalf("failed to read body: %s", err)
+		}
+		if len(read) != 1024 {
+			t.Errorf("counts length %t: got %d bytes of body, want 1024", countsLength, len(read))
+		}
+		if !countsLength && (stream.read > 64*1024 || length != 1025) {
+			t.Errorf("expected reading to stop at the cap, read %d bytes (length %d)", stream.read, length)
+		}
+		if countsLength && length != 1<<20 {
+			t.Errorf("expected the discarded remainder to be counted, got length %d", length)
+		}
+		if !strings.Contains(logs.String(), "exceeds 1024 bytes") {
+			t.Errorf("expected a warning of the truncated body, got log: %q", logs.String())
+		}
+	}
+}
+
+func TestBodyLengthBounds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			fmt.Fprint(w, "ok")
+		case "/large":
+			w.Write(bytes.Repeat([]byte("x"), 1<<20))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"/empty", "response body too short: 0 bytes (expected at least 1)"},
+		{"/small", ""},
+		{"/large", "response body too long: 1048576 bytes (expected at most 1024)"},
+	}
+	for _, test := range tests {
+		pinger := newTestHTTPPinger(t, map[string]interface{}{
+			"url":    server.URL + test.path,
+			"expect": map[string]int{"statusCode": 200, "minBodyBytes": 1, "maxBodyBytes": 1024},
+		})
+		result, _ := pinger.Ping()
+		if test.wantErr == "" {
+			if result.Status != StatusOK {
+				t.Errorf("%s: expected body within bounds to pass: %v", test.path, result.Error)
+			}
+			continue
+		}
+		if result.Status != StatusNOK || result.Category != CategoryContent {
+			t.Errorf("%s: expected body out of bounds to fail the ping: %+v", test.path, result)
+			continue
+		}
+		if result.Error.Error() != test.wantErr {
+			t.Errorf("%s: got error %q, want %q", test.path, result.Error, test.wantErr)
+		}
+	}
+}
+
+func TestBodyLengthBoundsValidation(t *testing.T) {
+	for _, expect := range []config.HTTPExpectation{
+		{StatusCode: 200, MinBodyBytes: -1},
+		{StatusCode: 200, MaxBodyBytes: -1},
+		{StatusCode: 200, MinBodyBytes: 100, MaxBodyBytes: 10},
+	} {
+		check := config.HTTPCheck{URL: "http://localhost", Expect: expect}
+		if err := check.Validate(); err == nil {
+			t.Errorf("expected bounds %d-%d to be rejected", expect.MinBodyBytes, expect.MaxBodyBytes)
+		}
+	}
+}
+
+func TestURLUserinfo(t *testing.T) {
+	var lock sync.Mutex
+	var credentials string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		credentials = ""
+		if username, password, ok := r.BasicAuth(); ok {
+			credentials = username + ":" + password
+		}
+	}))
+	defer server.Close()
+	address := server.Listener.Addr().String()
+
+	tests := []struct {
+		name            string
+		check           map[string]interface{}
+		wantCredentials string
+	}{
+		{"userinfo", map[string]interface{}{"url": "http://alice:s3cret@" + address + "/"}, "alice:s3cret"},
+		{"escaped userinfo", map[string]interface{}{"url": "http://alice:p%40ss@" + address + "/"}, "alice:p@ss"},
+		{"userinfo of urls", map[string]interface{}{"urls": []string{"http://carol:pw@" + address + "/"}}, "carol:pw"},
+		{
+			"explicit basic auth takes precedence",
+			map[string]interface{}{"url": "http://alice:s3cret@" + address + "/", "basicAuth": map[string]string{"username": "bob", "password": "x"}},
+			"bob:x",
+		},
+		{"no credentials", map[string]interface{}{"url": "http://" + address + "/"}, ""},
+	}
+	for _, test := range tests {
+		test.check["expect"] = map[string]int{"statusCode": 200}
+		pinger := newTestHTTPPinger(t, test.check)
+		if result, _ := pinger.Ping(); result.Status != StatusOK {
+			t.Errorf("%s: ping failed: %v", test.name, result.Error)
+			continue
+		}
+		lock.Lock()
+		if credentials != test.wantCredentials {
+			t.Errorf("%s: got credentials %q, want %q", test.name, credentials, test.wantCredentials)
+		}
+		lock.Unlock()
+
+		// the credentials are kept out of the URLs that are requested
+		// (and logged)
+		check := pinger.(*HTTPPinger).Check
+		for _, url := range append([]string{check.URL}, check.URLs...) {
+			if strings.Contains(url, "@") {
+				t.Errorf("%s: credentials left in url %s", test.name, url)
+			}
+		}
+	}
+}
+
+func TestExpectCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		}
+		http.SetCookie(w, &http.Cookie{Name: "tracking", Value: "xyz"})
+	}))
+	defer server.Close()
+
+	for path, want := range map[string]Status{"/login": StatusOK, "/broken": StatusNOK} {
+		pinger := newTestHTTPPinger(t, map[string]interface{}{
+			"url":    server.URL + path,
+			"expect": map[string]interface{}{"statusCode": 200, "cookies": []string{"session"}},
+		})
+		result, _ := pinger.Ping()
+		if result.Status != want {
+			t.Errorf("%s: got %s, want %s (%v)", path, result.Status, want, result.Error)
+		}
+		if want == StatusNOK && (result.Category != CategoryContent || !strings.Contains(result.Error.Error(), "'session'")) {
+			t.Errorf("%s: expected the missing cookie to be named, got %s: %v", path, result.Category, result.Error)
+		}
+	}
+
+	invalid := config.HTTPExpectation{StatusCode: 200, Cookies: []string{" "}}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("expected an empty cookie name to be rejected")
+	}
+}
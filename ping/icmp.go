@@ -0,0 +1,160 @@
+package ping
+
+import (
+	"github.com/petergardfjall/watcher/config"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	defaultICMPCount   = 3
+	defaultICMPTimeout = 5 * time.Second
+
+	// IANA protocol numbers of ICMP and ICMPv6 (for parsing messages).
+	protocolICMP     = 1
+	protocolICMPIPv6 = 58
+)
+
+// icmpPingers counts the ICMPPingers created, to give each of them an echo
+// identifier of its own.
+var icmpPingers uint32
+
+// ICMPPinger is a Pinger that sends ICMP echo requests (as the ping utility
+// does) to a host. Note that ICMP requires raw sockets, which typically
+// require elevated privileges (such as root or the CAP_NET_RAW capability).
+type ICMPPinger struct {
+	Check config.ICMPCheck
+
+	// identifier of the echo requests sent by the pinger
+	id int
+}
+
+// NewICMPPinger creates a new pinger that sends ICMP echo requests to a host.
+func NewICMPPinger(icmpConfig *config.Pinger) (Pinger, error) {
+	log.Debugf("setting up icmp pinger ...")
+	var icmpCheck config.ICMPCheck
+	err := json.Unmarshal(icmpConfig.Check, &icmpCheck)
+	if err != nil {
+		return nil, fmt.Errorf("icmp pinger: illegal check: %s", err)
+	}
+	if err := icmpCheck.Validate(); err != nil {
+		return nil, fmt.Errorf("icmp pinger: invalid check: %s", err)
+	}
+	if icmpCheck.Count == 0 {
+		icmpCheck.Count = defaultICMPCount
+	}
+
+	id := (os.Getpid() + int(atomic.AddUint32(&icmpPingers, 1))) & 0xffff
+	return &ICMPPinger{Check: icmpCheck, id: id}, nil
+}
+
+// Ping sends the configured number of echo requests to the host, one at a
+// time. The ping fails if the packet loss exceeds the configured maximum. The
+// output lists the round-trip time of each echo request.
+func (icmpPinger *ICMPPinger) Ping() (result Result, output *bytes.Buffer) {
+	address, err := net.ResolveIPAddr("ip", icmpPinger.Check.Host)
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("failed to resolve %s: %s", icmpPinger.Check.Host, err), Category: CategoryConnection}, nil
+	}
+
+	network, listenAddress := "ip4:icmp", "0.0.0.0"
+	if address.IP.To4() == nil {
+		network, listenAddress = "ip6:ipv6-icmp", "::"
+	}
+	conn, err := icmp.ListenPacket(network, listenAddress)
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("failed to open ICMP socket (raw sockets may require root privileges or the CAP_NET_RAW capability): %s", err), Category: CategorySetup}, nil
+	}
+	defer conn.Close()
+
+	output = new(bytes.Buffer)
+	count := icmpPinger.Check.Count
+	var replies int
+	var totalRTT time.Duration
+	for seq := 1; seq <= count; seq++ {
+		rtt, err := icmpPinger.echo(conn, address, seq)
+		if err != nil {
+			fmt.Fprintf(output, "%s: seq=%d: %s\n", address, seq, err)
+			continue
+		}
+		fmt.Fprintf(output, "%s: seq=%d: time=%s\n", address, seq, rtt)
+		replies++
+		totalRTT += rtt
+	}
+
+	loss := 100 * float64(count-replies) / float64(count)
+	summary := fmt.Sprintf("%d/%d replies (%.1f%% packet loss)", replies, count, loss)
+	if replies > 0 {
+		summary += fmt.Sprintf(", average rtt %s", totalRTT/time.Duration(replies))
+	}
+	fmt.Fprintln(output, summary)
+
+	if loss > icmpPinger.Check.MaxPacketLoss {
+		err := fmt.Errorf("%s: packet loss above %.1f%%: %s", icmpPinger.Check.Host, icmpPinger.Check.MaxPacketLoss, summary)
+		return Result{Status: StatusNOK, Error: err, Category: CategoryConnection}, output
+	}
+	return Result{Status: StatusOK}, output
+}
+
+// echo sends an echo request with a given sequence number to an address and
+// returns the round-trip time of its reply.
+func (icmpPinger *ICMPPinger) echo(conn *icmp.PacketConn, address *net.IPAddr, seq int) (time.Duration, error) {
+	timeout := defaultICMPTimeout
+	if icmpPinger.Check.Timeout != nil {
+		timeout = icmpPinger.Check.Timeout.Duration
+	}
+
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	protocol := protocolICMP
+	if address.IP.To4() == nil {
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		protocol = protocolICMPIPv6
+	}
+
+	request := icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{ID: icmpPinger.id, Seq: seq, Data: []byte("watcher")},
+	}
+	packet, err := request.Marshal(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create echo request: %s", err)
+	}
+
+	start := time.Now()
+	if err := conn.SetReadDeadline(start.Add(timeout)); err != nil {
+		return 0, err
+	}
+	if _, err := conn.WriteTo(packet, address); err != nil {
+		return 0, fmt.Errorf("failed to send echo request: %s", err)
+	}
+
+	// the socket receives all ICMP traffic to the host: skip anything but
+	// the reply to our request
+	buffer := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return 0, fmt.Errorf("no reply within %s", timeout)
+			}
+			return 0, fmt.Errorf("failed to receive echo reply: %s", err)
+		}
+		reply, err := icmp.ParseMessage(protocol, buffer[:n])
+		if err != nil || reply.Type != replyType || peer.String() != address.String() {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == icmpPinger.id && echo.Seq == seq {
+			return time.Since(start), nil
+		}
+	}
+}
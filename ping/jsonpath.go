@@ -0,0 +1,157 @@
+package ping
+
+import (
+	"github.com/petergardfjall/watcher/config"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkJSONPaths parses a document as JSON and verifies that each of a set of
+// dotted paths (such as "status" or "db.replicas.0.state") holds an expected
+// value. Scalar values are compared by their string form (for example, "true"
+// or "3"). An error naming the first failing path (in lexical order) is
+// returned on mismatch, on a missing path, or if the document is not valid
+// JSON.
+func checkJSONPaths(document []byte, expected map[string]string) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var root interface{}
+	if err := decoder.Decode(&root); err != nil {
+		return fmt.Errorf("not valid JSON: %s", err)
+	}
+
+	paths := make([]string, 0, len(expected))
+	for path := range expected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		value, err := lookupJSONPath(root, path)
+		if err != nil {
+			return fmt.Errorf("json path '%s': %s", path, err)
+		}
+		if value != expected[path] {
+			return fmt.Errorf("json path '%s': expected value (%q) differs from actual (%q)", path, expected[path], value)
+		}
+	}
+	return nil
+}
+
+// defaultOKStatus is the status of an OK dependency of a health endpoint when
+// no okStatus is given.
+const defaultOKStatus = "ok"
+
+// checkDependencies parses the response of a health endpoint as JSON and
+// verifies that the dependencies it reports on are OK. An error naming all
+// dependencies that are not OK (in lexical order) is returned if any of them
+// is not OK or missing, or if the document is not valid JSON.
+func checkDependencies(document []byte, dependencies *config.HealthDependencies) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var root interface{}
+	if err := decoder.Decode(&root); err != nil {
+		return fmt.Errorf("not valid JSON: %s", err)
+	}
+
+	node := root
+	if dependencies.Path != "" {
+		var err error
+		if node, err = lookupJSONNode(root, dependencies.Path); err != nil {
+			return fmt.Errorf("json path '%s': %s", dependencies.Path, err)
+		}
+	}
+	statuses, ok := node.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("dependencies: not a JSON object")
+	}
+
+	names := dependencies.Names
+	if len(names) == 0 {
+		for name := range statuses {
+			names = append(names, name)
+		}
+	}
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+
+	okStatus := dependencies.OKStatus
+	if okStatus == "" {
+		okStatus = defaultOKStatus
+	}
+	var failing []string
+	for _, name := range names {
+		status, found := statuses[name]
+		if !found {
+			failing = append(failing, fmt.Sprintf("%s (missing)", name))
+			continue
+		}
+		value, err := scalarString(status)
+		if err != nil {
+			failing = append(failing, fmt.Sprintf("%s (%s)", name, err))
+		} else if value != okStatus {
+			failing = append(failing, fmt.Sprintf("%s (%q)", name, value))
+		}
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("dependencies not %s: %s", okStatus, strings.Join(failing, ", "))
+	}
+	return nil
+}
+
+// lookupJSONPath follows a dotted path through a decoded JSON document and
+// returns the string form of the scalar value found at the end of it.
+func lookupJSONPath(root interface{}, path string) (string, error) {
+	node, err := lookupJSONNode(root, path)
+	if err != nil {
+		return "", err
+	}
+	return scalarString(node)
+}
+
+// lookupJSONNode follows a dotted path through a decoded JSON document and
+// returns the value found at the end of it. Numeric path elements index into
+// arrays.
+func lookupJSONNode(root interface{}, path string) (interface{}, error) {
+	node := root
+	for _, key := range strings.Split(path, ".") {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[key]
+			if !ok {
+				return nil, fmt.Errorf("no such field: '%s'", key)
+			}
+			node = child
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(n) {
+				return nil, fmt.Errorf("no such array index: '%s'", key)
+			}
+			node = n[index]
+		default:
+			return nil, fmt.Errorf("cannot look up '%s' in a scalar value", key)
+		}
+	}
+	return node, nil
+}
+
+// scalarString returns the string form of a decoded scalar JSON value.
+func scalarString(node interface{}) (string, error) {
+	switch value := node.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case nil:
+		return "null", nil
+	default:
+		return "", fmt.Errorf("not a scalar value")
+	}
+}
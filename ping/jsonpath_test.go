@@ -0,0 +1,143 @@
+package ping
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+func TestCheckJSONPaths(t *testing.T) {
+	document := []byte(`{"status": "healthy", "db": {"up": true, "replicas": [{"state": "ok"}], "lag": 3}}`)
+
+	tests := []struct {
+		name     string
+		expected map[string]string
+		wantErr  string
+	}{
+		{"match", map[string]string{"status": "healthy", "db.up": "true", "db.lag": "3", "db.replicas.0.state": "ok"}, ""},
+		{"mismatch", map[string]string{"status": "healthy", "db.up": "false"}, `json path 'db.up': expected value ("false") differs from actual ("true")`},
+		{"missing", map[string]string{"cache": "up"}, "json path 'cache': "},
+		{"index out of range", map[string]string{"db.replicas.1.state": "ok"}, "json path 'db.replicas.1.state': "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkJSONPaths(document, test.expected)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+
+	if err := checkJSONPaths([]byte("<html>"), map[string]string{"status": "healthy"}); err == nil || !strings.HasPrefix(err.Error(), "not valid JSON") {
+		t.Errorf("expected invalid JSON to be rejected, got %v", err)
+	}
+}
+
+func TestSSHJSONPaths(t *testing.T) {
+	runner := &fakeRunner{output: `{"status": "healthy", "db": {"up": true}}`}
+	pinger := &SSHPinger{Client: runner, Command: "health --json", ExpectedJSONPaths: map[string]string{"status": "healthy", "db.up": "true"}}
+	if result, _ := pinger.Ping(); result.Status != StatusOK {
+		t.Errorf("expected matching json paths to pass: %+v", result)
+	}
+
+	pinger.ExpectedJSONPaths = map[string]string{"status": "healthy", "db.up": "false"}
+	result, output := pinger.Ping()
+	if result.Status != StatusNOK || result.Category != CategoryContent {
+		t.Fatalf("expected mismatching json path to fail the ping: %+v", result)
+	}
+	if !strings.Contains(result.Error.Error(), "'db.up'") {
+		t.Errorf("error does not name the mismatching path: %s", result.Error)
+	}
+	if output == nil || output.String() != runner.output {
+		t.Errorf("expected command output to be kept, got %v", output)
+	}
+}
+
+func TestCheckDependencies(t *testing.T) {
+	body := []byte(`{"db": "ok", "cache": "degraded", "checks": {"disk": "UP", "queue": "DOWN"}}`)
+
+	tests := []struct {
+		name         string
+		dependencies config.HealthDependencies
+		wantErr      string
+	}{
+		{"named ok", config.HealthDependencies{Names: []string{"db"}}, ""},
+		{"named degraded", config.HealthDependencies{Names: []string{"db", "cache"}}, `dependencies not ok: cache ("degraded")`},
+		{"named missing", config.HealthDependencies{Names: []string{"db", "search"}}, "dependencies not ok: search (missing)"},
+		{"nested with status", config.HealthDependencies{Path: "checks", Names: []string{"disk"}, OKStatus: "UP"}, ""},
+		{"nested all", config.HealthDependencies{Path: "checks", OKStatus: "UP"}, `dependencies not UP: queue ("DOWN")`},
+		{"all", config.HealthDependencies{}, `dependencies not ok: cache ("degraded"), checks (not a scalar value)`},
+		{"not an object", config.HealthDependencies{Path: "db"}, "dependencies: not a JSON object"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkDependencies(body, &test.dependencies)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPDependencies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "ok", "dependencies": {"db": "ok", "cache": "degraded"}}`)
+	}))
+	defer server.Close()
+
+	pinger := newTestHTTPPinger(t, map[string]interface{}{
+		"url": server.URL,
+		"expect": map[string]interface{}{
+			"statusCode":   200,
+			"dependencies": map[string]interface{}{"path": "dependencies"},
+		},
+	})
+	result, _ := pinger.Ping()
+	if result.Status != StatusNOK {
+		t.Fatalf("expected a degraded dependency to fail the ping: %+v", result)
+	}
+	if result.Category != CategoryContent {
+		t.Errorf("got category %q, want %q", result.Category, CategoryContent)
+	}
+	if !strings.Contains(result.Error.Error(), `cache ("degraded")`) || strings.Contains(result.Error.Error(), "db") {
+		t.Errorf("error does not name the failing dependency (only): %s", result.Error)
+	}
+
+	pinger = newTestHTTPPinger(t, map[string]interface{}{
+		"url": server.URL,
+		"expect": map[string]interface{}{
+			"statusCode":   200,
+			"dependencies": map[string]interface{}{"path": "dependencies", "names": []string{"db"}},
+		},
+	})
+	if result, _ := pinger.Ping(); result.Status != StatusOK {
+		t.Errorf("expected an ok named dependency to pass: %+v", result)
+	}
+}
+
+func TestHealthDependenciesValidation(t *testing.T) {
+	for _, dependencies := range []config.HealthDependencies{
+		{Path: "a..b"},
+		{Names: []string{"db", ""}},
+	} {
+		if err := dependencies.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", dependencies)
+		}
+	}
+}
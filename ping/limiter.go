@@ -0,0 +1,49 @@
+package ping
+
+import (
+	"sync"
+)
+
+// sshConnectionLimiter limits the number of concurrent SSH connections made
+// against any single host. By default, connections are not limited.
+var sshConnectionLimiter = newHostLimiter(0)
+
+// SetMaxSSHConnectionsPerHost limits the number of concurrent SSH connections
+// that SSHClients make against a single host. SSHClients that would exceed the
+// limit wait for a connection to the host to finish. A limit of 0 means no
+// limit. The limit should be set before any SSHClient is used.
+func SetMaxSSHConnectionsPerHost(limit int) {
+	sshConnectionLimiter = newHostLimiter(limit)
+}
+
+// A hostLimiter is a set of semaphores, one per host, that limit the number
+// of concurrent operations against each host.
+type hostLimiter struct {
+	limit int
+
+	lock  sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquire blocks until an operation against a host is allowed to proceed.
+// The returned function must be called when the operation is done.
+func (limiter *hostLimiter) acquire(host string) (release func()) {
+	if limiter.limit <= 0 {
+		return func() {}
+	}
+
+	limiter.lock.Lock()
+	slots, ok := limiter.slots[host]
+	if !ok {
+		slots = make(chan struct{}, limiter.limit)
+		limiter.slots[host] = slots
+	}
+	limiter.lock.Unlock()
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
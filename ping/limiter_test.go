@@ -0,0 +1,128 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// countingDialer is a Dialer that fails every connection after a delay, and
+// records the highest number of connections it saw in progress at once, both
+// per host and in total.
+type countingDialer struct {
+	delay time.Duration
+
+	lock      sync.Mutex
+	active    map[string]int
+	maxActive map[string]int
+	total     int
+	maxTotal  int
+	dialedTo  []string
+}
+
+func newCountingDialer(delay time.Duration) *countingDialer {
+	return &countingDialer{
+		delay:     delay,
+		active:    make(map[string]int),
+		maxActive: make(map[string]int),
+	}
+}
+
+func (dialer *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(address)
+	dialer.lock.Lock()
+	dialer.dialedTo = append(dialer.dialedTo, host)
+	dialer.active[host]++
+	dialer.total++
+	if dialer.active[host] > dialer.maxActive[host] {
+		dialer.maxActive[host] = dialer.active[host]
+	}
+	if dialer.total > dialer.maxTotal {
+		dialer.maxTotal = dialer.total
+	}
+	dialer.lock.Unlock()
+
+	time.Sleep(dialer.delay)
+
+	dialer.lock.Lock()
+	dialer.active[host]--
+	dialer.total--
+	dialer.lock.Unlock()
+	return nil, errors.New("connection refused")
+}
+
+func TestMaxSSHConnectionsPerHost(t *testing.T) {
+	SetMaxSSHConnectionsPerHost(1)
+	defer SetMaxSSHConnectionsPerHost(0)
+
+	delay := 100 * time.Millisecond
+	dialer := newCountingDialer(delay)
+	fingerprint := ssh.FingerprintSHA256(newTestSigner(t).PublicKey())
+	client := func(host string) *SSHClient {
+		return &SSHClient{
+			Config: &SSHClientConfig{
+				Host:                host,
+				Port:                22,
+				Username:            "user",
+				Password:            "secret",
+				Timeout:             time.Second,
+				TrustedFingerprints: []string{fingerprint},
+			},
+			Dialer: dialer,
+		}
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, host := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.2", "10.0.0.2", "10.0.0.2"} {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			if _, err := client(host).Run("uptime"); err == nil {
+				t.Errorf("expected connection to %s to fail", host)
+			}
+		}(host)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	dialer.lock.Lock()
+	defer dialer.lock.Unlock()
+	if len(dialer.dialedTo) != 6 {
+		t.Fatalf("expected every client to dial, got %d dials", len(dialer.dialedTo))
+	}
+	for _, host := range []string{"10.0.0.1", "10.0.0.2"} {
+		if dialer.maxActive[host] != 1 {
+			t.Errorf("%s: got up to %d concurrent connections, want 1", host, dialer.maxActive[host])
+		}
+	}
+	if dialer.maxTotal != 2 {
+		t.Errorf("expected connections to different hosts to be made in parallel, got up to %d at once", dialer.maxTotal)
+	}
+	if elapsed < 3*delay || elapsed >= 5*delay {
+		t.Errorf("expected connections to each host to be serialized, and hosts in parallel, took %s", elapsed)
+	}
+}
+
+func TestUnlimitedSSHConnections(t *testing.T) {
+	limiter := newHostLimiter(0)
+	releases := make([]func(), 0, 3)
+	for i := 0; i < 3; i++ {
+		acquired := make(chan func())
+		go func() { acquired <- limiter.acquire("10.0.0.1") }()
+		select {
+		case release := <-acquired:
+			releases = append(releases, release)
+		case <-time.After(time.Second):
+			t.Fatalf("expected acquire %d not to block without a limit", i+1)
+		}
+	}
+	for _, release := range releases {
+		release()
+	}
+}
@@ -0,0 +1,59 @@
+package ping
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// checkOCSPStaple verifies that the server of a TLS connection stapled an
+// OCSP response that is valid and does not report its certificate as revoked.
+func checkOCSPStaple(state *tls.ConnectionState) error {
+	if state == nil {
+		return fmt.Errorf("ocsp: not a TLS connection")
+	}
+	if len(state.OCSPResponse) == 0 {
+		return fmt.Errorf("ocsp: server did not staple an OCSP response")
+	}
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("ocsp: server presented no certificate")
+	}
+	leaf := state.PeerCertificates[0]
+	issuer := ocspIssuer(state)
+	if issuer == nil {
+		return fmt.Errorf("ocsp: cannot verify stapled response: issuer certificate of %s not presented", leaf.Subject)
+	}
+
+	response, err := ocsp.ParseResponseForCert(state.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("ocsp: invalid stapled response: %s", err)
+	}
+	if !response.NextUpdate.IsZero() && time.Now().After(response.NextUpdate) {
+		return fmt.Errorf("ocsp: stapled response expired at %s", response.NextUpdate)
+	}
+	switch response.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("ocsp: certificate %s revoked at %s", leaf.Subject, response.RevokedAt)
+	default:
+		return fmt.Errorf("ocsp: certificate %s has unknown revocation status", leaf.Subject)
+	}
+}
+
+// ocspIssuer returns the issuer of the leaf certificate of a TLS connection
+// (or nil if the server did not present it).
+func ocspIssuer(state *tls.ConnectionState) *x509.Certificate {
+	for _, chain := range state.VerifiedChains {
+		if len(chain) > 1 {
+			return chain[1]
+		}
+	}
+	if len(state.PeerCertificates) > 1 {
+		return state.PeerCertificates[1]
+	}
+	return nil
+}
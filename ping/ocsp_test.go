@@ -0,0 +1,104 @@
+package ping
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// newOCSPResponse creates an OCSP response, signed by the issuer of a
+// certificate, with a given status of the certificate and time of next
+// update.
+func newOCSPResponse(t *testing.T, leaf, issuer *testCert, status int, nextUpdate time.Time) []byte {
+	t.Helper()
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: leaf.cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   nextUpdate,
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	response, err := ocsp.CreateResponse(issuer.cert, issuer.cert, template, issuer.key)
+	if err != nil {
+		t.Fatalf("failed to create ocsp response: %s", err)
+	}
+	return response
+}
+
+// startStaplingTLSServer starts a server that presents a given certificate
+// chain (leaf first) and staples a given OCSP response (unless nil), and
+// returns its URL.
+func startStaplingTLSServer(t *testing.T, staple []byte, chain ...*testCert) string {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	certificate := tls.Certificate{PrivateKey: chain[0].key, OCSPStaple: staple}
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.cert.Raw)
+	}
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestOCSPStaple(t *testing.T) {
+	ca := newTestCert(t, "ca", true, nil, nil)
+	leaf := newTestCert(t, "leaf", false, ca, []net.IP{net.ParseIP("127.0.0.1")})
+	nextUpdate := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		staple  []byte
+		chain   []*testCert
+		wantErr string
+	}{
+		{"good", newOCSPResponse(t, leaf, ca, ocsp.Good, nextUpdate), []*testCert{leaf, ca}, ""},
+		{"revoked", newOCSPResponse(t, leaf, ca, ocsp.Revoked, nextUpdate), []*testCert{leaf, ca}, "ocsp: certificate CN=leaf revoked at"},
+		{"unknown", newOCSPResponse(t, leaf, ca, ocsp.Unknown, nextUpdate), []*testCert{leaf, ca}, "ocsp: certificate CN=leaf has unknown revocation status"},
+		{"expired", newOCSPResponse(t, leaf, ca, ocsp.Good, time.Now().Add(-time.Minute)), []*testCert{leaf, ca}, "ocsp: stapled response expired"},
+		{"absent", nil, []*testCert{leaf, ca}, "ocsp: server did not staple an OCSP response"},
+		{"issuer not presented", newOCSPResponse(t, leaf, ca, ocsp.Good, nextUpdate), []*testCert{leaf}, "ocsp: cannot verify stapled response"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _ := newTestHTTPPinger(t, map[string]interface{}{
+				"url":       startStaplingTLSServer(t, test.staple, test.chain...),
+				"checkOCSP": true,
+				"expect":    map[string]int{"statusCode": 200},
+			}).Ping()
+			if test.wantErr == "" {
+				if result.Status != StatusOK {
+					t.Fatalf("expected a good staple to pass: %v", result.Error)
+				}
+				return
+			}
+			if result.Status != StatusNOK || result.Category != CategoryTLS {
+				t.Fatalf("expected a TLS failure, got %s (%s): %v", result.Status, result.Category, result.Error)
+			}
+			if !strings.HasPrefix(result.Error.Error(), test.wantErr) {
+				t.Errorf("got error %q, want %q", result.Error, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestOCSPStapleIgnoredByDefault(t *testing.T) {
+	ca := newTestCert(t, "ca", true, nil, nil)
+	leaf := newTestCert(t, "leaf", false, ca, []net.IP{net.ParseIP("127.0.0.1")})
+	result, _ := newTestHTTPPinger(t, map[string]interface{}{
+		"url":    startStaplingTLSServer(t, nil, leaf, ca),
+		"expect": map[string]int{"statusCode": 200},
+	}).Ping()
+	if result.Status != StatusOK {
+		t.Errorf("expected a missing staple to pass without checkOCSP: %v", result.Error)
+	}
+}
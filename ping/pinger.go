@@ -2,10 +2,20 @@ package ping
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/op/go-logging"
+	"net"
+	"strings"
 )
 
+// redactedOutput replaces the excerpts of output left out by RedactOutput.
+const redactedOutput = "<output redacted>"
+
 var log = logging.MustGetLogger("pinger")
 
 // A Status is returned by a Pinger to indicate the health of the pinged
@@ -36,6 +46,66 @@ var (
 type Result struct {
 	Status Status
 	Error  error
+	// Category tells what kind of failure a failed ping ran into (empty
+	// if the ping did not fail).
+	Category ErrorCategory
+	// OutputChanged is set by Pingers that track their output when the
+	// output differs from that of the previous ping.
+	OutputChanged bool
+	// Summary is set by Pingers that can summarize the response to the
+	// ping (such as by the status code of a HTTP response).
+	Summary string
+	// TraceID is set by Pingers that start a trace for the ping (such as
+	// HTTP pingers with tracing) to the ID of the trace.
+	TraceID string
+}
+
+// An ErrorCategory is the kind of failure that a failed ping ran into, which
+// (unlike the error message) is suitable to route or aggregate alerts on.
+type ErrorCategory string
+
+const (
+	// CategoryConnection is a failure to reach the endpoint (such as a
+	// refused connection, a name that does not resolve or a connection
+	// that is dropped).
+	CategoryConnection ErrorCategory = "connection"
+	// CategoryTimeout is an endpoint that did not respond in time.
+	CategoryTimeout ErrorCategory = "timeout"
+	// CategoryTLS is a failed TLS handshake or an unacceptable
+	// certificate.
+	CategoryTLS ErrorCategory = "tls"
+	// CategoryStatus is an endpoint that responded with an unexpected
+	// status (such as an HTTP status code or a command exit code).
+	CategoryStatus ErrorCategory = "status"
+	// CategoryContent is a response (such as a body or command output)
+	// that does not meet the expectations of the check.
+	CategoryContent ErrorCategory = "content"
+	// CategorySetup is a ping that could not be made due to the setup of
+	// the pinger (such as an unreadable certificate file).
+	CategorySetup ErrorCategory = "setup"
+)
+
+// connectionErrorCategory returns the category of an error that occurred
+// while connecting to, or exchanging messages with, an endpoint: a timeout, a
+// TLS failure or, otherwise, a connection failure.
+func connectionErrorCategory(err error) ErrorCategory {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return CategoryTimeout
+	}
+	var chainErr *CertificateChainError
+	var verificationErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &chainErr) || errors.As(err, &verificationErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		// such as alerts sent by the server during the handshake
+		strings.Contains(err.Error(), "tls: ") {
+		return CategoryTLS
+	}
+	return CategoryConnection
 }
 
 // A Pinger interface implementation contacts a single endpoint according to
@@ -59,10 +129,85 @@ type Pinger interface {
 	Ping() (result Result, output *bytes.Buffer)
 }
 
+// A Retrier is implemented by Pingers that can tell if a failed ping is worth
+// retrying within the same ping cycle. A failed ping from a Pinger that does
+// not implement Retrier is always retried.
+type Retrier interface {
+	// ShouldRetry returns true if another attempt should be made after a
+	// ping that produced a given (failed) result.
+	ShouldRetry(result Result) bool
+}
+
+// A CycleStarter is implemented by Pingers that need to know when a ping
+// cycle (a ping along with all of its retry attempts) starts, such as to keep
+// pinging the same endpoint on retries.
+type CycleStarter interface {
+	// StartCycle is called before the first attempt of every ping.
+	StartCycle()
+}
+
+// A Warmer is implemented by Pingers that can keep connections to their
+// endpoint warm in between pings.
+type Warmer interface {
+	// KeepWarm keeps connections to the endpoint warm until stop is
+	// closed.
+	KeepWarm(stop <-chan struct{})
+}
+
+// A Closer is implemented by Pingers that hold on to resources (such as open
+// connections) in between pings, which are released by Close once the Pinger
+// is no longer used.
+type Closer interface {
+	Close() error
+}
+
+// An OutputError is the error of a ping that failed on the content of the
+// output, and whose message quotes an excerpt of the output.
+type OutputError struct {
+	Message string
+	// The excerpt of the output quoted by the Message.
+	Excerpt string
+}
+
+func (err *OutputError) Error() string {
+	return err.Message
+}
+
+// RedactOutput returns the message of an error with any excerpt of output that
+// it quotes (see OutputError) left out.
+func RedactOutput(err error) string {
+	message := err.Error()
+	var outputErr *OutputError
+	if errors.As(err, &outputErr) && outputErr.Excerpt != "" {
+		if i := strings.LastIndex(message, outputErr.Excerpt); i >= 0 {
+			message = message[:i] + redactedOutput + message[i+len(outputErr.Excerpt):]
+		}
+	}
+	return message
+}
+
 func (result Result) String() string {
 	return fmt.Sprintf("{Status: %s, Error: %v}", result.Status, result.Error)
 }
 
+// MarshalJSON implements the json.Marshaler interface for Result. The Error
+// is marshalled as its error message (or null, if there is no error).
+func (result Result) MarshalJSON() ([]byte, error) {
+	var errorMessage *string
+	if result.Error != nil {
+		message := result.Error.Error()
+		errorMessage = &message
+	}
+	return json.Marshal(struct {
+		Status        Status
+		Error         *string
+		Category      ErrorCategory `json:",omitempty"`
+		OutputChanged bool          `json:",omitempty"`
+		Summary       string        `json:",omitempty"`
+		TraceID       string        `json:",omitempty"`
+	}{result.Status, errorMessage, result.Category, result.OutputChanged, result.Summary, result.TraceID})
+}
+
 func (status Status) String() string {
 	return fmt.Sprintf("%s", statusStrings[status])
 }
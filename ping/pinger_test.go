@@ -0,0 +1,56 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestResultMarshalJSON(t *testing.T) {
+	failed := Result{Status: StatusNOK, Error: errors.New("ping failed: connection refused"), Category: CategoryConnection}
+	data, err := json.Marshal(failed)
+	if err != nil {
+		t.Fatalf("failed to marshal result: %s", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to parse marshalled result: %s", err)
+	}
+	if fields["Error"] != "ping failed: connection refused" || fields["Category"] != "connection" {
+		t.Errorf("unexpected marshalled failed result: %s", data)
+	}
+
+	data, err = json.Marshal(Result{Status: StatusOK})
+	if err != nil {
+		t.Fatalf("failed to marshal result: %s", err)
+	}
+	fields = nil
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to parse marshalled result: %s", err)
+	}
+	if errorField, ok := fields["Error"]; !ok || errorField != nil {
+		t.Errorf("expected a null Error for a successful result: %s", data)
+	}
+	if _, ok := fields["Category"]; ok {
+		t.Errorf("expected no Category for a successful result: %s", data)
+	}
+}
+
+func TestConnectionErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCategory
+	}{
+		{errors.New("dial tcp 127.0.0.1:1: connect: connection refused"), CategoryConnection},
+		{fmt.Errorf("request failed: %w", context.DeadlineExceeded), CategoryTimeout},
+		{&CertificateChainError{Problem: "self-signed certificate"}, CategoryTLS},
+		{errors.New("remote error: tls: bad certificate"), CategoryTLS},
+	}
+	for _, test := range tests {
+		if got := connectionErrorCategory(test.err); got != test.want {
+			t.Errorf("%v: got category %s, want %s", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ping
+
+import (
+	"github.com/petergardfjall/watcher/config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// sysvNotRunning is the exit code of a sysv init script status command for a
+// service that is not running (as per the LSB specification).
+const sysvNotRunning = 3
+
+// A ServicePinger pings a service by verifying over SSH that it is running
+// on a remote server.
+type ServicePinger struct {
+	Client CommandRunner
+	// The name of the service (unit).
+	Unit string
+	// The service manager of the server: systemd or sysv.
+	Manager string
+}
+
+// NewServicePinger creates a new ping.ServicePinger from a pinger
+// configuration.
+func NewServicePinger(pingerConfig *config.Pinger) (Pinger, error) {
+	log.Debugf("setting up service pinger ...")
+	var serviceCheck config.ServiceCheck
+	err := json.Unmarshal(pingerConfig.Check, &serviceCheck)
+	if err != nil {
+		return nil, fmt.Errorf("service pinger: illegal check: %s", err)
+	}
+	if err := serviceCheck.Validate(); err != nil {
+		return nil, fmt.Errorf("service pinger: invalid check: %s", err)
+	}
+
+	sshClient, err := NewSSHClient(NewSSHClientConfig(&serviceCheck.SSHTarget))
+	if err != nil {
+		return nil, fmt.Errorf("service pinger: failed to set up ssh client: %s", err)
+	}
+
+	manager := serviceCheck.Manager
+	if manager == "" {
+		manager = "systemd"
+	}
+	return &ServicePinger{Client: sshClient, Unit: serviceCheck.Unit, Manager: manager}, nil
+}
+
+// Ping pings the configured service for this ping.ServicePinger.
+func (servicePinger *ServicePinger) Ping() (result Result, output *bytes.Buffer) {
+	response, err := servicePinger.Client.Run(servicePinger.statusCommand())
+	if err != nil {
+		return Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: connectionErrorCategory(err)}, nil
+	}
+	output = response.Output
+
+	if err := servicePinger.checkStatus(response); err != nil {
+		return Result{Status: StatusNOK, Error: err, Category: CategoryStatus}, output
+	}
+	return Result{Status: StatusOK}, output
+}
+
+// SetDialer implements the DialerSetter interface.
+func (servicePinger *ServicePinger) SetDialer(dialer Dialer) {
+	if sshClient, ok := servicePinger.Client.(*SSHClient); ok {
+		sshClient.Dialer = dialer
+	}
+}
+
+// Close implements the Closer interface. It closes any connection kept open to
+// the server.
+func (servicePinger *ServicePinger) Close() error {
+	if closer, ok := servicePinger.Client.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// statusCommand returns the command that reports the status of the service.
+func (servicePinger *ServicePinger) statusCommand() string {
+	if servicePinger.Manager == "sysv" {
+		return fmt.Sprintf("service %s status", servicePinger.Unit)
+	}
+	return fmt.Sprintf("systemctl is-active %s", servicePinger.Unit)
+}
+
+// checkStatus interprets the result of the status command and returns an
+// error unless it shows that the service is running.
+func (servicePinger *ServicePinger) checkStatus(response *CommandResult) error {
+	if servicePinger.Manager == "sysv" {
+		switch response.ExitStatus {
+		case 0:
+			return nil
+		case sysvNotRunning:
+			return fmt.Errorf("service %s is not running", servicePinger.Unit)
+		default:
+			return fmt.Errorf("service %s is in an unknown state (exit code %d)", servicePinger.Unit, response.ExitStatus)
+		}
+	}
+
+	// systemctl is-active prints the state (such as active, inactive,
+	// failed, or activating) and exits with 0 only if the unit is active
+	state := strings.TrimSpace(response.Output.String())
+	if response.ExitStatus == 0 && state == "active" {
+		return nil
+	}
+	if state == "" {
+		state = fmt.Sprintf("unknown (exit code %d)", response.ExitStatus)
+	}
+	return fmt.Errorf("unit %s is not active: %s", servicePinger.Unit, state)
+}
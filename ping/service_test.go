@@ -0,0 +1,86 @@
+package ping
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+func TestServicePinger(t *testing.T) {
+	tests := []struct {
+		manager     string
+		exitStatus  int
+		output      string
+		wantCommand string
+		wantErr     string
+	}{
+		{"systemd", 0, "active\n", "systemctl is-active docker", ""},
+		{"systemd", 3, "inactive\n", "systemctl is-active docker", "unit docker is not active: inactive"},
+		{"systemd", 3, "failed\n", "systemctl is-active docker", "unit docker is not active: failed"},
+		{"systemd", 4, "", "systemctl is-active docker", "unit docker is not active: unknown (exit code 4)"},
+		{"sysv", 0, "docker is running", "service docker status", ""},
+		{"sysv", 3, "docker is stopped", "service docker status", "service docker is not running"},
+		{"sysv", 1, "", "service docker status", "service docker is in an unknown state (exit code 1)"},
+	}
+	for _, test := range tests {
+		runner := &fakeRunner{exitStatus: test.exitStatus, output: test.output}
+		pinger := &ServicePinger{Client: runner, Unit: "docker", Manager: test.manager}
+		result, output := pinger.Ping()
+		if len(runner.commands) != 1 || runner.commands[0] != test.wantCommand {
+			t.Errorf("%s: got commands %q, want %q", test.manager, runner.commands, test.wantCommand)
+		}
+		if output == nil || output.String() != test.output {
+			t.Errorf("%s: expected command output to be kept, got %v", test.manager, output)
+		}
+		if test.wantErr == "" {
+			if result.Status != StatusOK {
+				t.Errorf("%s, exit code %d: expected running service to pass: %v", test.manager, test.exitStatus, result.Error)
+			}
+			continue
+		}
+		if result.Status != StatusNOK || result.Category != CategoryStatus {
+			t.Errorf("%s, exit code %d: expected stopped service to fail the ping: %+v", test.manager, test.exitStatus, result)
+			continue
+		}
+		if result.Error.Error() != test.wantErr {
+			t.Errorf("%s, exit code %d: got error %q, want %q", test.manager, test.exitStatus, result.Error, test.wantErr)
+		}
+	}
+}
+
+func TestServicePingerConnectionFailure(t *testing.T) {
+	pinger := &ServicePinger{Client: &fakeRunner{err: errors.New("connection refused")}, Unit: "docker", Manager: "systemd"}
+	result, output := pinger.Ping()
+	if result.Status != StatusNOK || output != nil {
+		t.Fatalf("expected connection failure to fail the ping: %+v", result)
+	}
+	if result.Error.Error() != "ping failed: connection refused" {
+		t.Errorf("unexpected error: %s", result.Error)
+	}
+}
+
+func TestServiceCheckValidation(t *testing.T) {
+	password := "secret"
+	target := config.SSHTarget{Host: "localhost", Port: 22, Auth: config.SSHAuth{Username: "user", Password: &password}}
+	tests := []struct {
+		unit    string
+		manager string
+		valid   bool
+	}{
+		{"docker", "", true},
+		{"getty@tty1.service", "systemd", true},
+		{"nginx", "sysv", true},
+		{"nginx", "upstart", false},
+		{"", "systemd", false},
+		// the unit is part of a shell command
+		{"docker; reboot", "systemd", false},
+		{"$(reboot)", "systemd", false},
+	}
+	for _, test := range tests {
+		check := config.ServiceCheck{SSHTarget: target, Unit: test.unit, Manager: test.manager}
+		if err := check.Validate(); (err == nil) != test.valid {
+			t.Errorf("unit %q, manager %q: expected valid: %t, got error: %v", test.unit, test.manager, test.valid, err)
+		}
+	}
+}
@@ -3,13 +3,23 @@ package ping
 import (
 	"github.com/petergardfjall/watcher/config"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,9 +30,22 @@ import (
 
 // A SSHPinger pinger pings endpoints via the SSH protocol.
 type SSHPinger struct {
-	Client           *SSHClient
+	Client           CommandRunner
 	Command          string
 	ExpectedExitCode int
+	// Expected values at dotted paths of the (JSON) command output.
+	ExpectedJSONPaths map[string]string
+	// Patterns that fail the ping if matched by the command output.
+	ForbiddenOutput []*regexp.Regexp
+	// Pattern that the server version must match (if any).
+	ExpectedServerVersion *regexp.Regexp
+	// If true, the pinger compares the output of each ping to that of
+	// the previous ping and marks the result when the output changed.
+	AlertOnOutputChange bool
+
+	// hash of the output from the latest ping
+	outputHash *[sha256.Size]byte
+	lock       sync.Mutex
 }
 
 // NewSSHPinger creates a new ping.SSHPinger from a pinger configuration.
@@ -42,16 +65,27 @@ func NewSSHPinger(pingerConfig *config.Pinger) (Pinger, error) {
 		return nil, fmt.Errorf("ssh pinger: illegal command: %s", err)
 	}
 
-	sshClientConfig := NewSSHClientConfig(&sshCheck)
+	sshClientConfig := NewSSHClientConfig(&sshCheck.SSHTarget)
 	sshClient, err := NewSSHClient(sshClientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("ssh pinger: failed to set up ssh client: %s", err)
 	}
 
+	var forbiddenOutput []*regexp.Regexp
+	for _, pattern := range sshCheck.Expect.OutputForbidden {
+		forbiddenOutput = append(forbiddenOutput, regexp.MustCompile(pattern))
+	}
+
 	pinger := &SSHPinger{
-		Client:           sshClient,
-		Command:          command,
-		ExpectedExitCode: sshCheck.Expect.ExitCode,
+		Client:              sshClient,
+		Command:             command,
+		ExpectedExitCode:    sshCheck.Expect.ExitCode,
+		ExpectedJSONPaths:   sshCheck.Expect.JSONPath,
+		ForbiddenOutput:     forbiddenOutput,
+		AlertOnOutputChange: sshCheck.AlertOnOutputChange,
+	}
+	if sshCheck.ExpectServerVersion != "" {
+		pinger.ExpectedServerVersion = regexp.MustCompile(sshCheck.ExpectServerVersion)
 	}
 	return pinger, nil
 
@@ -61,22 +95,80 @@ func NewSSHPinger(pingerConfig *config.Pinger) (Pinger, error) {
 func (sshPinger *SSHPinger) Ping() (result Result, output *bytes.Buffer) {
 	response, err := sshPinger.Client.Run(sshPinger.Command)
 	if err != nil {
-		result = Result{StatusNOK, fmt.Errorf("ping failed: %s", err)}
+		result = Result{Status: StatusNOK, Error: fmt.Errorf("ping failed: %s", err), Category: connectionErrorCategory(err)}
 		output = nil
 		return
 	}
 
+	var outputChanged bool
+	if sshPinger.AlertOnOutputChange {
+		outputChanged = sshPinger.recordOutput(response.Output.Bytes())
+	}
+	summary := summarizeCommandResult(response)
+
+	if sshPinger.ExpectedServerVersion != nil && !sshPinger.ExpectedServerVersion.MatchString(response.ServerVersion) {
+		result = Result{Status: StatusNOK, Error: fmt.Errorf("server version %q does not match expected '%s'", response.ServerVersion, sshPinger.ExpectedServerVersion), Category: CategoryContent, OutputChanged: outputChanged, Summary: summary}
+		output = response.Output
+		return
+	}
+
 	if sshPinger.ExpectedExitCode != response.ExitStatus {
-		result = Result{StatusNOK, fmt.Errorf("expected exit code (%d) differs from actual (%d)", sshPinger.ExpectedExitCode, response.ExitStatus)}
+		result = Result{Status: StatusNOK, Error: fmt.Errorf("expected exit code (%d) differs from actual (%d)", sshPinger.ExpectedExitCode, response.ExitStatus), Category: CategoryStatus, OutputChanged: outputChanged, Summary: summary}
 		output = response.Output
 		return
 	}
 
-	result = Result{StatusOK, nil}
+	for _, forbidden := range sshPinger.ForbiddenOutput {
+		if match := forbidden.Find(response.Output.Bytes()); match != nil {
+			excerpt := fmt.Sprintf("%q", match)
+			err := &OutputError{Message: fmt.Sprintf("output matches forbidden pattern '%s': %s", forbidden, excerpt), Excerpt: excerpt}
+			result = Result{Status: StatusNOK, Error: err, Category: CategoryContent, OutputChanged: outputChanged, Summary: summary}
+			output = response.Output
+			return
+		}
+	}
+
+	if len(sshPinger.ExpectedJSONPaths) > 0 {
+		if err := checkJSONPaths(response.Output.Bytes(), sshPinger.ExpectedJSONPaths); err != nil {
+			result = Result{Status: StatusNOK, Error: err, Category: CategoryContent, OutputChanged: outputChanged, Summary: summary}
+			output = response.Output
+			return
+		}
+	}
+
+	result = Result{Status: StatusOK, OutputChanged: outputChanged, Summary: summary}
 	output = response.Output
 	return
 }
 
+// SetDialer implements the DialerSetter interface.
+func (sshPinger *SSHPinger) SetDialer(dialer Dialer) {
+	if sshClient, ok := sshPinger.Client.(*SSHClient); ok {
+		sshClient.Dialer = dialer
+	}
+}
+
+// Close implements the Closer interface. It closes any connection kept open to
+// the server.
+func (sshPinger *SSHPinger) Close() error {
+	if closer, ok := sshPinger.Client.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// recordOutput records (a hash of) the output of a ping and returns true if
+// it differs from the output of the previous ping.
+func (sshPinger *SSHPinger) recordOutput(output []byte) bool {
+	hash := sha256.Sum256(output)
+
+	sshPinger.lock.Lock()
+	defer sshPinger.lock.Unlock()
+	changed := sshPinger.outputHash != nil && *sshPinger.outputHash != hash
+	sshPinger.outputHash = &hash
+	return changed
+}
+
 // loadCommand returns the command that the pinger is configured to execute
 // (either via Command or CommandFile).
 func loadCommand(sshCheck *config.SSHCheck) (string, error) {
@@ -102,8 +194,23 @@ func loadCommand(sshCheck *config.SSHCheck) (string, error) {
 const (
 	// defaultSSHTimeout is the default SSH connection timeout to use.
 	defaultSSHTimeout = 30 * time.Second
+	// defaultSSHCommandTimeout is the default longest time that a command
+	// may run.
+	defaultSSHCommandTimeout = 1 * time.Minute
 )
 
+// hostCertAlgorithms are the host key algorithms to accept when the server
+// is expected to present a host certificate.
+var hostCertAlgorithms = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01,
+	ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA512v01,
+	ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSAv01,
+}
+
 // SSHClientConfig controls the behavior of a pinger.SSHClient
 type SSHClientConfig struct {
 	Username        string
@@ -113,34 +220,87 @@ type SSHClientConfig struct {
 	Host            string
 	Port            int
 	Timeout         time.Duration
+	// The longest time that a command may run (0 means the default).
+	CommandTimeout time.Duration
+	// Paths to public keys of certificate authorities trusted to sign
+	// the server's host certificate.
+	TrustedCAKeys []string
+	// The network to connect over (tcp, tcp4 or tcp6). Default: tcp.
+	Network string
+	// A SOCKS5 proxy (host:port) to connect through (if any).
+	Socks5Proxy string
+	// SHA-256 fingerprints of the host keys to accept the server with.
+	TrustedFingerprints []string
+	// A known_hosts file to verify the host key of the server against.
+	// Default: ~/.ssh/known_hosts (unless another means of verification is
+	// given).
+	KnownHostsFile string
+	// If true, the host key of the server is not verified.
+	InsecureIgnoreHostKey bool
+	// The passphrase that the private key at KeyPath is encrypted with.
+	KeyPassphrase string
+	// If true, the connection to the server is kept open and reused by
+	// subsequent commands (each command runs in a new session).
+	KeepAlive bool
 }
 
 // A SSHClient can be used to execute commands over SSH against remote servers.
 type SSHClient struct {
 	Config *SSHClientConfig
+	// Dialer to connect with (nil means the default).
+	Dialer Dialer
+
+	// connection kept open between commands (with Config.KeepAlive)
+	connection *ssh.Client
+	lock       sync.Mutex
+}
+
+// A CommandRunner executes commands against a remote server. It is
+// implemented by SSHClient.
+type CommandRunner interface {
+	Run(command string) (*CommandResult, error)
 }
 
 // CommandResult holds the result of executing a command via SSHClient.Run().
 type CommandResult struct {
 	ExitStatus int
 	Output     *bytes.Buffer
+	// The identification string of the server (such as
+	// "SSH-2.0-OpenSSH_8.9p1").
+	ServerVersion string
 }
 
-// NewSSHClientConfig converts a config.SSHCheck to a corresponding
+// NewSSHClientConfig converts a config.SSHTarget to a corresponding
 // SSHClientConfig.
-func NewSSHClientConfig(sshCheck *config.SSHCheck) *SSHClientConfig {
+func NewSSHClientConfig(target *config.SSHTarget) *SSHClientConfig {
 	var sshConfig = SSHClientConfig{
-		Host:            sshCheck.Host,
-		Port:            sshCheck.Port,
-		Username:        sshCheck.Auth.Username,
-		AgentForwarding: sshCheck.Auth.Agent,
+		Host:            target.Host,
+		Port:            target.Port,
+		Username:        target.Auth.Username,
+		AgentForwarding: target.Auth.Agent,
 	}
-	if sshCheck.Auth.Password != nil {
-		sshConfig.Password = *sshCheck.Auth.Password
+	if target.Auth.Password != nil {
+		sshConfig.Password = *target.Auth.Password
 	}
-	if sshCheck.Auth.Key != nil {
-		sshConfig.KeyPath = *sshCheck.Auth.Key
+	if target.Auth.Key != nil {
+		sshConfig.KeyPath = *target.Auth.Key
 	}
+	if target.Auth.KeyPassphrase != nil {
+		sshConfig.KeyPassphrase = *target.Auth.KeyPassphrase
+	}
+	if target.Timeout != nil {
+		sshConfig.Timeout = target.Timeout.Duration
+	}
+	if target.CommandTimeout != nil {
+		sshConfig.CommandTimeout = target.CommandTimeout.Duration
+	}
+	sshConfig.TrustedCAKeys = target.TrustedCAKeys
+	sshConfig.Network = target.Network
+	sshConfig.Socks5Proxy = target.Socks5Proxy
+	sshConfig.TrustedFingerprints = target.TrustedFingerprints
+	sshConfig.KnownHostsFile = target.KnownHostsFile
+	sshConfig.InsecureIgnoreHostKey = target.InsecureIgnoreHostKey
+	sshConfig.KeepAlive = target.KeepAlive
 
 	return &sshConfig
 }
@@ -169,14 +329,24 @@ func passwordAuth(password string) ssh.AuthMethod {
 	return ssh.Password(password)
 }
 
-// publicKeyAuth returns a public key authentication method.
-func publicKeyAuth(privateKeyPath string) (ssh.AuthMethod, error) {
+// publicKeyAuth returns a public key authentication method. The private key
+// is decrypted with the passphrase, if one is given.
+func publicKeyAuth(privateKeyPath, passphrase string) (ssh.AuthMethod, error) {
 	buffer, err := ioutil.ReadFile(privateKeyPath)
 	if err != nil {
 		return nil, err
 	}
 
-	key, err := ssh.ParsePrivateKey(buffer)
+	var key ssh.Signer
+	if passphrase != "" {
+		key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(passphrase))
+	} else {
+		key, err = ssh.ParsePrivateKey(buffer)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("private key %s is encrypted: a keyPassphrase is required", privateKeyPath)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -192,6 +362,97 @@ func agentAuth() (ssh.AuthMethod, error) {
 	return ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers), nil
 }
 
+// loadPublicKeys reads all public keys (in authorized_keys format) found in
+// a set of files.
+func loadPublicKeys(paths []string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for _, path := range paths {
+		rest, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for len(bytes.TrimSpace(rest)) > 0 {
+			var key ssh.PublicKey
+			key, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// certAuthorityCallback returns a host key callback that only accepts host
+// certificates signed by one of a given set of certificate authorities.
+func certAuthorityCallback(caKeys []ssh.PublicKey) ssh.HostKeyCallback {
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, caKey := range caKeys {
+				if bytes.Equal(auth.Marshal(), caKey.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+	return checker.CheckHostKey
+}
+
+// fingerprintCallback returns a host key callback that only accepts host keys
+// with one of a given set of SHA-256 fingerprints. Fingerprints are compared
+// in normalized form (see normalizeFingerprint).
+func fingerprintCallback(fingerprints []string) ssh.HostKeyCallback {
+	trusted := make(map[string]bool, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		trusted[normalizeFingerprint(fingerprint)] = true
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if trusted[normalizeFingerprint(fingerprint)] {
+			return nil
+		}
+		return fmt.Errorf("host key of %s has untrusted fingerprint %s", hostname, fingerprint)
+	}
+}
+
+// normalizeFingerprint returns a SHA-256 fingerprint in the form output by
+// ssh-keygen (SHA256:<base64>, without padding). Whitespace and base64
+// padding are dropped and the SHA256: prefix may be in any case. A
+// fingerprint given as hex digits (in any case, optionally separated by
+// colons) is converted to base64. The base64 digits themselves are case
+// sensitive and left as is.
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.Join(strings.Fields(fingerprint), "")
+	if len(fingerprint) >= len("SHA256:") && strings.EqualFold(fingerprint[:len("SHA256:")], "SHA256:") {
+		fingerprint = fingerprint[len("SHA256:"):]
+	}
+	if digest, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1)); err == nil && len(digest) == sha256.Size {
+		return "SHA256:" + base64.RawStdEncoding.EncodeToString(digest)
+	}
+	return "SHA256:" + strings.TrimRight(fingerprint, "=")
+}
+
+// knownHostsCallback returns a HostKeyCallback that only accepts servers whose
+// host key is listed for them in a known_hosts file.
+func knownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf("host %s is not in known hosts file %s", hostname, path)
+			}
+			return fmt.Errorf("host key mismatch: host key of %s (%s) differs from the one in %s:%d", hostname, ssh.FingerprintSHA256(key), keyErr.Want[0].Filename, keyErr.Want[0].Line)
+		}
+		return err
+	}, nil
+}
+
 // clientConfig creates an ssh.ClientConfig to use for a single call of
 // pinger.SSHClient.Run()
 func (client *SSHClient) clientConfig() (*ssh.ClientConfig, error) {
@@ -203,7 +464,7 @@ func (client *SSHClient) clientConfig() (*ssh.ClientConfig, error) {
 	}
 	if client.Config.KeyPath != "" {
 		log.Debugf("using public key auth")
-		keyAuth, err := publicKeyAuth(client.Config.KeyPath)
+		keyAuth, err := publicKeyAuth(client.Config.KeyPath, client.Config.KeyPassphrase)
 		if err != nil {
 			return nil, fmt.Errorf("failed to set up public key auth: %s", err)
 		}
@@ -224,60 +485,222 @@ func (client *SSHClient) clientConfig() (*ssh.ClientConfig, error) {
 	} else {
 		timeout = defaultSSHTimeout
 	}
-	return &ssh.ClientConfig{
+	sshConfig := &ssh.ClientConfig{
 		User:    client.Config.Username,
 		Timeout: timeout,
 		Auth:    authMethods,
-	}, nil
+	}
+
+	switch {
+	case client.Config.InsecureIgnoreHostKey:
+		log.Debugf("not verifying host key")
+		sshConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	case len(client.Config.TrustedCAKeys) > 0:
+		log.Debugf("verifying host certificate against trusted CAs")
+		caKeys, err := loadPublicKeys(client.Config.TrustedCAKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load trusted CA keys: %s", err)
+		}
+		sshConfig.HostKeyCallback = certAuthorityCallback(caKeys)
+		// ask the server to present its host certificate
+		sshConfig.HostKeyAlgorithms = hostCertAlgorithms
+	case len(client.Config.TrustedFingerprints) > 0:
+		log.Debugf("verifying host key against trusted fingerprints")
+		sshConfig.HostKeyCallback = fingerprintCallback(client.Config.TrustedFingerprints)
+	default:
+		path := client.Config.KnownHostsFile
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("no host key verification given and no default known hosts file: %s", err)
+			}
+			path = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		log.Debugf("verifying host key against known hosts file %s", path)
+		callback, err := knownHostsCallback(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known hosts file: %s", err)
+		}
+		sshConfig.HostKeyCallback = callback
+	}
+	return sshConfig, nil
 }
 
-// connect connects to a remote server (according to the config of the
-// SSHClient) and establishes an SSH session.
-func (client *SSHClient) connect() (*ssh.Session, error) {
+// dial connects to a remote server (according to the config of the
+// SSHClient).
+func (client *SSHClient) dial() (*ssh.Client, error) {
 	hostPort := fmt.Sprintf("%s:%d", client.Config.Host, client.Config.Port)
 	clientConfig, err := client.clientConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debugf("Connecting %s@%s ...", clientConfig.User, hostPort)
-	connection, err := ssh.Dial("tcp", hostPort, clientConfig)
+	network := "tcp"
+	if client.Config.Network != "" {
+		network = client.Config.Network
+	}
+
+	var connection *ssh.Client
+	if client.Dialer != nil {
+		log.Debugf("Connecting %s@%s over %s via dialer ...", clientConfig.User, hostPort, network)
+		connection, err = dialVia(client.Dialer, network, hostPort, clientConfig)
+	} else if client.Config.Socks5Proxy != "" {
+		log.Debugf("Connecting %s@%s over %s via SOCKS5 proxy %s ...", clientConfig.User, hostPort, network, client.Config.Socks5Proxy)
+		connection, err = dialViaSocks5(client.Config.Socks5Proxy, network, hostPort, clientConfig)
+	} else {
+		log.Debugf("Connecting %s@%s over %s ...", clientConfig.User, hostPort, network)
+		connection, err = ssh.Dial(network, hostPort, clientConfig)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("%s", err)
+		return nil, fmt.Errorf("%w", err)
+	}
+	log.Debugf("Connected.")
+	return connection, nil
+}
+
+// connect establishes an SSH session with a remote server and returns it along
+// with the connection that it runs on. The returned function ends the session.
+// Unless Config.KeepAlive is set, a new connection is made, which is closed
+// along with the session. With Config.KeepAlive, the open connection from a
+// previous session is reused. Should the server have dropped that connection,
+// it is transparently redialed (once).
+func (client *SSHClient) connect() (*ssh.Client, *ssh.Session, func(), error) {
+	if !client.Config.KeepAlive {
+		connection, err := client.dial()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		session, err := connection.NewSession()
+		if err != nil {
+			connection.Close()
+			return nil, nil, nil, fmt.Errorf("failed to establish session: %s", err)
+		}
+		return connection, session, func() { session.Close(); connection.Close() }, nil
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.connection != nil {
+		session, err := client.connection.NewSession()
+		if err == nil {
+			return client.connection, session, func() { session.Close() }, nil
+		}
+		log.Debugf("kept-alive connection to %s lost, redialing: %s", client.Config.Host, err)
+		client.connection.Close()
+		client.connection = nil
 	}
 
+	connection, err := client.dial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	session, err := connection.NewSession()
 	if err != nil {
-		return nil, fmt.Errorf("failed to establish session: %s", err)
+		connection.Close()
+		return nil, nil, nil, fmt.Errorf("failed to establish session: %s", err)
 	}
-	log.Debugf("Connected.")
-	return session, nil
+	client.connection = connection
+	return connection, session, func() { session.Close() }, nil
+}
+
+// Close closes the connection kept open by the SSHClient (if any). The
+// SSHClient can still be used afterwards, in which case it reconnects.
+func (client *SSHClient) Close() error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.connection == nil {
+		return nil
+	}
+	err := client.connection.Close()
+	client.connection = nil
+	return err
+}
+
+// dialViaSocks5 establishes an SSH connection to a server (hostPort) by
+// tunneling through a SOCKS5 proxy.
+func dialViaSocks5(proxyAddr, network, hostPort string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, &net.Dialer{Timeout: clientConfig.Timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up socks5 proxy: %s", err)
+	}
+	conn, err := dialer.Dial(network, hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect via socks5 proxy %s: %w", proxyAddr, err)
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, hostPort, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
 
+// dialVia establishes an SSH connection to a server (hostPort) over a
+// connection made by a given Dialer.
+func dialVia(dialer Dialer, network, hostPort string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clientConfig.Timeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, network, hostPort)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, hostPort, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, channels, requests), nil
 }
 
 // Run executes a command against a remote server (according to the config
 // set for the SSHClient) and returns a CommandResult which indicates the
-// command execution result. On connection problems, an error is returned.
+// command execution result. On connection problems, an error is returned, as
+// it is if the command does not complete within the command timeout (in which
+// case it is killed).
 func (client *SSHClient) Run(command string) (*CommandResult, error) {
-	session, err := client.connect()
+	release := sshConnectionLimiter.acquire(client.Config.Host)
+	defer release()
+
+	connection, session, closeSession, err := client.connect()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect: %s", err)
+		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
-	defer session.Close()
+	defer closeSession()
 
-	var result = CommandResult{ExitStatus: 0}
+	var result = CommandResult{ExitStatus: 0, ServerVersion: string(connection.ServerVersion())}
 	var writer SharedWriter
 	session.Stdout = &writer
 	session.Stderr = &writer
 	result.Output = &writer.buffer
 
-	if err := session.Run(command); err != nil {
+	timeout := defaultSSHCommandTimeout
+	if client.Config.CommandTimeout != 0 {
+		timeout = client.Config.CommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		log.Debugf("command timed out after %s: killing it", timeout)
+		session.Signal(ssh.SIGKILL)
+		// the session is closed on return, which makes session.Run return
+		return nil, fmt.Errorf("command timed out after %s: %w", timeout, ctx.Err())
+	}
+
+	if err != nil {
 		log.Debugf("command failed: %s", err)
 		switch err := err.(type) {
 		case *ssh.ExitError:
 			result.ExitStatus = err.ExitStatus()
 		default:
 			result.ExitStatus = -1
+			// the connection may be broken: redial on next command
+			if client.Config.KeepAlive {
+				client.Close()
+			}
 		}
 	}
 
@@ -0,0 +1,594 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server that accepts any password. It
+// runs a command by printing "ok", except for commands starting with "sleep",
+// which never finish.
+type testSSHServer struct {
+	host string
+	port int
+
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+// newTestSigner generates an ed25519 key to sign with.
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to create signer: %s", err)
+	}
+	return signer
+}
+
+// startTestSSHServer starts a testSSHServer that identifies itself with a
+// given version and host key. It is stopped when the test finishes.
+func startTestSSHServer(t *testing.T, version string, hostKey ssh.Signer) *testSSHServer {
+	t.Helper()
+	serverConfig := &ssh.ServerConfig{
+		ServerVersion: version,
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	server := &testSSHServer{host: "127.0.0.1", port: listener.Addr().(*net.TCPAddr).Port}
+	t.Cleanup(func() {
+		listener.Close()
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		for _, conn := range server.conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.lock.Lock()
+			server.conns = append(server.conns, conn)
+			server.lock.Unlock()
+			go server.serve(conn, serverConfig)
+		}
+	}()
+	return server
+}
+
+// serve runs the commands of the sessions of a client connection.
+func (server *testSSHServer) serve(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					request.Reply(false, nil)
+					continue
+				}
+				request.Reply(true, nil)
+				var exec struct{ Command string }
+				if ssh.Unmarshal(request.Payload, &exec) != nil || strings.HasPrefix(exec.Command, "sleep") {
+					continue
+				}
+				channel.Write([]byte("ok"))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+			}
+		}()
+	}
+}
+
+// pinger creates an SSHPinger for a check against the testSSHServer, which
+// is completed with the target and credentials of the server.
+func (server *testSSHServer) pinger(t *testing.T, check map[string]interface{}) Pinger {
+	t.Helper()
+	check["host"] = server.host
+	check["port"] = server.port
+	check["auth"] = map[string]string{"username": "user", "password": "secret"}
+	if _, ok := check["command"]; !ok {
+		check["command"] = "uptime"
+	}
+	rawCheck, err := json.Marshal(check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinger, err := NewSSHPinger(&config.Pinger{Name: "test", Type: "ssh", Check: rawCheck})
+	if err != nil {
+		t.Fatalf("failed to create pinger: %s", err)
+	}
+	return pinger
+}
+
+// fakeRunner is a CommandRunner that responds to every command with a given
+// exit status and output, and records the commands it is asked to run.
+type fakeRunner struct {
+	exitStatus int
+	output     string
+	err        error
+	commands   []string
+}
+
+func (runner *fakeRunner) Run(command string) (*CommandResult, error) {
+	runner.commands = append(runner.commands, command)
+	if runner.err != nil {
+		return nil, runner.err
+	}
+	return &CommandResult{ExitStatus: runner.exitStatus, Output: bytes.NewBufferString(runner.output)}, nil
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	digest := sha256.Sum256([]byte("host key"))
+	want := "SHA256:" + base64.RawStdEncoding.EncodeToString(digest[:])
+
+	hexDigits := hex.EncodeToString(digest[:])
+	var hexPairs []string
+	for i := 0; i < len(hexDigits); i += 2 {
+		hexPairs = append(hexPairs, hexDigits[i:i+2])
+	}
+	for _, fingerprint := range []string{
+		want,
+		"  " + want + "\n",
+		"sha256:" + strings.TrimPrefix(want, "SHA256:"),
+		"SHA256:" + base64.StdEncoding.EncodeToString(digest[:]),
+		"SHA256:" + hexDigits,
+		"SHA256:" + strings.ToUpper(hexDigits),
+		"sha256:" + strings.Join(hexPairs, ":"),
+	} {
+		if got := normalizeFingerprint(fingerprint); got != want {
+			t.Errorf("%q: expected %s, got %s", fingerprint, want, got)
+		}
+	}
+	// base64 digits are case sensitive
+	if got := normalizeFingerprint(strings.ToLower(want)); got == want {
+		t.Errorf("expected base64 fingerprint to be case sensitive")
+	}
+}
+
+func TestFingerprintCallback(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	hostKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("failed to create host key: %s", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(hostKey)
+
+	callback := fingerprintCallback([]string{" sha256:" + strings.TrimPrefix(fingerprint, "SHA256:") + "= "})
+	if err := callback("host", nil, hostKey); err != nil {
+		t.Errorf("expected host key to be trusted: %s", err)
+	}
+	callback = fingerprintCallback([]string{"SHA256:" + base64.RawStdEncoding.EncodeToString(make([]byte, sha256.Size))})
+	if err := callback("host", nil, hostKey); err == nil {
+		t.Errorf("expected host key to be untrusted")
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	hostKey := newTestSigner(t)
+	server := startTestSSHServer(t, "SSH-2.0-OpenSSH_7.4", hostKey)
+
+	tests := []struct {
+		expect     string
+		wantStatus Status
+	}{
+		{"", StatusOK},
+		{`^SSH-2\.0-OpenSSH_7\.4$`, StatusOK},
+		{`OpenSSH_(8|9)\.`, StatusNOK},
+	}
+	for _, test := range tests {
+		pinger := server.pinger(t, map[string]interface{}{
+			"trustedFingerprints": []string{ssh.FingerprintSHA256(hostKey.PublicKey())},
+			"expectServerVersion": test.expect,
+		})
+		result, output := pinger.Ping()
+		if result.Status != test.wantStatus {
+			t.Errorf("%q: got status %s, want %s (%v)", test.expect, result.Status, test.wantStatus, result.Error)
+			continue
+		}
+		if output.String() != "ok" {
+			t.Errorf("%q: unexpected output: %q", test.expect, output.String())
+		}
+		if result.Status == StatusNOK && (result.Category != CategoryContent || !strings.Contains(result.Error.Error(), "SSH-2.0-OpenSSH_7.4")) {
+			t.Errorf("%q: expected error naming the server version, got %s: %s", test.expect, result.Category, result.Error)
+		}
+	}
+}
+
+func TestServerVersionValidation(t *testing.T) {
+	check := config.SSHCheck{
+		SSHTarget:           config.SSHTarget{Host: "localhost", Port: 22, Auth: config.SSHAuth{Username: "user", Agent: true}},
+		Command:             "uptime",
+		ExpectServerVersion: "OpenSSH_7",
+	}
+	if err := check.Validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	check.ExpectServerVersion = "OpenSSH_(7"
+	if err := check.Validate(); err == nil {
+		t.Errorf("expected illegal expectServerVersion to be rejected")
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	hostKey := newTestSigner(t)
+	server := startTestSSHServer(t, "SSH-2.0-test", hostKey)
+	fingerprint := ssh.FingerprintSHA256(hostKey.PublicKey())
+
+	for _, keepAlive := range []bool{false, true} {
+		pinger := server.pinger(t, map[string]interface{}{
+			"trustedFingerprints": []string{fingerprint},
+			"commandTimeout":      "200ms",
+			"keepAlive":           keepAlive,
+			"command":             "sleep 3600",
+		})
+		start := time.Now()
+		result, _ := pinger.Ping()
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("keepAlive %t: command not stopped at timeout, took %s", keepAlive, elapsed)
+		}
+		if result.Status != StatusNOK || result.Category != CategoryTimeout || !strings.Contains(result.Error.Error(), "timed out after 200ms") {
+			t.Errorf("keepAlive %t: expected timeout, got %s (%s): %v", keepAlive, result.Status, result.Category, result.Error)
+		}
+
+		// the client remains usable after a timed out command
+		sshPinger := pinger.(*SSHPinger)
+		sshPinger.Command = "uptime"
+		if result, _ := pinger.Ping(); result.Status != StatusOK {
+			t.Errorf("keepAlive %t: ping after timeout failed: %v", keepAlive, result.Error)
+		}
+		sshPinger.Close()
+	}
+}
+
+// blockingDialer is a Dialer whose connections never complete.
+type blockingDialer struct{}
+
+func (blockingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestConnectTimeout(t *testing.T) {
+	hostKey := newTestSigner(t)
+	server := startTestSSHServer(t, "SSH-2.0-test", hostKey)
+	pinger := server.pinger(t, map[string]interface{}{
+		"trustedFingerprints": []string{ssh.FingerprintSHA256(hostKey.PublicKey())},
+		"timeout":             "100ms",
+	})
+	pinger.(*SSHPinger).SetDialer(blockingDialer{})
+
+	result, _ := pinger.Ping()
+	if result.Status != StatusNOK || result.Category != CategoryTimeout {
+		t.Errorf("expected connect timeout, got %s (%s): %v", result.Status, result.Category, result.Error)
+	}
+}
+
+func TestCommandTimeoutValidation(t *testing.T) {
+	target := config.SSHTarget{Host: "localhost", Port: 22, Auth: config.SSHAuth{Username: "user", Agent: true}, CommandTimeout: &config.Duration{Duration: time.Second}}
+	if err := target.Validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	target.CommandTimeout = &config.Duration{}
+	if err := target.Validate(); err == nil {
+		t.Errorf("expected zero commandTimeout to be rejected")
+	}
+}
+
+// newHostCertSigner creates a host key signer that presents a host
+// certificate, for a given principal, signed by a certificate authority.
+func newHostCertSigner(t *testing.T, ca ssh.Signer, principal string) ssh.Signer {
+	t.Helper()
+	hostKey := newTestSigner(t)
+	cert := &ssh.Certificate{
+		Key:             hostKey.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{principal},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("failed to sign host certificate: %s", err)
+	}
+	signer, err := ssh.NewCertSigner(cert, hostKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate signer: %s", err)
+	}
+	return signer
+}
+
+func TestTrustedCAKeys(t *testing.T) {
+	ca := newTestSigner(t)
+	caKeyFile := filepath.Join(t.TempDir(), "ca.pub")
+	if err := os.WriteFile(caKeyFile, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hostKey ssh.Signer
+		wantOK  bool
+	}{
+		{"signed by trusted CA", newHostCertSigner(t, ca, "127.0.0.1"), true},
+		{"signed for other host", newHostCertSigner(t, ca, "other.example.com"), false},
+		{"signed by untrusted CA", newHostCertSigner(t, newTestSigner(t), "127.0.0.1"), false},
+		{"unsigned", newTestSigner(t), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startTestSSHServer(t, "SSH-2.0-test", test.hostKey)
+			pinger := server.pinger(t, map[string]interface{}{"trustedCAKeys": []string{caKeyFile}})
+			result, _ := pinger.Ping()
+			if (result.Status == StatusOK) != test.wantOK {
+				t.Errorf("got status %s, want OK: %t (%v)", result.Status, test.wantOK, result.Error)
+			}
+		})
+	}
+}
+
+func TestCertAuthorityCallback(t *testing.T) {
+	ca := newTestSigner(t)
+	callback := certAuthorityCallback([]ssh.PublicKey{ca.PublicKey()})
+	address := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+
+	if err := callback("host:22", address, newHostCertSigner(t, ca, "host").PublicKey()); err != nil {
+		t.Errorf("expected CA-signed host certificate to be accepted: %s", err)
+	}
+	if err := callback("host:22", address, newTestSigner(t).PublicKey()); err == nil {
+		t.Errorf("expected plain host key to be rejected")
+	}
+}
+
+func TestSSHNetwork(t *testing.T) {
+	hostKey := newTestSigner(t)
+	// the server only listens on IPv4
+	server := startTestSSHServer(t, "SSH-2.0-test", hostKey)
+	for network, want := range map[string]Status{"tcp4": StatusOK, "tcp6": StatusNOK} {
+		pinger := server.pinger(t, map[string]interface{}{
+			"trustedFingerprints": []string{ssh.FingerprintSHA256(hostKey.PublicKey())},
+			"network":             network,
+		})
+		if result, _ := pinger.Ping(); result.Status != want {
+			t.Errorf("%s: got %s, want %s (%v)", network, result.Status, want, result.Error)
+		}
+	}
+}
+
+func TestOutputChange(t *testing.T) {
+	pinger := &SSHPinger{AlertOnOutputChange: true}
+	for i, step := range []struct {
+		output  string
+		changed bool
+	}{
+		// the first output has nothing to compare with
+		{"version 1.0", false},
+		{"version 1.0", false},
+		{"version 1.1", true},
+		{"version 1.1", false},
+		{"", true},
+	} {
+		if changed := pinger.recordOutput([]byte(step.output)); changed != step.changed {
+			t.Errorf("step %d (%q): got changed %t, want %t", i, step.output, changed, step.changed)
+		}
+	}
+}
+
+func TestForbiddenOutput(t *testing.T) {
+	forbidden := []*regexp.Regexp{regexp.MustCompile(`WARNING: .*degraded`), regexp.MustCompile(`(?i)panic`)}
+	tests := []struct {
+		name       string
+		exitStatus int
+		output     string
+		wantErr    string
+	}{
+		{"clean", 0, "ok\n", ""},
+		{"forbidden despite exit 0", 0, "ok\nWARNING: disk sda degraded\n", `output matches forbidden pattern 'WARNING: .*degraded': "WARNING: disk sda degraded"`},
+		{"second pattern", 0, "Kernel PANIC\n", `output matches forbidden pattern '(?i)panic': "PANIC"`},
+		// the exit code is checked first
+		{"forbidden with failing exit code", 1, "WARNING: disk sda degraded\n", "expected exit code (0) differs from actual (1)"},
+	}
+	for _, test := range tests {
+		pinger := &SSHPinger{Client: &fakeRunner{exitStatus: test.exitStatus, output: test.output}, Command: "health", ForbiddenOutput: forbidden}
+		result, output := pinger.Ping()
+		if test.wantErr == "" {
+			if result.Status != StatusOK {
+				t.Errorf("%s: expected output without forbidden patterns to pass: %v", test.name, result.Error)
+			}
+			continue
+		}
+		if result.Status != StatusNOK {
+			t.Errorf("%s: expected the ping to fail", test.name)
+			continue
+		}
+		if result.Error.Error() != test.wantErr {
+			t.Errorf("%s: got error %q, want %q", test.name, result.Error, test.wantErr)
+		}
+		if output == nil || output.String() != test.output {
+			t.Errorf("%s: expected command output to be kept, got %v", test.name, output)
+		}
+	}
+
+	// the matched output can be left out of alerts
+	pinger := &SSHPinger{Client: &fakeRunner{output: "WARNING: disk sda degraded"}, ForbiddenOutput: forbidden}
+	result, _ := pinger.Ping()
+	if result.Category != CategoryContent {
+		t.Errorf("got category %q, want %q", result.Category, CategoryContent)
+	}
+	if redacted := RedactOutput(result.Error); strings.Contains(redacted, "sda") {
+		t.Errorf("expected the matched output to be redacted, got %q", redacted)
+	}
+}
+
+func TestForbiddenOutputValidation(t *testing.T) {
+	for pattern, valid := range map[string]bool{"WARNING: .*degraded": true, "(unclosed": false} {
+		expect := config.SSHExpectation{OutputForbidden: []string{pattern}}
+		if err := expect.Validate(); (err == nil) != valid {
+			t.Errorf("%q: expected valid: %t, got error: %v", pattern, valid, err)
+		}
+	}
+}
+
+// socks5Proxy is a minimal SOCKS5 proxy (without authentication) that records
+// the addresses that it connects to.
+type socks5Proxy struct {
+	address string
+
+	lock    sync.Mutex
+	targets []string
+}
+
+func startSocks5Proxy(t *testing.T) *socks5Proxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	proxy := &socks5Proxy{address: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serve(conn)
+		}
+	}()
+	return proxy
+}
+
+// serve handles a CONNECT request of a client and relays its traffic.
+func (proxy *socks5Proxy) serve(client net.Conn) {
+	defer client.Close()
+	// greeting: version, number of methods and methods
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(client, greeting); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(client, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	client.Write([]byte{5, 0})
+
+	// request: version, command, reserved, address type, address and port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(client, request); err != nil {
+		return
+	}
+	var host []byte
+	switch request[3] {
+	case 1:
+		host = make([]byte, net.IPv4len)
+	case 4:
+		host = make([]byte, net.IPv6len)
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(client, length); err != nil {
+			return
+		}
+		host = make([]byte, length[0])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(client, host); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(client, port); err != nil {
+		return
+	}
+	hostName := string(host)
+	if request[3] != 3 {
+		hostName = net.IP(host).String()
+	}
+	target := net.JoinHostPort(hostName, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	proxy.lock.Lock()
+	proxy.targets = append(proxy.targets, target)
+	proxy.lock.Unlock()
+
+	server, err := net.Dial("tcp", target)
+	if err != nil {
+		client.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer server.Close()
+	client.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(server, client)
+	io.Copy(client, server)
+}
+
+func TestDialViaSocks5(t *testing.T) {
+	hostKey := newTestSigner(t)
+	server := startTestSSHServer(t, "SSH-2.0-test", hostKey)
+	proxy := startSocks5Proxy(t)
+
+	pinger := server.pinger(t, map[string]interface{}{
+		"trustedFingerprints": []string{ssh.FingerprintSHA256(hostKey.PublicKey())},
+		"socks5Proxy":         proxy.address,
+	})
+	if result, _ := pinger.Ping(); result.Status != StatusOK {
+		t.Fatalf("ping via proxy failed: %v", result.Error)
+	}
+	proxy.lock.Lock()
+	targets := proxy.targets
+	proxy.lock.Unlock()
+	if want := net.JoinHostPort(server.host, strconv.Itoa(server.port)); len(targets) != 1 || targets[0] != want {
+		t.Errorf("expected the proxy to connect to %s, got %v", want, targets)
+	}
+
+	// a proxy that cannot be reached fails the ping
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	unreachable.Close()
+	pinger = server.pinger(t, map[string]interface{}{
+		"trustedFingerprints": []string{ssh.FingerprintSHA256(hostKey.PublicKey())},
+		"socks5Proxy":         unreachable.Addr().String(),
+	})
+	result, _ := pinger.Ping()
+	if result.Status != StatusNOK || result.Category != CategoryConnection || !strings.Contains(result.Error.Error(), "socks5 proxy") {
+		t.Errorf("expected the unreachable proxy to fail the ping, got %s (%s): %v", result.Status, result.Category, result.Error)
+	}
+}
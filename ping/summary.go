@@ -0,0 +1,57 @@
+package ping
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSummaryLineLength is the longest line of output (in bytes) to include
+// in a summary. Longer lines are cut short.
+const maxSummaryLineLength = 120
+
+// summarizeHTTPResponse summarizes a HTTP response by its status, content
+// type and size, as in "200 OK, text/html, 5120 bytes". If truncated, the
+// body (of a given length) was cut short and its full size is unknown.
+func summarizeHTTPResponse(response *http.Response, bodyLength int64, truncated bool) string {
+	parts := []string{response.Status}
+	if contentType := response.Header.Get("Content-Type"); contentType != "" {
+		parts = append(parts, contentType)
+	}
+	if truncated {
+		parts = append(parts, fmt.Sprintf("more than %d bytes", bodyLength-1))
+	} else {
+		parts = append(parts, fmt.Sprintf("%d bytes", bodyLength))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// summarizeCommandResult summarizes the result of a command by its exit code
+// and the last (non-empty) line of its output, as in "exit code 0: /dev/sda1
+// 40G 12G 28G 30% /".
+func summarizeCommandResult(result *CommandResult) string {
+	summary := fmt.Sprintf("exit code %d", result.ExitStatus)
+	if result.Output == nil {
+		return summary
+	}
+	output := bytes.TrimRight(result.Output.Bytes(), " \t\r\n")
+	if len(output) == 0 {
+		return summary
+	}
+	lastLine := output[bytes.LastIndexByte(output, '\n')+1:]
+	return summary + ": " + cutLine(string(bytes.TrimSpace(lastLine)), maxSummaryLineLength)
+}
+
+// cutLine cuts a line down to at most a given number of bytes (marking it as
+// cut with "...") without splitting a multi-byte character.
+func cutLine(line string, max int) string {
+	if len(line) <= max {
+		return line
+	}
+	for max > 0 && !utf8.RuneStart(line[max]) {
+		max--
+	}
+	return line[:max] + "..."
+}
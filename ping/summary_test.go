@@ -0,0 +1,50 @@
+package ping
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSummary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>hello</html>"))
+	}))
+	defer server.Close()
+	pinger := newTestHTTPPinger(t, map[string]interface{}{"url": server.URL, "expect": map[string]int{"statusCode": 200}})
+
+	result, _ := pinger.Ping()
+	if result.Summary != "200 OK, text/html, 18 bytes" {
+		t.Errorf("unexpected summary: %q", result.Summary)
+	}
+	resultJSON, _ := json.Marshal(result)
+	if !strings.Contains(string(resultJSON), `"Summary":"200 OK, text/html, 18 bytes"`) {
+		t.Errorf("summary missing from status JSON: %s", resultJSON)
+	}
+}
+
+func TestCommandSummary(t *testing.T) {
+	for _, test := range []struct {
+		result  CommandResult
+		summary string
+	}{
+		{CommandResult{ExitStatus: 1, Output: bytes.NewBufferString("Filesystem Size\n/dev/sda1 40G 30%\n\n")}, "exit code 1: /dev/sda1 40G 30%"},
+		{CommandResult{ExitStatus: 0, Output: &bytes.Buffer{}}, "exit code 0"},
+		{CommandResult{ExitStatus: 0}, "exit code 0"},
+		{CommandResult{ExitStatus: 0, Output: bytes.NewBufferString(strings.Repeat("x", 200))}, "exit code 0: " + strings.Repeat("x", maxSummaryLineLength) + "..."},
+	} {
+		if summary := summarizeCommandResult(&test.result); summary != test.summary {
+			t.Errorf("expected summary %q, got %q", test.summary, summary)
+		}
+	}
+}
+
+func TestCutLineKeepsCharactersWhole(t *testing.T) {
+	if line := cutLine(strings.Repeat("é", 100), 9); line != "éééé..." {
+		t.Errorf("unexpected cut line: %q", line)
+	}
+}
@@ -0,0 +1,58 @@
+package ping
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// TracingW3C propagates traces with a W3C Trace Context traceparent
+	// header.
+	TracingW3C = "w3c"
+	// TracingB3 propagates traces with (multi-header) B3 headers.
+	TracingB3 = "b3"
+)
+
+// setTraceHeaders starts a new (sampled) trace for a request by setting the
+// headers that propagate it in a given format (TracingW3C or TracingB3). It
+// returns the ID of the trace.
+func setTraceHeaders(req *http.Request, format string) (string, error) {
+	traceID, err := randomID(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate trace id: %s", err)
+	}
+	spanID, err := randomID(8)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate span id: %s", err)
+	}
+
+	switch format {
+	case TracingW3C:
+		req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", traceID, spanID))
+	case TracingB3:
+		req.Header.Set("X-B3-TraceId", traceID)
+		req.Header.Set("X-B3-SpanId", spanID)
+		req.Header.Set("X-B3-Sampled", "1")
+	default:
+		return "", fmt.Errorf("unknown tracing format: '%s'", format)
+	}
+	return traceID, nil
+}
+
+// randomID generates a random, non-zero ID of a given number of bytes and
+// returns it hex-encoded.
+func randomID(size int) (string, error) {
+	id := make([]byte, size)
+	for {
+		if _, err := rand.Read(id); err != nil {
+			return "", err
+		}
+		for _, b := range id {
+			if b != 0 {
+				return hex.EncodeToString(id), nil
+			}
+		}
+	}
+}
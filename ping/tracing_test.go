@@ -0,0 +1,108 @@
+package ping
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/petergardfjall/watcher/config"
+)
+
+// traceparentPattern matches a sampled W3C traceparent header, capturing its
+// trace id.
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-[0-9a-f]{16}-01$`)
+
+func TestTracingHeaders(t *testing.T) {
+	var lock sync.Mutex
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		headers = append(headers, r.Header.Clone())
+		// the trace id is recorded whatever the outcome of the ping
+		if len(headers) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		format  string
+		traceID func(header http.Header) string
+	}{
+		{TracingW3C, func(header http.Header) string {
+			match := traceparentPattern.FindStringSubmatch(header.Get("traceparent"))
+			if match == nil {
+				return ""
+			}
+			return match[1]
+		}},
+		{TracingB3, func(header http.Header) string {
+			if len(header.Get("X-B3-SpanId")) != 16 || header.Get("X-B3-Sampled") != "1" {
+				return ""
+			}
+			return header.Get("X-B3-TraceId")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			lock.Lock()
+			headers = nil
+			lock.Unlock()
+			pinger := newTestHTTPPinger(t, map[string]interface{}{
+				"url":     server.URL,
+				"tracing": test.format,
+				"expect":  map[string]int{"statusCode": 200},
+			})
+
+			var traceIDs []string
+			for _, wantStatus := range []Status{StatusOK, StatusNOK} {
+				result, _ := pinger.Ping()
+				if result.Status != wantStatus {
+					t.Fatalf("unexpected status: %s (%v)", result.Status, result.Error)
+				}
+				lock.Lock()
+				header := headers[len(headers)-1]
+				lock.Unlock()
+				traceID := test.traceID(header)
+				if traceID == "" {
+					t.Fatalf("no valid trace headers sent: %v", header)
+				}
+				if result.TraceID != traceID {
+					t.Errorf("recorded trace id %q does not match the header: %q", result.TraceID, traceID)
+				}
+				traceIDs = append(traceIDs, traceID)
+			}
+			if traceIDs[0] == traceIDs[1] {
+				t.Errorf("expected a new trace id per ping, got %q twice", traceIDs[0])
+			}
+		})
+	}
+}
+
+func TestTraceIDInStatus(t *testing.T) {
+	result := Result{Status: StatusOK, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("failed to marshal result: %s", err)
+	}
+	var decoded struct{ TraceID string }
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("failed to parse result: %s", err)
+	}
+	if decoded.TraceID != result.TraceID {
+		t.Errorf("trace id not in status: %s", encoded)
+	}
+}
+
+func TestTracingValidation(t *testing.T) {
+	for format, valid := range map[string]bool{"": true, "w3c": true, "b3": true, "zipkin": false} {
+		check := config.HTTPCheck{URL: "http://localhost", Tracing: format, Expect: config.HTTPExpectation{StatusCode: 200}}
+		if err := check.Validate(); (err == nil) != valid {
+			t.Errorf("%q: expected valid: %t, got error: %v", format, valid, err)
+		}
+	}
+}
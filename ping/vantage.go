@@ -0,0 +1,48 @@
+package ping
+
+import (
+	"github.com/petergardfjall/watcher/config"
+
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// defaultDialTimeout is the connection timeout of vantage Dialers.
+const defaultDialTimeout = 30 * time.Second
+
+// A Dialer makes network connections.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// A DialerSetter is a Pinger that can make its connections through a given
+// Dialer, which lets it ping from a certain vantage.
+type DialerSetter interface {
+	SetDialer(dialer Dialer)
+}
+
+// NewVantageDialer creates a Dialer that makes connections from a vantage
+// (either from a local source address or through a SOCKS5 proxy).
+func NewVantageDialer(vantage *config.Vantage) (Dialer, error) {
+	dialer := &net.Dialer{Timeout: defaultDialTimeout}
+	if vantage.SourceAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(vantage.SourceAddress)}
+	}
+	if vantage.Socks5Proxy == "" {
+		return dialer, nil
+	}
+
+	socksDialer, err := proxy.SOCKS5("tcp", vantage.Socks5Proxy, nil, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("vantage %s: failed to set up socks5 proxy: %s", vantage.Name, err)
+	}
+	contextDialer, ok := socksDialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("vantage %s: socks5 dialer does not support contexts", vantage.Name)
+	}
+	return contextDialer, nil
+}
@@ -4,6 +4,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/op/go-logging"
 
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/petergardfjall/watcher/engine"
@@ -13,9 +15,8 @@ import (
 
 var log = logging.MustGetLogger("server")
 
-const (
-	protocol = "https"
-)
+// Scheme is the URL scheme that the Server publishes its REST API over.
+const Scheme = "https"
 
 // A Server publishes information about a pinger Engine over HTTP.
 type Server struct {
@@ -23,6 +24,8 @@ type Server struct {
 	httpServer *http.Server
 	certFile   string
 	keyFile    string
+	// time at which the Server was created
+	startTime time.Time
 }
 
 // NewServer creates a new Server running on a given port and publishing
@@ -32,6 +35,7 @@ func NewServer(engine *engine.Engine, port int, certFile, keyFile string) (*Serv
 	server.engine = engine
 	server.certFile = certFile
 	server.keyFile = keyFile
+	server.startTime = time.Now()
 
 	router := mux.NewRouter()
 	router.Handle(
@@ -43,6 +47,45 @@ func NewServer(engine *engine.Engine, port int, certFile, keyFile string) (*Serv
 	router.Handle(
 		"/pingers/{name}/output", http.HandlerFunc(server.pingerOutput)).
 		Methods("GET")
+	router.Handle(
+		"/pingers/{name}/history", http.HandlerFunc(server.pingerHistory)).
+		Methods("GET")
+	router.Handle(
+		"/pingers/{name}/ack", http.HandlerFunc(server.pingerAck)).
+		Methods("POST")
+	router.Handle(
+		"/pingers/{name}/trigger", http.HandlerFunc(server.pingerTrigger)).
+		Methods("POST")
+	router.Handle(
+		"/pingers/{name}/silence", http.HandlerFunc(server.pingerSilence)).
+		Methods("POST")
+	router.Handle(
+		"/pingers/{name}/silence", http.HandlerFunc(server.pingerUnsilence)).
+		Methods("DELETE")
+	router.Handle(
+		"/groups/{group}/run", http.HandlerFunc(server.groupRun)).
+		Methods("POST")
+	router.Handle(
+		"/status", http.HandlerFunc(server.status)).
+		Methods("GET")
+	router.Handle(
+		"/config", http.HandlerFunc(server.exportConfig)).
+		Methods("GET")
+	router.Handle(
+		"/score", http.HandlerFunc(server.healthScore)).
+		Methods("GET")
+	router.Handle(
+		"/metrics", http.HandlerFunc(server.metrics)).
+		Methods("GET")
+	router.Handle(
+		"/healthz", http.HandlerFunc(server.healthz)).
+		Methods("GET")
+	router.Handle(
+		"/alerting/pause", http.HandlerFunc(server.pauseAlerting)).
+		Methods("POST")
+	router.Handle(
+		"/alerting/resume", http.HandlerFunc(server.resumeAlerting)).
+		Methods("POST")
 
 	server.httpServer = &http.Server{
 		Addr:        fmt.Sprintf(":%d", port),
@@ -63,31 +106,97 @@ func (server *Server) Start() error {
 		server.certFile, server.keyFile)
 }
 
+// Stop gracefully shuts down the server, waiting for active requests to
+// finish until the context is done.
+func (server *Server) Stop(ctx context.Context) error {
+	log.Infof("stopping server ...")
+	return server.httpServer.Shutdown(ctx)
+}
+
 // pingers is a REST API endpoint that returns a list of pingers for the engine.
 func (server *Server) pingers(w http.ResponseWriter, r *http.Request) {
 	var pingerUrls []string
-	for _, pinger := range server.engine.Pingers {
-		url := fmt.Sprintf("%s://%s/pingers/%s", protocol, r.Host, pinger.Name)
+	pingers, failed := server.engine.Pingers(), server.engine.FailedPingers()
+	for _, pinger := range pingers {
+		url := fmt.Sprintf("%s://%s/pingers/%s", Scheme, r.Host, pinger.Name)
+		pingerUrls = append(pingerUrls, url)
+	}
+	for name := range failed {
+		url := fmt.Sprintf("%s://%s/pingers/%s", Scheme, r.Host, name)
 		pingerUrls = append(pingerUrls, url)
 	}
 
 	respondWithJSON(w, r, pingerUrls)
 }
 
+// PingerStatus is the status of a pinger as published by the REST API.
+type PingerStatus struct {
+	Description string `json:",omitempty"`
+	// Silenced is true if all alerts for the pinger are muted.
+	Silenced bool
+	// Time when the silence of the pinger expires (only given if silenced).
+	SilencedUntil *time.Time `json:",omitempty"`
+	// Why the pinger was silenced (only given if silenced).
+	SilenceReason string `json:",omitempty"`
+	// Flapping is true if the pinger changes state too often for its state
+	// transitions to be alerted on.
+	Flapping bool
+	// Time since which the pinger has been flapping (only given if
+	// flapping).
+	FlappingSince *time.Time `json:",omitempty"`
+	engine.PingerTaskStatus
+}
+
+// pingerStatusOf returns the status, as published by the REST API, of a
+// pinger given its current task status.
+func (server *Server) pingerStatusOf(pinger *engine.PingerTask, taskStatus engine.PingerTaskStatus) PingerStatus {
+	status := PingerStatus{Description: pinger.Description, PingerTaskStatus: taskStatus}
+	if silence, ok := server.engine.Silenced(pinger.Name); ok {
+		until := silence.Until
+		status.Silenced = true
+		status.SilencedUntil = &until
+		status.SilenceReason = silence.Reason
+	}
+	if since, ok := server.engine.Flapping(pinger.Name); ok {
+		status.Flapping = true
+		status.FlappingSince = &since
+	}
+	return status
+}
+
+// FailedPingerStatus is the status, as published by the REST API, of a pinger
+// that was skipped (in best-effort mode or on reload) since it could not be
+// set up.
+type FailedPingerStatus struct {
+	Failed bool
+	Error  string
+}
+
 // pingerStatus is a REST API endpoint that returns the current status of a
 // given pinger.
 func (server *Server) pingerStatus(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 	log.Debugf("getPingerStatus on %s", pathVars["name"])
 
+	// pingers that run from several vantages report an aggregate
+	if aggregate, ok := server.engine.VantageAggregate(pathVars["name"]); ok {
+		respondWithJSON(w, r, aggregate)
+		return
+	}
+
+	if err, ok := server.engine.FailedPingers()[pathVars["name"]]; ok {
+		respondWithJSON(w, r, FailedPingerStatus{Failed: true, Error: err.Error()})
+		return
+	}
+
 	// verify that requested pinger exists
-	pinger, ok := server.engine.Pingers[pathVars["name"]]
+	pinger, ok := server.engine.Pinger(pathVars["name"])
 	if !ok {
 		http.Error(w, fmt.Sprintf("%s: requested pinger does not exist", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
 		return
 	}
 
-	respondWithJSON(w, r, pinger.Status)
+	respondWithJSON(w, r, server.pingerStatusOf(pinger, pinger.Snapshot()))
 
 }
 
@@ -98,28 +207,268 @@ func (server *Server) pingerOutput(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("getPingerStatus on %s", pathVars["name"])
 
 	// verify that requested pinger exists
-	pinger, ok := server.engine.Pingers[pathVars["name"]]
+	pinger, ok := server.engine.Pinger(pathVars["name"])
 	if !ok {
 		http.Error(w, fmt.Sprintf("%s: requested pinger does not exist", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
 		return
 	}
 
-	if pinger.Output == nil {
+	encoding := r.URL.Query().Get("encoding")
+	if encoding != "" && encoding != "base64" {
+		http.Error(w, fmt.Sprintf("%s: illegal encoding: '%s' (only base64 is supported)", http.StatusText(http.StatusBadRequest), encoding), http.StatusBadRequest)
+		return
+	}
+
+	output := pinger.OutputBytes()
+	if output == nil {
 		http.Error(w, fmt.Sprintf("%s: no output recorded by pinger", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	_, err := w.Write(pinger.Output.Bytes())
+	switch {
+	case encoding == "base64":
+		w.Header().Set("Content-Type", "text/plain")
+		output = []byte(base64.StdEncoding.EncodeToString(output))
+	case pinger.OutputIsBinary():
+		w.Header().Set("Content-Type", "application/octet-stream")
+	default:
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	if changedAt := pinger.OutputChangeTime(); changedAt != nil {
+		w.Header().Set("Last-Modified", changedAt.Format(http.TimeFormat))
+	}
+	_, err := w.Write(output)
+	if err != nil {
+		log.Errorf("failed to write response on %s: %s", r.RequestURI, err)
+	}
+
+}
+
+// pingerHistory is a REST API endpoint that returns the most recent pings of
+// a given pinger, oldest first.
+func (server *Server) pingerHistory(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+	log.Debugf("pingerHistory on %s", pathVars["name"])
+
+	// verify that requested pinger exists
+	pinger, ok := server.engine.Pinger(pathVars["name"])
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s: requested pinger does not exist", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
+		return
+	}
+
+	respondWithJSON(w, r, pinger.History())
+}
+
+// pingerAck is a REST API endpoint that acknowledges a failing pinger,
+// suppressing reminder alerts for it until it recovers or, if a snooze
+// duration is given as a query parameter, until the snooze expires.
+func (server *Server) pingerAck(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+	log.Debugf("pingerAck on %s", pathVars["name"])
+
+	var snooze time.Duration
+	if value := r.URL.Query().Get("snooze"); value != "" {
+		var err error
+		snooze, err = time.ParseDuration(value)
+		if err != nil || snooze < 0 {
+			http.Error(w, fmt.Sprintf("%s: illegal snooze duration: '%s'", http.StatusText(http.StatusBadRequest), value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ack, err := server.engine.Acknowledge(pathVars["name"], snooze)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusNotFound), err), http.StatusNotFound)
+		return
+	}
+	respondWithJSON(w, r, ack)
+}
+
+// pingerTrigger is a REST API endpoint that makes a given pinger ping right
+// away and returns its resulting status (aggregated, for a pinger that runs
+// from several vantages).
+func (server *Server) pingerTrigger(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+	log.Debugf("pingerTrigger on %s", pathVars["name"])
+
+	// verify that requested pinger exists
+	pinger, ok := server.engine.Pinger(pathVars["name"])
+	if !ok {
+		if _, ok = server.engine.VantageAggregate(pathVars["name"]); !ok {
+			http.Error(w, fmt.Sprintf("%s: requested pinger does not exist", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
+			return
+		}
+	}
+
+	statuses, err := server.engine.Trigger(pathVars["name"])
+	if err == engine.ErrPingInFlight {
+		http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusConflict), err), http.StatusConflict)
+		return
+	}
 	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusServiceUnavailable), err), http.StatusServiceUnavailable)
+		return
+	}
+	if pinger == nil {
+		aggregate, ok := server.engine.VantageAggregate(pathVars["name"])
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s: requested pinger does not exist", http.StatusText(http.StatusNotFound)), http.StatusNotFound)
+			return
+		}
+		respondWithJSON(w, r, aggregate)
+		return
+	}
+	respondWithJSON(w, r, server.pingerStatusOf(pinger, statuses[pinger.Name]))
+}
+
+// SilenceRequest is the body of a request to silence a pinger.
+type SilenceRequest struct {
+	// How long to silence the pinger for (for example, "1h").
+	Duration string `json:"duration"`
+	// Why the pinger is silenced (optional).
+	Reason string `json:"reason"`
+}
+
+// pingerSilence is a REST API endpoint that mutes all alerts for a given
+// pinger for the duration given in the request body.
+func (server *Server) pingerSilence(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+	log.Debugf("pingerSilence on %s", pathVars["name"])
+
+	var request SilenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("%s: illegal silence request: %s", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil || duration <= 0 {
+		http.Error(w, fmt.Sprintf("%s: illegal silence duration: '%s'", http.StatusText(http.StatusBadRequest), request.Duration), http.StatusBadRequest)
+		return
+	}
+
+	silence, err := server.engine.Silence(pathVars["name"], duration, request.Reason)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusNotFound), err), http.StatusNotFound)
+		return
+	}
+	respondWithJSON(w, r, silence)
+}
+
+// pingerUnsilence is a REST API endpoint that ends the silence of a given
+// pinger.
+func (server *Server) pingerUnsilence(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+	log.Debugf("pingerUnsilence on %s", pathVars["name"])
+
+	if err := server.engine.Unsilence(pathVars["name"]); err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusNotFound), err), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// groupRun is a REST API endpoint that pings all pingers of a given group
+// right away and returns their combined result.
+func (server *Server) groupRun(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+	log.Debugf("groupRun on %s", pathVars["group"])
+
+	run, err := server.engine.RunGroup(pathVars["group"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusNotFound), err), http.StatusNotFound)
+		return
+	}
+	respondWithJSON(w, r, run)
+}
+
+// status is a REST API endpoint that returns a summary of all configured
+// pingers.
+func (server *Server) status(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, server.engine.Summary())
+}
+
+// exportConfig is a REST API endpoint that returns the effective
+// configuration of the engine (with secrets redacted).
+func (server *Server) exportConfig(w http.ResponseWriter, r *http.Request) {
+	engineConf, err := server.engine.Config()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: failed to export config: %s", http.StatusText(http.StatusInternalServerError), err), http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, r, engineConf)
+}
+
+// healthScore is a REST API endpoint that returns the weighted health score
+// of all pingers.
+func (server *Server) healthScore(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, server.engine.HealthScore())
+}
+
+// metrics is a REST API endpoint that exposes metrics of the pingers in the
+// Prometheus text format.
+func (server *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := server.engine.Metrics.WriteText(w); err != nil {
 		log.Errorf("failed to write response on %s: %s", r.RequestURI, err)
 	}
+}
+
+// Health describes the liveness of the watcher itself (as opposed to that of
+// the pinged endpoints).
+type Health struct {
+	Status  string `json:"status"`
+	Pingers int    `json:"pingers"`
+	Uptime  string `json:"uptime"`
+	// The number of pingers that are producing results (only given with a
+	// liveness configuration).
+	Reporting *int `json:"reporting,omitempty"`
+}
+
+// healthz is a REST API endpoint for liveness probes. It responds as long as
+// the server is up, regardless of the statuses of the pingers. With a
+// liveness configuration, it responds with 503 Service Unavailable if too few
+// pingers are producing results.
+func (server *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	uptime := time.Since(server.startTime).Round(time.Second)
+	health := Health{Status: "ok", Pingers: len(server.engine.Pingers()), Uptime: uptime.String()}
+	if liveness := server.engine.Liveness(); liveness != nil {
+		health.Reporting = &liveness.Reporting
+		if !liveness.Live {
+			health.Status = "stalled"
+			respondWithJSONStatus(w, r, http.StatusServiceUnavailable, health)
+			return
+		}
+	}
+	respondWithJSON(w, r, health)
+}
+
+// AlertingState describes whether alerting is paused.
+type AlertingState struct {
+	Paused bool
+}
 
+// pauseAlerting is a REST API endpoint that pauses all alerting.
+func (server *Server) pauseAlerting(w http.ResponseWriter, r *http.Request) {
+	server.engine.PauseAlerting()
+	respondWithJSON(w, r, AlertingState{Paused: server.engine.AlertingPaused()})
+}
+
+// resumeAlerting is a REST API endpoint that resumes (paused) alerting.
+func (server *Server) resumeAlerting(w http.ResponseWriter, r *http.Request) {
+	server.engine.ResumeAlerting()
+	respondWithJSON(w, r, AlertingState{Paused: server.engine.AlertingPaused()})
 }
 
 // Produces a JSON response to a HTTP request with a given object which is
 // marshalled to json.
 func respondWithJSON(w http.ResponseWriter, r *http.Request, object interface{}) {
+	respondWithJSONStatus(w, r, http.StatusOK, object)
+}
+
+// Produces a JSON response with a given status code to a HTTP request with a
+// given object which is marshalled to json.
+func respondWithJSONStatus(w http.ResponseWriter, r *http.Request, statusCode int, object interface{}) {
 	response, err := json.MarshalIndent(object, "", "    ")
 	if err != nil {
 		http.Error(w, fmt.Sprintf("%s: failed to marshal response: %s", http.StatusText(http.StatusInternalServerError), err), http.StatusInternalServerError)
@@ -127,6 +476,7 @@ func respondWithJSON(w http.ResponseWriter, r *http.Request, object interface{})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	_, err = w.Write(response)
 	if err != nil {
 		log.Errorf("failed to write response on %s: %s", r.RequestURI, err)
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/petergardfjall/watcher/config"
+	"github.com/petergardfjall/watcher/engine"
+)
+
+// testHTTPPinger returns the configuration of an http pinger against a given
+// URL, which runs once an hour.
+func testHTTPPinger(name, url string) config.Pinger {
+	return config.Pinger{
+		Name:  name,
+		Type:  "http",
+		Check: json.RawMessage(fmt.Sprintf(`{"url": %q, "expect": {"statusCode": 200}}`, url)),
+		Schedule: &config.Schedule{
+			Interval: &config.Duration{Duration: time.Hour},
+			Retries:  &config.Retries{Attempts: 1},
+		},
+	}
+}
+
+// newTestServer creates a Server (that is not started) for an Engine created
+// from a given configuration.
+func newTestServer(t *testing.T, engineConf *config.Engine) *Server {
+	t.Helper()
+	eng, err := engine.NewEngine(engineConf, "http://localhost", false)
+	if err != nil {
+		t.Fatalf("failed to create engine: %s", err)
+	}
+	server, err := NewServer(eng, 0, "", "")
+	if err != nil {
+		t.Fatalf("failed to create server: %s", err)
+	}
+	return server
+}
+
+// serve serves a request with a given method and path on a Server and returns
+// the recorded response.
+func serve(server *Server, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+// triggerPinger triggers a ping of a pinger of a started Server (retrying
+// while the pinger is not yet running).
+func triggerPinger(t *testing.T, server *Server, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response := serve(server, "POST", "/pingers/"+name+"/trigger")
+		if response.Code == http.StatusOK {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to trigger pinger %s: %d", name, response.Code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFailedPingerAfterReload(t *testing.T) {
+	server := newTestServer(t, &config.Engine{Pingers: []config.Pinger{testHTTPPinger("good", "http://127.0.0.1:1")}})
+	err := server.engine.Reload(&config.Engine{Pingers: []config.Pinger{
+		testHTTPPinger("good", "http://127.0.0.1:1"),
+		testHTTPPinger("bad", ""),
+	}})
+	if err != nil {
+		t.Fatalf("reload failed: %s", err)
+	}
+
+	response := serve(server, "GET", "/pingers/")
+	var urls []string
+	if err := json.Unmarshal(response.Body.Bytes(), &urls); err != nil {
+		t.Fatalf("failed to parse pinger list: %s", err)
+	}
+	listed := strings.Join(urls, " ")
+	if !strings.Contains(listed, "/pingers/good") || !strings.Contains(listed, "/pingers/bad") {
+		t.Errorf("pinger list lacks a pinger: %v", urls)
+	}
+
+	response = serve(server, "GET", "/pingers/bad")
+	if response.Code != http.StatusOK {
+		t.Fatalf("unexpected status code for failed pinger: %d", response.Code)
+	}
+	var status FailedPingerStatus
+	if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to parse failed pinger status: %s", err)
+	}
+	if !status.Failed || status.Error == "" {
+		t.Errorf("pinger not reported as failed with its error: %+v", status)
+	}
+}
+
+// TestHandlersDuringUpdates reads pinger statuses and outputs over the REST
+// API while the pinger keeps updating them (run with -race).
+func TestHandlersDuringUpdates(t *testing.T) {
+	var requests int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "response %d", atomic.AddInt64(&requests, 1))
+	}))
+	defer target.Close()
+
+	pingerConf := testHTTPPinger("busy", target.URL)
+	pingerConf.Schedule.Interval = &config.Duration{Duration: time.Millisecond}
+	server := newTestServer(t, &config.Engine{Pingers: []config.Pinger{pingerConf}})
+	server.engine.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.engine.Stop(ctx); err != nil {
+			t.Errorf("failed to stop engine: %s", err)
+		}
+	}()
+
+	// keep reading until the pinger has updated its status a number of
+	// times
+	deadline := time.Now().Add(10 * time.Second)
+	var wg sync.WaitGroup
+	for _, path := range []string{"/pingers/", "/pingers/busy", "/pingers/busy/output", "/pingers/busy/history", "/status", "/score", "/metrics"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for atomic.LoadInt64(&requests) < 20 && time.Now().Before(deadline) {
+				// there is no output until the first ping
+				if response := serve(server, "GET", path); response.Code != http.StatusOK && response.Code != http.StatusNotFound {
+					t.Errorf("%s: unexpected status code: %d", path, response.Code)
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	if pings := atomic.LoadInt64(&requests); pings < 20 {
+		t.Errorf("expected the pinger to keep pinging, got %d pings", pings)
+	}
+}
+
+func TestAcknowledge(t *testing.T) {
+	server := newTestServer(t, &config.Engine{Pingers: []config.Pinger{testHTTPPinger("test", "http://127.0.0.1:1")}})
+
+	response := serve(server, "POST", "/pingers/test/ack?snooze=1h")
+	if response.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", response.Code)
+	}
+	var ack engine.Acknowledgement
+	if err := json.Unmarshal(response.Body.Bytes(), &ack); err != nil {
+		t.Fatalf("failed to parse acknowledgement: %s", err)
+	}
+	if ack.Pinger != "test" || ack.Until == nil {
+		t.Errorf("unexpected acknowledgement: %+v", ack)
+	}
+
+	if response := serve(server, "POST", "/pingers/test/ack?snooze=-1h"); response.Code != http.StatusBadRequest {
+		t.Errorf("expected illegal snooze to be rejected, got: %d", response.Code)
+	}
+	if response := serve(server, "POST", "/pingers/nope/ack"); response.Code != http.StatusNotFound {
+		t.Errorf("expected unknown pinger to be rejected, got: %d", response.Code)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	server := newTestServer(t, &config.Engine{Pingers: []config.Pinger{
+		testHTTPPinger("b", "http://127.0.0.1:2"),
+		testHTTPPinger("a", "http://127.0.0.1:1"),
+	}})
+
+	response := serve(server, "GET", "/status")
+	if response.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", response.Code)
+	}
+	var summaries []engine.PingerSummary
+	if err := json.Unmarshal(response.Body.Bytes(), &summaries); err != nil {
+		t.Fatalf("failed to parse summary: %s", err)
+	}
+	want := []engine.PingerSummary{
+		{Name: "a", Type: "http", Target: "http://127.0.0.1:1", Interval: "1h0m0s", Enabled: true},
+		{Name: "b", Type: "http", Target: "http://127.0.0.1:2", Interval: "1h0m0s", Enabled: true},
+	}
+	if len(summaries) != len(want) {
+		t.Fatalf("expected one entry per configured pinger, got %+v", summaries)
+	}
+	for i, summary := range summaries {
+		if summary != want[i] {
+			t.Errorf("got summary %+v, want %+v", summary, want[i])
+		}
+	}
+}
+
+func TestPingerDescription(t *testing.T) {
+	pingerConf := testHTTPPinger("web", "http://127.0.0.1:1")
+	pingerConf.Description = "Public web site, see runbook/web.md"
+	server := newTestServer(t, &config.Engine{Pingers: []config.Pinger{pingerConf}})
+
+	response := serve(server, "GET", "/pingers/web")
+	if response.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", response.Code)
+	}
+	var status PingerStatus
+	if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to parse status: %s", err)
+	}
+	if status.Description != pingerConf.Description {
+		t.Errorf("got description %q, want %q", status.Description, pingerConf.Description)
+	}
+}
+
+func TestOutputOfRedactedPinger(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "token=abc")
+	}))
+	defer target.Close()
+
+	pingerConf := testHTTPPinger("secret", target.URL)
+	pingerConf.RedactOutputInAlerts = true
+	server := newTestServer(t, &config.Engine{Pingers: []config.Pinger{pingerConf}})
+	server.engine.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.engine.Stop(ctx); err != nil {
+			t.Errorf("failed to stop engine: %s", err)
+		}
+	}()
+
+	triggerPinger(t, server, "secret")
+	// output is only redacted in alerts, the REST API still serves it
+	response := serve(server, "GET", "/pingers/secret/output")
+	if response.Code != http.StatusOK || response.Body.String() != "token=abc" {
+		t.Errorf("unexpected output: %d: %q", response.Code, response.Body.String())
+	}
+	if _, err := http.ParseTime(response.Header().Get("Last-Modified")); err != nil {
+		t.Errorf("expected the time the output changed, got Last-Modified %q", response.Header().Get("Last-Modified"))
+	}
+}
+
+func TestGroupRun(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	var pingers []config.Pinger
+	for _, name := range []string{"a", "b"} {
+		pingerConf := testHTTPPinger(name, target.URL)
+		pingerConf.Groups = []string{"web"}
+		pingers = append(pingers, pingerConf)
+	}
+	pingers = append(pingers, testHTTPPinger("other", "http://127.0.0.1:1"))
+	server := newTestServer(t, &config.Engine{Pingers: pingers})
+	server.engine.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.engine.Stop(ctx); err != nil {
+			t.Errorf("failed to stop engine: %s", err)
+		}
+	}()
+
+	// the pingers may not be running right after the engine is started
+	deadline := time.Now().Add(5 * time.Second)
+	var run engine.GroupRun
+	for {
+		response := serve(server, "POST", "/groups/web/run")
+		if response.Code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", response.Code)
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &run); err != nil {
+			t.Fatalf("failed to parse group run: %s", err)
+		}
+		if run.OK || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !run.OK || len(run.Pingers) != 2 || run.Pingers["a"].Status == nil || run.Pingers["b"].Status == nil {
+		t.Errorf("unexpected group run: %+v", run)
+	}
+
+	if response := serve(server, "POST", "/groups/none/run"); response.Code != http.StatusNotFound {
+		t.Errorf("expected unknown group to be rejected, got: %d", response.Code)
+	}
+}
+
+func TestBinaryOutput(t *testing.T) {
+	// the start of a DER-encoded certificate
+	binary := []byte{0x30, 0x82, 0x03, 0x00, 0xff, 0xfe}
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/binary" {
+			w.Write(binary)
+			return
+		}
+		fmt.Fprint(w, "plain text\n")
+	}))
+	defer target.Close()
+
+	server := newTestServer(t, &config.Engine{Pingers: []config.Pinger{
+		testHTTPPinger("binary", target.URL+"/binary"),
+		testHTTPPinger("text", target.URL+"/text"),
+	}})
+	server.engine.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.engine.Stop(ctx); err != nil {
+			t.Errorf("failed to stop engine: %s", err)
+		}
+	}()
+	triggerPinger(t, server, "binary")
+	triggerPinger(t, server, "text")
+
+	response := serve(server, "GET", "/pingers/binary/output")
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/octet-stream" {
+		t.Errorf("unexpected content type of binary output: %s", contentType)
+	}
+	if !bytes.Equal(response.Body.Bytes(), binary) {
+		t.Errorf("binary output not served intact: %x", response.Body.Bytes())
+	}
+	response = serve(server, "GET", "/pingers/binary/output?encoding=base64")
+	decoded, err := base64.StdEncoding.DecodeString(response.Body.String())
+	if err != nil || !bytes.Equal(decoded, binary) {
+		t.Errorf("base64-encoded output does not round-trip: %x (%v)", decoded, err)
+	}
+
+	response = serve(server, "GET", "/pingers/text/output")
+	if contentType := response.Header().Get("Content-Type"); contentType != "text/plain" || response.Body.String() != "plain text\n" {
+		t.Errorf("text output changed: %s: %q", contentType, response.Body.String())
+	}
+	response = serve(server, "GET", "/pingers/text/output?encoding=base64")
+	if response.Body.String() != base64.StdEncoding.EncodeToString([]byte("plain text\n")) {
+		t.Errorf("unexpected base64-encoded text output: %q", response.Body.String())
+	}
+
+	if response := serve(server, "GET", "/pingers/text/output?encoding=hex"); response.Code != http.StatusBadRequest {
+		t.Errorf("expected unsupported encoding to be rejected, got: %d", response.Code)
+	}
+}
+
+func TestHealthzLiveness(t *testing.T) {
+	server := newTestServer(t, &config.Engine{
+		Liveness: &config.Liveness{MinReporting: 1},
+		Pingers:  []config.Pinger{testHTTPPinger("down", "http://127.0.0.1:1")},
+	})
+
+	// pingers that are not running produce no results
+	response := serve(server, "GET", "/healthz")
+	var health Health
+	if err := json.Unmarshal(response.Body.Bytes(), &health); err != nil {
+		t.Fatalf("failed to parse health: %s", err)
+	}
+	if response.Code != http.StatusServiceUnavailable || health.Status != "stalled" || *health.Reporting != 0 {
+		t.Errorf("expected stalled engine to be unhealthy: %d: %+v", response.Code, health)
+	}
+
+	// a failing pinger still reports
+	server.engine.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.engine.Stop(ctx); err != nil {
+			t.Errorf("failed to stop engine: %s", err)
+		}
+	}()
+	triggerPinger(t, server, "down")
+	response = serve(server, "GET", "/healthz")
+	health = Health{}
+	if err := json.Unmarshal(response.Body.Bytes(), &health); err != nil {
+		t.Fatalf("failed to parse health: %s", err)
+	}
+	if response.Code != http.StatusOK || health.Status != "ok" || *health.Reporting != 1 {
+		t.Errorf("expected reporting engine to be healthy: %d: %+v", response.Code, health)
+	}
+}
+
+func TestPauseAndResumeAlerting(t *testing.T) {
+	server := newTestServer(t, &config.Engine{})
+	for _, step := range []struct {
+		path   string
+		paused bool
+	}{
+		{"/alerting/pause", true},
+		{"/alerting/pause", true},
+		{"/alerting/resume", false},
+	} {
+		response := serve(server, "POST", step.path)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected response: %d %s", step.path, response.Code, response.Body)
+		}
+		var state AlertingState
+		if err := json.Unmarshal(response.Body.Bytes(), &state); err != nil {
+			t.Fatalf("%s: failed to parse response: %s", step.path, err)
+		}
+		if state.Paused != step.paused || server.engine.AlertingPaused() != step.paused {
+			t.Errorf("%s: expected paused: %t, got %+v", step.path, step.paused, state)
+		}
+	}
+}